@@ -0,0 +1,107 @@
+// Package acmedns publishes the TXT records used by the ACME DNS-01
+// challenge, either into GoUp's own DNS server or to an external nameserver
+// through RFC 2136 dynamic updates.
+package acmedns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Provider publishes and removes DNS-01 challenge records. fqdn is the
+// fully-qualified record name (e.g. "_acme-challenge.example.com.") and value
+// the key authorization digest returned by the ACME client.
+type Provider interface {
+	Present(ctx context.Context, fqdn, value string) error
+	CleanUp(ctx context.Context, fqdn, value string) error
+	// PropagationDelay is how long to wait after Present before asking the CA
+	// to validate the record.
+	PropagationDelay() time.Duration
+}
+
+// NewProvider returns the provider configured for a site.
+func NewProvider(ssl config.SSLConfig) (Provider, error) {
+	switch ssl.DNSProvider {
+	case "", "internal":
+		return InternalProvider{}, nil
+	case "rfc2136":
+		if ssl.RFC2136 == nil {
+			return nil, fmt.Errorf("rfc2136 provider requires the rfc2136 block")
+		}
+		return NewRFC2136Provider(*ssl.RFC2136)
+	}
+	return nil, fmt.Errorf("unknown DNS provider %q", ssl.DNSProvider)
+}
+
+// ChallengeName returns the record name that carries the DNS-01 challenge for
+// a domain; wildcard domains are validated on their base name.
+func ChallengeName(domain string) string {
+	domain = strings.TrimPrefix(domain, "*.")
+	return "_acme-challenge." + strings.ToLower(strings.TrimSuffix(domain, ".")) + "."
+}
+
+// records holds the challenge TXT values served by the internal DNS server,
+// keyed by lowercase FQDN. Several values may coexist for the same name
+// (a wildcard and its apex are validated on the same record).
+var (
+	records   = make(map[string][]string)
+	recordsMu sync.RWMutex
+)
+
+// Lookup returns the challenge TXT values currently published for fqdn.
+func Lookup(fqdn string) []string {
+	recordsMu.RLock()
+	defer recordsMu.RUnlock()
+	vals := records[strings.ToLower(fqdn)]
+	if len(vals) == 0 {
+		return nil
+	}
+	return append([]string(nil), vals...)
+}
+
+// InternalProvider publishes challenge records into the in-process store
+// answered by GoUp's DNS server (internal/dns). It only works when the DNS
+// server runs in the same process and is authoritative for the zone.
+type InternalProvider struct{}
+
+// Present adds value to the TXT set of fqdn.
+func (InternalProvider) Present(_ context.Context, fqdn, value string) error {
+	fqdn = strings.ToLower(fqdn)
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+	for _, v := range records[fqdn] {
+		if v == value {
+			return nil
+		}
+	}
+	records[fqdn] = append(records[fqdn], value)
+	return nil
+}
+
+// CleanUp removes value from the TXT set of fqdn.
+func (InternalProvider) CleanUp(_ context.Context, fqdn, value string) error {
+	fqdn = strings.ToLower(fqdn)
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+	vals := records[fqdn]
+	for i, v := range vals {
+		if v == value {
+			vals = append(vals[:i], vals[i+1:]...)
+			break
+		}
+	}
+	if len(vals) == 0 {
+		delete(records, fqdn)
+	} else {
+		records[fqdn] = vals
+	}
+	return nil
+}
+
+// PropagationDelay is zero: the record is visible as soon as it is stored.
+func (InternalProvider) PropagationDelay() time.Duration { return 0 }
@@ -0,0 +1,99 @@
+package acmedns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// challengeTTL is the TTL of published challenge records; short so a stale
+// value from a previous attempt does not linger in resolvers.
+const challengeTTL = 60
+
+// RFC2136Provider publishes challenge records on an external primary
+// nameserver through RFC 2136 dynamic updates, signed with TSIG when a key is
+// configured.
+type RFC2136Provider struct {
+	conf   config.RFC2136Config
+	client *dns.Client
+}
+
+// NewRFC2136Provider validates conf and returns a provider for it.
+func NewRFC2136Provider(conf config.RFC2136Config) (*RFC2136Provider, error) {
+	if conf.Nameserver == "" {
+		return nil, fmt.Errorf("rfc2136: nameserver is required")
+	}
+	if _, _, err := net.SplitHostPort(conf.Nameserver); err != nil {
+		conf.Nameserver = net.JoinHostPort(conf.Nameserver, "53")
+	}
+	if conf.TSIGAlgorithm == "" {
+		conf.TSIGAlgorithm = dns.HmacSHA256
+	}
+	conf.TSIGAlgorithm = dns.Fqdn(conf.TSIGAlgorithm)
+
+	c := &dns.Client{Net: "tcp", Timeout: 10 * time.Second}
+	if conf.TSIGKey != "" {
+		c.TsigSecret = map[string]string{dns.Fqdn(conf.TSIGKey): conf.TSIGSecret}
+	}
+	return &RFC2136Provider{conf: conf, client: c}, nil
+}
+
+// Present inserts the TXT record.
+func (p *RFC2136Provider) Present(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, true)
+}
+
+// CleanUp removes the TXT record.
+func (p *RFC2136Provider) CleanUp(ctx context.Context, fqdn, value string) error {
+	return p.update(ctx, fqdn, value, false)
+}
+
+// PropagationDelay returns the configured wait (default 10s) that gives
+// secondaries time to pick up the change.
+func (p *RFC2136Provider) PropagationDelay() time.Duration {
+	if p.conf.PropagationSeconds > 0 {
+		return time.Duration(p.conf.PropagationSeconds) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func (p *RFC2136Provider) update(ctx context.Context, fqdn, value string, insert bool) error {
+	fqdn = dns.Fqdn(fqdn)
+	zone := p.conf.Zone
+	if zone == "" {
+		// Without an explicit zone, update the parent of the _acme-challenge
+		// label; most setups keep that name in the site's own zone.
+		zone = strings.TrimPrefix(fqdn, "_acme-challenge.")
+	}
+	zone = dns.Fqdn(zone)
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: challengeTTL},
+		Txt: []string{value},
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	if insert {
+		m.Insert([]dns.RR{rr})
+	} else {
+		m.Remove([]dns.RR{rr})
+	}
+	if p.conf.TSIGKey != "" {
+		m.SetTsig(dns.Fqdn(p.conf.TSIGKey), p.conf.TSIGAlgorithm, 300, time.Now().Unix())
+	}
+
+	resp, _, err := p.client.ExchangeContext(ctx, m, p.conf.Nameserver)
+	if err != nil {
+		return fmt.Errorf("rfc2136: update %s: %w", fqdn, err)
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136: update %s rejected: %s", fqdn, dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mirkobrombin/goup/internal/certs"
+)
+
+// renewTimeout bounds a forced renewal (order, challenge, issuance).
+const renewTimeout = 5 * time.Minute
+
+// reloadCertificatesHandler re-reads every static certificate from disk. Pairs
+// that fail validation are reported and the previous certificate stays in
+// use.
+func reloadCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	if err := certs.ReloadAll(); err != nil {
+		jsonStatusResponse(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	jsonResponse(w, map[string]string{"message": "Certificates reloaded"})
+}
+
+// listCertificatesHandler returns the certificate inventory: every TLS domain
+// with its certificate details, expiry and last renewal error.
+func listCertificatesHandler(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, certs.Inventory())
+}
+
+// renewCertificateHandler forces a new ACME certificate for a domain. It
+// blocks until the CA issued it or the attempt failed.
+func renewCertificateHandler(w http.ResponseWriter, r *http.Request) {
+	domain := mux.Vars(r)["domain"]
+	// Issuance can outlast the API server's write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(renewTimeout + 10*time.Second))
+	ctx, cancel := context.WithTimeout(r.Context(), renewTimeout)
+	defer cancel()
+
+	err := certs.Renew(ctx, domain)
+	switch {
+	case errors.Is(err, certs.ErrUnknownCertificate):
+		http.Error(w, "Certificate not found", http.StatusNotFound)
+	case errors.Is(err, certs.ErrNotRenewable):
+		http.Error(w, "Only ACME certificates can be renewed", http.StatusBadRequest)
+	case err != nil:
+		jsonStatusResponse(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+	default:
+		jsonResponse(w, map[string]string{"message": "Certificate renewed for " + domain})
+	}
+}
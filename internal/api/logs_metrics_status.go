@@ -74,6 +74,7 @@ func MetricsSnapshot() map[string]any {
 		"ram_usage_mb":   vm.Used / 1024 / 1024,
 		"active_sites":   activeSites,
 		"active_plugins": activePlugins,
+		"file_cache":     monitor.FileCacheSnapshot(),
 	}
 }
 
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/releases"
+)
+
+// deployTimeout bounds the upload and unpacking of a release, longer than
+// the API server's read and write timeouts.
+const deployTimeout = 15 * time.Minute
+
+// siteReleases returns the releases of the site named in the route, writing
+// the error response when there are none.
+func siteReleases(w http.ResponseWriter, r *http.Request) *releases.Site {
+	domain := mux.Vars(r)["domain"]
+	config.SiteConfigsMu.RLock()
+	site, ok := config.SiteConfigs[domain]
+	config.SiteConfigsMu.RUnlock()
+	if !ok {
+		http.Error(w, "Site not found", http.StatusNotFound)
+		return nil
+	}
+	s, err := releases.ForSite(site)
+	if err != nil {
+		http.Error(w, "Releases are not enabled for this site", http.StatusBadRequest)
+		return nil
+	}
+	return s
+}
+
+// releaseError writes the response for a failed release operation.
+func releaseError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, releases.ErrUnknownRelease):
+		status = http.StatusNotFound
+	case errors.Is(err, releases.ErrNoPrevious):
+		status = http.StatusConflict
+	case errors.Is(err, releases.ErrTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, releases.ErrInvalidArchive):
+		status = http.StatusUnprocessableEntity
+	}
+	jsonStatusResponse(w, status, map[string]string{"error": err.Error()})
+}
+
+// listReleasesHandler returns the kept releases of a site, newest first.
+func listReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	s := siteReleases(w, r)
+	if s == nil {
+		return
+	}
+	list, err := s.List()
+	if err != nil {
+		releaseError(w, err)
+		return
+	}
+	if list == nil {
+		list = []releases.Release{}
+	}
+	jsonResponse(w, list)
+}
+
+// deployReleaseHandler unpacks the tar.gz or zip archive in the request
+// body into a new release and activates it.
+func deployReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	s := siteReleases(w, r)
+	if s == nil {
+		return
+	}
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(deployTimeout))
+	_ = rc.SetWriteDeadline(time.Now().Add(deployTimeout + 10*time.Second))
+
+	rel, err := s.Deploy(r.Body)
+	if err != nil {
+		releaseError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	jsonStatusResponse(w, http.StatusCreated, rel)
+}
+
+// activateReleaseHandler switches a site to one of its kept releases.
+func activateReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	s := siteReleases(w, r)
+	if s == nil {
+		return
+	}
+	rel, err := s.Activate(mux.Vars(r)["id"])
+	if err != nil {
+		releaseError(w, err)
+		return
+	}
+	jsonResponse(w, rel)
+}
+
+// rollbackReleaseHandler switches a site to the release before the active
+// one.
+func rollbackReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	s := siteReleases(w, r)
+	if s == nil {
+		return
+	}
+	rel, err := s.Rollback()
+	if err != nil {
+		releaseError(w, err)
+		return
+	}
+	jsonResponse(w, rel)
+}
@@ -37,6 +37,11 @@ func SetupRoutes() *mux.Router {
 	// Restart
 	r.HandleFunc("/api/restart", restartHandler).Methods("POST")
 
+	// Certificates
+	r.HandleFunc("/api/certificates", listCertificatesHandler).Methods("GET")
+	r.HandleFunc("/api/certificates/reload", reloadCertificatesHandler).Methods("POST")
+	r.HandleFunc("/api/certificates/{domain}/renew", renewCertificateHandler).Methods("POST")
+
 	// Sites
 	r.HandleFunc("/api/sites", listSitesHandler).Methods("GET")
 	r.HandleFunc("/api/sites", createSiteHandler).Methods("POST")
@@ -45,5 +50,11 @@ func SetupRoutes() *mux.Router {
 	r.HandleFunc("/api/sites/{domain}", deleteSiteHandler).Methods("DELETE")
 	r.HandleFunc("/api/sites/{domain}/validate", validateSiteHandler).Methods("GET")
 
+	// Releases
+	r.HandleFunc("/api/sites/{domain}/releases", listReleasesHandler).Methods("GET")
+	r.HandleFunc("/api/sites/{domain}/releases", deployReleaseHandler).Methods("POST")
+	r.HandleFunc("/api/sites/{domain}/releases/rollback", rollbackReleaseHandler).Methods("POST")
+	r.HandleFunc("/api/sites/{domain}/releases/{id}/activate", activateReleaseHandler).Methods("POST")
+
 	return r
 }
@@ -9,3 +9,11 @@ func jsonResponse(w http.ResponseWriter, data any) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
+
+// jsonStatusResponse is jsonResponse with a status other than 200; the
+// Content-Type has to be set before the status is written.
+func jsonStatusResponse(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
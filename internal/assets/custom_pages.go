@@ -6,10 +6,11 @@ import (
 )
 
 var (
-	ErrorTemplate   *template.Template
-	WelcomeTemplate *template.Template
-	ListingTemplate *template.Template
-	GlobalStyles    template.HTML
+	ErrorTemplate    *template.Template
+	WelcomeTemplate  *template.Template
+	ListingTemplate  *template.Template
+	MarkdownTemplate *template.Template
+	GlobalStyles     template.HTML
 )
 
 func init() {
@@ -30,6 +31,10 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	MarkdownTemplate, err = template.New("markdown").Parse(MarkdownHTML)
+	if err != nil {
+		panic(err)
+	}
 }
 
 // ErrorPageData holds data for the error page template
@@ -48,6 +53,36 @@ type ListingPageData struct {
 	ShowBack   bool
 	FooterLink string
 	Styles     template.HTML
+
+	Sort     string   // column the items are sorted by: name, size or mtime
+	Desc     bool     // descending order
+	Readme   string   // README of the directory, shown above the items
+	Archives []string // formats the directory can be downloaded as
+
+	ReadmeHTML template.HTML // rendered Markdown README, shown under the items
+	CodeCSS    template.CSS  // styles of its code blocks
+
+	CanUpload   bool // show the upload form
+	CanDelete   bool // show a delete button next to files
+	UploadLogin bool // uploads need credentials the request lacks
+}
+
+// MarkdownPageData holds data for the Markdown page template
+type MarkdownPageData struct {
+	Title      string
+	Path       string
+	Content    template.HTML
+	TOC        []MarkdownHeading
+	CodeCSS    template.CSS
+	FooterLink string
+	Styles     template.HTML
+}
+
+// MarkdownHeading is an entry of the table of contents of a Markdown page
+type MarkdownHeading struct {
+	Level int
+	ID    string
+	Text  string
 }
 
 // ListingItem represents a file or directory in the listing
@@ -97,16 +132,17 @@ func RenderWelcomePage(w http.ResponseWriter) {
 
 // RenderDirectoryListing renders the directory listing page
 func RenderDirectoryListing(w http.ResponseWriter, path string, items []ListingItem, showBack bool) {
+	RenderListing(w, ListingPageData{Path: path, Items: items, ShowBack: showBack, Sort: "name"})
+}
+
+// RenderListing renders a directory listing with sorting, README and
+// download options
+func RenderListing(w http.ResponseWriter, data ListingPageData) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
-	data := ListingPageData{
-		Path:       path,
-		Items:      items,
-		ShowBack:   showBack,
-		FooterLink: "https://github.com/tryGoUp",
-		Styles:     GlobalStyles,
-	}
+	data.FooterLink = "https://github.com/tryGoUp"
+	data.Styles = GlobalStyles
 
 	if err := ListingTemplate.Execute(w, data); err != nil {
 		http.Error(w, "Directory Listing", http.StatusOK)
@@ -7,5 +7,7 @@ const (
 
 	WelcomeHTML = `<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>Welcome to GoUp</title>{{.Styles}}</head><body><div class="main-container"><div class="logo-wrapper"><svg class="logo-svg" width="156" height="151" viewBox="0 0 156 151" fill="none" xmlns="http://www.w3.org/2000/svg"><path d="M124.463 14L127.714 102.007H151.286L154.537 14H124.463Z" fill="#F6F8EA"/><path d="M151.448 144.223C148.305 147.408 144.296 149 139.419 149C134.65 149 130.749 147.408 127.714 144.223C124.571 140.916 123 136.874 123 132.097C123 127.197 124.571 123.094 127.714 119.787C130.749 116.48 134.65 114.826 139.419 114.826C144.296 114.826 148.305 116.48 151.448 119.787C154.483 123.094 156 127.197 156 132.097C156 136.874 154.483 140.916 151.448 144.223Z" fill="#2ABFC4"/><path d="M91.3172 63C86.3218 63 81.8138 61.9431 77.7931 59.8293C73.8333 57.7155 70.696 54.6957 68.381 50.77C66.127 46.8444 65 42.2544 65 37C65 31.806 66.1575 27.2462 68.4724 23.3206C70.7874 19.3345 73.9552 16.2846 77.9759 14.1707C81.9966 12.0569 86.5046 11 91.5 11C96.4954 11 101.003 12.0569 105.024 14.1707C109.045 16.2846 112.213 19.3345 114.528 23.3206C116.843 27.2462 118 31.806 118 37C118 42.194 116.812 46.784 114.436 50.77C112.121 54.6957 108.923 57.7155 104.841 59.8293C100.821 61.9431 96.3126 63 91.3172 63ZM91.3172 49.5923C94.3023 49.5923 96.8305 48.5052 98.9017 46.331C101.034 44.1568 102.1 41.0465 102.1 37C102.1 32.9535 101.064 29.8432 98.9931 27.669C96.9828 25.4948 94.4851 24.4077 91.5 24.4077C88.454 24.4077 85.9259 25.4948 83.9155 27.669C81.9052 29.7828 80.9 32.8932 80.9 37C80.9 41.0465 81.8747 44.1568 83.8241 46.331C85.8345 48.5052 88.3322 49.5923 91.3172 49.5923Z" fill="#2ABFC4"/><path d="M43.3836 20.2657C42.2735 18.2098 40.6667 16.6531 38.5632 15.5958C36.5182 14.4797 34.0934 13.9217 31.2888 13.9217C26.4392 13.9217 22.5536 15.5371 19.6322 18.7678C16.7107 21.9399 15.25 26.1986 15.25 31.5441C15.25 37.242 16.7692 41.7063 19.8075 44.9371C22.9042 48.1091 27.1403 49.6951 32.5158 49.6951C36.1968 49.6951 39.2936 48.7552 41.806 46.8755C44.3769 44.9958 46.2466 42.2937 47.4152 38.7692H28.3966V27.6671H61V41.6769C59.8898 45.4364 57.9909 48.9315 55.3032 52.1622C52.6738 55.393 49.3142 58.007 45.2241 60.0042C41.1341 62.0014 36.5182 63 31.3764 63C25.2998 63 19.8659 61.6783 15.0747 59.035C10.342 56.3329 6.6317 52.6028 3.94397 47.8448C1.31466 43.0867 0 37.6531 0 31.5441C0 25.435 1.31466 20.0014 3.94397 15.2434C6.6317 10.4266 10.342 6.6965 15.0747 4.05315C19.8075 1.35105 25.2122 0 31.2888 0C38.6509 0 44.8443 1.79161 49.8693 5.37482C54.9526 8.95804 58.3123 13.9217 59.9483 20.2657H43.3836Z" fill="#2ABFC4"/><path d="M58 71V125.415H41.0845V118.004C39.3699 120.409 37.0288 122.359 34.0612 123.855C31.1595 125.285 27.9281 126 24.3669 126C20.1463 126 16.4203 125.09 13.1888 123.27C9.95743 121.384 7.45144 118.686 5.67086 115.176C3.89029 111.665 3 107.537 3 102.791V71H19.8165V100.548C19.8165 104.189 20.7728 107.017 22.6853 109.032C24.5977 111.047 27.1697 112.055 30.4011 112.055C33.6984 112.055 36.3034 111.047 38.2158 109.032C40.1283 107.017 41.0845 104.189 41.0845 100.548V71H58Z" fill="#F6F8EA"/><path d="M76.8387 78.47C78.4799 75.9387 80.7448 73.8942 83.6333 72.3365C86.5218 70.7788 89.9027 70 93.7759 70C98.3056 70 102.409 71.1358 106.085 73.4075C109.761 75.6791 112.65 78.9243 114.75 83.143C116.917 87.3618 118 92.262 118 97.8438C118 103.425 116.917 108.358 114.75 112.642C112.65 116.861 109.761 120.138 106.085 122.475C102.409 124.746 98.3056 125.882 93.7759 125.882C89.9683 125.882 86.5874 125.103 83.6333 123.546C80.7448 121.988 78.4799 119.976 76.8387 117.51V151H60V70.7788H76.8387V78.47ZM100.866 97.8438C100.866 93.6899 99.6842 90.4447 97.3209 88.1082C95.0232 85.7067 92.1675 84.506 88.7538 84.506C85.4058 84.506 82.5501 85.7067 80.1868 88.1082C77.8891 90.5096 76.7402 93.7873 76.7402 97.9411C76.7402 102.095 77.8891 105.373 80.1868 107.774C82.5501 110.175 85.4058 111.376 88.7538 111.376C92.1019 111.376 94.9576 110.175 97.3209 107.774C99.6842 105.308 100.866 101.998 100.866 97.8438Z" fill="#F6F8EA"/></svg></div><h2 class="title">Welcome!</h2><p class="description">Your GoUp server is up and running. Upload your files to the root directory to get started.</p><a href="https://github.com/tryGoUp" target="_blank" class="btn-home">Read Documentation</a><br><a href="{{.FooterLink}}" target="_blank" class="footer-link">GoUp Project on GitHub</a></div></body></html>`
 
-	ListingHTML = `<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>GoUp - Index of {{.Path}}</title>{{.Styles}}<style>.listing-container{text-align:left;padding:2rem;max-width:900px;width:100%;margin:0 auto;background:rgba(255,255,255,0.03);backdrop-filter:blur(10px);border-radius:20px;border:1px solid rgba(255,255,255,0.05);animation:fadeUp 1s var(--ease-out-expo) forwards}.listing-header{display:flex;align-items:center;justify-content:space-between;margin-bottom:2rem;padding-bottom:1rem;border-bottom:1px solid rgba(255,255,255,0.05)}.listing-path{font-size:1.25rem;font-weight:600;color:var(--color-accent);word-break:break-all}.listing-table{width:100%;border-collapse:collapse}.listing-table th,.listing-table td{padding:1rem;text-align:left;border-bottom:1px solid rgba(255,255,255,0.02)}.listing-table th{color:var(--color-text-muted);font-weight:600;text-transform:uppercase;font-size:0.75rem;letter-spacing:0.05em}.listing-item:hover{background:rgba(42,191,196,0.05)}.listing-link{color:var(--color-text);text-decoration:none;display:flex;align-items:center;gap:0.75rem;transition:color 0.2s ease}.listing-link:hover{color:var(--color-accent)}.icon{width:20px;height:20px;opacity:0.7}.size,.mtime{color:var(--color-text-muted);font-size:0.875rem}.footer{margin-top:2rem;text-align:center}::-webkit-scrollbar{width:8px}::-webkit-scrollbar-track{background:transparent}::-webkit-scrollbar-thumb{background:rgba(42,191,196,0.2);border-radius:4px}::-webkit-scrollbar-thumb:hover{background:rgba(42,191,196,0.4)}body.listing-body{overflow-y:auto;height:auto;min-height:auto;display:flex;flex-direction:column;align-items:center;justify-content:flex-start}body.listing-body .main-container{height:auto;max-height:none;overflow:visible;display:flex;flex-direction:column;align-items:center;justify-content:flex-start}</style></head><body class="listing-body"><div class="main-container" style="max-width:1000px;padding:2rem 1rem;justify-content:flex-start"><div class="logo-wrapper" style="margin-bottom:1.5rem"><svg class="logo-svg" width="156" height="151" viewBox="0 0 156 151" fill="none" xmlns="http://www.w3.org/2000/svg" style="width:50px"><path d="M124.463 14L127.714 102.007H151.286L154.537 14H124.463Z" fill="#F6F8EA"/><path d="M151.448 144.223C148.305 147.408 144.296 149 139.419 149C134.65 149 130.749 147.408 127.714 144.223C124.571 140.916 123 136.874 123 132.097C123 127.197 124.571 123.094 127.714 119.787C130.749 116.48 134.65 114.826 139.419 114.826C144.296 114.826 148.305 116.48 151.448 119.787C154.483 123.094 156 127.197 156 132.097C156 136.874 154.483 140.916 151.448 144.223Z" fill="#2ABFC4"/><path d="M91.3172 63C86.3218 63 81.8138 61.9431 77.7931 59.8293C73.8333 57.7155 70.696 54.6957 68.381 50.77C66.127 46.8444 65 42.2544 65 37C65 31.806 66.1575 27.2462 68.4724 23.3206C70.7874 19.3345 73.9552 16.2846 77.9759 14.1707C81.9966 12.0569 86.5046 11 91.5 11C96.4954 11 101.003 12.0569 105.024 14.1707C109.045 16.2846 112.213 19.3345 114.528 23.3206C116.843 27.2462 118 31.806 118 37C118 42.194 116.812 46.784 114.436 50.77C112.121 54.6957 108.923 57.7155 104.841 59.8293C100.821 61.9431 96.3126 63 91.3172 63ZM91.3172 49.5923C94.3023 49.5923 96.8305 48.5052 98.9017 46.331C101.034 44.1568 102.1 41.0465 102.1 37C102.1 32.9535 101.064 29.8432 98.9931 27.669C96.9828 25.4948 94.4851 24.4077 91.5 24.4077C88.454 24.4077 85.9259 25.4948 83.9155 27.669C81.9052 29.7828 80.9 32.8932 80.9 37C80.9 41.0465 81.8747 44.1568 83.8241 46.331C85.8345 48.5052 88.3322 49.5923 91.3172 49.5923Z" fill="#2ABFC4"/><path d="M43.3836 20.2657C42.2735 18.2098 40.6667 16.6531 38.5632 15.5958C36.5182 14.4797 34.0934 13.9217 31.2888 13.9217C26.4392 13.9217 22.5536 15.5371 19.6322 18.7678C16.7107 21.9399 15.25 26.1986 15.25 31.5441C15.25 37.242 16.7692 41.7063 19.8075 44.9371C22.9042 48.1091 27.1403 49.6951 32.5158 49.6951C36.1968 49.6951 39.2936 48.7552 41.806 46.8755C44.3769 44.9958 46.2466 42.2937 47.4152 38.7692H28.3966V27.6671H61V41.6769C59.8898 45.4364 57.9909 48.9315 55.3032 52.1622C52.6738 55.393 49.3142 58.007 45.2241 60.0042C41.1341 62.0014 36.5182 63 31.3764 63C25.2998 63 19.8659 61.6783 15.0747 59.035C10.342 56.3329 6.6317 52.6028 3.94397 47.8448C1.31466 43.0867 0 37.6531 0 31.5441C0 25.435 1.31466 20.0014 3.94397 15.2434C6.6317 10.4266 10.342 6.6965 15.0747 4.05315C19.8075 1.35105 25.2122 0 31.2888 0C38.6509 0 44.8443 1.79161 49.8693 5.37482C54.9526 8.95804 58.3123 13.9217 59.9483 20.2657H43.3836Z" fill="#2ABFC4"/><path d="M58 71V125.415H41.0845V118.004C39.3699 120.409 37.0288 122.359 34.0612 123.855C31.1595 125.285 27.9281 126 24.3669 126C20.1463 126 16.4203 125.09 13.1888 123.27C9.95743 121.384 7.45144 118.686 5.67086 115.176C3.89029 111.665 3 107.537 3 102.791V71H19.8165V100.548C19.8165 104.189 20.7728 107.017 22.6853 109.032C24.5977 111.047 27.1697 112.055 30.4011 112.055C33.6984 112.055 36.3034 111.047 38.2158 109.032C40.1283 107.017 41.0845 104.189 41.0845 100.548V71H58Z" fill="#F6F8EA"/><path d="M76.8387 78.47C78.4799 75.9387 80.7448 73.8942 83.6333 72.3365C86.5218 70.7788 89.9027 70 93.7759 70C98.3056 70 102.409 71.1358 106.085 73.4075C109.761 75.6791 112.65 78.9243 114.75 83.143C116.917 87.3618 118 92.262 118 97.8438C118 103.425 116.917 108.358 114.75 112.642C112.65 116.861 109.761 120.138 106.085 122.475C102.409 124.746 98.3056 125.882 93.7759 125.882C89.9683 125.882 86.5874 125.103 83.6333 123.546C80.7448 121.988 78.4799 119.976 76.8387 117.51V151H60V70.7788H76.8387V78.47ZM100.866 97.8438C100.866 93.6899 99.6842 90.4447 97.3209 88.1082C95.0232 85.7067 92.1675 84.506 88.7538 84.506C85.4058 84.506 82.5501 85.7067 80.1868 88.1082C77.8891 90.5096 76.7402 93.7873 76.7402 97.9411C76.7402 102.095 77.8891 105.373 80.1868 107.774C82.5501 110.175 85.4058 111.376 88.7538 111.376C92.1019 111.376 94.9576 110.175 97.3209 107.774C99.6842 105.308 100.866 101.998 100.866 97.8438Z" fill="#F6F8EA"/></svg></div><div class="listing-container"><div class="listing-header"><span class="listing-path">Index of {{.Path}}</span></div><table class="listing-table"><thead><tr><th>Name</th><th>Size</th><th>Last Modified</th></tr></thead><tbody>{{if .ShowBack}}<tr class="listing-item"><td colspan="3"><a href=".." class="listing-link"><svg class="icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M11 17l-5-5 5-5M18 12H6"/></svg><span>..</span></a></td></tr>{{end}}{{range .Items}}<tr class="listing-item"><td><a href="{{.Name}}{{if .IsDir}}/{{end}}" class="listing-link">{{if .IsDir}}<svg class="icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M22 19a2 2 0 0 1-2 2H4a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h5l2 3h9a2 2 0 0 1 2 2z"/></svg>{{else}}<svg class="icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M13 2H6a2 2 0 0 0-2 2v16a2 2 0 0 0 2 2h12a2 2 0 0 0 2-2V9z"/><polyline points="13 2 13 9 20 9"/></svg>{{end}}<span>{{.Name}}</span></a></td><td class="size">{{if .IsDir}}-{{else}}{{.Size}}{{end}}</td><td class="mtime">{{.ModTime}}</td></tr>{{end}}</tbody></table></div><div class="footer"><a href="{{.FooterLink}}" target="_blank" class="footer-link">Powered by GoUp</a></div></div></body></html>`
+	ListingHTML = `<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>GoUp - Index of {{.Path}}</title>{{.Styles}}<style>.listing-container{text-align:left;padding:2rem;max-width:900px;width:100%;margin:0 auto;background:rgba(255,255,255,0.03);backdrop-filter:blur(10px);border-radius:20px;border:1px solid rgba(255,255,255,0.05);animation:fadeUp 1s var(--ease-out-expo) forwards}.listing-header{display:flex;align-items:center;justify-content:space-between;margin-bottom:2rem;padding-bottom:1rem;border-bottom:1px solid rgba(255,255,255,0.05)}.listing-path{font-size:1.25rem;font-weight:600;color:var(--color-accent);word-break:break-all}.listing-table{width:100%;border-collapse:collapse}.listing-table th,.listing-table td{padding:1rem;text-align:left;border-bottom:1px solid rgba(255,255,255,0.02)}.listing-table th{color:var(--color-text-muted);font-weight:600;text-transform:uppercase;font-size:0.75rem;letter-spacing:0.05em}.listing-item:hover{background:rgba(42,191,196,0.05)}.listing-link{color:var(--color-text);text-decoration:none;display:flex;align-items:center;gap:0.75rem;transition:color 0.2s ease}.listing-link:hover{color:var(--color-accent)}.icon{width:20px;height:20px;opacity:0.7}.size,.mtime{color:var(--color-text-muted);font-size:0.875rem}.listing-table th a{color:inherit;text-decoration:none}.listing-table th a:hover{color:var(--color-accent)}.listing-downloads{display:flex;gap:0.5rem}.listing-download{color:var(--color-accent);text-decoration:none;font-size:0.875rem;padding:0.25rem 0.75rem;border:1px solid rgba(42,191,196,0.3);border-radius:8px}.listing-download:hover{background:rgba(42,191,196,0.1)}.listing-readme{white-space:pre-wrap;font-size:0.875rem;color:var(--color-text-muted);margin:0 0 2rem;padding:1rem;background:rgba(255,255,255,0.02);border-radius:12px;overflow-x:auto}.listing-upload{display:flex;gap:0.75rem;align-items:center;margin:0 0 2rem;font-size:0.875rem;color:var(--color-text-muted)}.listing-upload button,.listing-delete{color:var(--color-accent);background:none;font:inherit;font-size:0.875rem;padding:0.25rem 0.75rem;border:1px solid rgba(42,191,196,0.3);border-radius:8px;cursor:pointer}.listing-upload button:hover,.listing-delete:hover{background:rgba(42,191,196,0.1)}.listing-readme-html{margin-top:2rem;padding-top:2rem;border-top:1px solid rgba(255,255,255,0.05)}.markdown-body{color:var(--color-text);line-height:1.7;font-size:0.95rem;overflow-wrap:break-word}.markdown-body h1,.markdown-body h2,.markdown-body h3,.markdown-body h4{line-height:1.3;margin:2rem 0 1rem;font-weight:600}.markdown-body h1{font-size:1.75rem}.markdown-body h2{font-size:1.4rem;padding-bottom:0.5rem;border-bottom:1px solid rgba(255,255,255,0.05)}.markdown-body h3{font-size:1.15rem}.markdown-body>:first-child{margin-top:0}.markdown-body p,.markdown-body ul,.markdown-body ol,.markdown-body table,.markdown-body blockquote,.markdown-body pre{margin:0 0 1rem}.markdown-body ul,.markdown-body ol{padding-left:1.5rem}.markdown-body a{color:var(--color-accent)}.markdown-body img{max-width:100%}.markdown-body blockquote{padding:0 1rem;color:var(--color-text-muted);border-left:3px solid rgba(42,191,196,0.4)}.markdown-body code{font-family:ui-monospace,SFMono-Regular,Menlo,Consolas,monospace;font-size:0.85em;padding:0.15em 0.35em;background:rgba(255,255,255,0.06);border-radius:6px}.markdown-body pre{padding:1rem;border-radius:12px;overflow-x:auto;background:rgba(0,0,0,0.3)}.markdown-body pre code{padding:0;background:none;font-size:0.85rem}.markdown-body table{border-collapse:collapse;display:block;overflow-x:auto}.markdown-body th,.markdown-body td{padding:0.5rem 0.75rem;border:1px solid rgba(255,255,255,0.08)}.markdown-body th{color:var(--color-text-muted);font-weight:600}.markdown-body hr{border:0;border-top:1px solid rgba(255,255,255,0.08);margin:2rem 0}.footer{margin-top:2rem;text-align:center}::-webkit-scrollbar{width:8px}::-webkit-scrollbar-track{background:transparent}::-webkit-scrollbar-thumb{background:rgba(42,191,196,0.2);border-radius:4px}::-webkit-scrollbar-thumb:hover{background:rgba(42,191,196,0.4)}body.listing-body{overflow-y:auto;height:auto;min-height:auto;display:flex;flex-direction:column;align-items:center;justify-content:flex-start}body.listing-body .main-container{height:auto;max-height:none;overflow:visible;display:flex;flex-direction:column;align-items:center;justify-content:flex-start}</style>{{if .CodeCSS}}<style>{{.CodeCSS}}</style>{{end}}</head><body class="listing-body"><div class="main-container" style="max-width:1000px;padding:2rem 1rem;justify-content:flex-start"><div class="logo-wrapper" style="margin-bottom:1.5rem"><svg class="logo-svg" width="156" height="151" viewBox="0 0 156 151" fill="none" xmlns="http://www.w3.org/2000/svg" style="width:50px"><path d="M124.463 14L127.714 102.007H151.286L154.537 14H124.463Z" fill="#F6F8EA"/><path d="M151.448 144.223C148.305 147.408 144.296 149 139.419 149C134.65 149 130.749 147.408 127.714 144.223C124.571 140.916 123 136.874 123 132.097C123 127.197 124.571 123.094 127.714 119.787C130.749 116.48 134.65 114.826 139.419 114.826C144.296 114.826 148.305 116.48 151.448 119.787C154.483 123.094 156 127.197 156 132.097C156 136.874 154.483 140.916 151.448 144.223Z" fill="#2ABFC4"/><path d="M91.3172 63C86.3218 63 81.8138 61.9431 77.7931 59.8293C73.8333 57.7155 70.696 54.6957 68.381 50.77C66.127 46.8444 65 42.2544 65 37C65 31.806 66.1575 27.2462 68.4724 23.3206C70.7874 19.3345 73.9552 16.2846 77.9759 14.1707C81.9966 12.0569 86.5046 11 91.5 11C96.4954 11 101.003 12.0569 105.024 14.1707C109.045 16.2846 112.213 19.3345 114.528 23.3206C116.843 27.2462 118 31.806 118 37C118 42.194 116.812 46.784 114.436 50.77C112.121 54.6957 108.923 57.7155 104.841 59.8293C100.821 61.9431 96.3126 63 91.3172 63ZM91.3172 49.5923C94.3023 49.5923 96.8305 48.5052 98.9017 46.331C101.034 44.1568 102.1 41.0465 102.1 37C102.1 32.9535 101.064 29.8432 98.9931 27.669C96.9828 25.4948 94.4851 24.4077 91.5 24.4077C88.454 24.4077 85.9259 25.4948 83.9155 27.669C81.9052 29.7828 80.9 32.8932 80.9 37C80.9 41.0465 81.8747 44.1568 83.8241 46.331C85.8345 48.5052 88.3322 49.5923 91.3172 49.5923Z" fill="#2ABFC4"/><path d="M43.3836 20.2657C42.2735 18.2098 40.6667 16.6531 38.5632 15.5958C36.5182 14.4797 34.0934 13.9217 31.2888 13.9217C26.4392 13.9217 22.5536 15.5371 19.6322 18.7678C16.7107 21.9399 15.25 26.1986 15.25 31.5441C15.25 37.242 16.7692 41.7063 19.8075 44.9371C22.9042 48.1091 27.1403 49.6951 32.5158 49.6951C36.1968 49.6951 39.2936 48.7552 41.806 46.8755C44.3769 44.9958 46.2466 42.2937 47.4152 38.7692H28.3966V27.6671H61V41.6769C59.8898 45.4364 57.9909 48.9315 55.3032 52.1622C52.6738 55.393 49.3142 58.007 45.2241 60.0042C41.1341 62.0014 36.5182 63 31.3764 63C25.2998 63 19.8659 61.6783 15.0747 59.035C10.342 56.3329 6.6317 52.6028 3.94397 47.8448C1.31466 43.0867 0 37.6531 0 31.5441C0 25.435 1.31466 20.0014 3.94397 15.2434C6.6317 10.4266 10.342 6.6965 15.0747 4.05315C19.8075 1.35105 25.2122 0 31.2888 0C38.6509 0 44.8443 1.79161 49.8693 5.37482C54.9526 8.95804 58.3123 13.9217 59.9483 20.2657H43.3836Z" fill="#2ABFC4"/><path d="M58 71V125.415H41.0845V118.004C39.3699 120.409 37.0288 122.359 34.0612 123.855C31.1595 125.285 27.9281 126 24.3669 126C20.1463 126 16.4203 125.09 13.1888 123.27C9.95743 121.384 7.45144 118.686 5.67086 115.176C3.89029 111.665 3 107.537 3 102.791V71H19.8165V100.548C19.8165 104.189 20.7728 107.017 22.6853 109.032C24.5977 111.047 27.1697 112.055 30.4011 112.055C33.6984 112.055 36.3034 111.047 38.2158 109.032C40.1283 107.017 41.0845 104.189 41.0845 100.548V71H58Z" fill="#F6F8EA"/><path d="M76.8387 78.47C78.4799 75.9387 80.7448 73.8942 83.6333 72.3365C86.5218 70.7788 89.9027 70 93.7759 70C98.3056 70 102.409 71.1358 106.085 73.4075C109.761 75.6791 112.65 78.9243 114.75 83.143C116.917 87.3618 118 92.262 118 97.8438C118 103.425 116.917 108.358 114.75 112.642C112.65 116.861 109.761 120.138 106.085 122.475C102.409 124.746 98.3056 125.882 93.7759 125.882C89.9683 125.882 86.5874 125.103 83.6333 123.546C80.7448 121.988 78.4799 119.976 76.8387 117.51V151H60V70.7788H76.8387V78.47ZM100.866 97.8438C100.866 93.6899 99.6842 90.4447 97.3209 88.1082C95.0232 85.7067 92.1675 84.506 88.7538 84.506C85.4058 84.506 82.5501 85.7067 80.1868 88.1082C77.8891 90.5096 76.7402 93.7873 76.7402 97.9411C76.7402 102.095 77.8891 105.373 80.1868 107.774C82.5501 110.175 85.4058 111.376 88.7538 111.376C92.1019 111.376 94.9576 110.175 97.3209 107.774C99.6842 105.308 100.866 101.998 100.866 97.8438Z" fill="#F6F8EA"/></svg></div><div class="listing-container"><div class="listing-header"><span class="listing-path">Index of {{.Path}}</span>{{if .Archives}}<span class="listing-downloads">{{range .Archives}}<a href="?download={{.}}" class="listing-download">.{{.}}</a>{{end}}</span>{{end}}</div>{{if .Readme}}<pre class="listing-readme">{{.Readme}}</pre>{{end}}{{if .CanUpload}}<form class="listing-upload" method="post" enctype="multipart/form-data"><input type="file" name="file" multiple required><button type="submit">Upload</button></form>{{else if .UploadLogin}}<div class="listing-upload"><a href="?login" class="listing-download">Log in to upload</a></div>{{end}}<table class="listing-table"><thead><tr><th><a href="?sort=name{{if and (eq .Sort "name") (not .Desc)}}&amp;order=desc{{end}}">Name{{if eq .Sort "name"}}{{if .Desc}} &darr;{{else}} &uarr;{{end}}{{end}}</a></th><th><a href="?sort=size{{if and (eq .Sort "size") (not .Desc)}}&amp;order=desc{{end}}">Size{{if eq .Sort "size"}}{{if .Desc}} &darr;{{else}} &uarr;{{end}}{{end}}</a></th><th><a href="?sort=mtime{{if and (eq .Sort "mtime") (not .Desc)}}&amp;order=desc{{end}}">Last Modified{{if eq .Sort "mtime"}}{{if .Desc}} &darr;{{else}} &uarr;{{end}}{{end}}</a></th>{{if .CanDelete}}<th></th>{{end}}</tr></thead><tbody>{{if .ShowBack}}<tr class="listing-item"><td colspan="{{if .CanDelete}}4{{else}}3{{end}}"><a href=".." class="listing-link"><svg class="icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M11 17l-5-5 5-5M18 12H6"/></svg><span>..</span></a></td></tr>{{end}}{{range .Items}}<tr class="listing-item"><td><a href="{{.Name}}{{if .IsDir}}/{{end}}" class="listing-link">{{if .IsDir}}<svg class="icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M22 19a2 2 0 0 1-2 2H4a2 2 0 0 1-2-2V5a2 2 0 0 1 2-2h5l2 3h9a2 2 0 0 1 2 2z"/></svg>{{else}}<svg class="icon" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M13 2H6a2 2 0 0 0-2 2v16a2 2 0 0 0 2 2h12a2 2 0 0 0 2-2V9z"/><polyline points="13 2 13 9 20 9"/></svg>{{end}}<span>{{.Name}}</span></a></td><td class="size">{{if .IsDir}}-{{else}}{{.Size}}{{end}}</td><td class="mtime">{{.ModTime}}</td>{{if $.CanDelete}}<td>{{if not .IsDir}}<button type="button" class="listing-delete" data-name="{{.Name}}">Delete</button>{{end}}</td>{{end}}</tr>{{end}}</tbody></table>{{if .ReadmeHTML}}<article class="markdown-body listing-readme-html">{{.ReadmeHTML}}</article>{{end}}</div><div class="footer"><a href="{{.FooterLink}}" target="_blank" class="footer-link">Powered by GoUp</a></div></div>{{if .CanDelete}}<script>document.querySelectorAll(".listing-delete").forEach(function(b){b.addEventListener("click",function(){if(!confirm("Delete "+b.dataset.name+"?"))return;fetch(encodeURIComponent(b.dataset.name),{method:"DELETE"}).then(function(r){if(r.ok){location.reload()}else{r.text().then(function(t){alert(t)})}})})})</script>{{end}}</body></html>`
+
+	MarkdownHTML = `<!DOCTYPE html><html lang="en"><head><meta charset="UTF-8"><meta name="viewport" content="width=device-width,initial-scale=1"><title>{{if .Title}}{{.Title}}{{else}}{{.Path}}{{end}}</title>{{.Styles}}<style>.markdown-container{text-align:left;padding:2rem 2.5rem;max-width:900px;width:100%;margin:0 auto;background:rgba(255,255,255,0.03);backdrop-filter:blur(10px);border-radius:20px;border:1px solid rgba(255,255,255,0.05);animation:fadeUp 1s var(--ease-out-expo) forwards}.markdown-header{display:flex;align-items:center;justify-content:space-between;gap:1rem;margin-bottom:1.5rem;padding-bottom:1rem;border-bottom:1px solid rgba(255,255,255,0.05)}.markdown-path{font-size:1rem;font-weight:600;color:var(--color-accent);word-break:break-all}.markdown-raw{color:var(--color-accent);text-decoration:none;font-size:0.875rem;padding:0.25rem 0.75rem;border:1px solid rgba(42,191,196,0.3);border-radius:8px}.markdown-raw:hover{background:rgba(42,191,196,0.1)}.markdown-toc{margin:0 0 2rem;padding:1rem 1.25rem;background:rgba(255,255,255,0.02);border-radius:12px;font-size:0.875rem}.markdown-toc ul{list-style:none;margin:0;padding:0}.markdown-toc li{margin:0.25rem 0}.markdown-toc .toc-3{padding-left:1.25rem}.markdown-toc a{color:var(--color-text-muted);text-decoration:none}.markdown-toc a:hover{color:var(--color-accent)}.markdown-body{color:var(--color-text);line-height:1.7;font-size:0.95rem;overflow-wrap:break-word}.markdown-body h1,.markdown-body h2,.markdown-body h3,.markdown-body h4{line-height:1.3;margin:2rem 0 1rem;font-weight:600}.markdown-body h1{font-size:1.75rem}.markdown-body h2{font-size:1.4rem;padding-bottom:0.5rem;border-bottom:1px solid rgba(255,255,255,0.05)}.markdown-body h3{font-size:1.15rem}.markdown-body>:first-child{margin-top:0}.markdown-body p,.markdown-body ul,.markdown-body ol,.markdown-body table,.markdown-body blockquote,.markdown-body pre{margin:0 0 1rem}.markdown-body ul,.markdown-body ol{padding-left:1.5rem}.markdown-body a{color:var(--color-accent)}.markdown-body img{max-width:100%}.markdown-body blockquote{padding:0 1rem;color:var(--color-text-muted);border-left:3px solid rgba(42,191,196,0.4)}.markdown-body code{font-family:ui-monospace,SFMono-Regular,Menlo,Consolas,monospace;font-size:0.85em;padding:0.15em 0.35em;background:rgba(255,255,255,0.06);border-radius:6px}.markdown-body pre{padding:1rem;border-radius:12px;overflow-x:auto;background:rgba(0,0,0,0.3)}.markdown-body pre code{padding:0;background:none;font-size:0.85rem}.markdown-body table{border-collapse:collapse;display:block;overflow-x:auto}.markdown-body th,.markdown-body td{padding:0.5rem 0.75rem;border:1px solid rgba(255,255,255,0.08)}.markdown-body th{color:var(--color-text-muted);font-weight:600}.markdown-body hr{border:0;border-top:1px solid rgba(255,255,255,0.08);margin:2rem 0}.footer{margin-top:2rem;text-align:center}::-webkit-scrollbar{width:8px}::-webkit-scrollbar-track{background:transparent}::-webkit-scrollbar-thumb{background:rgba(42,191,196,0.2);border-radius:4px}::-webkit-scrollbar-thumb:hover{background:rgba(42,191,196,0.4)}body.listing-body{overflow-y:auto;height:auto;min-height:auto;display:flex;flex-direction:column;align-items:center;justify-content:flex-start}body.listing-body .main-container{height:auto;max-height:none;overflow:visible;display:flex;flex-direction:column;align-items:center;justify-content:flex-start}</style><style>{{.CodeCSS}}</style></head><body class="listing-body"><div class="main-container" style="max-width:1000px;padding:2rem 1rem;justify-content:flex-start"><div class="logo-wrapper" style="margin-bottom:1.5rem"><svg class="logo-svg" width="156" height="151" viewBox="0 0 156 151" fill="none" xmlns="http://www.w3.org/2000/svg" style="width:50px"><path d="M124.463 14L127.714 102.007H151.286L154.537 14H124.463Z" fill="#F6F8EA"/><path d="M151.448 144.223C148.305 147.408 144.296 149 139.419 149C134.65 149 130.749 147.408 127.714 144.223C124.571 140.916 123 136.874 123 132.097C123 127.197 124.571 123.094 127.714 119.787C130.749 116.48 134.65 114.826 139.419 114.826C144.296 114.826 148.305 116.48 151.448 119.787C154.483 123.094 156 127.197 156 132.097C156 136.874 154.483 140.916 151.448 144.223Z" fill="#2ABFC4"/><path d="M91.3172 63C86.3218 63 81.8138 61.9431 77.7931 59.8293C73.8333 57.7155 70.696 54.6957 68.381 50.77C66.127 46.8444 65 42.2544 65 37C65 31.806 66.1575 27.2462 68.4724 23.3206C70.7874 19.3345 73.9552 16.2846 77.9759 14.1707C81.9966 12.0569 86.5046 11 91.5 11C96.4954 11 101.003 12.0569 105.024 14.1707C109.045 16.2846 112.213 19.3345 114.528 23.3206C116.843 27.2462 118 31.806 118 37C118 42.194 116.812 46.784 114.436 50.77C112.121 54.6957 108.923 57.7155 104.841 59.8293C100.821 61.9431 96.3126 63 91.3172 63ZM91.3172 49.5923C94.3023 49.5923 96.8305 48.5052 98.9017 46.331C101.034 44.1568 102.1 41.0465 102.1 37C102.1 32.9535 101.064 29.8432 98.9931 27.669C96.9828 25.4948 94.4851 24.4077 91.5 24.4077C88.454 24.4077 85.9259 25.4948 83.9155 27.669C81.9052 29.7828 80.9 32.8932 80.9 37C80.9 41.0465 81.8747 44.1568 83.8241 46.331C85.8345 48.5052 88.3322 49.5923 91.3172 49.5923Z" fill="#2ABFC4"/><path d="M43.3836 20.2657C42.2735 18.2098 40.6667 16.6531 38.5632 15.5958C36.5182 14.4797 34.0934 13.9217 31.2888 13.9217C26.4392 13.9217 22.5536 15.5371 19.6322 18.7678C16.7107 21.9399 15.25 26.1986 15.25 31.5441C15.25 37.242 16.7692 41.7063 19.8075 44.9371C22.9042 48.1091 27.1403 49.6951 32.5158 49.6951C36.1968 49.6951 39.2936 48.7552 41.806 46.8755C44.3769 44.9958 46.2466 42.2937 47.4152 38.7692H28.3966V27.6671H61V41.6769C59.8898 45.4364 57.9909 48.9315 55.3032 52.1622C52.6738 55.393 49.3142 58.007 45.2241 60.0042C41.1341 62.0014 36.5182 63 31.3764 63C25.2998 63 19.8659 61.6783 15.0747 59.035C10.342 56.3329 6.6317 52.6028 3.94397 47.8448C1.31466 43.0867 0 37.6531 0 31.5441C0 25.435 1.31466 20.0014 3.94397 15.2434C6.6317 10.4266 10.342 6.6965 15.0747 4.05315C19.8075 1.35105 25.2122 0 31.2888 0C38.6509 0 44.8443 1.79161 49.8693 5.37482C54.9526 8.95804 58.3123 13.9217 59.9483 20.2657H43.3836Z" fill="#2ABFC4"/><path d="M58 71V125.415H41.0845V118.004C39.3699 120.409 37.0288 122.359 34.0612 123.855C31.1595 125.285 27.9281 126 24.3669 126C20.1463 126 16.4203 125.09 13.1888 123.27C9.95743 121.384 7.45144 118.686 5.67086 115.176C3.89029 111.665 3 107.537 3 102.791V71H19.8165V100.548C19.8165 104.189 20.7728 107.017 22.6853 109.032C24.5977 111.047 27.1697 112.055 30.4011 112.055C33.6984 112.055 36.3034 111.047 38.2158 109.032C40.1283 107.017 41.0845 104.189 41.0845 100.548V71H58Z" fill="#F6F8EA"/><path d="M76.8387 78.47C78.4799 75.9387 80.7448 73.8942 83.6333 72.3365C86.5218 70.7788 89.9027 70 93.7759 70C98.3056 70 102.409 71.1358 106.085 73.4075C109.761 75.6791 112.65 78.9243 114.75 83.143C116.917 87.3618 118 92.262 118 97.8438C118 103.425 116.917 108.358 114.75 112.642C112.65 116.861 109.761 120.138 106.085 122.475C102.409 124.746 98.3056 125.882 93.7759 125.882C89.9683 125.882 86.5874 125.103 83.6333 123.546C80.7448 121.988 78.4799 119.976 76.8387 117.51V151H60V70.7788H76.8387V78.47ZM100.866 97.8438C100.866 93.6899 99.6842 90.4447 97.3209 88.1082C95.0232 85.7067 92.1675 84.506 88.7538 84.506C85.4058 84.506 82.5501 85.7067 80.1868 88.1082C77.8891 90.5096 76.7402 93.7873 76.7402 97.9411C76.7402 102.095 77.8891 105.373 80.1868 107.774C82.5501 110.175 85.4058 111.376 88.7538 111.376C92.1019 111.376 94.9576 110.175 97.3209 107.774C99.6842 105.308 100.866 101.998 100.866 97.8438Z" fill="#F6F8EA"/></svg></div><div class="markdown-container"><div class="markdown-header"><span class="markdown-path">{{.Path}}</span><a href="?raw=1" class="markdown-raw">Raw</a></div>{{if .TOC}}<nav class="markdown-toc"><ul>{{range .TOC}}<li class="toc-{{.Level}}"><a href="#{{.ID}}">{{.Text}}</a></li>{{end}}</ul></nav>{{end}}<article class="markdown-body">{{.Content}}</article></div><div class="footer"><a href="{{.FooterLink}}" target="_blank" class="footer-link">Powered by GoUp</a></div></div></body></html>`
 )
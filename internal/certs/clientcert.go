@@ -0,0 +1,201 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientCertInfo describes the TLS client certificate of a request. It is
+// attached to the request context for plugins and handlers, and forwarded to
+// upstreams in headers.
+type ClientCertInfo struct {
+	Subject     string    `json:"subject"`
+	CommonName  string    `json:"common_name"`
+	SANs        []string  `json:"sans"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	Fingerprint string    `json:"fingerprint"` // hex SHA-256 of the DER certificate
+	NotAfter    time.Time `json:"not_after"`
+	// Verified is true when the certificate chained to the site's CA bundle.
+	Verified bool `json:"verified"`
+}
+
+type clientCertKey struct{}
+
+// NewClientCertInfo extracts the details of a client certificate.
+func NewClientCertInfo(cert *x509.Certificate, verified bool) *ClientCertInfo {
+	return &ClientCertInfo{
+		Subject:     cert.Subject.String(),
+		CommonName:  cert.Subject.CommonName,
+		SANs:        certSANs(cert),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.Text(16),
+		Fingerprint: certFingerprint(cert.Raw),
+		NotAfter:    cert.NotAfter,
+		Verified:    verified,
+	}
+}
+
+// WithClientCert returns a context carrying info.
+func WithClientCert(ctx context.Context, info *ClientCertInfo) context.Context {
+	return context.WithValue(ctx, clientCertKey{}, info)
+}
+
+// ClientCert returns the client certificate details attached to the request,
+// or nil when the client did not present a verified one.
+func ClientCert(r *http.Request) *ClientCertInfo {
+	info, _ := r.Context().Value(clientCertKey{}).(*ClientCertInfo)
+	return info
+}
+
+// certSANs lists the DNS, email, IP and URI subject alternative names.
+func certSANs(cert *x509.Certificate) []string {
+	var sans []string
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return sans
+}
+
+// ClientCertPolicy restricts which verified client certificates a site
+// accepts, beyond chaining to its CA bundle.
+type ClientCertPolicy struct {
+	AllowedSubjects []string
+	AllowedSANs     []string
+	CRL             *CRL
+}
+
+// Check rejects a certificate that is revoked or matches none of the allowed
+// patterns. With no patterns configured every certificate passes.
+func (p *ClientCertPolicy) Check(cert *x509.Certificate) error {
+	if p.CRL != nil {
+		if err := p.CRL.Check(cert); err != nil {
+			return err
+		}
+	}
+	if len(p.AllowedSubjects) == 0 && len(p.AllowedSANs) == 0 {
+		return nil
+	}
+	for _, pat := range p.AllowedSubjects {
+		if globMatch(pat, cert.Subject.CommonName) || globMatch(pat, cert.Subject.String()) {
+			return nil
+		}
+	}
+	for _, pat := range p.AllowedSANs {
+		for _, san := range certSANs(cert) {
+			if globMatch(pat, san) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("client certificate %q is not allowed", cert.Subject.String())
+}
+
+// globMatch matches s against a path.Match pattern, case-insensitively.
+func globMatch(pattern, s string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(s))
+	return err == nil && ok
+}
+
+// crlRecheckInterval bounds how often the CRL file is checked for changes.
+const crlRecheckInterval = 30 * time.Second
+
+// CRL is a certificate revocation list loaded from a PEM or DER file and
+// reloaded when the file changes.
+type CRL struct {
+	file string
+
+	mu        sync.RWMutex
+	issuer    []byte          // raw subject of the CRL issuer
+	revoked   map[string]bool // serial numbers, hex
+	stamp     string
+	checkedAt time.Time
+}
+
+// LoadCRL parses a CRL file.
+func LoadCRL(file string) (*CRL, error) {
+	c := &CRL{file: file}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *CRL) load() error {
+	stamp := fileStamp(c.file)
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("parsing CRL %s: %w", c.file, err)
+	}
+	revoked := make(map[string]bool, len(list.RevokedCertificateEntries))
+	for _, e := range list.RevokedCertificateEntries {
+		revoked[e.SerialNumber.Text(16)] = true
+	}
+	c.mu.Lock()
+	c.issuer = list.RawIssuer
+	c.revoked = revoked
+	c.stamp = stamp
+	c.checkedAt = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// Check returns an error when cert is listed as revoked. Certificates from
+// another issuer are not covered by the list and pass.
+func (c *CRL) Check(cert *x509.Certificate) error {
+	c.mu.RLock()
+	stale := time.Since(c.checkedAt) > crlRecheckInterval
+	c.mu.RUnlock()
+	if stale {
+		c.mu.Lock()
+		c.checkedAt = time.Now()
+		changed := fileStamp(c.file) != c.stamp
+		c.mu.Unlock()
+		if changed {
+			// A broken update keeps the previous list in force.
+			_ = c.load()
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if bytes.Equal(cert.RawIssuer, c.issuer) && c.revoked[cert.SerialNumber.Text(16)] {
+		return errors.New("client certificate is revoked")
+	}
+	return nil
+}
+
+// LoadCertPool reads a PEM bundle into a certificate pool.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", file)
+	}
+	return pool, nil
+}
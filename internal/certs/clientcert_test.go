@@ -0,0 +1,99 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCertPolicy(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/billing")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		DNSNames: []string{"alice.clients.example.com"},
+		URIs:     []*url.URL{spiffe},
+	}
+	cases := []struct {
+		name   string
+		policy ClientCertPolicy
+		ok     bool
+	}{
+		{"no patterns", ClientCertPolicy{}, true},
+		{"subject common name", ClientCertPolicy{AllowedSubjects: []string{"ALICE"}}, true},
+		{"subject distinguished name", ClientCertPolicy{AllowedSubjects: []string{"CN=alice,O=*"}}, true},
+		{"dns san", ClientCertPolicy{AllowedSANs: []string{"*.clients.example.com"}}, true},
+		{"uri san", ClientCertPolicy{AllowedSANs: []string{"spiffe://example.org/*"}}, true},
+		{"no match", ClientCertPolicy{AllowedSubjects: []string{"bob"}, AllowedSANs: []string{"*.example.net"}}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.policy.Check(cert)
+			if c.ok && err != nil {
+				t.Errorf("expected certificate to pass, got %v", err)
+			}
+			if !c.ok && err == nil {
+				t.Error("expected certificate to be rejected")
+			}
+		})
+	}
+}
+
+func TestCRL(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now(),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(42), RevocationTime: time.Now()}},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	crl, err := LoadCRL(file)
+	if err != nil {
+		t.Fatalf("LoadCRL: %v", err)
+	}
+
+	revoked := &x509.Certificate{SerialNumber: big.NewInt(42), RawIssuer: ca.RawSubject}
+	if crl.Check(revoked) == nil {
+		t.Error("revoked certificate passed the CRL")
+	}
+	if err := crl.Check(&x509.Certificate{SerialNumber: big.NewInt(43), RawIssuer: ca.RawSubject}); err != nil {
+		t.Errorf("valid certificate rejected: %v", err)
+	}
+	// The same serial from another issuer is not covered by this list.
+	if err := crl.Check(&x509.Certificate{SerialNumber: big.NewInt(42), RawIssuer: []byte("other")}); err != nil {
+		t.Errorf("certificate from another issuer rejected: %v", err)
+	}
+}
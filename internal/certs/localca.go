@@ -0,0 +1,203 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File names of the local development CA inside its directory.
+const (
+	LocalCACertFile = "rootCA.pem"
+	LocalCAKeyFile  = "rootCA-key.pem"
+)
+
+const (
+	localCALifetime   = 10 * 365 * 24 * time.Hour
+	localLeafLifetime = 7 * 24 * time.Hour
+	// localLeafRenewBefore re-issues a cached leaf that is about to expire.
+	localLeafRenewBefore = 24 * time.Hour
+)
+
+// LocalCA is a root certificate authority persisted on disk that signs
+// short-lived leaf certificates for local development hosts (*.localhost,
+// *.test, ...). Installing it in a trust store is left to the user.
+type LocalCA struct {
+	CertFile string
+	cert     *x509.Certificate
+	key      crypto.Signer
+}
+
+// LoadOrCreateLocalCA loads the CA stored in dir, creating it first when it
+// does not exist yet. created reports whether a new CA was generated.
+func LoadOrCreateLocalCA(dir string) (ca *LocalCA, created bool, err error) {
+	certFile := filepath.Join(dir, LocalCACertFile)
+	keyFile := filepath.Join(dir, LocalCAKeyFile)
+	if _, err := os.Stat(certFile); errors.Is(err, os.ErrNotExist) {
+		if err := createLocalCA(certFile, keyFile); err != nil {
+			return nil, false, err
+		}
+		created = true
+	}
+	ca, err = loadLocalCA(certFile, keyFile)
+	return ca, created, err
+}
+
+func createLocalCA(certFile, keyFile string) error {
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"GoUp local development CA"},
+			CommonName:   "GoUp Local CA " + host,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(localCALifetime),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// The key is written first so a crash never leaves a certificate
+	// without its key.
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+func loadLocalCA(certFile, keyFile string) (*LocalCA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading local CA: %w", err)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok || !pair.Leaf.IsCA {
+		return nil, fmt.Errorf("%s is not a usable CA certificate", certFile)
+	}
+	return &LocalCA{CertFile: certFile, cert: pair.Leaf, key: signer}, nil
+}
+
+// LocalIssuer issues and caches leaf certificates signed by a LocalCA for the
+// hosts accepted by its policy.
+type LocalIssuer struct {
+	ca     *LocalCA
+	policy func(host string) bool
+	// fallback is used for handshakes without SNI.
+	fallback string
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// Issuer returns a LocalIssuer serving the hosts for which policy returns
+// true. Handshakes without SNI get a certificate for fallback, when set.
+func (ca *LocalCA) Issuer(policy func(host string) bool, fallback string) *LocalIssuer {
+	return &LocalIssuer{ca: ca, policy: policy, fallback: fallback, leaves: make(map[string]*tls.Certificate)}
+}
+
+// Matches reports whether the issuer serves host.
+func (li *LocalIssuer) Matches(host string) bool {
+	return host != "" && li.policy(strings.ToLower(strings.TrimSuffix(host, ".")))
+}
+
+// GetCertificate implements tls.Config.GetCertificate, issuing a leaf for
+// the SNI on first use and again shortly before it expires.
+func (li *LocalIssuer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if host == "" {
+		host = li.fallback
+	} else if !li.policy(host) {
+		return nil, fmt.Errorf("local CA: host %q not allowed", host)
+	}
+	if host == "" {
+		return nil, errors.New("local CA: missing server name")
+	}
+
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	if cert := li.leaves[host]; cert != nil && time.Until(cert.Leaf.NotAfter) > localLeafRenewBefore {
+		return cert, nil
+	}
+	cert, err := li.ca.issue(host)
+	if err != nil {
+		return nil, err
+	}
+	li.leaves[host] = cert
+	return cert, nil
+}
+
+// issue signs a leaf certificate for host.
+func (ca *LocalCA) issue(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"GoUp local development certificate"}, CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(localLeafLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,44 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestLocalCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, created, err := LoadOrCreateLocalCA(dir)
+	if err != nil || !created {
+		t.Fatalf("LoadOrCreateLocalCA: created=%v err=%v", created, err)
+	}
+	again, created, err := LoadOrCreateLocalCA(dir)
+	if err != nil || created {
+		t.Fatalf("second LoadOrCreateLocalCA: created=%v err=%v", created, err)
+	}
+	if !again.cert.Equal(ca.cert) {
+		t.Fatal("the persisted CA was not reused")
+	}
+
+	issuer := ca.Issuer(func(host string) bool { return strings.HasSuffix(host, ".localhost") }, "app.localhost")
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	cert, err := issuer.GetCertificate(&tls.ClientHelloInfo{ServerName: "App.localhost."})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "app.localhost", Roots: roots}); err != nil {
+		t.Errorf("leaf does not verify against the local CA: %v", err)
+	}
+	if cached, _ := issuer.GetCertificate(&tls.ClientHelloInfo{ServerName: "app.localhost"}); cached != cert {
+		t.Error("leaf was re-issued instead of cached")
+	}
+	if noSNI, err := issuer.GetCertificate(&tls.ClientHelloInfo{}); err != nil || noSNI != cert {
+		t.Errorf("handshake without SNI did not get the fallback host: %v", err)
+	}
+	if _, err := issuer.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"}); err == nil {
+		t.Error("issued a certificate for a host outside the policy")
+	}
+}
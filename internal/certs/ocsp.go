@@ -0,0 +1,360 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// ocspCheckInterval is how often the stapler looks for responses due for
+	// a refresh.
+	ocspCheckInterval = time.Minute
+	// ocspRetryInterval is the back-off after a failed fetch.
+	ocspRetryInterval = 10 * time.Minute
+	// ocspDefaultRefresh applies when a responder omits NextUpdate.
+	ocspDefaultRefresh = time.Hour
+	// ocspIdleExpiry drops certificates no handshake asked for in this long
+	// (e.g. replaced by a reload or renewal).
+	ocspIdleExpiry = 48 * time.Hour
+	// ocspMaxResponseSize bounds the responder's reply.
+	ocspMaxResponseSize = 1 << 20
+)
+
+// OCSP statuses reported by OCSPStapler.Status.
+const (
+	OCSPStatusGood        = "good"
+	OCSPStatusRevoked     = "revoked"
+	OCSPStatusUnknown     = "unknown"
+	OCSPStatusUnreachable = "unreachable"
+	OCSPStatusPending     = "pending"
+)
+
+// OCSPStapler fetches, caches and staples OCSP responses for the certificates
+// handed out by GetCertificate callbacks. Responses are refreshed halfway
+// through their validity (well before NextUpdate) and persisted to disk, so a
+// restart can staple immediately. Certificates without an OCSP responder or
+// an issuer in their chain are served unchanged.
+type OCSPStapler struct {
+	dir string
+	lg  *logger.Logger
+	// Client performs responder requests; tests point it at a local stand-in.
+	Client *http.Client
+
+	mu      sync.RWMutex
+	entries map[string]*ocspEntry
+
+	startOnce sync.Once
+}
+
+type ocspEntry struct {
+	name   string
+	leaf   *x509.Certificate
+	issuer *x509.Certificate
+	cert   *tls.Certificate
+
+	// stapled is cert with the current good response attached (nil when
+	// there is none); validUntil is that response's NextUpdate (unix nanos).
+	stapled    atomic.Pointer[tls.Certificate]
+	validUntil atomic.Int64
+	lastUsed   atomic.Int64
+	fetching   atomic.Bool
+
+	mu          sync.Mutex
+	status      string
+	nextRefresh time.Time
+	lastErr     string
+}
+
+// NewOCSPStapler returns a stapler persisting responses under dir (empty
+// disables persistence).
+func NewOCSPStapler(dir string, lg *logger.Logger) *OCSPStapler {
+	return &OCSPStapler{
+		dir:     dir,
+		lg:      lg,
+		Client:  &http.Client{Timeout: 15 * time.Second},
+		entries: make(map[string]*ocspEntry),
+	}
+}
+
+// Wrap returns a GetCertificate callback that staples the certificates
+// returned by get.
+func (s *OCSPStapler) Wrap(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.startOnce.Do(func() { go s.refreshLoop() })
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if err != nil || cert == nil {
+			return cert, err
+		}
+		return s.Staple(cert), nil
+	}
+}
+
+// Staple returns cert with a valid OCSP response attached when one is
+// available, starting to track the certificate on first sight.
+func (s *OCSPStapler) Staple(cert *tls.Certificate) *tls.Certificate {
+	if len(cert.Certificate) < 2 {
+		// Self-signed or chain without issuer: nothing to ask a responder.
+		return cert
+	}
+	key := certFingerprint(cert.Certificate[0])
+
+	s.mu.RLock()
+	e := s.entries[key]
+	s.mu.RUnlock()
+	if e == nil {
+		if e = s.track(key, cert); e == nil {
+			return cert
+		}
+	}
+
+	now := time.Now()
+	e.lastUsed.Store(now.UnixNano())
+	if st := e.stapled.Load(); st != nil && now.UnixNano() < e.validUntil.Load() {
+		return st
+	}
+	return cert
+}
+
+// Status reports the OCSP state of a certificate (by DER) and the last
+// error, or empty strings when it is not tracked.
+func (s *OCSPStapler) Status(leafDER []byte) (status string, lastErr string) {
+	s.mu.RLock()
+	e := s.entries[certFingerprint(leafDER)]
+	s.mu.RUnlock()
+	if e == nil {
+		return "", ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status, e.lastErr
+}
+
+// track registers a certificate, loads its persisted response and schedules
+// a fetch. It returns nil for certificates that cannot be stapled.
+func (s *OCSPStapler) track(key string, cert *tls.Certificate) *ocspEntry {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil
+		}
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil
+	}
+
+	name := leaf.Subject.CommonName
+	if len(leaf.DNSNames) > 0 {
+		name = leaf.DNSNames[0]
+	}
+	e := &ocspEntry{name: name, leaf: leaf, issuer: issuer, cert: cert, status: OCSPStatusPending}
+	e.lastUsed.Store(time.Now().UnixNano())
+
+	s.mu.Lock()
+	if existing := s.entries[key]; existing != nil {
+		s.mu.Unlock()
+		return existing
+	}
+	s.entries[key] = e
+	s.mu.Unlock()
+
+	if raw, err := s.load(key); err == nil {
+		if resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer); err == nil {
+			s.apply(e, raw, resp)
+		}
+	}
+	e.mu.Lock()
+	due := !time.Now().Before(e.nextRefresh)
+	e.mu.Unlock()
+	if due {
+		s.fetchAsync(key, e)
+	}
+	return e
+}
+
+// refreshLoop refreshes due responses and forgets idle certificates.
+func (s *OCSPStapler) refreshLoop() {
+	ticker := time.NewTicker(ocspCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		s.mu.Lock()
+		for key, e := range s.entries {
+			if now.Sub(time.Unix(0, e.lastUsed.Load())) > ocspIdleExpiry {
+				delete(s.entries, key)
+				continue
+			}
+			e.mu.Lock()
+			due := !now.Before(e.nextRefresh)
+			e.mu.Unlock()
+			if due {
+				s.fetchAsync(key, e)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *OCSPStapler) fetchAsync(key string, e *ocspEntry) {
+	if !e.fetching.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer e.fetching.Store(false)
+		s.refresh(key, e)
+	}()
+}
+
+// refresh queries the responder once and updates the entry.
+func (s *OCSPStapler) refresh(key string, e *ocspEntry) {
+	raw, resp, err := s.fetch(e)
+	if err != nil {
+		e.mu.Lock()
+		e.status = OCSPStatusUnreachable
+		e.lastErr = err.Error()
+		e.nextRefresh = time.Now().Add(ocspRetryInterval)
+		e.mu.Unlock()
+		if s.lg != nil {
+			s.lg.Warnf("OCSP responder unreachable for %s: %v", e.name, err)
+		}
+		return
+	}
+
+	s.apply(e, raw, resp)
+	switch resp.Status {
+	case ocsp.Good:
+		if err := s.save(key, raw); err != nil && s.lg != nil {
+			s.lg.Warnf("OCSP: persisting response for %s: %v", e.name, err)
+		}
+	case ocsp.Revoked:
+		if s.lg != nil {
+			s.lg.Errorf("OCSP: certificate for %s (serial %x) is REVOKED since %s", e.name, e.leaf.SerialNumber, resp.RevokedAt.Format(time.RFC3339))
+		}
+	default:
+		if s.lg != nil {
+			s.lg.Warnf("OCSP: responder does not know the certificate for %s (serial %x)", e.name, e.leaf.SerialNumber)
+		}
+	}
+}
+
+// apply records a parsed response and, when it is good and current, staples
+// it.
+func (s *OCSPStapler) apply(e *ocspEntry, raw []byte, resp *ocsp.Response) {
+	now := time.Now()
+	next := now.Add(ocspDefaultRefresh)
+	if !resp.NextUpdate.IsZero() {
+		// Refresh halfway through the validity window.
+		next = resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+		if next.Before(now) {
+			next = now
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextRefresh = next
+	e.lastErr = ""
+	switch resp.Status {
+	case ocsp.Good:
+		e.status = OCSPStatusGood
+		if !resp.NextUpdate.IsZero() && !now.Before(resp.NextUpdate) {
+			// Expired (e.g. a stale persisted copy): fetch a new one now.
+			e.nextRefresh = now
+			return
+		}
+		stapled := *e.cert
+		stapled.OCSPStaple = raw
+		validUntil := resp.NextUpdate
+		if validUntil.IsZero() {
+			validUntil = now.Add(ocspDefaultRefresh * 2)
+		}
+		e.validUntil.Store(validUntil.UnixNano())
+		e.stapled.Store(&stapled)
+	case ocsp.Revoked:
+		e.status = OCSPStatusRevoked
+		e.stapled.Store(nil)
+	default:
+		e.status = OCSPStatusUnknown
+		e.stapled.Store(nil)
+	}
+}
+
+// fetch POSTs an OCSP request to the first responder of the certificate.
+func (s *OCSPStapler) fetch(e *ocspEntry) ([]byte, *ocsp.Response, error) {
+	reqDER, err := ocsp.CreateRequest(e.leaf, e.issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.leaf.OCSPServer[0], bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	httpResp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("responder returned HTTP %d", httpResp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, e.leaf, e.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return raw, resp, nil
+}
+
+func (s *OCSPStapler) load(key string) ([]byte, error) {
+	if s.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(s.dir, key+".ocsp"))
+}
+
+func (s *OCSPStapler) save(key string, raw []byte) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, key+".ocsp.tmp")
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, key+".ocsp"))
+}
+
+// certFingerprint is the hex SHA-256 of a DER certificate.
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
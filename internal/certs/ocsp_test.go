@@ -0,0 +1,180 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testOCSPResponder is a local OCSP responder stand-in answering for every
+// certificate issued by its CA with the configured status.
+type testOCSPResponder struct {
+	*httptest.Server
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	status atomic.Int64
+	hits   atomic.Int64
+}
+
+func newTestOCSPResponder(t *testing.T) *testOCSPResponder {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "GoUp Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+
+	r := &testOCSPResponder{ca: ca, caKey: caKey}
+	r.status.Store(int64(ocsp.Good))
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.hits.Add(1)
+		body, _ := io.ReadAll(req.Body)
+		ocspReq, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		now := time.Now()
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       int(r.status.Load()),
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now.Add(-time.Minute),
+		}, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// issue returns a leaf certificate (with the CA in its chain) pointing at the
+// responder.
+func (r *testOCSPResponder) issue(t *testing.T, serial int64) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(12 * time.Hour),
+		OCSPServer:   []string{r.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, r.ca, &key.PublicKey, r.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return &tls.Certificate{Certificate: [][]byte{der, r.ca.Raw}, PrivateKey: key, Leaf: leaf}
+}
+
+func waitForStatus(t *testing.T, s *OCSPStapler, cert *tls.Certificate, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.Staple(cert)
+		if status, _ := s.Status(cert.Certificate[0]); status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			status, lastErr := s.Status(cert.Certificate[0])
+			t.Fatalf("status %q (err %q), want %q", status, lastErr, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOCSPStaplerGood(t *testing.T) {
+	responder := newTestOCSPResponder(t)
+	dir := t.TempDir()
+	s := NewOCSPStapler(dir, nil)
+	cert := responder.issue(t, 100)
+
+	waitForStatus(t, s, cert, OCSPStatusGood)
+	stapled := s.Staple(cert)
+	if len(stapled.OCSPStaple) == 0 {
+		t.Fatal("expected an OCSP staple")
+	}
+	resp, err := ocsp.ParseResponseForCert(stapled.OCSPStaple, cert.Leaf, responder.ca)
+	if err != nil || resp.Status != ocsp.Good {
+		t.Fatalf("unexpected staple: %v, %v", resp, err)
+	}
+	if len(cert.OCSPStaple) != 0 {
+		t.Fatal("the original certificate must not be mutated")
+	}
+
+	// A new stapler (e.g. after a restart) staples the persisted response
+	// right away, without asking the responder.
+	responder.Close()
+	restarted := NewOCSPStapler(dir, nil)
+	if got := restarted.Staple(cert); len(got.OCSPStaple) == 0 {
+		t.Fatal("expected the persisted response to be stapled")
+	}
+}
+
+func TestOCSPStaplerRevoked(t *testing.T) {
+	responder := newTestOCSPResponder(t)
+	responder.status.Store(int64(ocsp.Revoked))
+	s := NewOCSPStapler("", nil)
+	cert := responder.issue(t, 101)
+
+	waitForStatus(t, s, cert, OCSPStatusRevoked)
+	if got := s.Staple(cert); len(got.OCSPStaple) != 0 {
+		t.Fatal("a revoked response must not be stapled")
+	}
+}
+
+func TestOCSPStaplerUnreachable(t *testing.T) {
+	responder := newTestOCSPResponder(t)
+	cert := responder.issue(t, 102)
+	responder.Close()
+
+	s := NewOCSPStapler("", nil)
+	waitForStatus(t, s, cert, OCSPStatusUnreachable)
+	if got := s.Staple(cert); len(got.OCSPStaple) != 0 {
+		t.Fatal("no staple expected without a response")
+	}
+}
+
+func TestOCSPStaplerSkipsUnstaplable(t *testing.T) {
+	s := NewOCSPStapler("", nil)
+	selfSigned := &tls.Certificate{Certificate: [][]byte{{0x30}}}
+	if got := s.Staple(selfSigned); got != selfSigned {
+		t.Fatal("certificates without an issuer must be returned unchanged")
+	}
+}
@@ -0,0 +1,202 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate sources recorded in the inventory.
+const (
+	SourceStatic   = "static"
+	SourceACME     = "acme"
+	SourceInternal = "internal"
+)
+
+// Certificate states reported by the inventory.
+const (
+	StatusPending  = "pending" // no certificate obtained or loaded yet
+	StatusValid    = "valid"
+	StatusExpiring = "expiring" // valid, but within ExpiryWarning of its end
+	StatusExpired  = "expired"
+	StatusError    = "error" // no usable certificate and the last attempt failed
+)
+
+// ExpiryWarning is how close to its expiry a certificate is flagged as
+// expiring. It is shorter than the 30-day ACME renewal window, so an ACME
+// certificate only gets there when renewals keep failing.
+const ExpiryWarning = 14 * 24 * time.Hour
+
+// Errors returned by Renew.
+var (
+	ErrUnknownCertificate = errors.New("no certificate is configured for this domain")
+	ErrNotRenewable       = errors.New("certificate is not managed by ACME")
+)
+
+// CertificateInfo is an inventory entry: the certificate served for a
+// configured domain and the state of its renewals.
+type CertificateInfo struct {
+	Domain      string    `json:"domain"`
+	Source      string    `json:"source"`
+	Challenge   string    `json:"challenge,omitempty"`
+	Status      string    `json:"status"`
+	Subject     string    `json:"subject,omitempty"`
+	SANs        []string  `json:"sans,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	Serial      string    `json:"serial,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	NotBefore   time.Time `json:"not_before,omitzero"`
+	NotAfter    time.Time `json:"not_after,omitzero"`
+	DaysLeft    int       `json:"days_left"`
+	Renewable   bool      `json:"renewable"`
+	// LastRenewal is when a different certificate replaced the previous one
+	// (renewal, reload) while the process was running.
+	LastRenewal time.Time `json:"last_renewal,omitzero"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitzero"`
+}
+
+type inventoryEntry struct {
+	info    CertificateInfo
+	raw     []byte // DER of the observed leaf
+	renewer func(context.Context) error
+}
+
+var (
+	inventory   = make(map[string]*inventoryEntry)
+	inventoryMu sync.RWMutex
+)
+
+// Track adds a configured domain to the inventory, before any certificate
+// is known for it.
+func Track(domain, source, challenge string) {
+	domain = strings.ToLower(domain)
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	e := inventory[domain]
+	if e == nil {
+		e = &inventoryEntry{}
+		inventory[domain] = e
+	}
+	e.info.Domain = domain
+	e.info.Source = source
+	e.info.Challenge = challenge
+}
+
+// Observe records the certificate currently served for domain. It is cheap
+// when the certificate did not change, so it can run on every handshake.
+func Observe(domain string, cert *tls.Certificate) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return
+	}
+	domain = strings.ToLower(domain)
+	inventoryMu.RLock()
+	e := inventory[domain]
+	same := e != nil && bytes.Equal(e.raw, cert.Certificate[0])
+	inventoryMu.RUnlock()
+	if e == nil || same {
+		return
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return
+		}
+	}
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	if e.raw != nil {
+		e.info.LastRenewal = time.Now()
+	}
+	e.raw = cert.Certificate[0]
+	e.info.Subject = leaf.Subject.String()
+	e.info.SANs = certSANs(leaf)
+	e.info.Issuer = leaf.Issuer.String()
+	e.info.Serial = leaf.SerialNumber.Text(16)
+	e.info.Fingerprint = certFingerprint(leaf.Raw)
+	e.info.NotBefore = leaf.NotBefore
+	e.info.NotAfter = leaf.NotAfter
+	e.info.LastError = ""
+	e.info.LastErrorAt = time.Time{}
+}
+
+// RecordError records a failed load, issuance or renewal for domain.
+func RecordError(domain string, err error) {
+	if err == nil {
+		return
+	}
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	if e := inventory[strings.ToLower(domain)]; e != nil {
+		e.info.LastError = err.Error()
+		e.info.LastErrorAt = time.Now()
+	}
+}
+
+// RegisterRenewer makes the certificate of domain renewable on demand.
+func RegisterRenewer(domain string, renew func(context.Context) error) {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+	if e := inventory[strings.ToLower(domain)]; e != nil {
+		e.renewer = renew
+		e.info.Renewable = true
+	}
+}
+
+// Renew forces a new certificate for domain, regardless of the current
+// one's expiry. Failures are recorded in the inventory.
+func Renew(ctx context.Context, domain string) error {
+	inventoryMu.RLock()
+	e := inventory[strings.ToLower(domain)]
+	inventoryMu.RUnlock()
+	if e == nil {
+		return ErrUnknownCertificate
+	}
+	if e.renewer == nil {
+		return ErrNotRenewable
+	}
+	err := e.renewer(ctx)
+	RecordError(domain, err)
+	return err
+}
+
+// Inventory returns every tracked certificate, sorted by domain.
+func Inventory() []CertificateInfo {
+	now := time.Now()
+	inventoryMu.RLock()
+	list := make([]CertificateInfo, 0, len(inventory))
+	for _, e := range inventory {
+		info := e.info
+		info.SANs = slices.Clone(info.SANs)
+		info.Status = certificateStatus(info, now)
+		if !info.NotAfter.IsZero() {
+			info.DaysLeft = int(info.NotAfter.Sub(now).Hours() / 24)
+		}
+		list = append(list, info)
+	}
+	inventoryMu.RUnlock()
+	slices.SortFunc(list, func(a, b CertificateInfo) int { return strings.Compare(a.Domain, b.Domain) })
+	return list
+}
+
+func certificateStatus(info CertificateInfo, now time.Time) string {
+	switch {
+	case info.NotAfter.IsZero() && info.LastError != "":
+		return StatusError
+	case info.NotAfter.IsZero():
+		return StatusPending
+	case !now.Before(info.NotAfter):
+		return StatusExpired
+	case info.NotAfter.Sub(now) < ExpiryWarning:
+		return StatusExpiring
+	}
+	return StatusValid
+}
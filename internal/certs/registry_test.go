@@ -0,0 +1,94 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func inventoryEntryFor(t *testing.T, domain string) CertificateInfo {
+	t.Helper()
+	for _, info := range Inventory() {
+		if info.Domain == domain {
+			return info
+		}
+	}
+	t.Fatalf("%s is not in the inventory", domain)
+	return CertificateInfo{}
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, "inventory.example.com", 1, time.Now().Add(90*24*time.Hour))
+
+	Track("pending.example.com", SourceACME, "dns-01")
+	if info := inventoryEntryFor(t, "pending.example.com"); info.Status != StatusPending {
+		t.Errorf("untouched ACME host status = %q, want pending", info.Status)
+	}
+	RecordError("pending.example.com", errors.New("rate limited"))
+	if info := inventoryEntryFor(t, "pending.example.com"); info.Status != StatusError || info.LastError != "rate limited" {
+		t.Errorf("failed ACME host = %+v", info)
+	}
+
+	// Static certificates are recorded when loaded.
+	s := NewStaticStore(nil)
+	if err := s.Add("inventory.example.com", certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	info := inventoryEntryFor(t, "inventory.example.com")
+	if info.Status != StatusValid || info.Source != SourceStatic || info.DaysLeft < 88 || len(info.SANs) != 1 {
+		t.Errorf("static certificate = %+v", info)
+	}
+	if info.Renewable {
+		t.Error("static certificate reported as renewable")
+	}
+	if err := Renew(context.Background(), "inventory.example.com"); !errors.Is(err, ErrNotRenewable) {
+		t.Errorf("Renew(static) = %v, want ErrNotRenewable", err)
+	}
+	if err := Renew(context.Background(), "unknown.example.com"); !errors.Is(err, ErrUnknownCertificate) {
+		t.Errorf("Renew(unknown) = %v, want ErrUnknownCertificate", err)
+	}
+
+	// A replacement close to expiry is flagged and counts as a renewal.
+	writeSelfSigned(t, certFile, keyFile, "inventory.example.com", 2, time.Now().Add(5*24*time.Hour))
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	info = inventoryEntryFor(t, "inventory.example.com")
+	if info.Status != StatusExpiring || info.LastRenewal.IsZero() || info.Serial != "2" {
+		t.Errorf("replaced certificate = %+v", info)
+	}
+}
+
+func TestRenewRecordsErrors(t *testing.T) {
+	Track("renew.example.com", SourceACME, "http-01")
+	calls := 0
+	RegisterRenewer("renew.example.com", func(context.Context) error {
+		calls++
+		return errors.New("challenge failed")
+	})
+	if err := Renew(context.Background(), "RENEW.example.com"); err == nil || calls != 1 {
+		t.Fatalf("Renew = %v after %d calls", err, calls)
+	}
+	info := inventoryEntryFor(t, "renew.example.com")
+	if !info.Renewable || info.LastError != "challenge failed" {
+		t.Errorf("renewal failure not recorded: %+v", info)
+	}
+
+	// Observing a certificate clears the error.
+	dir := t.TempDir()
+	writeSelfSigned(t, filepath.Join(dir, "c.pem"), filepath.Join(dir, "k.pem"), "renew.example.com", 3, time.Now().Add(time.Hour*24*60))
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "c.pem"), filepath.Join(dir, "k.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Observe("renew.example.com", &cert)
+	if info := inventoryEntryFor(t, "renew.example.com"); info.LastError != "" || info.Status != StatusValid {
+		t.Errorf("observed certificate = %+v", info)
+	}
+}
@@ -0,0 +1,223 @@
+// Package certs holds the certificate sources shared by the web servers and
+// the administrative API.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/logger"
+)
+
+// DefaultWatchInterval is how often static certificate files are polled for
+// changes.
+const DefaultWatchInterval = 10 * time.Second
+
+// StaticStore serves certificates loaded from cert/key files and reloads them
+// when the files change, so certificates renewed by an external tool
+// (certbot, Vault agent, ...) are picked up without a restart. A reload only
+// replaces a certificate once the new pair has been validated; on failure the
+// previous certificate keeps being served.
+type StaticStore struct {
+	lg *logger.Logger
+
+	mu    sync.RWMutex
+	certs []*staticCert
+
+	watchOnce sync.Once
+}
+
+type staticCert struct {
+	domain   string
+	certFile string
+	keyFile  string
+	// stamp identifies the file versions of the last load attempt.
+	stamp string
+	cert  *tls.Certificate
+}
+
+var (
+	stores   []*StaticStore
+	storesMu sync.Mutex
+)
+
+// NewStaticStore returns an empty store. Every store is registered so
+// ReloadAll can refresh it on demand.
+func NewStaticStore(lg *logger.Logger) *StaticStore {
+	s := &StaticStore{lg: lg}
+	storesMu.Lock()
+	stores = append(stores, s)
+	storesMu.Unlock()
+	return s
+}
+
+// Add loads the certificate of a site. The site is tracked even when the
+// first load fails, so fixing the files on disk brings it online.
+func (s *StaticStore) Add(domain, certFile, keyFile string) error {
+	sc := &staticCert{domain: domain, certFile: certFile, keyFile: keyFile}
+	sc.stamp = fileStamp(certFile, keyFile)
+	Track(domain, SourceStatic, "")
+	cert, err := loadKeyPair(certFile, keyFile)
+	if err == nil {
+		sc.cert = cert
+		Observe(domain, cert)
+	} else {
+		RecordError(domain, err)
+	}
+	s.mu.Lock()
+	s.certs = append(s.certs, sc)
+	s.mu.Unlock()
+	return err
+}
+
+// Len returns the number of tracked certificates.
+func (s *StaticStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.certs)
+}
+
+// Lookup returns the loaded certificate that supports the client hello, or
+// nil when none does.
+func (s *StaticStore) Lookup(hello *tls.ClientHelloInfo) *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sc := range s.certs {
+		if sc.cert != nil && hello.SupportsCertificate(sc.cert) == nil {
+			return sc.cert
+		}
+	}
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. Like a static
+// tls.Config.Certificates list, it falls back to the first certificate when
+// none matches the SNI.
+func (s *StaticStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.Lookup(hello); cert != nil {
+		return cert, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sc := range s.certs {
+		if sc.cert != nil {
+			return sc.cert, nil
+		}
+	}
+	return nil, errors.New("no static certificate available")
+}
+
+// Watch polls the certificate files every interval and reloads the ones that
+// changed. It starts at most one watcher per store.
+func (s *StaticStore) Watch(interval time.Duration) {
+	s.watchOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				s.reload(false)
+			}
+		}()
+	})
+}
+
+// Reload reloads every certificate of the store, changed or not.
+func (s *StaticStore) Reload() error {
+	return s.reload(true)
+}
+
+func (s *StaticStore) reload(force bool) error {
+	s.mu.RLock()
+	certs := append([]*staticCert(nil), s.certs...)
+	s.mu.RUnlock()
+
+	var errs error
+	for _, sc := range certs {
+		stamp := fileStamp(sc.certFile, sc.keyFile)
+		s.mu.RLock()
+		unchanged := stamp == sc.stamp
+		s.mu.RUnlock()
+		if unchanged && !force {
+			continue
+		}
+
+		cert, err := loadKeyPair(sc.certFile, sc.keyFile)
+		s.mu.Lock()
+		sc.stamp = stamp
+		if err == nil {
+			sc.cert = cert
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			RecordError(sc.domain, err)
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", sc.domain, err))
+			if s.lg != nil {
+				s.lg.Errorf("Certificate reload for %s failed, keeping the current one: %v", sc.domain, err)
+			}
+			continue
+		}
+		Observe(sc.domain, cert)
+		if s.lg != nil {
+			s.lg.Infof("Reloaded certificate for %s (expires %s)", sc.domain, cert.Leaf.NotAfter.Format(time.RFC3339))
+		}
+	}
+	return errs
+}
+
+// ReloadAll forces a reload of every registered static store, e.g. after an
+// external renewal. It returns the combined errors of the pairs that were
+// rejected.
+func ReloadAll() error {
+	storesMu.Lock()
+	all := append([]*StaticStore(nil), stores...)
+	storesMu.Unlock()
+
+	var errs error
+	for _, s := range all {
+		errs = errors.Join(errs, s.Reload())
+	}
+	return errs
+}
+
+// loadKeyPair loads and validates a certificate/key pair: the key must match
+// the certificate and the certificate must be currently valid.
+func loadKeyPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	if now.After(cert.Leaf.NotAfter) {
+		return nil, fmt.Errorf("certificate expired on %s", cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.Leaf.NotBefore) {
+		return nil, fmt.Errorf("certificate not valid before %s", cert.Leaf.NotBefore.Format(time.RFC3339))
+	}
+	return &cert, nil
+}
+
+// fileStamp summarizes the modification time and size of the given files;
+// it follows symlinks, so certbot-style "live" links are tracked as well.
+func fileStamp(paths ...string) string {
+	var stamp string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			stamp += "missing;"
+			continue
+		}
+		stamp += fmt.Sprintf("%d-%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp
+}
@@ -0,0 +1,124 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a self-signed certificate for name with the given
+// serial number, valid until notAfter.
+func writeSelfSigned(t *testing.T, certFile, keyFile, name string, serial int64, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedSerial(t *testing.T, s *StaticStore) int64 {
+	t.Helper()
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+func TestStaticStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, "example.com", 1, time.Now().Add(time.Hour))
+
+	s := NewStaticStore(nil)
+	if err := s.Add("example.com", certFile, keyFile); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if got := servedSerial(t, s); got != 1 {
+		t.Fatalf("expected serial 1, got %d", got)
+	}
+
+	// A renewed pair is swapped in.
+	writeSelfSigned(t, certFile, keyFile, "example.com", 2, time.Now().Add(time.Hour))
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got := servedSerial(t, s); got != 2 {
+		t.Fatalf("expected serial 2 after reload, got %d", got)
+	}
+
+	// A mismatched pair is rejected and the current certificate kept.
+	otherCert := filepath.Join(dir, "other.pem")
+	writeSelfSigned(t, otherCert, filepath.Join(dir, "other.key"), "example.com", 3, time.Now().Add(time.Hour))
+	if err := os.Rename(otherCert, certFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Fatal("expected a mismatched key pair to be rejected")
+	}
+	if got := servedSerial(t, s); got != 2 {
+		t.Fatalf("expected serial 2 to be kept, got %d", got)
+	}
+
+	// So is an expired certificate.
+	writeSelfSigned(t, certFile, keyFile, "example.com", 4, time.Now().Add(-time.Hour))
+	if err := s.Reload(); err == nil {
+		t.Fatal("expected an expired certificate to be rejected")
+	}
+	if got := servedSerial(t, s); got != 2 {
+		t.Fatalf("expected serial 2 to be kept, got %d", got)
+	}
+}
+
+func TestStaticStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, "example.com", 1, time.Now().Add(time.Hour))
+
+	s := NewStaticStore(nil)
+	if err := s.Add("example.com", certFile, keyFile); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s.Watch(10 * time.Millisecond)
+
+	writeSelfSigned(t, certFile, keyFile, "example.com", 2, time.Now().Add(time.Hour))
+	deadline := time.Now().Add(2 * time.Second)
+	for servedSerial(t, s) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher did not pick up the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+package certs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// CertStorage keeps ACME certificates and account keys. On top of the
+// autocert cache operations it provides named locks, which storages shared
+// between nodes use to let a single node obtain or renew a certificate.
+type CertStorage interface {
+	autocert.Cache
+	// TryLock takes the named lock until ttl elapses or Unlock is called,
+	// reporting false when another owner holds it. Taking a lock already
+	// held by this storage extends it.
+	TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error)
+	// Unlock releases a lock taken by TryLock.
+	Unlock(ctx context.Context, name string) error
+}
+
+// lockPollInterval is how often Lock retries a lock held elsewhere.
+const lockPollInterval = time.Second
+
+// Lock takes the named lock, waiting for its holder to release it or for
+// the lock to expire, until ctx is done.
+func Lock(ctx context.Context, s CertStorage, name string, ttl time.Duration) error {
+	for {
+		ok, err := s.TryLock(ctx, name, ttl)
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for lock %s: %w", name, ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// lockRecord is the content of a lock in a shared storage.
+type lockRecord struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+func (r lockRecord) expired(now time.Time) bool {
+	return !now.Before(r.Expires)
+}
+
+func parseLockRecord(data []byte) (lockRecord, error) {
+	var r lockRecord
+	err := json.Unmarshal(data, &r)
+	return r, err
+}
+
+// newLockOwner returns an identifier unique to this storage instance, with
+// the host name for the benefit of whoever looks at a stuck lock.
+func newLockOwner() string {
+	host, _ := os.Hostname()
+	var b [8]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%s/%d/%s", host, os.Getpid(), hex.EncodeToString(b[:]))
+}
+
+// FileStorage keeps certificates in a local directory, like
+// autocert.DirCache. A local directory has a single owner, so its locks
+// always succeed; use SharedDirStorage for a directory shared by processes.
+type FileStorage struct {
+	autocert.DirCache
+}
+
+// NewFileStorage returns a storage backed by dir, created on first write.
+func NewFileStorage(dir string) *FileStorage {
+	return &FileStorage{DirCache: autocert.DirCache(dir)}
+}
+
+// TryLock implements CertStorage.
+func (s *FileStorage) TryLock(context.Context, string, time.Duration) (bool, error) {
+	return true, nil
+}
+
+// Unlock implements CertStorage.
+func (s *FileStorage) Unlock(context.Context, string) error {
+	return nil
+}
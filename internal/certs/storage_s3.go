@@ -0,0 +1,293 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// s3LockPrefix is where locks live, below the storage prefix.
+const s3LockPrefix = "locks/"
+
+// S3Options configures an S3Storage.
+type S3Options struct {
+	Endpoint  string // default https://s3.<region>.amazonaws.com
+	Region    string // default us-east-1
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	Client    *http.Client
+}
+
+// S3Storage keeps certificates in an S3-compatible bucket (AWS S3, MinIO,
+// Ceph, ...). Locks are objects created with conditional writes
+// (If-None-Match / If-Match), so only one node can take a lock, and carry
+// their expiry so a crashed node cannot hold one forever.
+type S3Storage struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+	owner    string
+}
+
+// NewS3Storage returns a storage for the configured bucket. The keys fall
+// back to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment
+// variables.
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("s3 storage: bucket is required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	if opts.AccessKey == "" {
+		opts.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if opts.SecretKey == "" {
+		opts.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, errors.New("s3 storage: access_key and secret_key are required")
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("s3 storage: invalid endpoint %q", opts.Endpoint)
+	}
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &S3Storage{opts: opts, endpoint: u, client: client, owner: newLockOwner()}, nil
+}
+
+// Get implements autocert.Cache.
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := s.get(ctx, s.opts.Prefix+key)
+	return data, err
+}
+
+// Put implements autocert.Cache.
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.put(ctx, s.opts.Prefix+key, data, nil)
+	return err
+}
+
+// Delete implements autocert.Cache.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	return s.delete(ctx, s.opts.Prefix+key)
+}
+
+// TryLock implements CertStorage.
+func (s *S3Storage) TryLock(ctx context.Context, name string, ttl time.Duration) (bool, error) {
+	key := s.opts.Prefix + s3LockPrefix + name
+	data, err := json.Marshal(lockRecord{Owner: s.owner, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	ok, err := s.put(ctx, key, data, http.Header{"If-None-Match": {"*"}})
+	if ok || err != nil {
+		return ok, err
+	}
+
+	current, etag, err := s.get(ctx, key)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		// Released meanwhile; try once more.
+		return s.put(ctx, key, data, http.Header{"If-None-Match": {"*"}})
+	}
+	if err != nil {
+		return false, err
+	}
+	if rec, err := parseLockRecord(current); err == nil && rec.Owner != s.owner && !rec.expired(time.Now()) {
+		return false, nil
+	}
+	// Ours to extend, or stale: replace it only if nobody else did.
+	return s.put(ctx, key, data, http.Header{"If-Match": {etag}})
+}
+
+// Unlock implements CertStorage.
+func (s *S3Storage) Unlock(ctx context.Context, name string) error {
+	key := s.opts.Prefix + s3LockPrefix + name
+	current, _, err := s.get(ctx, key)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rec, err := parseLockRecord(current); err != nil || rec.Owner != s.owner {
+		return nil // expired and taken over by another node
+	}
+	return s.delete(ctx, key)
+}
+
+// get returns an object and its ETag, or autocert.ErrCacheMiss.
+func (s *S3Storage) get(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", autocert.ErrCacheMiss
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", s3Error(resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("ETag"), err
+}
+
+// put writes an object, reporting false when a conditional header in h
+// was not satisfied.
+func (s *S3Storage) put(ctx context.Context, key string, data []byte, h http.Header) (bool, error) {
+	resp, err := s.do(ctx, http.MethodPut, key, data, h)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 is returned for a concurrent conditional write.
+		return false, nil
+	}
+	return false, s3Error(resp)
+}
+
+func (s *S3Storage) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s3Error(resp)
+}
+
+// do sends a signed request for the object key.
+func (s *S3Storage) do(ctx context.Context, method, key string, body []byte, h http.Header) (*http.Response, error) {
+	u := *s.endpoint
+	escaped := strings.TrimSuffix(u.EscapedPath(), "/")
+	if s.opts.PathStyle {
+		escaped += "/" + s3EscapePath(s.opts.Bucket)
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+	}
+	escaped += "/" + s3EscapePath(key)
+	u.RawPath = escaped
+	u.Path, _ = url.PathUnescape(escaped)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range h {
+		req.Header[k] = v
+	}
+	signS3Request(req, body, s.opts.AccessKey, s.opts.SecretKey, s.opts.Region, time.Now())
+	return s.client.Do(req)
+}
+
+// s3Error turns an unexpected response into an error with the S3 error
+// code when there is one.
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := strings.TrimSpace(string(body))
+	if i := strings.Index(msg, "<Code>"); i >= 0 {
+		if j := strings.Index(msg[i:], "</Code>"); j > 0 {
+			msg = msg[i+len("<Code>") : i+j]
+		}
+	}
+	return fmt.Errorf("s3 storage: %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, msg)
+}
+
+// s3EscapePath escapes an object key as AWS Signature Version 4 expects:
+// everything but unreserved characters and "/".
+func s3EscapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signS3Request adds AWS Signature Version 4 headers to req, signing the
+// host and every header already set.
+func signS3Request(req *http.Request, body []byte, accessKey, secretKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{day, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
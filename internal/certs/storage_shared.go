@@ -0,0 +1,122 @@
+package certs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// sharedLockDir is the subdirectory holding the lock files.
+const sharedLockDir = ".locks"
+
+// SharedDirStorage keeps certificates in a directory mounted by every node
+// (NFS, SMB, a replicated volume). Locks are files created with link(2),
+// which is atomic on network filesystems too, and carry their expiry so a
+// crashed node cannot hold one forever.
+type SharedDirStorage struct {
+	autocert.DirCache
+	owner string
+}
+
+// NewSharedDirStorage returns a storage backed by dir, creating it.
+func NewSharedDirStorage(dir string) (*SharedDirStorage, error) {
+	if err := os.MkdirAll(filepath.Join(dir, sharedLockDir), 0700); err != nil {
+		return nil, err
+	}
+	return &SharedDirStorage{DirCache: autocert.DirCache(dir), owner: newLockOwner()}, nil
+}
+
+func (s *SharedDirStorage) lockPath(name string) string {
+	return filepath.Join(string(s.DirCache), sharedLockDir, name+".lock")
+}
+
+// TryLock implements CertStorage.
+func (s *SharedDirStorage) TryLock(_ context.Context, name string, ttl time.Duration) (bool, error) {
+	path := s.lockPath(name)
+	data, err := json.Marshal(lockRecord{Owner: s.owner, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	// The record is written to a private file first, so the lock never
+	// exists half-written.
+	tmp := fmt.Sprintf("%s.%s.tmp", path, filepath.Base(s.owner))
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		err := os.Link(tmp, path)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return false, err
+		}
+		current, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue // released meanwhile
+		}
+		if err != nil {
+			return false, err
+		}
+		rec, err := parseLockRecord(current)
+		if err == nil && rec.Owner == s.owner {
+			// Ours: extend it in place.
+			return true, os.Rename(tmp, path)
+		}
+		if err == nil && !rec.expired(time.Now()) {
+			return false, nil
+		}
+		// Expired or unreadable: break it, unless another node got there
+		// first and already replaced it.
+		if !s.breakLock(path, current) {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// breakLock removes the lock at path if it still holds stale. It moves the
+// file aside first, so it can tell a stale lock from one that another node
+// took in the meantime and put back the latter.
+func (s *SharedDirStorage) breakLock(path string, stale []byte) bool {
+	aside := fmt.Sprintf("%s.%s.stale", path, filepath.Base(s.owner))
+	if err := os.Rename(path, aside); err != nil {
+		return errors.Is(err, os.ErrNotExist)
+	}
+	moved, err := os.ReadFile(aside)
+	if err == nil && string(moved) != string(stale) {
+		// A fresh lock: restore it unless yet another one appeared.
+		os.Link(aside, path)
+		os.Remove(aside)
+		return false
+	}
+	os.Remove(aside)
+	return true
+}
+
+// Unlock implements CertStorage.
+func (s *SharedDirStorage) Unlock(_ context.Context, name string) error {
+	path := s.lockPath(name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rec, err := parseLockRecord(data); err != nil || rec.Owner != s.owner {
+		return nil // expired and taken over by another node
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
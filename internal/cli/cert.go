@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var certCmd = &cobra.Command{
+	Use:   "cert",
+	Short: "Manage TLS certificates",
+}
+
+var certLocalCmd = &cobra.Command{
+	Use:   "local",
+	Short: "Create the local development CA",
+	Long: `Create (or show) the local root CA used by sites with "ssl.mode": "internal".
+Certificates for those sites are issued on the fly and signed by this CA;
+add the printed CA certificate to your system or browser trust store.`,
+	Args: cobra.NoArgs,
+	Run:  certLocal,
+}
+
+func init() {
+	certCmd.AddCommand(certLocalCmd)
+}
+
+func certLocal(cmd *cobra.Command, args []string) {
+	ca, created, err := certs.LoadOrCreateLocalCA(config.GetLocalCADir())
+	if err != nil {
+		fmt.Printf("Error preparing the local CA: %v\n", err)
+		os.Exit(1)
+	}
+	if created {
+		fmt.Println("Created a new local CA.")
+	} else {
+		fmt.Println("Local CA already exists.")
+	}
+	fmt.Printf("CA certificate: %s\n", ca.CertFile)
+	fmt.Println(`Trust it to avoid browser warnings, then set "ssl": {"enabled": true, "mode": "internal"} on your sites.`)
+}
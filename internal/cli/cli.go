@@ -65,6 +65,9 @@ func init() {
 	rootCmd.AddCommand(stopCmd)
 	rootCmd.AddCommand(restartCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(certCmd)
+	rootCmd.AddCommand(precompressCmd)
+	rootCmd.AddCommand(releaseCmd)
 
 	startCmd.Flags().BoolVarP(&tuiMode, "tui", "t", false, "Enable TUI mode")
 	startCmd.Flags().BoolVarP(&benchMode, "bench", "b", false, "Enable benchmark mode")
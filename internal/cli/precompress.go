@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/server"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
+	"github.com/spf13/cobra"
+)
+
+var (
+	precompressZstd     bool
+	precompressMinSize  int64
+	precompressWatch    bool
+	precompressInterval time.Duration
+)
+
+var precompressCmd = &cobra.Command{
+	Use:   "precompress [site|dir]",
+	Short: "Write precompressed sidecars for static files",
+	Long: `Write brotli and gzip (and, with --zstd, zstd) sidecars next to the
+compressible files of a static site, so they are served without compressing
+on every request. The target is a configured site's domain or a directory;
+without one, every static site is processed.
+
+Sidecars newer than their file are kept, sidecars whose file is gone are
+removed. With --watch the command keeps running and regenerates sidecars as
+files change.`,
+	Args: cobra.MaximumNArgs(1),
+	Run:  precompress,
+}
+
+func init() {
+	precompressCmd.Flags().BoolVar(&precompressZstd, "zstd", false, "Also write zstd (.zst) sidecars")
+	precompressCmd.Flags().Int64Var(&precompressMinSize, "min-size", 0, "Skip files smaller than this many bytes (default: the site's compression.min_length, or 512)")
+	precompressCmd.Flags().BoolVarP(&precompressWatch, "watch", "w", false, "Keep running and regenerate sidecars as files change")
+	precompressCmd.Flags().DurationVar(&precompressInterval, "interval", 2*time.Second, "How often --watch checks for changes")
+}
+
+// precompressTarget is a directory and the options to precompress it with.
+type precompressTarget struct {
+	root string
+	opts server.PrecompressOptions
+}
+
+func precompress(cmd *cobra.Command, args []string) {
+	targets, err := precompressTargets(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if !precompressWatch {
+		failed := false
+		for _, t := range targets {
+			res, err := server.Precompress(t.root, t.opts)
+			printPrecompressResult(t.root, res, err)
+			failed = failed || err != nil
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var mu sync.Mutex // keeps the reports of concurrent roots apart
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			server.WatchPrecompress(ctx, t.root, t.opts, precompressInterval, func(res server.PrecompressResult, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				printPrecompressResult(t.root, res, err)
+			})
+		}()
+	}
+	fmt.Println("Watching for changes, press Ctrl+C to stop.")
+	wg.Wait()
+}
+
+// precompressTargets resolves the command argument: a site domain, a
+// directory, or every static site when empty.
+func precompressTargets(args []string) ([]precompressTarget, error) {
+	opts := func(compression *config.CompressionConfig) server.PrecompressOptions {
+		o := server.PrecompressOptions{
+			MinSize:      precompressMinSize,
+			Compressible: middleware.CompressibleTypes(compression),
+		}
+		if o.MinSize == 0 && compression != nil {
+			o.MinSize = int64(compression.MinLength)
+		}
+		if precompressZstd {
+			o.Encodings = append(append([]string{}, server.DefaultPrecompressEncodings...), config.EncodingZstd)
+		}
+		return o
+	}
+
+	// A directory argument works without any site configured.
+	configs, err := loadConfigs()
+	if err != nil && len(args) == 0 {
+		return nil, fmt.Errorf("loading configurations: %w", err)
+	}
+
+	var targets []precompressTarget
+	for _, conf := range configs {
+		if conf.RootDirectory == "" || conf.ProxyPass != "" || len(conf.ProxyUpstreams) > 0 {
+			continue
+		}
+		if len(args) == 0 || args[0] == conf.Domain {
+			targets = append(targets, precompressTarget{root: conf.RootDirectory, opts: opts(conf.Compression)})
+		}
+	}
+	if len(args) == 0 {
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("no static sites configured")
+		}
+		return targets, nil
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+	if info, err := os.Stat(args[0]); err == nil && info.IsDir() {
+		return []precompressTarget{{root: args[0], opts: opts(nil)}}, nil
+	}
+	return nil, fmt.Errorf("%s is neither a static site nor a directory", args[0])
+}
+
+func printPrecompressResult(root string, res server.PrecompressResult, err error) {
+	fmt.Printf("%s: %d written, %d removed, %d up to date\n", root, len(res.Written), len(res.Removed), res.UpToDate)
+	for _, p := range res.Written {
+		fmt.Printf("  + %s\n", p)
+	}
+	for _, p := range res.Removed {
+		fmt.Printf("  - %s\n", p)
+	}
+	for _, p := range res.Unmanaged {
+		fmt.Printf("  ! %s: older than its file, not written by precompress, left as is\n", p)
+	}
+	if err != nil {
+		fmt.Printf("  error: %v\n", err)
+	}
+}
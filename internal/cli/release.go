@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mirkobrombin/goup/internal/releases"
+	"github.com/spf13/cobra"
+)
+
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Deploy and roll back static site releases",
+	Long: `Manage the releases of static sites with "releases" configured. Every
+deploy is unpacked into a release directory of its own and root_directory,
+a symlink, is switched to it atomically; older releases are kept for
+rollbacks. A running server picks the switch up within a second.`,
+}
+
+var releaseDeployCmd = &cobra.Command{
+	Use:   "deploy <site> <archive|dir>",
+	Short: "Deploy a tar.gz or zip archive, or a directory, as a new release",
+	Args:  cobra.ExactArgs(2),
+	Run:   releaseDeploy,
+}
+
+var releaseListCmd = &cobra.Command{
+	Use:   "list <site>",
+	Short: "List the kept releases of a site",
+	Args:  cobra.ExactArgs(1),
+	Run:   releaseList,
+}
+
+var releaseRollbackCmd = &cobra.Command{
+	Use:   "rollback <site> [release]",
+	Short: "Switch back to the previous release, or to the given one",
+	Args:  cobra.RangeArgs(1, 2),
+	Run:   releaseRollback,
+}
+
+func init() {
+	releaseCmd.AddCommand(releaseDeployCmd)
+	releaseCmd.AddCommand(releaseListCmd)
+	releaseCmd.AddCommand(releaseRollbackCmd)
+}
+
+// siteReleases returns the releases of a configured site, exiting on error.
+func siteReleases(domain string) *releases.Site {
+	configs, err := loadConfigs()
+	if err != nil {
+		fmt.Printf("Error loading configurations: %v\n", err)
+		os.Exit(1)
+	}
+	for _, conf := range configs {
+		if conf.Domain != domain {
+			continue
+		}
+		s, err := releases.ForSite(conf)
+		if err != nil {
+			fmt.Printf("Error: %s: %v\n", domain, err)
+			os.Exit(1)
+		}
+		return s
+	}
+	fmt.Printf("Error: no site configured for %s\n", domain)
+	os.Exit(1)
+	return nil
+}
+
+func releaseDeploy(cmd *cobra.Command, args []string) {
+	s := siteReleases(args[0])
+	info, err := os.Stat(args[1])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var src io.Reader
+	if info.IsDir() {
+		pr, pw := io.Pipe()
+		go func() { pw.CloseWithError(releases.Pack(args[1], pw)) }()
+		src = pr
+	} else {
+		f, err := os.Open(args[1])
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	rel, err := s.Deploy(src)
+	if err != nil {
+		fmt.Printf("Error deploying %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+	fmt.Printf("Deployed and activated release %s (%d files, %s).\n", rel.ID, rel.Files, formatBytes(rel.Bytes))
+}
+
+func releaseList(cmd *cobra.Command, args []string) {
+	list, err := siteReleases(args[0]).List()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(list) == 0 {
+		fmt.Println("No releases yet.")
+		return
+	}
+	for _, rel := range list {
+		marker := " "
+		if rel.Active {
+			marker = "*"
+		}
+		fmt.Printf("%s %s  %s  %d files, %s\n", marker, rel.ID, rel.Created.Local().Format("2006-01-02 15:04:05"), rel.Files, formatBytes(rel.Bytes))
+	}
+}
+
+func releaseRollback(cmd *cobra.Command, args []string) {
+	s := siteReleases(args[0])
+	var rel releases.Release
+	var err error
+	if len(args) == 2 {
+		rel, err = s.Activate(args[1])
+	} else {
+		rel, err = s.Rollback()
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Release %s is now active.\n", rel.ID)
+}
+
+// formatBytes renders a size for humans, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -23,11 +23,121 @@ type SSLConfig struct {
 	Enabled     bool   `json:"enabled"`
 	Certificate string `json:"certificate"`
 	Key         string `json:"key"`
+	// Mode "internal" serves certificates issued on the fly by the local
+	// development CA (see `goup cert local`); Certificate/Key are ignored.
+	Mode string `json:"mode,omitempty"`
 	// ACME enables automatic TLS via Let's Encrypt (TLS-ALPN-01 on :443).
 	// When set, Certificate/Key are ignored for this site.
 	ACME     bool   `json:"acme"`
 	Email    string `json:"email"`     // ACME account email (recommended)
 	CacheDir string `json:"cache_dir"` // where issued certificates are cached
+	// Challenge selects the ACME challenge: "tls-alpn-01" (default),
+	// "http-01" (served from the port-80 listener) or "dns-01" (required for
+	// wildcard domains).
+	Challenge string `json:"challenge,omitempty"`
+	// DNSProvider publishes DNS-01 TXT records: "internal" (GoUp's own DNS
+	// zones, default) or "rfc2136" (dynamic updates to an external server).
+	DNSProvider string         `json:"dns_provider,omitempty"`
+	RFC2136     *RFC2136Config `json:"rfc2136,omitempty"`
+	// ClientAuth requests or requires TLS client certificates (mTLS).
+	ClientAuth *ClientAuthConfig `json:"client_auth,omitempty"`
+	// TLSPolicy overrides the protocol settings of this site (selected by
+	// SNI); nil keeps the defaults (TLS 1.2+, Go's cipher suites).
+	TLSPolicy *TLSPolicy `json:"tls_policy,omitempty"`
+	// OnDemand makes this ACME site a catch-all that obtains certificates for
+	// hosts not listed in any config, once the ask check approves them.
+	OnDemand *OnDemandConfig `json:"on_demand,omitempty"`
+}
+
+// OnDemandConfig configures on-demand TLS. At least one of Ask and AllowList
+// must be set; a host is approved when either allows it.
+type OnDemandConfig struct {
+	// Ask is a URL queried with ?domain=<host>; a 200 response approves the
+	// host.
+	Ask string `json:"ask"`
+	// AllowList is a file with one permitted domain per line ("*.example.com"
+	// wildcards and # comments allowed), re-read when it changes.
+	AllowList string `json:"allow_list"`
+	// RateLimit caps new certificate issuances per RateWindow (default 10
+	// per "1h").
+	RateLimit  int    `json:"rate_limit"`
+	RateWindow string `json:"rate_window"`
+	// NegativeTTL is how long a rejected host is refused without asking
+	// again (default "10m").
+	NegativeTTL string `json:"negative_ttl"`
+}
+
+// SSLModeInternal selects certificates signed by the local development CA.
+const SSLModeInternal = "internal"
+
+// Client certificate modes accepted in ClientAuthConfig.Mode.
+const (
+	ClientAuthNone          = "none"
+	ClientAuthRequest       = "request"         // ask, accept anything (unverified)
+	ClientAuthRequire       = "require"         // a certificate signed by ca_bundle is mandatory
+	ClientAuthVerifyIfGiven = "verify-if-given" // optional, but verified when presented
+)
+
+// ClientAuthConfig configures TLS client certificate authentication for a
+// site.
+type ClientAuthConfig struct {
+	Mode     string `json:"mode"`
+	CABundle string `json:"ca_bundle"` // PEM bundle of accepted client CAs
+	// AllowedSubjects and AllowedSANs are glob patterns (e.g. "*.ops.example.com",
+	// "CN=admin-*"); when either is set a certificate must match at least one.
+	// Subjects match the common name or the full distinguished name.
+	AllowedSubjects []string `json:"allowed_subjects"`
+	AllowedSANs     []string `json:"allowed_sans"`
+	CRLFile         string   `json:"crl_file"` // PEM or DER CRL checked on every handshake
+	// Headers names the request headers carrying the verified certificate to
+	// upstreams; empty names use the X-Client-Cert-* defaults.
+	Headers ClientCertHeaders `json:"headers"`
+}
+
+// ClientCertHeaders names the headers used to forward client certificate
+// details.
+type ClientCertHeaders struct {
+	Subject     string `json:"subject"`
+	SAN         string `json:"san"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// NormalizedMode returns the client auth mode in canonical spelling
+// ("verify_if_given" is accepted as an alias).
+func (c *ClientAuthConfig) NormalizedMode() string {
+	if c == nil || c.Mode == "" {
+		return ClientAuthNone
+	}
+	return strings.ReplaceAll(strings.ToLower(c.Mode), "_", "-")
+}
+
+// ACME challenge types accepted in SSLConfig.Challenge.
+const (
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeHTTP01    = "http-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+// RFC2136Config configures DNS-01 TXT publishing through RFC 2136 dynamic
+// updates, optionally authenticated with TSIG.
+type RFC2136Config struct {
+	Nameserver    string `json:"nameserver"`     // host:port of the primary server
+	Zone          string `json:"zone"`           // zone to update (default: derived from the domain)
+	TSIGKey       string `json:"tsig_key"`       // TSIG key name
+	TSIGSecret    string `json:"tsig_secret"`    // base64 TSIG secret
+	TSIGAlgorithm string `json:"tsig_algorithm"` // default hmac-sha256
+	// PropagationSeconds is how long to wait after publishing before asking
+	// the CA to validate (default 10).
+	PropagationSeconds int `json:"propagation_seconds"`
+}
+
+// ACMEChallenge returns the configured challenge type, defaulting to
+// TLS-ALPN-01.
+func (s SSLConfig) ACMEChallenge() string {
+	if s.Challenge == "" {
+		return ChallengeTLSALPN01
+	}
+	return strings.ToLower(s.Challenge)
 }
 
 // SiteConfig contains the configuration for a single site.
@@ -61,10 +171,263 @@ type SiteConfig struct {
 	RateLimitRPS    float64     `json:"rate_limit_rps"`   // per-IP requests/sec (0 = disabled)
 	RateLimitBurst  int         `json:"rate_limit_burst"` // per-IP burst size
 	CORS            *CORSConfig `json:"cors,omitempty"`
+	// LimitRate caps the bandwidth of each response, in bytes per second,
+	// and LimitRatePerIP that of all the responses to one client together,
+	// both after the first LimitRateAfter bytes of a response. 0 disables a
+	// limit.
+	LimitRate      int64 `json:"limit_rate,omitempty"`
+	LimitRateAfter int64 `json:"limit_rate_after,omitempty"`
+	LimitRatePerIP int64 `json:"limit_rate_per_ip,omitempty"`
+	// LimitRateRoutes replace limit_rate and limit_rate_after under a path
+	// prefix; the longest matching prefix wins.
+	LimitRateRoutes []LimitRateRoute `json:"limit_rate_routes,omitempty"`
+	// CacheRules set the caching headers of the paths they match, static
+	// and proxied responses alike; the first matching rule wins.
+	CacheRules []CacheRule `json:"cache_rules,omitempty"`
+	// CacheAuto marks fingerprinted assets ("app.3f9a2c.js") immutable and
+	// HTML no-cache when no cache rule matches.
+	CacheAuto bool `json:"cache_auto,omitempty"`
+	// Compression tunes on-the-fly response compression (default: zstd,
+	// brotli and gzip for common text types).
+	Compression *CompressionConfig `json:"compression,omitempty"`
+
+	// DenyPaths hides the static files matching these globs, on top of
+	// dotfiles: "*.bak" matches a name at any depth, "/private/**" a tree.
+	DenyPaths []string `json:"deny_paths,omitempty"`
+	// IndexFiles are the names served for a directory, in order (default
+	// index.html).
+	IndexFiles []string `json:"index_files,omitempty"`
+	// AllowedMethods limits the methods of a static site; others get 405.
+	// GET allows HEAD. Empty allows every method.
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	// FollowSymlinks set to false refuses symlinks that resolve outside the
+	// root directory. Default true if nil.
+	FollowSymlinks *bool `json:"follow_symlinks,omitempty"`
+
+	// FileCache keeps small, hot static files in memory (opt-in).
+	FileCache *FileCacheConfig `json:"file_cache,omitempty"`
+
+	// Listing enables directory archive downloads and README display in
+	// directory listings.
+	Listing *ListingConfig `json:"listing,omitempty"`
+
+	// Markdown renders Markdown files, READMEs of listings included, to
+	// HTML for browsers.
+	Markdown *MarkdownConfig `json:"markdown,omitempty"`
+
+	// Images resizes the images of a static site on request (?w=, ?h=,
+	// ?fit=, ?q=) and caches the variants on disk.
+	Images *ImagesConfig `json:"images,omitempty"`
+
+	// WebDAV exposes the root directory over WebDAV, behind Basic
+	// Authentication.
+	WebDAV *WebDAVConfig `json:"webdav,omitempty"`
+
+	// Uploads lets authenticated users add (and optionally delete) files
+	// over HTTP.
+	Uploads *UploadsConfig `json:"uploads,omitempty"`
+
+	// RootArchive serves a static site from a .zip or uncompressed .tar
+	// file instead of root_directory, reloaded when the file changes.
+	RootArchive string `json:"root_archive,omitempty"`
+
+	// Templates renders the HTML files of a static site through Go's
+	// html/template, with includes and request values.
+	Templates *TemplatesConfig `json:"templates,omitempty"`
+
+	// Releases turns root_directory into a symlink to the active release of
+	// the site, deployed atomically through the API or "goup release".
+	Releases *ReleasesConfig `json:"releases,omitempty"`
+
+	// TryFiles resolves static requests nginx-style: each candidate is
+	// tried in order ("$uri", "$uri.html", "$uri/index.html", ...) and the
+	// last one is the fallback, e.g. "/index.html" for single-page apps or
+	// "=404".
+	TryFiles []string `json:"try_files,omitempty"`
+	// TryFilesStatus is the status of the fallback response: 200 (default)
+	// or 404.
+	TryFilesStatus int `json:"try_files_status,omitempty"`
+	// TryFilesRoutes override TryFiles below a path prefix.
+	TryFilesRoutes []TryFilesRoute `json:"try_files_routes,omitempty"`
 
 	PluginConfigs map[string]any `json:"plugin_configs"`
 }
 
+// FileCacheConfig sizes the in-memory static file cache of a site. Zero
+// values keep the defaults.
+type FileCacheConfig struct {
+	// MaxBytes is the memory budget (default 64 MiB); the least recently
+	// used files are evicted beyond it.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// MaxFileSize is the largest file kept, sidecars included (default
+	// 256 KiB).
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+}
+
+// Archive formats a directory listing can be downloaded as.
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+// ListingConfig tunes the directory listings of a static site.
+type ListingConfig struct {
+	// Archives are the formats ("zip", "tar.gz") a directory can be
+	// downloaded as, streamed on the fly. Empty disables downloads.
+	Archives []string `json:"archives,omitempty"`
+	// ArchiveMaxBytes caps the total size of the files in a download
+	// (default 1 GiB).
+	ArchiveMaxBytes int64 `json:"archive_max_bytes,omitempty"`
+	// ArchiveMaxFiles caps the number of files in a download (default
+	// 10000).
+	ArchiveMaxFiles int `json:"archive_max_files,omitempty"`
+	// Readme shows the README of a directory above its listing.
+	Readme bool `json:"readme,omitempty"`
+}
+
+// MarkdownConfig renders the Markdown files of a static site. Browsers get
+// a styled page; ?raw=1 and clients not asking for HTML get the file as is.
+type MarkdownConfig struct {
+	// Extensions are the files rendered (default [".md", ".markdown"]).
+	Extensions []string `json:"extensions,omitempty"`
+	// CodeStyle is the Chroma theme of code blocks (default "monokai").
+	CodeStyle string `json:"code_style,omitempty"`
+}
+
+// Image fit modes, for requests giving both ?w= and ?h=.
+const (
+	FitContain = "contain" // the whole image within the box (default)
+	FitCover   = "cover"   // the box filled, the overflow cropped evenly
+	FitFill    = "fill"    // the box filled, the aspect ratio ignored
+)
+
+// ImagesConfig resizes JPEG, PNG and GIF files of a static site. Only the
+// listed sizes and qualities are accepted, so a client can't make the
+// server produce (and cache) unbounded variants.
+type ImagesConfig struct {
+	// Widths are the values ?w= accepts (default 160, 320, 640, 960,
+	// 1280, 1920 and 2560).
+	Widths []int `json:"widths,omitempty"`
+	// Heights are the values ?h= accepts (default: the default widths).
+	Heights []int `json:"heights,omitempty"`
+	// Qualities are the JPEG qualities ?q= accepts (default 50, 75 and 90;
+	// 75 without ?q=).
+	Qualities []int `json:"qualities,omitempty"`
+	// MaxPixels bounds the originals resized (default 50 megapixels);
+	// larger ones are served as is.
+	MaxPixels int64 `json:"max_pixels,omitempty"`
+	// CacheDir holds the variants (default: "images/<domain>" in the GoUp
+	// data directory).
+	CacheDir string `json:"cache_dir,omitempty"`
+	// CacheMaxBytes caps the size of the cache (default 1 GiB); the oldest
+	// variants are removed first.
+	CacheMaxBytes int64 `json:"cache_max_bytes,omitempty"`
+}
+
+// DefaultWebDAVPath is where WebDAV is mounted unless configured.
+const DefaultWebDAVPath = "/dav/"
+
+// WebDAVConfig mounts the root directory of a static site over WebDAV.
+type WebDAVConfig struct {
+	// Path is the mount prefix (default "/dav/"); every request below it,
+	// reads included, goes to WebDAV and must authenticate.
+	Path string `json:"path,omitempty"`
+	// ReadOnly allows browsing and downloading only.
+	ReadOnly bool `json:"read_only,omitempty"`
+	// Users may access the share; empty means the global account.
+	Users []Credential `json:"users,omitempty"`
+}
+
+// Upload overwrite policies.
+const (
+	OverwriteDeny    = "deny"    // an existing file is an error (default)
+	OverwriteReplace = "replace" // the upload replaces the existing file
+	OverwriteRename  = "rename"  // the upload gets a free name: "a (1).txt"
+)
+
+// UploadsConfig accepts files uploaded to a static site: multipart POSTs
+// to a directory, raw PUTs to a file path and resumable chunked PUTs.
+type UploadsConfig struct {
+	// MaxSize caps the size of an uploaded file (default 100 MiB).
+	MaxSize int64 `json:"max_size,omitempty"`
+	// AllowedExtensions restricts the names accepted, e.g. [".jpg", ".pdf"];
+	// empty accepts any.
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+	// Overwrite is the policy for names already taken: deny (default),
+	// replace or rename.
+	Overwrite string `json:"overwrite,omitempty"`
+	// AllowDelete also accepts DELETE of files.
+	AllowDelete bool `json:"allow_delete,omitempty"`
+	// Users may upload; empty means the global account.
+	Users []Credential `json:"users,omitempty"`
+}
+
+// ReleasesConfig keeps versioned releases of a static site. Each deploy is
+// unpacked into its own directory next to the others, and root_directory is
+// a symlink switched atomically to the active one.
+type ReleasesConfig struct {
+	// Directory holds the releases (default: root_directory + ".releases").
+	// It must not be inside root_directory.
+	Directory string `json:"directory,omitempty"`
+	// Keep is how many releases are kept for rollbacks, the active one
+	// included (default 5).
+	Keep int `json:"keep,omitempty"`
+	// MaxBytes caps the size of an archive and of its unpacked files
+	// (default 1 GiB).
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// MaxFiles caps the number of files in a release (default 100000).
+	MaxFiles int `json:"max_files,omitempty"`
+}
+
+// TemplatesConfig renders the pages of a static site as Go templates.
+type TemplatesConfig struct {
+	// Extensions are the files rendered (default [".html", ".gohtml"]).
+	Extensions []string `json:"extensions,omitempty"`
+	// Env lists the environment variables templates may read with env;
+	// any other reads as empty.
+	Env []string `json:"env,omitempty"`
+}
+
+// Credential is a Basic Authentication user with a bcrypt password hash, as
+// printed by "goup gen-pass".
+type Credential struct {
+	Username     string `json:"username"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// TryFilesRoute applies a try_files list to the requests below Path. The
+// longest matching prefix wins.
+type TryFilesRoute struct {
+	Path     string   `json:"path"`
+	TryFiles []string `json:"try_files"`
+	Status   int      `json:"status,omitempty"`
+}
+
+// LimitRateRoute sets the response bandwidth of the paths under a prefix;
+// a LimitRate of 0 leaves them unlimited.
+type LimitRateRoute struct {
+	Path           string `json:"path"`
+	LimitRate      int64  `json:"limit_rate"`
+	LimitRateAfter int64  `json:"limit_rate_after,omitempty"`
+}
+
+// CacheRule sets the caching headers of the responses to the paths it
+// matches.
+type CacheRule struct {
+	// Path is a glob: without a slash ("*.woff2") it matches file names at
+	// any depth, with one ("/assets/*") the path or a parent directory.
+	Path string `json:"path,omitempty"`
+	// Regex matches the request path instead of Path.
+	Regex string `json:"regex,omitempty"`
+	// CacheControl replaces the Cache-Control header.
+	CacheControl string `json:"cache_control,omitempty"`
+	// Expires sets the Expires header this far in the future (e.g. "24h"),
+	// and max-age too when CacheControl is empty.
+	Expires string `json:"expires,omitempty"`
+	// Headers are set on the matching responses.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
 // CORSConfig configures Cross-Origin Resource Sharing for a site.
 type CORSConfig struct {
 	AllowedOrigins   []string `json:"allowed_origins"` // "*" allowed
@@ -74,6 +437,33 @@ type CORSConfig struct {
 	MaxAge           int      `json:"max_age"` // preflight cache seconds
 }
 
+// Compression encodings.
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+)
+
+// CompressionConfig configures on-the-fly response compression for a site.
+// Zero values keep the defaults.
+type CompressionConfig struct {
+	Disabled bool `json:"disabled"`
+	// Encodings lists the encodings offered, in server preference order
+	// for clients that accept several equally (default zstd, br, gzip).
+	Encodings []string `json:"encodings,omitempty"`
+	// Types are the compressible MIME types; "text/*" matches a whole
+	// family.
+	Types []string `json:"types,omitempty"`
+	// MinLength is the smallest body worth compressing, in bytes (default
+	// 512).
+	MinLength int `json:"min_length,omitempty"`
+	// Levels sets the level per encoding: gzip 1-9, br 0-11, zstd 1-22.
+	Levels map[string]int `json:"levels,omitempty"`
+	// ExcludePaths are path prefixes, or path.Match patterns when they
+	// contain "*", never compressed.
+	ExcludePaths []string `json:"exclude_paths,omitempty"`
+}
+
 // GetConfigDir returns the directory where configuration files are stored.
 func GetConfigDir() string {
 	var configDir string
@@ -87,18 +477,32 @@ func GetConfigDir() string {
 	return configDir
 }
 
-// GetACMEDir returns the default directory where ACME-issued certificates are
-// cached.
-func GetACMEDir() string {
-	var base string
+// GetDataDir returns the directory where GoUp keeps its persistent data
+// (ACME cache, local CA, ...).
+func GetDataDir() string {
 	if xdgDataHome := os.Getenv("XDG_DATA_HOME"); xdgDataHome != "" {
-		base = filepath.Join(xdgDataHome, "goup")
+		return filepath.Join(xdgDataHome, "goup")
 	} else if runtime.GOOS == "windows" {
-		base = filepath.Join(os.Getenv("APPDATA"), "goup")
-	} else {
-		base = filepath.Join(os.Getenv("HOME"), ".local", "share", "goup")
+		return filepath.Join(os.Getenv("APPDATA"), "goup")
 	}
-	return filepath.Join(base, "acme")
+	return filepath.Join(os.Getenv("HOME"), ".local", "share", "goup")
+}
+
+// GetACMEDir returns the default directory where ACME-issued certificates are
+// cached.
+func GetACMEDir() string {
+	return filepath.Join(GetDataDir(), "acme")
+}
+
+// GetLocalCADir returns the directory holding the local development CA.
+func GetLocalCADir() string {
+	return filepath.Join(GetDataDir(), "ca")
+}
+
+// GetImageCacheDir returns the default directory of the resized image
+// variants of a site.
+func GetImageCacheDir(domain string) string {
+	return filepath.Join(GetDataDir(), "images", domain)
 }
 
 // GetLogDir returns the directory where log files are stored.
@@ -32,6 +32,67 @@ type GlobalConfig struct {
 	SafeGuard        SafeGuardConfig `json:"safeguard"`
 	DNS              *DNSConfig      `json:"dns"`
 	LogRetentionDays int             `json:"log_retention_days"` // delete logs older than N days (0 = keep forever)
+	ACME             *ACMEConfig     `json:"acme,omitempty"`
+	OCSPStapling     *bool           `json:"ocsp_stapling,omitempty"` // staple OCSP responses (default true)
+}
+
+// ACMEConfig holds the ACME settings shared by every site that enables
+// ssl.acme. It takes precedence over the per-site ssl.email and ssl.cache_dir.
+type ACMEConfig struct {
+	Email    string `json:"email"`     // account contact
+	CacheDir string `json:"cache_dir"` // certificate and account key cache
+	// DirectoryURL selects the CA (default: Let's Encrypt production), e.g.
+	// the Let's Encrypt staging URL, ZeroSSL, step-ca or a local Pebble.
+	DirectoryURL string `json:"directory_url"`
+	// RootCA is a PEM bundle trusted when talking to the ACME server, for
+	// private CAs whose directory is served with a self-issued certificate.
+	RootCA string     `json:"root_ca"`
+	EAB    *EABConfig `json:"eab,omitempty"`
+	// Storage moves certificates and the account key off the local cache
+	// directory, so several nodes can share them.
+	Storage *CertStorageConfig `json:"storage,omitempty"`
+}
+
+// Certificate storage types.
+const (
+	CertStorageFS     = "fs"     // cache_dir on the local disk (default)
+	CertStorageShared = "shared" // a directory every node mounts (NFS, ...)
+	CertStorageS3     = "s3"     // an S3-compatible bucket (AWS, MinIO, ...)
+)
+
+// CertStorageConfig selects where ACME certificates and account keys are
+// kept. Shared storages also hold the locks that let only one node obtain or
+// renew a given certificate.
+type CertStorageConfig struct {
+	Type string `json:"type"`
+	// Path is the shared directory (default: cache_dir).
+	Path string `json:"path,omitempty"`
+	// LockTTL bounds how long an issuance lock is held if the node holding
+	// it dies (default "10m").
+	LockTTL string           `json:"lock_ttl,omitempty"`
+	S3      *S3StorageConfig `json:"s3,omitempty"`
+}
+
+// S3StorageConfig locates the bucket of an "s3" certificate storage. The
+// keys fall back to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables.
+type S3StorageConfig struct {
+	Endpoint  string `json:"endpoint"` // default https://s3.<region>.amazonaws.com
+	Region    string `json:"region"`   // default us-east-1
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"` // key prefix, e.g. "goup/certs/"
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// PathStyle addresses the bucket as endpoint/bucket (MinIO) instead of
+	// bucket.endpoint.
+	PathStyle bool `json:"path_style"`
+}
+
+// EABConfig is an ACME external account binding, required by CAs such as
+// ZeroSSL to tie the ACME account to an existing customer account.
+type EABConfig struct {
+	KeyID   string `json:"key_id"`
+	HMACKey string `json:"hmac_key"` // base64url-encoded, as issued by the CA
 }
 
 // GlobalConf is the global configuration in memory.
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TLSPolicy configures the TLS protocol settings of a site.
+type TLSPolicy struct {
+	MinVersion string `json:"min_version"` // "1.0", "1.1", "1.2" (default), "1.3"
+	MaxVersion string `json:"max_version"` // empty = highest supported
+	// CipherSuites lists TLS 1.0-1.2 suites by their Go/IANA name (e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"). TLS 1.3 suites are not
+	// configurable.
+	CipherSuites []string `json:"cipher_suites"`
+	// Curves lists key exchange groups in preference order: "X25519MLKEM768",
+	// "X25519", "P256", "P384", "P521".
+	Curves []string `json:"curves"`
+	// ALPN lists the protocols offered over TCP ("h2", "http/1.1"); QUIC
+	// always negotiates "h3".
+	ALPN []string `json:"alpn"`
+	// SessionTickets enables TLS session resumption tickets (default true).
+	SessionTickets *bool `json:"session_tickets,omitempty"`
+	// TicketKeyRotation is how often a new session ticket key is generated
+	// (default "24h"); the two previous keys still decrypt older tickets.
+	TicketKeyRotation string `json:"ticket_key_rotation"`
+}
+
+// DefaultTicketKeyRotation is the session ticket key lifetime used when
+// TLSPolicy.TicketKeyRotation is empty.
+const DefaultTicketKeyRotation = 24 * time.Hour
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"x25519mlkem768": tls.X25519MLKEM768,
+	"x25519":         tls.X25519,
+	"p256":           tls.CurveP256,
+	"p384":           tls.CurveP384,
+	"p521":           tls.CurveP521,
+}
+
+var alpnProtocols = map[string]bool{"h2": true, "http/1.1": true}
+
+// Versions returns the minimum and maximum TLS versions; a zero maximum
+// means the highest version Go supports.
+func (p *TLSPolicy) Versions() (min, max uint16, err error) {
+	min = tls.VersionTLS12
+	if p.MinVersion != "" {
+		if min = tlsVersions[strings.TrimPrefix(p.MinVersion, "TLS")]; min == 0 {
+			return 0, 0, fmt.Errorf("unknown TLS version %q", p.MinVersion)
+		}
+	}
+	if p.MaxVersion != "" {
+		if max = tlsVersions[strings.TrimPrefix(p.MaxVersion, "TLS")]; max == 0 {
+			return 0, 0, fmt.Errorf("unknown TLS version %q", p.MaxVersion)
+		}
+		if max < min {
+			return 0, 0, fmt.Errorf("max_version %s is lower than min_version", p.MaxVersion)
+		}
+	}
+	return min, max, nil
+}
+
+// CipherSuiteIDs resolves the configured cipher suite names.
+func (p *TLSPolicy) CipherSuiteIDs() ([]uint16, error) {
+	if len(p.CipherSuites) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, list := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, cs := range list {
+			known[cs.Name] = cs.ID
+		}
+	}
+	ids := make([]uint16, 0, len(p.CipherSuites))
+	for _, name := range p.CipherSuites {
+		id, ok := known[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// CurveIDs resolves the configured curve names.
+func (p *TLSPolicy) CurveIDs() ([]tls.CurveID, error) {
+	ids := make([]tls.CurveID, 0, len(p.Curves))
+	for _, name := range p.Curves {
+		id, ok := tlsCurves[strings.ToLower(strings.ReplaceAll(name, "-", ""))]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// TicketRotation returns the session ticket key rotation interval.
+func (p *TLSPolicy) TicketRotation() (time.Duration, error) {
+	if p.TicketKeyRotation == "" {
+		return DefaultTicketKeyRotation, nil
+	}
+	d, err := time.ParseDuration(p.TicketKeyRotation)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("ticket_key_rotation %q is not a positive duration", p.TicketKeyRotation)
+	}
+	return d, nil
+}
+
+// SessionTicketsEnabled reports whether session tickets are on (default).
+func (p *TLSPolicy) SessionTicketsEnabled() bool {
+	return p.SessionTickets == nil || *p.SessionTickets
+}
+
+// validate checks every field of the policy.
+func (p *TLSPolicy) validate() []string {
+	var errs []string
+	if _, _, err := p.Versions(); err != nil {
+		errs = append(errs, "ssl.tls_policy: "+err.Error())
+	}
+	if _, err := p.CipherSuiteIDs(); err != nil {
+		errs = append(errs, "ssl.tls_policy: "+err.Error())
+	}
+	if _, err := p.CurveIDs(); err != nil {
+		errs = append(errs, "ssl.tls_policy: "+err.Error())
+	}
+	for _, proto := range p.ALPN {
+		if !alpnProtocols[proto] {
+			errs = append(errs, fmt.Sprintf("ssl.tls_policy: unsupported ALPN protocol %q (h2, http/1.1)", proto))
+		}
+	}
+	if _, err := p.TicketRotation(); err != nil {
+		errs = append(errs, "ssl.tls_policy: "+err.Error())
+	}
+	return errs
+}
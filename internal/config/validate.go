@@ -4,8 +4,16 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,8 +31,8 @@ func (c SiteConfig) Validate() []string {
 		errs = append(errs, fmt.Sprintf("port %d is out of range (1-65535)", c.Port))
 	}
 
-	if c.ProxyPass == "" && c.RootDirectory == "" && len(c.ProxyUpstreams) == 0 {
-		errs = append(errs, "either proxy_pass, proxy_upstreams or root_directory must be set")
+	if c.ProxyPass == "" && c.RootDirectory == "" && c.RootArchive == "" && len(c.ProxyUpstreams) == 0 {
+		errs = append(errs, "either proxy_pass, proxy_upstreams, root_directory or root_archive must be set")
 	}
 	if c.ProxyPass != "" {
 		if u, err := url.Parse(c.ProxyPass); err != nil || u.Scheme == "" || u.Host == "" {
@@ -43,12 +51,40 @@ func (c SiteConfig) Validate() []string {
 		switch {
 		case invalid:
 			errs = append(errs, "root_directory must be an absolute path without '..'")
-		case !exists:
+		case !exists && c.Releases == nil:
+			// With releases, the first deploy creates it.
 			errs = append(errs, "root_directory does not exist")
 		}
 	}
 
-	if c.SSL.Enabled {
+	if c.RootArchive != "" {
+		if c.RootDirectory != "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "root_archive excludes root_directory, proxy_pass and proxy_upstreams")
+		}
+		exists, invalid := CheckPath(c.RootArchive)
+		switch {
+		case invalid:
+			errs = append(errs, "root_archive must be an absolute path without '..'")
+		case !exists:
+			errs = append(errs, "root_archive does not exist")
+		}
+		if ext := strings.ToLower(filepath.Ext(c.RootArchive)); ext != ".zip" && ext != ".tar" {
+			errs = append(errs, "root_archive must be a .zip or .tar file")
+		}
+	}
+
+	switch c.SSL.Mode {
+	case "", SSLModeInternal:
+	default:
+		errs = append(errs, fmt.Sprintf("ssl.mode %q is not supported (internal)", c.SSL.Mode))
+	}
+	if c.SSL.Enabled && c.SSL.Mode == SSLModeInternal {
+		if c.SSL.ACME {
+			errs = append(errs, "ssl.mode \"internal\" cannot be combined with ssl.acme")
+		}
+	} else if c.SSL.Enabled && c.SSL.ACME {
+		errs = append(errs, c.SSL.validateACME(c.Domain)...)
+	} else if c.SSL.Enabled {
 		if c.SSL.Certificate == "" || c.SSL.Key == "" {
 			errs = append(errs, "ssl.enabled requires both certificate and key")
 		} else {
@@ -61,6 +97,119 @@ func (c SiteConfig) Validate() []string {
 		}
 	}
 
+	if c.SSL.ClientAuth != nil {
+		errs = append(errs, c.SSL.validateClientAuth()...)
+	}
+	if c.SSL.OnDemand != nil {
+		errs = append(errs, c.SSL.validateOnDemand()...)
+	}
+	if c.SSL.TLSPolicy != nil {
+		errs = append(errs, c.SSL.TLSPolicy.validate()...)
+	}
+
+	if c.FileCache != nil {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "file_cache only applies to static sites (root_directory without a proxy)")
+		}
+		if c.FileCache.MaxBytes < 0 || c.FileCache.MaxFileSize < 0 {
+			errs = append(errs, "file_cache sizes must not be negative")
+		} else if c.FileCache.MaxBytes > 0 && c.FileCache.MaxFileSize > c.FileCache.MaxBytes {
+			errs = append(errs, "file_cache.max_file_size must not exceed max_bytes")
+		}
+	}
+	if c.Compression != nil {
+		errs = append(errs, c.Compression.validate()...)
+	}
+	if c.LimitRate < 0 || c.LimitRateAfter < 0 || c.LimitRatePerIP < 0 {
+		errs = append(errs, "limit_rate, limit_rate_after and limit_rate_per_ip must not be negative")
+	}
+	for i, route := range c.LimitRateRoutes {
+		field := fmt.Sprintf("limit_rate_routes[%d]", i)
+		if !strings.HasPrefix(route.Path, "/") {
+			errs = append(errs, field+".path must start with /")
+		}
+		if route.LimitRate < 0 || route.LimitRateAfter < 0 {
+			errs = append(errs, field+": limits must not be negative")
+		}
+	}
+	for i, rule := range c.CacheRules {
+		errs = append(errs, rule.validate(fmt.Sprintf("cache_rules[%d]", i))...)
+	}
+	if c.WebDAV != nil {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "webdav only applies to static sites (root_directory without a proxy)")
+		}
+		if p := c.WebDAV.Path; p != "" && (!strings.HasPrefix(p, "/") || !strings.HasSuffix(p, "/")) {
+			errs = append(errs, fmt.Sprintf("webdav.path: %q must start and end with /", p))
+		}
+		errs = append(errs, validateCredentials("webdav.users", c.WebDAV.Users)...)
+	}
+	if c.Releases != nil {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "releases only applies to static sites (root_directory without a proxy)")
+		}
+		errs = append(errs, c.Releases.validate(c.RootDirectory)...)
+	}
+	if c.Uploads != nil {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "uploads only applies to static sites (root_directory without a proxy)")
+		}
+		errs = append(errs, c.Uploads.validate()...)
+	}
+	if c.Markdown != nil {
+		if (c.RootDirectory == "" && c.RootArchive == "") || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "markdown only applies to static sites (root_directory or root_archive without a proxy)")
+		}
+		for _, ext := range c.Markdown.Extensions {
+			if !strings.HasPrefix(ext, ".") || strings.ContainsAny(ext, "/\\") {
+				errs = append(errs, fmt.Sprintf("markdown.extensions: %q must look like \".ext\"", ext))
+			}
+		}
+	}
+	if c.Images != nil {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "images only applies to static sites (root_directory without a proxy)")
+		}
+		errs = append(errs, c.Images.validate()...)
+	}
+	if c.Templates != nil {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "templates only applies to static sites (root_directory without a proxy)")
+		}
+		errs = append(errs, c.Templates.validate()...)
+	}
+	if c.Listing != nil {
+		if (c.RootDirectory == "" && c.RootArchive == "") || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "listing only applies to static sites (root_directory or root_archive without a proxy)")
+		}
+		errs = append(errs, c.Listing.validate()...)
+	}
+	if len(c.DenyPaths) > 0 || len(c.IndexFiles) > 0 || len(c.AllowedMethods) > 0 {
+		if (c.RootDirectory == "" && c.RootArchive == "") || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "deny_paths, index_files and allowed_methods only apply to static sites (root_directory or root_archive without a proxy)")
+		}
+		errs = append(errs, validateStaticAccess(c.DenyPaths, c.IndexFiles, c.AllowedMethods)...)
+	}
+	if c.FollowSymlinks != nil && !*c.FollowSymlinks && (c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0) {
+		errs = append(errs, "follow_symlinks only applies to static sites (root_directory without a proxy)")
+	}
+
+	if len(c.TryFiles) > 0 || len(c.TryFilesRoutes) > 0 {
+		if c.RootDirectory == "" || c.ProxyPass != "" || len(c.ProxyUpstreams) > 0 {
+			errs = append(errs, "try_files only applies to static sites (root_directory without a proxy)")
+		}
+		if len(c.TryFiles) > 0 || c.TryFilesStatus != 0 {
+			errs = append(errs, validateTryFiles("try_files", c.TryFiles, c.TryFilesStatus)...)
+		}
+		for i, route := range c.TryFilesRoutes {
+			field := fmt.Sprintf("try_files_routes[%d]", i)
+			if !strings.HasPrefix(route.Path, "/") {
+				errs = append(errs, field+".path must start with /")
+			}
+			errs = append(errs, validateTryFiles(field, route.TryFiles, route.Status)...)
+		}
+	}
+
 	if c.FlushInterval != "" {
 		if _, err := time.ParseDuration(c.FlushInterval); err != nil {
 			errs = append(errs, "proxy_flush_interval is not a valid duration (e.g. \"100ms\")")
@@ -70,6 +219,330 @@ func (c SiteConfig) Validate() []string {
 	return errs
 }
 
+// validateACME checks the ACME challenge settings of a site.
+func (s SSLConfig) validateACME(domain string) []string {
+	var errs []string
+	challenge := s.ACMEChallenge()
+	switch challenge {
+	case ChallengeTLSALPN01, ChallengeHTTP01, ChallengeDNS01:
+	default:
+		errs = append(errs, fmt.Sprintf("ssl.challenge %q is not supported (tls-alpn-01, http-01, dns-01)", s.Challenge))
+	}
+	if strings.HasPrefix(domain, "*.") && challenge != ChallengeDNS01 {
+		errs = append(errs, "wildcard domains require ssl.challenge \"dns-01\"")
+	}
+	if challenge == ChallengeDNS01 {
+		switch s.DNSProvider {
+		case "", "internal":
+		case "rfc2136":
+			if s.RFC2136 == nil || s.RFC2136.Nameserver == "" {
+				errs = append(errs, "ssl.dns_provider \"rfc2136\" requires ssl.rfc2136.nameserver")
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("ssl.dns_provider %q is not supported (internal, rfc2136)", s.DNSProvider))
+		}
+	}
+	return errs
+}
+
+// validateClientAuth checks the mTLS settings of a site.
+func (s SSLConfig) validateClientAuth() []string {
+	var errs []string
+	ca := s.ClientAuth
+	mode := ca.NormalizedMode()
+	switch mode {
+	case ClientAuthNone, ClientAuthRequest:
+	case ClientAuthRequire, ClientAuthVerifyIfGiven:
+		if ca.CABundle == "" {
+			errs = append(errs, fmt.Sprintf("ssl.client_auth mode %q requires ca_bundle", mode))
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("ssl.client_auth.mode %q is not supported (none, request, require, verify-if-given)", ca.Mode))
+	}
+	if mode != ClientAuthNone && !s.Enabled {
+		errs = append(errs, "ssl.client_auth requires ssl.enabled")
+	}
+	for _, p := range []string{ca.CABundle, ca.CRLFile} {
+		if p == "" {
+			continue
+		}
+		if exists, invalid := CheckPath(p); invalid || !exists {
+			errs = append(errs, "ssl.client_auth file not found (must be an absolute path): "+p)
+		}
+	}
+	for _, pat := range append(append([]string(nil), ca.AllowedSubjects...), ca.AllowedSANs...) {
+		if _, err := path.Match(pat, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("ssl.client_auth pattern %q is invalid", pat))
+		}
+	}
+	return errs
+}
+
+// compressionLevels are the accepted level ranges per encoding.
+var compressionLevels = map[string][2]int{
+	EncodingGzip:   {1, 9},
+	EncodingBrotli: {0, 11},
+	EncodingZstd:   {1, 22},
+}
+
+// validate checks the compression settings of a site.
+func (c *CompressionConfig) validate() []string {
+	var errs []string
+	for _, enc := range c.Encodings {
+		if _, ok := compressionLevels[enc]; !ok {
+			errs = append(errs, fmt.Sprintf("compression.encodings: %q is not supported (gzip, br, zstd)", enc))
+		}
+	}
+	for _, typ := range c.Types {
+		if !strings.Contains(typ, "/") {
+			errs = append(errs, fmt.Sprintf("compression.types: %q is not a MIME type", typ))
+		}
+	}
+	if c.MinLength < 0 {
+		errs = append(errs, "compression.min_length must not be negative")
+	}
+	for _, enc := range slices.Sorted(maps.Keys(c.Levels)) {
+		bounds, ok := compressionLevels[enc]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("compression.levels: %q is not a supported encoding", enc))
+		} else if level := c.Levels[enc]; level < bounds[0] || level > bounds[1] {
+			errs = append(errs, fmt.Sprintf("compression.levels.%s must be between %d and %d", enc, bounds[0], bounds[1]))
+		}
+	}
+	for _, p := range c.ExcludePaths {
+		if !strings.HasPrefix(p, "/") {
+			errs = append(errs, fmt.Sprintf("compression.exclude_paths: %q must start with /", p))
+		} else if _, err := path.Match(p, "/"); err != nil {
+			errs = append(errs, fmt.Sprintf("compression.exclude_paths: %q is not a valid pattern", p))
+		}
+	}
+	return errs
+}
+
+// validate checks a cache rule, reported under field.
+func (r CacheRule) validate(field string) []string {
+	var errs []string
+	switch {
+	case (r.Path == "") == (r.Regex == ""):
+		errs = append(errs, field+": set either path or regex")
+	case r.Regex != "":
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.regex: %v", field, err))
+		}
+	default:
+		if _, err := path.Match(r.Path, "/"); err != nil {
+			errs = append(errs, fmt.Sprintf("%s.path: %q is not a valid pattern", field, r.Path))
+		}
+	}
+	if r.Expires != "" {
+		if _, err := time.ParseDuration(r.Expires); err != nil {
+			errs = append(errs, field+".expires is not a valid duration (e.g. \"24h\")")
+		}
+	}
+	if r.CacheControl == "" && r.Expires == "" && len(r.Headers) == 0 {
+		errs = append(errs, field+" sets no header (cache_control, expires or headers)")
+	}
+	return errs
+}
+
+func (c *ListingConfig) validate() []string {
+	var errs []string
+	for _, format := range c.Archives {
+		if format != ArchiveZip && format != ArchiveTarGz {
+			errs = append(errs, fmt.Sprintf("listing.archives: %q is not supported (zip, tar.gz)", format))
+		}
+	}
+	if c.ArchiveMaxBytes < 0 || c.ArchiveMaxFiles < 0 {
+		errs = append(errs, "listing archive limits must not be negative")
+	}
+	return errs
+}
+
+func (c *UploadsConfig) validate() []string {
+	var errs []string
+	if c.MaxSize < 0 {
+		errs = append(errs, "uploads.max_size must not be negative")
+	}
+	for _, ext := range c.AllowedExtensions {
+		if !strings.HasPrefix(ext, ".") || strings.ContainsAny(ext, "/\\") {
+			errs = append(errs, fmt.Sprintf("uploads.allowed_extensions: %q must look like \".ext\"", ext))
+		}
+	}
+	switch c.Overwrite {
+	case "", OverwriteDeny, OverwriteReplace, OverwriteRename:
+	default:
+		errs = append(errs, fmt.Sprintf("uploads.overwrite: %q is not supported (deny, replace, rename)", c.Overwrite))
+	}
+	errs = append(errs, validateCredentials("uploads.users", c.Users)...)
+	return errs
+}
+
+// validateStaticAccess checks the deny globs, index names and methods of a
+// static site.
+func validateStaticAccess(denyPaths, indexFiles, methods []string) []string {
+	var errs []string
+	for _, pattern := range denyPaths {
+		if pattern == "" || pattern == "/" {
+			errs = append(errs, fmt.Sprintf("deny_paths: %q matches nothing", pattern))
+			continue
+		}
+		for _, seg := range strings.Split(strings.TrimPrefix(pattern, "/"), "/") {
+			if strings.Contains(seg, "**") && seg != "**" {
+				errs = append(errs, fmt.Sprintf("deny_paths: %q: ** must be a whole path segment", pattern))
+			} else if _, err := path.Match(seg, ""); err != nil {
+				errs = append(errs, fmt.Sprintf("deny_paths: %q is not a valid glob", pattern))
+			}
+		}
+	}
+	for _, name := range indexFiles {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			errs = append(errs, fmt.Sprintf("index_files: %q must be a file name", name))
+		}
+	}
+	for _, m := range methods {
+		if m == "" || strings.ContainsFunc(m, func(r rune) bool { return r < 'A' || r > 'Z' }) {
+			errs = append(errs, fmt.Sprintf("allowed_methods: %q must be an uppercase method name such as GET", m))
+		}
+	}
+	return errs
+}
+
+func (c *ImagesConfig) validate() []string {
+	var errs []string
+	for _, list := range []struct {
+		field  string
+		values []int
+		max    int
+	}{{"images.widths", c.Widths, 8192}, {"images.heights", c.Heights, 8192}, {"images.qualities", c.Qualities, 100}} {
+		for _, v := range list.values {
+			if v < 1 || v > list.max {
+				errs = append(errs, fmt.Sprintf("%s: %d must be between 1 and %d", list.field, v, list.max))
+			}
+		}
+	}
+	if c.MaxPixels < 0 || c.CacheMaxBytes < 0 {
+		errs = append(errs, "images limits must not be negative")
+	}
+	if c.CacheDir != "" && !filepath.IsAbs(c.CacheDir) {
+		errs = append(errs, "images.cache_dir must be an absolute path")
+	}
+	return errs
+}
+
+func (c *TemplatesConfig) validate() []string {
+	var errs []string
+	for _, ext := range c.Extensions {
+		if !strings.HasPrefix(ext, ".") || strings.ContainsAny(ext, "/\\") {
+			errs = append(errs, fmt.Sprintf("templates.extensions: %q must look like \".ext\"", ext))
+		}
+	}
+	for _, name := range c.Env {
+		if name == "" || strings.Contains(name, "=") {
+			errs = append(errs, fmt.Sprintf("templates.env: %q is not a variable name", name))
+		}
+	}
+	return errs
+}
+
+func (c *ReleasesConfig) validate(root string) []string {
+	var errs []string
+	if c.Keep < 0 {
+		errs = append(errs, "releases.keep must not be negative")
+	}
+	if c.MaxBytes < 0 {
+		errs = append(errs, "releases.max_bytes must not be negative")
+	}
+	if c.MaxFiles < 0 {
+		errs = append(errs, "releases.max_files must not be negative")
+	}
+	if c.Directory != "" && root != "" {
+		rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(c.Directory))
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			errs = append(errs, "releases.directory must not be inside root_directory")
+		}
+	}
+	return errs
+}
+
+func validateCredentials(field string, users []Credential) []string {
+	var errs []string
+	seen := make(map[string]bool)
+	for i, u := range users {
+		if u.Username == "" || strings.Contains(u.Username, ":") {
+			errs = append(errs, fmt.Sprintf("%s[%d]: username must be set and must not contain ':'", field, i))
+		} else if seen[u.Username] {
+			errs = append(errs, fmt.Sprintf("%s: duplicate user %q", field, u.Username))
+		}
+		seen[u.Username] = true
+		if !strings.HasPrefix(u.PasswordHash, "$2") {
+			errs = append(errs, fmt.Sprintf("%s[%d]: password_hash must be a bcrypt hash (see goup gen-pass)", field, i))
+		}
+	}
+	return errs
+}
+
+// validateTryFiles checks a try_files list and its fallback status.
+func validateTryFiles(field string, list []string, status int) []string {
+	var errs []string
+	if len(list) == 0 {
+		errs = append(errs, field+" must list at least one candidate")
+	}
+	for i, candidate := range list {
+		if code, ok := strings.CutPrefix(candidate, "="); ok {
+			if n, err := strconv.Atoi(code); err != nil || n < 100 || n > 599 {
+				errs = append(errs, fmt.Sprintf("%s: %q is not a valid status code", field, candidate))
+			} else if i != len(list)-1 {
+				errs = append(errs, fmt.Sprintf("%s: %q must be the last candidate", field, candidate))
+			}
+			continue
+		}
+		if !strings.HasPrefix(candidate, "/") && !strings.HasPrefix(candidate, "$uri") {
+			errs = append(errs, fmt.Sprintf("%s: %q must start with / or $uri", field, candidate))
+		}
+	}
+	if status != 0 && status != http.StatusOK && status != http.StatusNotFound {
+		errs = append(errs, field+" status must be 200 or 404")
+	}
+	return errs
+}
+
+// validateOnDemand checks the on-demand TLS settings of a site.
+func (s SSLConfig) validateOnDemand() []string {
+	var errs []string
+	od := s.OnDemand
+	if !s.Enabled || !s.ACME {
+		errs = append(errs, "ssl.on_demand requires ssl.enabled and ssl.acme")
+	}
+	if s.ACMEChallenge() == ChallengeDNS01 {
+		errs = append(errs, "ssl.on_demand cannot use the dns-01 challenge")
+	}
+	if od.Ask == "" && od.AllowList == "" {
+		errs = append(errs, "ssl.on_demand requires ask or allow_list")
+	}
+	if od.Ask != "" {
+		if u, err := url.Parse(od.Ask); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, "ssl.on_demand.ask must be an absolute http(s) URL")
+		}
+	}
+	if od.AllowList != "" {
+		if exists, invalid := CheckPath(od.AllowList); invalid || !exists {
+			errs = append(errs, "ssl.on_demand.allow_list not found (must be an absolute path)")
+		}
+	}
+	if od.RateLimit < 0 {
+		errs = append(errs, "ssl.on_demand.rate_limit must not be negative")
+	}
+	for _, f := range []struct{ name, value string }{{"rate_window", od.RateWindow}, {"negative_ttl", od.NegativeTTL}} {
+		if f.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(f.value); err != nil || d <= 0 {
+			errs = append(errs, fmt.Sprintf("ssl.on_demand.%s is not a positive duration", f.name))
+		}
+	}
+	return errs
+}
+
 // StrictParseSiteConfig parses a site config file rejecting unknown fields, so a
 // typo like "prot" instead of "port" is reported instead of silently ignored.
 func StrictParseSiteConfig(path string) (SiteConfig, error) {
@@ -1,8 +1,15 @@
 package config
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
 
 func TestSiteConfigValidate(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	os.WriteFile(archive, nil, 0644)
+
 	cases := []struct {
 		name     string
 		conf     SiteConfig
@@ -38,6 +45,367 @@ func TestSiteConfigValidate(t *testing.T) {
 			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", FlushInterval: "notaduration"},
 			wantErrs: true,
 		},
+		{
+			name:     "acme wildcard without dns-01",
+			conf:     SiteConfig{Domain: "*.example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true}},
+			wantErrs: true,
+		},
+		{
+			name:     "acme unknown challenge",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, Challenge: "email-01"}},
+			wantErrs: true,
+		},
+		{
+			name:     "acme rfc2136 without nameserver",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, Challenge: ChallengeDNS01, DNSProvider: "rfc2136"}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid acme wildcard site",
+			conf:     SiteConfig{Domain: "*.example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, Challenge: ChallengeDNS01}},
+			wantErrs: false,
+		},
+		{
+			name:     "client auth require without ca bundle",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, ClientAuth: &ClientAuthConfig{Mode: ClientAuthRequire}}},
+			wantErrs: true,
+		},
+		{
+			name:     "client auth unknown mode",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, ClientAuth: &ClientAuthConfig{Mode: "optional"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "client auth without ssl",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", SSL: SSLConfig{ClientAuth: &ClientAuthConfig{Mode: ClientAuthRequest}}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid client auth request",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, ClientAuth: &ClientAuthConfig{Mode: "request", AllowedSANs: []string{"*.clients.example.com"}}}},
+			wantErrs: false,
+		},
+		{
+			name:     "tls policy max below min",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, TLSPolicy: &TLSPolicy{MinVersion: "1.3", MaxVersion: "1.2"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "tls policy unknown cipher suite",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, TLSPolicy: &TLSPolicy{CipherSuites: []string{"TLS_NULL_WITH_NULL_NULL"}}}},
+			wantErrs: true,
+		},
+		{
+			name:     "tls policy unsupported alpn",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, TLSPolicy: &TLSPolicy{ALPN: []string{"h3"}}}},
+			wantErrs: true,
+		},
+		{
+			name: "valid tls policy",
+			conf: SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, TLSPolicy: &TLSPolicy{
+				MinVersion:        "1.2",
+				CipherSuites:      []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+				Curves:            []string{"X25519", "P256"},
+				ALPN:              []string{"h2", "http/1.1"},
+				TicketKeyRotation: "12h",
+			}}},
+			wantErrs: false,
+		},
+		{
+			name:     "unknown ssl mode",
+			conf:     SiteConfig{Domain: "app.localhost", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, Mode: "selfsigned"}},
+			wantErrs: true,
+		},
+		{
+			name:     "internal ssl with acme",
+			conf:     SiteConfig{Domain: "app.localhost", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, Mode: SSLModeInternal, ACME: true}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid internal ssl site",
+			conf:     SiteConfig{Domain: "*.test", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, Mode: SSLModeInternal}},
+			wantErrs: false,
+		},
+		{
+			name:     "on-demand without ask or allow list",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, OnDemand: &OnDemandConfig{}}},
+			wantErrs: true,
+		},
+		{
+			name:     "on-demand without acme",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, Mode: SSLModeInternal, OnDemand: &OnDemandConfig{Ask: "http://localhost:9000/ask"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "on-demand bad negative ttl",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, OnDemand: &OnDemandConfig{Ask: "http://localhost:9000/ask", NegativeTTL: "-1m"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid on-demand site",
+			conf:     SiteConfig{Domain: "example.com", Port: 443, ProxyPass: "http://localhost:3000", SSL: SSLConfig{Enabled: true, ACME: true, OnDemand: &OnDemandConfig{Ask: "http://localhost:9000/ask", RateLimit: 5, RateWindow: "1h"}}},
+			wantErrs: false,
+		},
+		{
+			name:     "try_files on a proxy site",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", TryFiles: []string{"$uri", "/index.html"}},
+			wantErrs: true,
+		},
+		{
+			name:     "try_files status code not last",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", TryFiles: []string{"=404", "$uri"}},
+			wantErrs: true,
+		},
+		{
+			name:     "try_files bad fallback status",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", TryFiles: []string{"$uri", "/index.html"}, TryFilesStatus: 302},
+			wantErrs: true,
+		},
+		{
+			name:     "try_files route without leading slash",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", TryFilesRoutes: []TryFilesRoute{{Path: "app", TryFiles: []string{"$uri", "/app/index.html"}}}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid spa site",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", TryFiles: []string{"$uri", "$uri.html", "$uri/index.html", "/index.html"}, TryFilesRoutes: []TryFilesRoute{{Path: "/api-docs/", TryFiles: []string{"$uri", "=404"}}}},
+			wantErrs: false,
+		},
+		{
+			name:     "compression unknown encoding",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", Compression: &CompressionConfig{Encodings: []string{"deflate"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "compression level out of range",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", Compression: &CompressionConfig{Levels: map[string]int{"br": 12}}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid compression policy",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", Compression: &CompressionConfig{Encodings: []string{"br", "gzip"}, Types: []string{"text/*", "application/json"}, MinLength: 1024, Levels: map[string]int{"br": 5, "gzip": 6}, ExcludePaths: []string{"/events/", "/*.zip"}}},
+			wantErrs: false,
+		},
+		{
+			name:     "file_cache on a proxy site",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", FileCache: &FileCacheConfig{}},
+			wantErrs: true,
+		},
+		{
+			name:     "file_cache file size over budget",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", FileCache: &FileCacheConfig{MaxBytes: 1024, MaxFileSize: 4096}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid file_cache",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", FileCache: &FileCacheConfig{MaxBytes: 32 << 20, MaxFileSize: 128 << 10}},
+			wantErrs: false,
+		},
+		{
+			name:     "listing unknown archive format",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Listing: &ListingConfig{Archives: []string{"rar"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "listing on a proxy site",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000", Listing: &ListingConfig{Readme: true}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid listing",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", FileServerMode: true, Listing: &ListingConfig{Archives: []string{"zip", "tar.gz"}, ArchiveMaxBytes: 1 << 30, ArchiveMaxFiles: 500, Readme: true}},
+			wantErrs: false,
+		},
+		{
+			name:     "webdav path without trailing slash",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", WebDAV: &WebDAVConfig{Path: "/dav"}},
+			wantErrs: true,
+		},
+		{
+			name:     "webdav user with plain password",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", WebDAV: &WebDAVConfig{Users: []Credential{{Username: "ci", PasswordHash: "secret"}}}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid webdav share",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", FileServerMode: true, WebDAV: &WebDAVConfig{Path: "/share/", ReadOnly: true, Users: []Credential{{Username: "ci", PasswordHash: "$2a$10$abcdefghijklmnopqrstuv"}}}},
+			wantErrs: false,
+		},
+		{
+			name:     "uploads extension without dot",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Uploads: &UploadsConfig{AllowedExtensions: []string{"jpg"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "uploads unknown overwrite policy",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Uploads: &UploadsConfig{Overwrite: "merge"}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid uploads",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", FileServerMode: true, Uploads: &UploadsConfig{MaxSize: 1 << 30, AllowedExtensions: []string{".jpg", ".pdf"}, Overwrite: OverwriteRename, AllowDelete: true}},
+			wantErrs: false,
+		},
+		{
+			name:     "releases inside the root directory",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/srv/site", Releases: &ReleasesConfig{Directory: "/srv/site/releases"}},
+			wantErrs: true,
+		},
+		{
+			name:     "releases negative keep",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/srv/site", Releases: &ReleasesConfig{Keep: -1}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid releases",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/srv/site", Releases: &ReleasesConfig{Directory: "/srv/site-releases", Keep: 3}},
+			wantErrs: false,
+		},
+		{
+			name:     "root_archive with root_directory",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", RootArchive: archive},
+			wantErrs: true,
+		},
+		{
+			name:     "root_archive not an archive",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootArchive: filepath.Dir(archive)},
+			wantErrs: true,
+		},
+		{
+			name:     "valid root_archive",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootArchive: archive, Listing: &ListingConfig{Readme: true}},
+			wantErrs: false,
+		},
+		{
+			name:     "cache rule with path and regex",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", CacheRules: []CacheRule{{Path: "*.js", Regex: `\.js$`, CacheControl: "no-cache"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "cache rule with an invalid regex",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", CacheRules: []CacheRule{{Regex: "(", CacheControl: "no-cache"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "cache rule without headers",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", CacheRules: []CacheRule{{Path: "/assets/*"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "cache rule with an invalid expires",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", CacheRules: []CacheRule{{Path: "*.css", Expires: "1 day"}}},
+			wantErrs: true,
+		},
+		{
+			name: "valid cache rules on a proxy",
+			conf: SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://127.0.0.1:3000", CacheAuto: true, CacheRules: []CacheRule{
+				{Path: "/static/*", CacheControl: "public, max-age=86400"},
+				{Regex: `^/img/.+\.webp$`, Expires: "24h", Headers: map[string]string{"Vary": "Accept"}},
+			}},
+			wantErrs: false,
+		},
+		{
+			name:     "templates on a proxy",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://127.0.0.1:3000", Templates: &TemplatesConfig{}},
+			wantErrs: true,
+		},
+		{
+			name:     "templates with an invalid extension",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Templates: &TemplatesConfig{Extensions: []string{"html"}}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid templates",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Templates: &TemplatesConfig{Extensions: []string{".html"}, Env: []string{"SITE_NAME"}}},
+			wantErrs: false,
+		},
+		{
+			name:     "markdown on a proxy",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://127.0.0.1:3000", Markdown: &MarkdownConfig{}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid markdown",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", FileServerMode: true, Markdown: &MarkdownConfig{Extensions: []string{".md"}, CodeStyle: "github-dark"}},
+			wantErrs: false,
+		},
+		{
+			name:     "images on a proxy",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://127.0.0.1:3000", Images: &ImagesConfig{}},
+			wantErrs: true,
+		},
+		{
+			name:     "images with an out of range width",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Images: &ImagesConfig{Widths: []int{320, 10000}}},
+			wantErrs: true,
+		},
+		{
+			name:     "images with an out of range quality",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Images: &ImagesConfig{Qualities: []int{0}}},
+			wantErrs: true,
+		},
+		{
+			name:     "images with a relative cache dir",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Images: &ImagesConfig{CacheDir: "cache"}},
+			wantErrs: true,
+		},
+		{
+			name:     "valid images",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", Images: &ImagesConfig{Widths: []int{320, 640}, Qualities: []int{80}, MaxPixels: 20000000, CacheDir: "/var/cache/goup"}},
+			wantErrs: false,
+		},
+		{
+			name:     "deny_paths on a proxy",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://127.0.0.1:3000", DenyPaths: []string{"*.bak"}},
+			wantErrs: true,
+		},
+		{
+			name:     "deny_paths with an invalid glob",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", DenyPaths: []string{"/private/[a"}},
+			wantErrs: true,
+		},
+		{
+			name:     "deny_paths with a partial **",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", DenyPaths: []string{"/private/**.txt"}},
+			wantErrs: true,
+		},
+		{
+			name:     "index_files with a path",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", IndexFiles: []string{"pages/index.html"}},
+			wantErrs: true,
+		},
+		{
+			name:     "allowed_methods in lowercase",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", AllowedMethods: []string{"get"}},
+			wantErrs: true,
+		},
+		{
+			name:     "follow_symlinks on an archive",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootArchive: "/srv/site.zip", FollowSymlinks: new(bool)},
+			wantErrs: true,
+		},
+		{
+			name: "valid access rules",
+			conf: SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", DenyPaths: []string{"*.bak", "/private/**"},
+				IndexFiles: []string{"index.htm", "default.html"}, AllowedMethods: []string{"GET", "OPTIONS"}, FollowSymlinks: new(bool)},
+			wantErrs: false,
+		},
+		{
+			name:     "negative limit_rate",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", LimitRate: -1},
+			wantErrs: true,
+		},
+		{
+			name:     "limit_rate_routes with a relative path",
+			conf:     SiteConfig{Domain: "example.com", Port: 80, RootDirectory: "/", LimitRateRoutes: []LimitRateRoute{{Path: "downloads/", LimitRate: 1000}}},
+			wantErrs: true,
+		},
+		{
+			name: "valid bandwidth limits",
+			conf: SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://127.0.0.1:3000", LimitRate: 1 << 20, LimitRateAfter: 10 << 20, LimitRatePerIP: 4 << 20,
+				LimitRateRoutes: []LimitRateRoute{{Path: "/api/"}, {Path: "/downloads/", LimitRate: 512 << 10}}},
+			wantErrs: false,
+		},
 		{
 			name:     "valid proxy site",
 			conf:     SiteConfig{Domain: "example.com", Port: 80, ProxyPass: "http://localhost:3000"},
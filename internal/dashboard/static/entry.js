@@ -6,6 +6,7 @@ import Sites from "./js/sites.js";
 import Tools from "./js/tools.js";
 import Search from "./js/search.js";
 import Logs from "./js/logs.js";
+import Certificates from "./js/certificates.js";
 
 const router = new Navigo("/", { hash: false });
 
@@ -23,6 +24,7 @@ router
     "/config": () => render(Config),
     "/tools": () => render(Tools),
     "/logs": () => render(Logs),
+    "/certificates": () => render(Certificates),
   })
   .resolve();
 
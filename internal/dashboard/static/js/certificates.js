@@ -0,0 +1,64 @@
+import Search from "./search.js";
+import Toast from "./toasts.js";
+
+const badges = {
+  valid: "bg-green-100 text-green-700",
+  pending: "bg-gray-100 text-gray-600",
+  expiring: "bg-yellow-100 text-yellow-700",
+  expired: "bg-red-100 text-red-700",
+  error: "bg-red-100 text-red-700",
+};
+
+Handlebars.registerHelper("join", (list) => (list || []).join(", "));
+
+const Certificates = {
+  render: async (containerId, searchTerm = "") => {
+    const resp = await fetch("/templates/certificates.html");
+    const templateText = await resp.text();
+    const template = Handlebars.compile(templateText);
+
+    const certsResp = await fetch("/api/certificates");
+    const certificates = (await certsResp.json()).map((c) => ({
+      ...c,
+      badge: badges[c.status] || badges.pending,
+      expires: c.not_after ? new Date(c.not_after).toLocaleString() : "",
+    }));
+    const warnings = certificates.filter(
+      (c) => c.status !== "valid" && c.status !== "pending"
+    );
+
+    document.querySelector(containerId).innerHTML = template({
+      certificates,
+      warnings,
+    });
+
+    document
+      .getElementById("certificatesList")
+      .addEventListener("click", async (e) => {
+        const renewButton = e.target.closest(".renew-cert");
+        if (!renewButton) return;
+        const domain = renewButton.dataset.domain;
+        if (!confirm(`Request a new certificate for ${domain}?`)) return;
+
+        renewButton.disabled = true;
+        Toast.show(`Renewing ${domain}...`, "info", 3000);
+        const renewResp = await fetch(
+          `/api/certificates/${encodeURIComponent(domain)}/renew`,
+          { method: "POST" }
+        );
+        if (renewResp.ok) {
+          Toast.show(`Certificate renewed for ${domain}`, "success", 3000);
+        } else {
+          const text = await renewResp.text();
+          Toast.show(`Renewal failed: ${text}`, "error", 6000);
+        }
+        Certificates.render(containerId);
+      });
+
+    window.currentView.applySearch = (searchTerm) => {
+      Search.filterList("#certificatesList li", searchTerm);
+    };
+  },
+};
+
+export default Certificates;
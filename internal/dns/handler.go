@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/mirkobrombin/goup/internal/acmedns"
 	"github.com/mirkobrombin/goup/internal/config"
 	"github.com/mirkobrombin/goup/internal/logger"
 )
@@ -213,11 +214,23 @@ func (h *DNSHandler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 }
 
 func (h *DNSHandler) findRecords(qname string, qtype uint16) (answers []dns.RR, foundName bool) {
-	foundName = h.names[qname]
+	// Pending ACME DNS-01 challenges are published at runtime, outside the
+	// pre-built index.
+	challenge := acmedns.Lookup(qname)
+	foundName = h.names[qname] || len(challenge) > 0
 	if !foundName {
 		return nil, false
 	}
 
+	if qtype == dns.TypeTXT {
+		for _, v := range challenge {
+			answers = append(answers, &dns.TXT{
+				Hdr: dns.RR_Header{Name: qname, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: []string{v},
+			})
+		}
+	}
+
 	if qtype != dns.TypeANY {
 		if byType, ok := h.rrIndex[qname]; ok {
 			answers = append(answers, byType[qtype]...)
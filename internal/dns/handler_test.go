@@ -1,10 +1,12 @@
 package dns
 
 import (
+	"context"
 	"net"
 	"testing"
 
 	"github.com/miekg/dns"
+	"github.com/mirkobrombin/goup/internal/acmedns"
 	"github.com/mirkobrombin/goup/internal/config"
 )
 
@@ -118,3 +120,48 @@ func (m *mockResponseWriter) Close() error              { return nil }
 func (m *mockResponseWriter) TsigStatus() error         { return nil }
 func (m *mockResponseWriter) TsigTimersOnly(bool)       {}
 func (m *mockResponseWriter) Hijack()                   {}
+
+func TestDNSHandler_ACMEChallenge(t *testing.T) {
+	conf := &config.DNSConfig{
+		Enable: true,
+		Zones: map[string][]config.DNSRecord{
+			"example.com": {{Type: "A", Name: "@", Value: "1.2.3.4", TTL: 3600}},
+		},
+	}
+	handler, err := NewDNSHandler(conf)
+	if err != nil {
+		t.Fatalf("Failed to create handler: %v", err)
+	}
+
+	qname := acmedns.ChallengeName("*.example.com")
+	query := func(qtype uint16) *dns.Msg {
+		w := &mockResponseWriter{}
+		req := new(dns.Msg)
+		req.SetQuestion(qname, qtype)
+		handler.ServeDNS(w, req)
+		return w.msg
+	}
+
+	if msg := query(dns.TypeTXT); msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN before publishing, got %v", msg.Rcode)
+	}
+
+	p := acmedns.InternalProvider{}
+	if err := p.Present(context.Background(), qname, "token-value"); err != nil {
+		t.Fatalf("Present: %v", err)
+	}
+	msg := query(dns.TypeTXT)
+	if len(msg.Answer) != 1 || msg.Answer[0].(*dns.TXT).Txt[0] != "token-value" {
+		t.Fatalf("expected the challenge TXT record, got %v", msg.Answer)
+	}
+	if msg := query(dns.TypeA); msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 0 {
+		t.Fatalf("expected NODATA for A on the challenge name, got %v", msg)
+	}
+
+	if err := p.CleanUp(context.Background(), qname, "token-value"); err != nil {
+		t.Fatalf("CleanUp: %v", err)
+	}
+	if msg := query(dns.TypeTXT); msg.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN after cleanup, got %v", msg.Rcode)
+	}
+}
@@ -0,0 +1,217 @@
+// Package markdown renders the Markdown files of a static site to HTML:
+// GitHub Flavored Markdown with heading anchors, a table of contents,
+// syntax-highlighted code blocks and relative links resolved against the
+// directory of the file. Raw HTML in the source is left out, so files
+// written by visitors (uploads, WebDAV) can't inject scripts.
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultCodeStyle is the highlighting theme used unless configured; it
+// suits the dark GoUp pages.
+const DefaultCodeStyle = "monokai"
+
+// minTOCHeadings is how many section headings a document needs for a
+// table of contents.
+const minTOCHeadings = 3
+
+// Document is a rendered Markdown file.
+type Document struct {
+	Title   string        // text of the first level 1 heading, if any
+	HTML    template.HTML // the rendered body
+	TOC     []Heading     // level 2 and 3 headings, when there are enough
+	CodeCSS template.CSS  // rules of the highlighted code blocks
+}
+
+// Heading is an entry of a table of contents.
+type Heading struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// dirKey holds the URL directory of the file being parsed.
+var dirKey = parser.NewContextKey()
+
+var (
+	md = goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(util.Prioritized(linkResolver{}, 100)),
+		),
+		goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(codeRenderer{}, 100)),
+		),
+	)
+	codeFormatter = chromahtml.New(chromahtml.WithClasses(true), chromahtml.TabWidth(4))
+	codeCSS       sync.Map // style name -> template.CSS
+)
+
+// Render converts src to HTML. dir is the URL directory of the file (e.g.
+// "/docs"), against which relative links and images are resolved, so the
+// document reads the same wherever it is shown. codeStyle is a Chroma
+// style name; unknown names fall back to DefaultCodeStyle.
+func Render(src []byte, dir, codeStyle string) (*Document, error) {
+	ctx := parser.NewContext()
+	ctx.Set(dirKey, dir)
+	doc := md.Parser().Parse(text.NewReader(src), parser.WithContext(ctx))
+
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, src, doc); err != nil {
+		return nil, err
+	}
+	d := &Document{HTML: template.HTML(buf.String()), CodeCSS: css(codeStyle)}
+
+	var toc []Heading
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		title := plainText(h, src)
+		switch {
+		case h.Level == 1 && d.Title == "":
+			d.Title = title
+		case h.Level == 2 || h.Level == 3:
+			id, _ := h.AttributeString("id")
+			idBytes, _ := id.([]byte)
+			toc = append(toc, Heading{Level: h.Level, ID: string(idBytes), Text: title})
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	if len(toc) >= minTOCHeadings {
+		d.TOC = toc
+	}
+	return d, nil
+}
+
+// css returns the stylesheet of a Chroma style, generated once.
+func css(name string) template.CSS {
+	style := styles.Get(name)
+	if name == "" || style == styles.Fallback {
+		style = styles.Get(DefaultCodeStyle)
+	}
+	if v, ok := codeCSS.Load(style.Name); ok {
+		return v.(template.CSS)
+	}
+	var buf bytes.Buffer
+	codeFormatter.WriteCSS(&buf, style)
+	v, _ := codeCSS.LoadOrStore(style.Name, template.CSS(buf.String()))
+	return v.(template.CSS)
+}
+
+// plainText returns the text of an inline tree, formatting left out.
+func plainText(n ast.Node, src []byte) string {
+	var b strings.Builder
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			b.Write(c.Segment.Value(src))
+			if c.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(c.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return b.String()
+}
+
+// linkResolver rewrites relative link and image destinations to absolute
+// paths from the directory of the document.
+type linkResolver struct{}
+
+func (linkResolver) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	dir, _ := pc.Get(dirKey).(string)
+	if dir == "" {
+		return
+	}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = resolve(dir, n.Destination)
+		case *ast.Image:
+			n.Destination = resolve(dir, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// resolve makes dest absolute when it is a relative path.
+func resolve(dir string, dest []byte) []byte {
+	s := string(dest)
+	if s == "" || strings.HasPrefix(s, "/") || strings.HasPrefix(s, "#") || strings.HasPrefix(s, "?") {
+		return dest
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Opaque != "" {
+		return dest
+	}
+	p := path.Join(dir, u.Path)
+	if strings.HasSuffix(u.Path, "/") && p != "/" {
+		p += "/"
+	}
+	u.Path = p
+	return []byte(u.String())
+}
+
+// codeRenderer highlights fenced code blocks with Chroma, by the language
+// of the fence.
+type codeRenderer struct{}
+
+func (r codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCode)
+}
+
+func (codeRenderer) renderFencedCode(w util.BufWriter, src []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+	var code strings.Builder
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		seg := lines.At(i)
+		code.Write(seg.Value(src))
+	}
+
+	lexer := lexers.Get(string(n.Language(src)))
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		w.WriteString("<pre><code>")
+		w.WriteString(template.HTMLEscapeString(code.String()))
+		w.WriteString("</code></pre>\n")
+		return ast.WalkSkipChildren, nil
+	}
+	return ast.WalkSkipChildren, codeFormatter.Format(w, styles.Fallback, tokens)
+}
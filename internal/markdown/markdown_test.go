@@ -0,0 +1,65 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	src := "# Guide\n\n" +
+		"See [setup](setup.md#install), [up](../index.md), [docs](sub/), [top](/about) and [site](https://example.com/a). ![logo](img/logo.png)\n\n" +
+		"<script>alert(1)</script>\n\n" +
+		"## Install\n\n## Configure `goup`\n\n### Options\n\n" +
+		"```go\nfunc main() {}\n```\n"
+	doc, err := Render([]byte(src), "/docs/guide", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Title != "Guide" {
+		t.Errorf("Title = %q", doc.Title)
+	}
+	html := string(doc.HTML)
+	for _, want := range []string{
+		`href="/docs/guide/setup.md#install"`,
+		`href="/docs/index.md"`,
+		`href="/docs/guide/sub/"`,
+		`href="/about"`,
+		`href="https://example.com/a"`,
+		`src="/docs/guide/img/logo.png"`,
+		`<h2 id="install">Install</h2>`,
+		`<pre class="chroma">`,
+		`<span class="kd">func</span>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("missing %s in\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "<script>") {
+		t.Error("raw HTML was rendered")
+	}
+	want := []Heading{{2, "install", "Install"}, {2, "configure-goup", "Configure goup"}, {3, "options", "Options"}}
+	if len(doc.TOC) != len(want) {
+		t.Fatalf("TOC = %+v", doc.TOC)
+	}
+	for i := range want {
+		if doc.TOC[i] != want[i] {
+			t.Errorf("TOC[%d] = %+v, want %+v", i, doc.TOC[i], want[i])
+		}
+	}
+	if !strings.Contains(string(doc.CodeCSS), ".chroma") {
+		t.Error("no stylesheet for the code blocks")
+	}
+}
+
+func TestRenderShortDocumentHasNoTOC(t *testing.T) {
+	doc, err := Render([]byte("## One\n\n## Two\n"), "/", "no-such-style")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.TOC != nil {
+		t.Errorf("TOC = %+v, want none for two headings", doc.TOC)
+	}
+	if doc.CodeCSS != css(DefaultCodeStyle) {
+		t.Error("an unknown style did not fall back to the default")
+	}
+}
@@ -1,9 +1,13 @@
 package middleware
 
 import (
+	"crypto/sha256"
 	"crypto/subtle"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mirkobrombin/goup/internal/config"
 	"golang.org/x/crypto/bcrypt"
@@ -72,3 +76,89 @@ func TokenAuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// credentialCacheTTL is how long SiteBasicAuth trusts a verified password.
+const credentialCacheTTL = 5 * time.Minute
+
+// SiteAuth checks Basic Authentication credentials for part of a site,
+// against its users or against the global account when it has none. Like
+// the dashboard it fails closed when no credentials are configured.
+// Verified credentials are remembered for a few minutes: clients such as
+// WebDAV mounts authenticate every request and bcrypt is deliberately slow.
+type SiteAuth struct {
+	users     []config.Credential
+	challenge string
+
+	verified      sync.Map // sha256(user, password, hash) -> expiry
+	verifiedCount atomic.Int64
+}
+
+// NewSiteAuth returns the authenticator of a protection realm.
+func NewSiteAuth(realm string, users []config.Credential) *SiteAuth {
+	return &SiteAuth{
+		users:     users,
+		challenge: `Basic realm="` + strings.ReplaceAll(realm, `"`, "") + `"`,
+	}
+}
+
+// SiteBasicAuth protects a handler with NewSiteAuth(realm, users).
+func SiteBasicAuth(realm string, users []config.Credential) func(http.Handler) http.Handler {
+	return NewSiteAuth(realm, users).Middleware
+}
+
+// Middleware rejects the requests that are not Authorized.
+func (a *SiteAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Authorized(r) {
+			a.Challenge(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Challenge answers 401 with the realm, so browsers prompt for credentials.
+func (a *SiteAuth) Challenge(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", a.challenge)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// Authorized reports whether r carries valid credentials.
+func (a *SiteAuth) Authorized(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	accounts := a.users
+	if len(accounts) == 0 {
+		config.GlobalConfMu.RLock()
+		if conf := config.GlobalConf; conf != nil && conf.Account.Username != "" && conf.Account.PasswordHash != "" {
+			accounts = []config.Credential{{Username: conf.Account.Username, PasswordHash: conf.Account.PasswordHash}}
+		}
+		config.GlobalConfMu.RUnlock()
+	}
+
+	var account *config.Credential
+	for i := range accounts {
+		if subtle.ConstantTimeCompare([]byte(user), []byte(accounts[i].Username)) == 1 {
+			account = &accounts[i]
+		}
+	}
+	if account == nil {
+		return false
+	}
+
+	key := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + account.PasswordHash))
+	if exp, hit := a.verified.Load(key); hit && time.Now().Before(exp.(time.Time)) {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(pass)) != nil {
+		return false
+	}
+	if a.verifiedCount.Add(1) > 1024 {
+		a.verified.Clear()
+		a.verifiedCount.Store(0)
+	}
+	a.verified.Store(key, time.Now().Add(credentialCacheTTL))
+	return true
+}
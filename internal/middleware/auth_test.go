@@ -115,6 +115,17 @@ func TestAuthDeniedIfUnconfigured(t *testing.T) {
 		}
 	})
 
+	t.Run("SiteBasicAuthDenied", func(t *testing.T) {
+		middleware := SiteBasicAuth("Share", nil)(nextHandler)
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth("", "")
+		rec := httptest.NewRecorder()
+		middleware.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %v, got %v", http.StatusUnauthorized, rec.Code)
+		}
+	})
+
 	t.Run("TokenAuthDenied", func(t *testing.T) {
 		middleware := TokenAuthMiddleware(nextHandler)
 		req := httptest.NewRequest("GET", "/", nil)
@@ -125,3 +136,45 @@ func TestAuthDeniedIfUnconfigured(t *testing.T) {
 		}
 	})
 }
+
+func TestSiteBasicAuth(t *testing.T) {
+	adminHash, _ := bcrypt.GenerateFromPassword([]byte("admin-pw"), bcrypt.MinCost)
+	userHash, _ := bcrypt.GenerateFromPassword([]byte("ci-pw"), bcrypt.MinCost)
+	config.GlobalConf = &config.GlobalConfig{
+		Account: config.AccountConfig{Username: "admin", PasswordHash: string(adminHash)},
+	}
+	nextHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		users          []config.Credential
+		user, pass     string
+		expectedStatus int
+	}{
+		{"SiteUser", []config.Credential{{Username: "ci", PasswordHash: string(userHash)}}, "ci", "ci-pw", http.StatusOK},
+		{"SiteUserWrongPassword", []config.Credential{{Username: "ci", PasswordHash: string(userHash)}}, "ci", "admin-pw", http.StatusUnauthorized},
+		{"GlobalAccountNotAllowedWithSiteUsers", []config.Credential{{Username: "ci", PasswordHash: string(userHash)}}, "admin", "admin-pw", http.StatusUnauthorized},
+		{"GlobalAccountFallback", nil, "admin", "admin-pw", http.StatusOK},
+		{"GlobalAccountWrongPassword", nil, "admin", "ci-pw", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			middleware := SiteBasicAuth("Share", tt.users)(nextHandler)
+			// Twice, the second time from the verified credentials cache.
+			for range 2 {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.SetBasicAuth(tt.user, tt.pass)
+				rec := httptest.NewRecorder()
+				middleware.ServeHTTP(rec, req)
+				if rec.Code != tt.expectedStatus {
+					t.Fatalf("expected status %v, got %v", tt.expectedStatus, rec.Code)
+				}
+				if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") != `Basic realm="Share"` {
+					t.Errorf("unexpected challenge %q", rec.Header().Get("WWW-Authenticate"))
+				}
+			}
+		})
+	}
+}
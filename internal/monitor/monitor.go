@@ -64,3 +64,51 @@ func RecentRequestLogs(limit int) []RequestLog {
 func RequestCount() uint64 {
 	return atomic.LoadUint64(&requestCount)
 }
+
+// FileCacheStats counts the activity of a site's in-memory static file
+// cache.
+type FileCacheStats struct {
+	Hits      atomic.Uint64
+	Misses    atomic.Uint64
+	Evictions atomic.Uint64
+	Entries   atomic.Int64
+	Bytes     atomic.Int64
+}
+
+var fileCaches = struct {
+	sync.Mutex
+	byDomain map[string]*FileCacheStats
+}{byDomain: make(map[string]*FileCacheStats)}
+
+// FileCache returns the counters of domain's file cache, creating them on
+// first use. Hits and misses survive a reload of the site; the entry and
+// byte gauges belong to the current cache and are reset.
+func FileCache(domain string) *FileCacheStats {
+	fileCaches.Lock()
+	defer fileCaches.Unlock()
+	s, ok := fileCaches.byDomain[domain]
+	if !ok {
+		s = &FileCacheStats{}
+		fileCaches.byDomain[domain] = s
+	}
+	s.Entries.Store(0)
+	s.Bytes.Store(0)
+	return s
+}
+
+// FileCacheSnapshot returns the counters of every site's file cache.
+func FileCacheSnapshot() map[string]map[string]any {
+	fileCaches.Lock()
+	defer fileCaches.Unlock()
+	out := make(map[string]map[string]any, len(fileCaches.byDomain))
+	for domain, s := range fileCaches.byDomain {
+		out[domain] = map[string]any{
+			"hits":      s.Hits.Load(),
+			"misses":    s.Misses.Load(),
+			"evictions": s.Evictions.Load(),
+			"entries":   s.Entries.Load(),
+			"bytes":     s.Bytes.Load(),
+		}
+	}
+	return out
+}
@@ -0,0 +1,226 @@
+package releases
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// extractor unpacks the entries of an archive below dir within the limits
+// of a release.
+type extractor struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+	files    int
+	bytes    int64
+}
+
+// extract unpacks the tar.gz or zip archive f, of the given size, into dir
+// and returns the number and total size of its files. Only directories and
+// regular files are accepted: links and special files make the archive
+// invalid, as do names escaping dir.
+func extract(f *os.File, size int64, dir string, maxBytes int64, maxFiles int) (int, int64, error) {
+	var magic [4]byte
+	if _, err := f.ReadAt(magic[:], 0); err != nil {
+		return 0, 0, fmt.Errorf("%w: unknown format", ErrInvalidArchive)
+	}
+	x := &extractor{dir: dir, maxBytes: maxBytes, maxFiles: maxFiles}
+	var err error
+	switch {
+	case bytes.HasPrefix(magic[:], []byte{0x1f, 0x8b}):
+		err = x.tarGz(io.NewSectionReader(f, 0, size))
+	case bytes.Equal(magic[:], []byte("PK\x03\x04")):
+		err = x.zip(f, size)
+	default:
+		return 0, 0, fmt.Errorf("%w: expected a tar.gz or zip archive", ErrInvalidArchive)
+	}
+	return x.files, x.bytes, err
+}
+
+func (x *extractor) tarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(hdr.Name)
+		case tar.TypeReg:
+			err = x.file(hdr.Name, tr, hdr.ModTime)
+		default:
+			err = fmt.Errorf("%w: %s is not a regular file or directory", ErrInvalidArchive, hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (x *extractor) zip(f *os.File, size int64) error {
+	zr, err := zip.NewReader(f, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(zf.Name)
+		case mode.IsRegular():
+			var rc io.ReadCloser
+			if rc, err = zf.Open(); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+			}
+			err = x.file(zf.Name, rc, zf.Modified)
+			rc.Close()
+		default:
+			err = fmt.Errorf("%w: %s is not a regular file or directory", ErrInvalidArchive, zf.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// local maps an archive name to a path below x.dir; "" is the root.
+func (x *extractor) local(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: absolute name %s", ErrInvalidArchive, name)
+	}
+	for _, seg := range strings.Split(name, "/") {
+		if seg == ".." {
+			return "", fmt.Errorf("%w: %s points outside the release", ErrInvalidArchive, name)
+		}
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if rel == "" {
+		return x.dir, nil
+	}
+	local, err := filepath.Localize(rel)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+	}
+	return filepath.Join(x.dir, local), nil
+}
+
+func (x *extractor) mkdir(name string) error {
+	p, err := x.local(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	return nil
+}
+
+// file writes one file, with fixed permissions (the archive's modes, setuid
+// bits included, are ignored) and the archived modification time.
+func (x *extractor) file(name string, r io.Reader, modTime time.Time) error {
+	p, err := x.local(name)
+	if err != nil {
+		return err
+	}
+	if p == x.dir {
+		return fmt.Errorf("%w: invalid file name %q", ErrInvalidArchive, name)
+	}
+	x.files++
+	if x.files > x.maxFiles {
+		return ErrTooLarge
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	out, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	n, err := io.Copy(out, io.LimitReader(r, x.maxBytes-x.bytes+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	x.bytes += n
+	if x.bytes > x.maxBytes {
+		return ErrTooLarge
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+	}
+	if !modTime.IsZero() {
+		os.Chtimes(p, modTime, modTime)
+	}
+	return nil
+}
+
+// Pack writes the regular files and directories below dir to w as a tar.gz
+// archive suitable for Deploy. Symlinks and special files are skipped.
+func Pack(dir string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, io.LimitReader(f, info.Size())); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package releases
+
+import "os"
+
+// lockFile is a no-op where flock is not available: changes are then only
+// serialized within a process.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package releases
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDeployHoldsTheDirectoryLock(t *testing.T) {
+	s, _ := newTestSite(t, 0)
+	unlock, err := s.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process opens the lock file on its own.
+	f, err := os.Open(filepath.Join(s.dir, lockName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != unix.EWOULDBLOCK {
+		t.Fatalf("flock while held: %v, want EWOULDBLOCK", err)
+	}
+	unlock()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		t.Fatalf("flock once released: %v", err)
+	}
+
+	// A deploy waits for the other process.
+	archive := siteArchive(t, "v1")
+	done := make(chan error)
+	go func() {
+		_, err := s.Deploy(bytes.NewReader(archive))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("deploy did not wait for the lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,418 @@
+// Package releases deploys static sites atomically: every release is
+// unpacked into a directory of its own and root_directory, a symlink, is
+// switched to it with a single rename, so requests never see a half-updated
+// tree. Older releases are kept for rollbacks.
+package releases
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Defaults of config.ReleasesConfig.
+const (
+	DefaultKeep     = 5
+	DefaultMaxBytes = 1 << 30
+	DefaultMaxFiles = 100000
+)
+
+const (
+	// metaFile describes a release inside its directory. Being a dotfile,
+	// it is never served.
+	metaFile = ".goup-release.json"
+	// tempPrefix marks archives and directories of deploys in progress.
+	tempPrefix = ".tmp-"
+	// lockName is locked by every change to the releases directory, so a
+	// deploy from the CLI and one through the API never interleave.
+	lockName = ".lock"
+	idLayout = "20060102T150405Z"
+)
+
+// Errors returned by the operations on a site.
+var (
+	ErrNotEnabled     = errors.New("releases are not enabled for this site")
+	ErrUnknownRelease = errors.New("no such release")
+	ErrNoPrevious     = errors.New("there is no older release to roll back to")
+	ErrInvalidArchive = errors.New("invalid release archive")
+	ErrTooLarge       = errors.New("release exceeds the size limits")
+)
+
+// Release is a deployed version of a site.
+type Release struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"`
+	Bytes   int64     `json:"bytes"`
+	Active  bool      `json:"active"`
+}
+
+// Site manages the releases of a static site.
+type Site struct {
+	Domain   string
+	root     string
+	dir      string
+	keep     int
+	maxBytes int64
+	maxFiles int
+	mu       *sync.Mutex
+}
+
+var (
+	// locks serializes the changes to a releases directory between the
+	// Site values of a process; lockName does so between processes.
+	locks   = make(map[string]*sync.Mutex)
+	locksMu sync.Mutex
+
+	hooks   []func(domain string)
+	hooksMu sync.Mutex
+)
+
+// OnActivate registers fn to run after a site switched release, e.g. to
+// drop caches of the previous tree.
+func OnActivate(fn func(domain string)) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, fn)
+}
+
+func notify(domain string) {
+	hooksMu.Lock()
+	fns := slices.Clone(hooks)
+	hooksMu.Unlock()
+	for _, fn := range fns {
+		fn(domain)
+	}
+}
+
+// ForSite returns the releases of a site, or ErrNotEnabled.
+func ForSite(conf config.SiteConfig) (*Site, error) {
+	if conf.Releases == nil || conf.RootDirectory == "" {
+		return nil, ErrNotEnabled
+	}
+	root := filepath.Clean(conf.RootDirectory)
+	s := &Site{
+		Domain:   conf.Domain,
+		root:     root,
+		dir:      conf.Releases.Directory,
+		keep:     conf.Releases.Keep,
+		maxBytes: conf.Releases.MaxBytes,
+		maxFiles: conf.Releases.MaxFiles,
+	}
+	if s.dir == "" {
+		s.dir = root + ".releases"
+	}
+	s.dir = filepath.Clean(s.dir)
+	if s.keep == 0 {
+		s.keep = DefaultKeep
+	}
+	if s.maxBytes == 0 {
+		s.maxBytes = DefaultMaxBytes
+	}
+	if s.maxFiles == 0 {
+		s.maxFiles = DefaultMaxFiles
+	}
+
+	locksMu.Lock()
+	if locks[s.dir] == nil {
+		locks[s.dir] = &sync.Mutex{}
+	}
+	s.mu = locks[s.dir]
+	locksMu.Unlock()
+	return s, nil
+}
+
+// List returns the releases of the site, newest first.
+func (s *Site) List() ([]Release, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+func (s *Site) list() ([]Release, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	active := s.active()
+	var releases []Release
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		rel := Release{ID: e.Name(), Active: e.Name() == active}
+		if data, err := os.ReadFile(filepath.Join(s.dir, e.Name(), metaFile)); err == nil {
+			json.Unmarshal(data, &rel)
+			rel.ID, rel.Active = e.Name(), e.Name() == active
+		} else if info, err := e.Info(); err == nil {
+			rel.Created = info.ModTime()
+		}
+		releases = append(releases, rel)
+	}
+	slices.SortFunc(releases, func(a, b Release) int {
+		if c := b.Created.Compare(a.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID, a.ID)
+	})
+	return releases, nil
+}
+
+// active returns the ID of the release root_directory points to, if any.
+func (s *Site) active() string {
+	target, err := os.Readlink(s.root)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(s.root), target)
+	}
+	if filepath.Dir(filepath.Clean(target)) != s.dir {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// Deploy unpacks a tar.gz or zip archive read from r into a new release,
+// activates it and prunes the releases beyond the ones to keep. Nothing
+// changes unless the whole archive is valid.
+func (s *Site) Deploy(r io.Reader) (Release, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Release{}, err
+	}
+	defer unlock()
+
+	s.removeStale()
+	if err := s.adopt(); err != nil {
+		return Release{}, err
+	}
+
+	archive, err := os.CreateTemp(s.dir, tempPrefix+"*.archive")
+	if err != nil {
+		return Release{}, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	size, err := io.Copy(archive, io.LimitReader(r, s.maxBytes+1))
+	if err != nil {
+		return Release{}, err
+	}
+	if size > s.maxBytes {
+		return Release{}, ErrTooLarge
+	}
+
+	staging, err := os.MkdirTemp(s.dir, tempPrefix+"*")
+	if err != nil {
+		return Release{}, err
+	}
+	defer os.RemoveAll(staging)
+	os.Chmod(staging, 0755)
+	files, total, err := extract(archive, size, staging, s.maxBytes, s.maxFiles)
+	if err != nil {
+		return Release{}, err
+	}
+	if files == 0 {
+		return Release{}, fmt.Errorf("%w: no files", ErrInvalidArchive)
+	}
+
+	rel := Release{ID: s.newID(), Created: time.Now().UTC().Truncate(time.Second), Files: files, Bytes: total}
+	if err := writeMeta(staging, rel); err != nil {
+		return Release{}, err
+	}
+	if err := os.Rename(staging, filepath.Join(s.dir, rel.ID)); err != nil {
+		return Release{}, err
+	}
+	if err := s.activate(rel.ID); err != nil {
+		return Release{}, err
+	}
+	s.prune()
+	rel.Active = true
+	return rel, nil
+}
+
+// Activate switches the site to a kept release.
+func (s *Site) Activate(id string) (Release, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Release{}, err
+	}
+	defer unlock()
+
+	releases, err := s.list()
+	if err != nil {
+		return Release{}, err
+	}
+	i := slices.IndexFunc(releases, func(r Release) bool { return r.ID == id })
+	if i < 0 {
+		return Release{}, ErrUnknownRelease
+	}
+	if err := s.activate(id); err != nil {
+		return Release{}, err
+	}
+	releases[i].Active = true
+	return releases[i], nil
+}
+
+// Rollback switches the site to the release preceding the active one.
+func (s *Site) Rollback() (Release, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return Release{}, err
+	}
+	defer unlock()
+
+	releases, err := s.list()
+	if err != nil {
+		return Release{}, err
+	}
+	i := slices.IndexFunc(releases, func(r Release) bool { return r.Active })
+	if i < 0 || i+1 >= len(releases) {
+		return Release{}, ErrNoPrevious
+	}
+	prev := releases[i+1]
+	if err := s.activate(prev.ID); err != nil {
+		return Release{}, err
+	}
+	prev.Active = true
+	return prev, nil
+}
+
+// lock takes the locks of the releases directory, creating it if needed,
+// and returns the function releasing them.
+func (s *Site) lock() (func(), error) {
+	s.mu.Lock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, lockName), os.O_RDWR|os.O_CREATE, 0644)
+	if err == nil {
+		if err = lockFile(f); err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		f.Close()
+		s.mu.Unlock()
+	}, nil
+}
+
+// activate points root_directory at a release: a new symlink is renamed
+// over the old one, which is atomic.
+func (s *Site) activate(id string) error {
+	target := filepath.Join(s.dir, id)
+	if rel, err := filepath.Rel(filepath.Dir(s.root), target); err == nil {
+		target = rel
+	}
+	next := s.root + ".goup-next"
+	os.Remove(next)
+	if err := os.Symlink(target, next); err != nil {
+		return err
+	}
+	if err := os.Rename(next, s.root); err != nil {
+		os.Remove(next)
+		return err
+	}
+	notify(s.Domain)
+	return nil
+}
+
+// adopt turns a root_directory that is still a plain directory into the
+// first release. Unlike later switches this isn't atomic: requests in the
+// instant between the move and the symlink get a 404.
+func (s *Site) adopt() error {
+	info, err := os.Lstat(s.root)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.Mode()&fs.ModeSymlink != 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is neither a directory nor a symlink", s.root)
+	}
+
+	rel := Release{ID: s.newID(), Created: time.Now().UTC().Truncate(time.Second)}
+	filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			rel.Files++
+			if info, err := d.Info(); err == nil {
+				rel.Bytes += info.Size()
+			}
+		}
+		return nil
+	})
+	dst := filepath.Join(s.dir, rel.ID)
+	if err := os.Rename(s.root, dst); err != nil {
+		return fmt.Errorf("moving %s into the releases directory (it must be on the same filesystem): %w", s.root, err)
+	}
+	writeMeta(dst, rel)
+	return s.activate(rel.ID)
+}
+
+// newID returns an unused, chronologically sortable release ID.
+func (s *Site) newID() string {
+	base := time.Now().UTC().Format(idLayout)
+	id := base
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(filepath.Join(s.dir, id)); errors.Is(err, fs.ErrNotExist) {
+			return id
+		}
+		id = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// prune removes the oldest releases beyond the ones to keep, never the
+// active one.
+func (s *Site) prune() {
+	releases, err := s.list()
+	if err != nil {
+		return
+	}
+	kept := 0
+	for _, r := range releases {
+		if kept < s.keep || r.Active {
+			kept++
+			continue
+		}
+		os.RemoveAll(filepath.Join(s.dir, r.ID))
+	}
+}
+
+// removeStale drops what interrupted deploys left behind.
+func (s *Site) removeStale() {
+	entries, _ := os.ReadDir(s.dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), tempPrefix) {
+			os.RemoveAll(filepath.Join(s.dir, e.Name()))
+		}
+	}
+}
+
+func writeMeta(dir string, rel Release) error {
+	rel.Active = false
+	data, err := json.Marshal(rel)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, metaFile), data, 0644)
+}
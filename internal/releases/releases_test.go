@@ -0,0 +1,233 @@
+package releases
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func tarGz(t *testing.T, entries []tar.Header, content map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range entries {
+		body := content[hdr.Name]
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(body))
+		}
+		hdr.Mode = 0644
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(body))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func siteArchive(t *testing.T, index string) []byte {
+	return tarGz(t, []tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir},
+		{Name: "./index.html", Typeflag: tar.TypeReg},
+		{Name: "./css/site.css", Typeflag: tar.TypeReg},
+	}, map[string]string{"./index.html": index, "./css/site.css": "body{}"})
+}
+
+func newTestSite(t *testing.T, keep int) (*Site, string) {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "site")
+	s, err := ForSite(config.SiteConfig{Domain: "example.com", RootDirectory: root, Releases: &config.ReleasesConfig{Keep: keep}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, root
+}
+
+func readRoot(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestDeployActivatesAndRollsBack(t *testing.T) {
+	s, root := newTestSite(t, 0)
+	var switched []string
+	OnActivate(func(domain string) { switched = append(switched, domain) })
+
+	first, err := s.Deploy(bytes.NewReader(siteArchive(t, "v1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Files != 2 || first.Bytes != 8 || !first.Active {
+		t.Errorf("first release: %+v", first)
+	}
+	if info, err := os.Lstat(root); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("root_directory should be a symlink: %v", err)
+	}
+	if got := readRoot(t, root, "index.html"); got != "v1" {
+		t.Errorf("index.html = %q", got)
+	}
+
+	if _, err := s.Deploy(bytes.NewReader(siteArchive(t, "v2"))); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRoot(t, root, "index.html"); got != "v2" {
+		t.Errorf("after the second deploy index.html = %q", got)
+	}
+	if got := readRoot(t, root, "css/site.css"); got != "body{}" {
+		t.Errorf("css/site.css = %q", got)
+	}
+
+	prev, err := s.Rollback()
+	if err != nil || prev.ID != first.ID {
+		t.Fatalf("Rollback = %+v, %v; want %s", prev, err, first.ID)
+	}
+	if got := readRoot(t, root, "index.html"); got != "v1" {
+		t.Errorf("after the rollback index.html = %q", got)
+	}
+	if _, err := s.Rollback(); !errors.Is(err, ErrNoPrevious) {
+		t.Errorf("Rollback past the oldest release: %v", err)
+	}
+
+	list, _ := s.List()
+	if len(list) != 2 || list[0].Active || !list[1].Active {
+		t.Errorf("List = %+v", list)
+	}
+	if _, err := s.Activate(list[0].ID); err != nil || readRoot(t, root, "index.html") != "v2" {
+		t.Errorf("Activate(%s): %v", list[0].ID, err)
+	}
+	if _, err := s.Activate("../site"); !errors.Is(err, ErrUnknownRelease) {
+		t.Errorf("Activate of an unknown release: %v", err)
+	}
+	if len(switched) != 4 {
+		t.Errorf("activation hooks ran %d times, want 4", len(switched))
+	}
+}
+
+func TestDeployKeepsTheLastReleases(t *testing.T) {
+	s, root := newTestSite(t, 2)
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if _, err := s.Deploy(bytes.NewReader(siteArchive(t, v))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, _ := s.List()
+	if len(list) != 2 || !list[0].Active {
+		t.Fatalf("List = %+v, want the 2 newest", list)
+	}
+	if got := readRoot(t, root, "index.html"); got != "v3" {
+		t.Errorf("index.html = %q", got)
+	}
+	entries, _ := os.ReadDir(s.dir)
+	if len(entries) != 3 { // the releases and the lock
+		t.Errorf("releases directory holds %d entries, want 3", len(entries))
+	}
+}
+
+func TestDeployAdoptsAnExistingRoot(t *testing.T) {
+	s, root := newTestSite(t, 0)
+	os.MkdirAll(root, 0755)
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("old"), 0644)
+
+	if _, err := s.Deploy(bytes.NewReader(siteArchive(t, "new"))); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := s.List()
+	if len(list) != 2 || list[1].Files != 1 {
+		t.Fatalf("List = %+v, want the adopted directory as the first release", list)
+	}
+	if _, err := s.Rollback(); err != nil || readRoot(t, root, "index.html") != "old" {
+		t.Errorf("rolling back to the adopted directory: %v", err)
+	}
+}
+
+func TestDeployRejectsInvalidArchives(t *testing.T) {
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	hdr := &zip.FileHeader{Name: "link"}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, _ := zw.CreateHeader(hdr)
+	w.Write([]byte("/etc/passwd"))
+	zw.Close()
+
+	tests := []struct {
+		name    string
+		archive []byte
+		want    error
+	}{
+		{"traversal", tarGz(t, []tar.Header{{Name: "../evil.html", Typeflag: tar.TypeReg}}, nil), ErrInvalidArchive},
+		{"absolute", tarGz(t, []tar.Header{{Name: "/etc/evil", Typeflag: tar.TypeReg}}, nil), ErrInvalidArchive},
+		{"symlink", tarGz(t, []tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}, nil), ErrInvalidArchive},
+		{"zip symlink", zipBuf.Bytes(), ErrInvalidArchive},
+		{"empty", tarGz(t, []tar.Header{{Name: "docs/", Typeflag: tar.TypeDir}}, nil), ErrInvalidArchive},
+		{"not an archive", []byte("<html>"), ErrInvalidArchive},
+		{"too large", tarGz(t, []tar.Header{{Name: "big.bin", Typeflag: tar.TypeReg}}, map[string]string{"big.bin": string(make([]byte, 4096))}), ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := filepath.Join(t.TempDir(), "site")
+			s, _ := ForSite(config.SiteConfig{RootDirectory: root, Releases: &config.ReleasesConfig{MaxBytes: 1024}})
+			if _, err := s.Deploy(bytes.NewReader(tt.archive)); !errors.Is(err, tt.want) {
+				t.Fatalf("Deploy: %v, want %v", err, tt.want)
+			}
+			if _, err := os.Lstat(root); err == nil {
+				t.Error("a failed deploy changed root_directory")
+			}
+			if entries, _ := os.ReadDir(s.dir); len(entries) != 1 || entries[0].Name() != lockName {
+				t.Errorf("a failed deploy left %d entries behind", len(entries)-1)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(root), "evil.html")); err == nil {
+				t.Error("an entry escaped the release directory")
+			}
+		})
+	}
+}
+
+func TestDeployZipAndPack(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("index.html")
+	w.Write([]byte("zipped"))
+	zw.Close()
+
+	s, root := newTestSite(t, 0)
+	if _, err := s.Deploy(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := readRoot(t, root, "index.html"); got != "zipped" {
+		t.Errorf("index.html = %q", got)
+	}
+
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "img"), 0755)
+	os.WriteFile(filepath.Join(src, "index.html"), []byte("packed"), 0644)
+	os.WriteFile(filepath.Join(src, "img", "a.svg"), []byte("<svg/>"), 0644)
+	buf.Reset()
+	if err := Pack(src, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Deploy(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if readRoot(t, root, "index.html") != "packed" || readRoot(t, root, "img/a.svg") != "<svg/>" {
+		t.Error("a packed directory did not deploy as is")
+	}
+}
+
+func TestForSiteDisabled(t *testing.T) {
+	if _, err := ForSite(config.SiteConfig{RootDirectory: "/srv/site"}); !errors.Is(err, ErrNotEnabled) {
+		t.Errorf("ForSite without releases: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// resolveACMEConfig returns the ACME settings for a group of sites. The global
+// "acme" section wins; otherwise the legacy per-site ssl.email and
+// ssl.cache_dir are used, warning when sites on the same port disagree.
+func resolveACMEConfig(confs []config.SiteConfig, lg *logger.Logger) config.ACMEConfig {
+	var ac config.ACMEConfig
+	config.GlobalConfMu.RLock()
+	if config.GlobalConf != nil && config.GlobalConf.ACME != nil {
+		ac = *config.GlobalConf.ACME
+	}
+	config.GlobalConfMu.RUnlock()
+
+	for _, c := range confs {
+		if !c.SSL.Enabled || !c.SSL.ACME {
+			continue
+		}
+		if c.SSL.Email != "" && c.SSL.Email != ac.Email {
+			if ac.Email != "" {
+				lg.Warnf("Ignoring ssl.email of %s: ACME email is already %q (set it once in the global acme section)", c.Domain, ac.Email)
+			} else {
+				ac.Email = c.SSL.Email
+			}
+		}
+		if c.SSL.CacheDir != "" && c.SSL.CacheDir != ac.CacheDir {
+			if ac.CacheDir != "" {
+				lg.Warnf("Ignoring ssl.cache_dir of %s: ACME cache is already %s (set it once in the global acme section)", c.Domain, ac.CacheDir)
+			} else {
+				ac.CacheDir = c.SSL.CacheDir
+			}
+		}
+	}
+
+	if ac.DirectoryURL == "" {
+		ac.DirectoryURL = autocert.DefaultACMEDirectory
+	}
+	if ac.CacheDir == "" {
+		ac.CacheDir = acmeCacheDir(ac.DirectoryURL)
+	}
+	return ac
+}
+
+// acmeCacheDir returns the default cache directory for a CA. Let's Encrypt
+// production keeps the historical location; any other directory gets its own
+// subdirectory so staging or test certificates are never served in
+// production after switching CAs.
+func acmeCacheDir(directoryURL string) string {
+	base := config.GetACMEDir()
+	if directoryURL == autocert.DefaultACMEDirectory {
+		return base
+	}
+	u, err := url.Parse(directoryURL)
+	if err != nil || u.Host == "" {
+		return base
+	}
+	return filepath.Join(base, strings.ReplaceAll(u.Host, ":", "_"))
+}
+
+// acmeHTTPClient returns the HTTP client used to reach the ACME server: nil
+// (the default client) unless a custom root CA is configured.
+func acmeHTTPClient(ac config.ACMEConfig) (*http.Client, error) {
+	if ac.RootCA == "" {
+		return nil, nil
+	}
+	pemData, err := os.ReadFile(ac.RootCA)
+	if err != nil {
+		return nil, fmt.Errorf("reading acme root_ca: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("acme root_ca %s contains no PEM certificates", ac.RootCA)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport, Timeout: 30 * time.Second}, nil
+}
+
+// newACMEClient builds a client for the configured CA. The account key is
+// left unset: autocert and the DNS-01 manager load it from their cache, so
+// each manager needs its own client.
+func newACMEClient(ac config.ACMEConfig, hc *http.Client) *acme.Client {
+	return &acme.Client{DirectoryURL: ac.DirectoryURL, HTTPClient: hc, UserAgent: "goup"}
+}
+
+// externalAccountBinding decodes the configured EAB credentials.
+func externalAccountBinding(eab *config.EABConfig) (*acme.ExternalAccountBinding, error) {
+	if eab == nil || eab.KeyID == "" {
+		return nil, nil
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(eab.HMACKey, "="))
+	if err != nil {
+		// Some CAs hand out standard base64; accept it too.
+		if key, err = base64.StdEncoding.DecodeString(eab.HMACKey); err != nil {
+			return nil, fmt.Errorf("acme eab hmac_key is not valid base64: %w", err)
+		}
+	}
+	return &acme.ExternalAccountBinding{KID: eab.KeyID, Key: key}, nil
+}
+
+// acmeAccount registers and holds the ACME account of a client. The account
+// key is the one autocert keeps in the cache, so every issuer shares one
+// account per cache.
+type acmeAccount struct {
+	cache *issuanceCache
+	email string
+	eab   *acme.ExternalAccountBinding
+
+	mu         sync.Mutex
+	acme       *acme.Client
+	registered bool
+}
+
+// newACMEAccount wraps client, which must not be shared with autocert (the
+// account key is set on first use).
+func newACMEAccount(cache *issuanceCache, client *acme.Client, email string, eab *acme.ExternalAccountBinding) *acmeAccount {
+	return &acmeAccount{cache: cache, email: email, eab: eab, acme: client}
+}
+
+// client returns the registered ACME client, creating the account on first
+// use.
+func (a *acmeAccount) client(ctx context.Context) (*acme.Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.registered {
+		return a.acme, nil
+	}
+
+	if a.acme.Key == nil {
+		key, err := a.accountKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a.acme.Key = key
+	}
+	var contact []string
+	if a.email != "" {
+		contact = []string{"mailto:" + a.email}
+	}
+	_, err := a.acme.Register(ctx, &acme.Account{Contact: contact, ExternalAccountBinding: a.eab}, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, err
+	}
+	a.registered = true
+	return a.acme, nil
+}
+
+// accountKey loads the ACME account key shared with autocert, generating and
+// caching one when none exists.
+func (a *acmeAccount) accountKey(ctx context.Context) (crypto.Signer, error) {
+	data, err := a.cache.Get(ctx, acmeAccountKeyName)
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		pemKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := a.cache.Put(ctx, acmeAccountKeyName, pemKey); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid account key in cache")
+	}
+	return parsePrivateKey(block.Bytes)
+}
+
+// obtainCertificate runs a full ACME order for an ECDSA certificate of
+// domain, answering each pending authorization with solve.
+func obtainCertificate(ctx context.Context, client *acme.Client, domain string, solve func(context.Context, *acme.Authorization) error) (*tls.Certificate, error) {
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
+	if err != nil {
+		return nil, fmt.Errorf("authorize order: %w", err)
+	}
+
+	for _, u := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("get authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		if err := solve(ctx, authz); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := client.WaitOrder(ctx, order.URI); err != nil {
+		return nil, fmt.Errorf("wait order: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("finalize order: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: der, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestResolveACMEConfigPrecedence(t *testing.T) {
+	config.GlobalConfMu.Lock()
+	prev := config.GlobalConf
+	config.GlobalConf = &config.GlobalConfig{ACME: &config.ACMEConfig{
+		Email:        "ops@example.com",
+		DirectoryURL: "https://acme-staging-v02.api.letsencrypt.org/directory",
+	}}
+	config.GlobalConfMu.Unlock()
+	defer func() {
+		config.GlobalConfMu.Lock()
+		config.GlobalConf = prev
+		config.GlobalConfMu.Unlock()
+	}()
+
+	lg, err := logger.NewLogger("test_acme_config", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lg.SetOutput(httptest.NewRecorder())
+
+	confs := []config.SiteConfig{
+		{Domain: "a.example.com", SSL: config.SSLConfig{Enabled: true, ACME: true, Email: "a@example.com"}},
+		{Domain: "b.example.com", SSL: config.SSLConfig{Enabled: true, ACME: true, CacheDir: "/tmp/acme-b"}},
+	}
+	ac := resolveACMEConfig(confs, lg)
+	if ac.Email != "ops@example.com" {
+		t.Errorf("global email must win, got %q", ac.Email)
+	}
+	if ac.CacheDir != "/tmp/acme-b" {
+		t.Errorf("per-site cache_dir should fill the unset global one, got %q", ac.CacheDir)
+	}
+
+	confs[1].SSL.CacheDir = ""
+	ac = resolveACMEConfig(confs, lg)
+	want := filepath.Join(config.GetACMEDir(), "acme-staging-v02.api.letsencrypt.org")
+	if ac.CacheDir != want {
+		t.Errorf("non-default CA must get its own cache dir, got %q want %q", ac.CacheDir, want)
+	}
+	if got := acmeCacheDir(autocert.DefaultACMEDirectory); got != config.GetACMEDir() {
+		t.Errorf("Let's Encrypt production keeps the historical cache dir, got %q", got)
+	}
+}
+
+func TestACMEClientTrustsCustomRootCA(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"newNonce":   srv.URL + "/nonce",
+			"newAccount": srv.URL + "/account",
+			"newOrder":   srv.URL + "/order",
+		})
+	}))
+	defer srv.Close()
+
+	rootPath := filepath.Join(t.TempDir(), "root.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(rootPath, pemData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ac := config.ACMEConfig{DirectoryURL: srv.URL + "/directory"}
+	if _, err := newACMEClient(ac, nil).Discover(context.Background()); err == nil {
+		t.Fatal("expected the untrusted test CA to be rejected without root_ca")
+	}
+
+	ac.RootCA = rootPath
+	hc, err := acmeHTTPClient(ac)
+	if err != nil {
+		t.Fatalf("acmeHTTPClient: %v", err)
+	}
+	dir, err := newACMEClient(ac, hc).Discover(context.Background())
+	if err != nil {
+		t.Fatalf("Discover with root_ca: %v", err)
+	}
+	if dir.OrderURL != srv.URL+"/order" {
+		t.Errorf("unexpected order URL %q", dir.OrderURL)
+	}
+}
+
+func TestExternalAccountBinding(t *testing.T) {
+	eab, err := externalAccountBinding(&config.EABConfig{KeyID: "kid-1", HMACKey: "c2VjcmV0LWtleQ"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if eab.KID != "kid-1" || string(eab.Key) != "secret-key" {
+		t.Errorf("unexpected binding %+v", eab)
+	}
+	if eab, err := externalAccountBinding(nil); eab != nil || err != nil {
+		t.Errorf("nil config must disable EAB, got %v, %v", eab, err)
+	}
+	if _, err := externalAccountBinding(&config.EABConfig{KeyID: "kid", HMACKey: "%%%"}); err == nil {
+		t.Error("expected an error for an invalid HMAC key")
+	}
+}
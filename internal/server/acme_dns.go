@@ -0,0 +1,366 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/acmedns"
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// acmeRenewBefore mirrors autocert's default renewal window.
+	acmeRenewBefore = 30 * 24 * time.Hour
+	// dns01CheckInterval is how often the renewal loop inspects certificates.
+	dns01CheckInterval = 12 * time.Hour
+	// acmeIssueTimeout bounds a single issuance (order, propagation,
+	// validation and finalization).
+	acmeIssueTimeout = 5 * time.Minute
+	// acmeAccountKeyName is the cache entry autocert uses for the account key;
+	// sharing it keeps one ACME account per cache directory.
+	acmeAccountKeyName = "acme_account+key"
+)
+
+// dnsCertManager obtains and renews certificates through the ACME DNS-01
+// challenge, which autocert does not implement. It is the only way to get
+// wildcard certificates. Issued certificates are stored in the same cache as
+// autocert, using the same PEM layout (private key followed by the chain).
+type dnsCertManager struct {
+	cache   *issuanceCache
+	account *acmeAccount
+	lg      *logger.Logger
+
+	// providers maps each managed domain (possibly "*.example.com") to the
+	// provider that publishes its challenge records.
+	providers map[string]acmedns.Provider
+
+	mu      sync.RWMutex
+	certs   map[string]*tls.Certificate
+	issueMu map[string]*sync.Mutex
+}
+
+// newDNSCertManager returns a manager talking to the CA behind client, which
+// must not be shared with autocert (the account key is set on first use).
+func newDNSCertManager(cache *issuanceCache, client *acme.Client, email string, eab *acme.ExternalAccountBinding, lg *logger.Logger) *dnsCertManager {
+	return &dnsCertManager{
+		cache:     cache,
+		account:   newACMEAccount(cache, client, email, eab),
+		lg:        lg,
+		providers: make(map[string]acmedns.Provider),
+		certs:     make(map[string]*tls.Certificate),
+		issueMu:   make(map[string]*sync.Mutex),
+	}
+}
+
+// add registers a domain to be issued through provider.
+func (m *dnsCertManager) add(domain string, provider acmedns.Provider) {
+	domain = strings.ToLower(domain)
+	m.providers[domain] = provider
+	m.issueMu[domain] = &sync.Mutex{}
+}
+
+// match returns the managed domain whose certificate covers serverName: an
+// exact name first, then a wildcard one label up.
+func (m *dnsCertManager) match(serverName string) (string, bool) {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if _, ok := m.providers[name]; ok {
+		return name, true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		wildcard := "*" + name[i:]
+		if _, ok := m.providers[wildcard]; ok {
+			return wildcard, true
+		}
+	}
+	return "", false
+}
+
+// GetCertificate serves the certificate for the SNI, obtaining it
+// synchronously when it is not cached yet.
+func (m *dnsCertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	domain, ok := m.match(hello.ServerName)
+	if !ok {
+		return nil, fmt.Errorf("acme/dns-01: host %q not configured", hello.ServerName)
+	}
+	m.mu.RLock()
+	cert := m.certs[domain]
+	m.mu.RUnlock()
+	if cert != nil {
+		return cert, nil
+	}
+
+	ctx, cancel := context.WithTimeout(hello.Context(), acmeIssueTimeout)
+	defer cancel()
+	return m.ensure(ctx, domain)
+}
+
+// start loads or issues every managed certificate in the background and keeps
+// renewing them until the process exits.
+func (m *dnsCertManager) start() {
+	go func() {
+		for {
+			for domain := range m.providers {
+				ctx, cancel := context.WithTimeout(context.Background(), acmeIssueTimeout)
+				if _, err := m.ensure(ctx, domain); err != nil {
+					m.lg.Errorf("ACME DNS-01: %s: %v", domain, err)
+				}
+				cancel()
+			}
+			time.Sleep(dns01CheckInterval)
+		}
+	}()
+}
+
+// ensure returns a valid certificate for domain from memory or the cache,
+// obtaining a new one when missing or inside the renewal window.
+func (m *dnsCertManager) ensure(ctx context.Context, domain string) (*tls.Certificate, error) {
+	lock := m.issueMu[domain]
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.mu.RLock()
+	cert := m.certs[domain]
+	m.mu.RUnlock()
+	if cert == nil {
+		if c, err := m.cacheGet(ctx, domain); err == nil {
+			cert = c
+		} else if !errors.Is(err, autocert.ErrCacheMiss) && !errors.Is(err, errIssuanceElsewhere) {
+			m.lg.Errorf("ACME DNS-01: reading cached certificate for %s: %v", domain, err)
+		}
+	}
+	if cert != nil && time.Until(cert.Leaf.NotAfter) > acmeRenewBefore {
+		m.store(domain, cert)
+		return cert, nil
+	}
+
+	// Only one node sharing the storage issues; the others wait for it and
+	// pick up its certificate.
+	key := dns01CacheKey(domain)
+	if err := m.cache.lock(ctx, key); err != nil {
+		return nil, err
+	}
+	defer m.cache.unlock(key)
+	if c, err := m.cacheGet(ctx, domain); err == nil && time.Until(c.Leaf.NotAfter) > acmeRenewBefore {
+		m.store(domain, c)
+		return c, nil
+	}
+
+	fresh, err := m.obtain(ctx, domain)
+	if err != nil {
+		certs.RecordError(domain, err)
+		if cert != nil && time.Now().Before(cert.Leaf.NotAfter) {
+			// Keep serving the old certificate while renewal keeps failing.
+			m.store(domain, cert)
+			return cert, nil
+		}
+		return nil, err
+	}
+	if err := m.cachePut(ctx, domain, fresh); err != nil {
+		m.lg.Errorf("ACME DNS-01: caching certificate for %s: %v", domain, err)
+	}
+	m.store(domain, fresh)
+	m.lg.Infof("ACME DNS-01: obtained certificate for %s (expires %s)", domain, fresh.Leaf.NotAfter.Format(time.RFC3339))
+	return fresh, nil
+}
+
+// renew obtains a new certificate for domain regardless of the expiry of
+// the current one, which stays in use when issuance fails.
+func (m *dnsCertManager) renew(ctx context.Context, domain string) error {
+	domain = strings.ToLower(domain)
+	lock, ok := m.issueMu[domain]
+	if !ok {
+		return fmt.Errorf("acme/dns-01: host %q not configured", domain)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, acmeIssueTimeout)
+	defer cancel()
+	key := dns01CacheKey(domain)
+	if err := m.cache.lock(ctx, key); err != nil {
+		return err
+	}
+	defer m.cache.unlock(key)
+	fresh, err := m.obtain(ctx, domain)
+	if err != nil {
+		return err
+	}
+	if err := m.cachePut(ctx, domain, fresh); err != nil {
+		m.lg.Errorf("ACME DNS-01: caching certificate for %s: %v", domain, err)
+	}
+	m.store(domain, fresh)
+	m.lg.Infof("ACME DNS-01: renewed certificate for %s on request (expires %s)", domain, fresh.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// store makes cert the served certificate of domain.
+func (m *dnsCertManager) store(domain string, cert *tls.Certificate) {
+	m.mu.Lock()
+	m.certs[domain] = cert
+	m.mu.Unlock()
+	certs.Observe(domain, cert)
+}
+
+// obtain runs a full ACME order for domain, answering every authorization
+// with a DNS-01 challenge.
+func (m *dnsCertManager) obtain(ctx context.Context, domain string) (*tls.Certificate, error) {
+	client, err := m.account.client(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("acme account: %w", err)
+	}
+	provider := m.providers[domain]
+	return obtainCertificate(ctx, client, domain, func(ctx context.Context, authz *acme.Authorization) error {
+		return m.solve(ctx, client, provider, authz)
+	})
+}
+
+// solve publishes the DNS-01 record for one authorization, asks the CA to
+// validate it and removes the record afterwards.
+func (m *dnsCertManager) solve(ctx context.Context, client *acme.Client, provider acmedns.Provider, authz *acme.Authorization) error {
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "dns-01" {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no dns-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	value, err := client.DNS01ChallengeRecord(chal.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := acmedns.ChallengeName(authz.Identifier.Value)
+	if err := provider.Present(ctx, fqdn, value); err != nil {
+		return fmt.Errorf("publish %s: %w", fqdn, err)
+	}
+	defer func() {
+		if err := provider.CleanUp(context.Background(), fqdn, value); err != nil {
+			m.lg.Errorf("ACME DNS-01: removing %s: %v", fqdn, err)
+		}
+	}()
+
+	if d := provider.PropagationDelay(); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept challenge: %w", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("dns-01 validation for %s: %w", authz.Identifier.Value, err)
+	}
+	return nil
+}
+
+// dns01CacheKey is the cache entry of a DNS-01 certificate. The "*" of
+// wildcard domains is spelled out so the name is a valid file name everywhere.
+func dns01CacheKey(domain string) string {
+	return strings.Replace(domain, "*", "_wildcard", 1) + "+dns01"
+}
+
+func (m *dnsCertManager) cacheGet(ctx context.Context, domain string) (*tls.Certificate, error) {
+	data, err := m.cache.Get(ctx, dns01CacheKey(domain))
+	if err != nil {
+		return nil, err
+	}
+	cert, err := parseCertBundle(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.Leaf.VerifyHostname(strings.Replace(domain, "*", "x", 1)); err != nil {
+		return nil, autocert.ErrCacheMiss
+	}
+	return cert, nil
+}
+
+func (m *dnsCertManager) cachePut(ctx context.Context, domain string, cert *tls.Certificate) error {
+	data, err := encodeCertBundle(cert)
+	if err != nil {
+		return err
+	}
+	return m.cache.Put(ctx, dns01CacheKey(domain), data)
+}
+
+// encodeCertBundle serializes a certificate in autocert's cache layout: the
+// PEM private key followed by the PEM chain.
+func encodeCertBundle(cert *tls.Certificate) ([]byte, error) {
+	var buf bytes.Buffer
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(&buf, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}); err != nil {
+		return nil, err
+	}
+	for _, der := range cert.Certificate {
+		if err := pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// parseCertBundle is the inverse of encodeCertBundle.
+func parseCertBundle(data []byte) (*tls.Certificate, error) {
+	keyBlock, rest := pem.Decode(data)
+	if keyBlock == nil || !strings.Contains(keyBlock.Type, "PRIVATE") {
+		return nil, errors.New("certificate bundle: missing private key")
+	}
+	key, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			break
+		}
+		chain = append(chain, b.Bytes)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("certificate bundle: missing certificate")
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: chain, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// parsePrivateKey accepts PKCS#8, PKCS#1 RSA and SEC 1 EC keys.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if s, ok := key.(crypto.Signer); ok {
+			return s, nil
+		}
+		return nil, errors.New("unsupported private key type")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("failed to parse private key")
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+)
+
+// acmeChallengePrefix is the path of HTTP-01 challenge requests (RFC 8555).
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// acmeHTTPHandlers maps a host using the HTTP-01 challenge to the autocert
+// handler that answers its tokens. Certificates are configured on the TLS
+// port, while the CA validates over port 80, so the two servers meet here.
+var (
+	acmeHTTPHandlers   = make(map[string]http.Handler)
+	acmeHTTPHandlersMu sync.RWMutex
+)
+
+// registerACMEHTTPHandler routes HTTP-01 challenges for host to h.
+func registerACMEHTTPHandler(host string, h http.Handler) {
+	acmeHTTPHandlersMu.Lock()
+	acmeHTTPHandlers[strings.ToLower(host)] = h
+	acmeHTTPHandlersMu.Unlock()
+}
+
+// acmeHTTPHandlerFor returns the challenge handler registered for host.
+func acmeHTTPHandlerFor(host string) http.Handler {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	acmeHTTPHandlersMu.RLock()
+	defer acmeHTTPHandlersMu.RUnlock()
+	return acmeHTTPHandlers[strings.ToLower(host)]
+}
+
+// withACMEChallenge answers HTTP-01 challenges for registered hosts before
+// the site handler (and its force_https redirect) sees the request.
+func withACMEChallenge(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, acmeChallengePrefix) {
+			if h := acmeHTTPHandlerFor(r.Host); h != nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// needsHTTP01 reports whether any site obtains its certificate through the
+// HTTP-01 challenge.
+func needsHTTP01(configs []config.SiteConfig) bool {
+	for _, c := range configs {
+		if c.SSL.Enabled && c.SSL.ACME && c.SSL.ACMEChallenge() == config.ChallengeHTTP01 {
+			return true
+		}
+	}
+	return false
+}
+
+// startACMEChallengeServer listens on port 80 when HTTP-01 is in use but no
+// site is configured on that port. It answers challenges and redirects every
+// other request to HTTPS.
+func startACMEChallengeServer(lg *logger.Logger) {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+	srv := createHTTPServer(config.SiteConfig{Port: 80}, redirect)
+	registerServer(srv)
+
+	go func() {
+		lg.Infof("Serving ACME HTTP-01 challenges on port 80")
+		ln, err := listenOptimized(srv.Addr)
+		if err != nil {
+			lg.Errorf("ACME HTTP-01 listener on port 80: %v", err)
+			return
+		}
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			lg.Errorf("ACME HTTP-01 server error: %v", err)
+		}
+	}()
+}
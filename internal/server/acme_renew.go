@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// autocertRenewer serves certificates through an autocert.Manager and
+// supports forced renewals, which autocert has no API for. autocert keeps
+// issued certificates in memory and its renewal timers can't be stopped, so
+// rather than replacing the manager, a forced renewal runs the ACME order
+// itself: challenge tokens go through the shared cache, where the manager
+// answers them, and the new certificate is stored where the manager looks
+// for it. It is served from here until the manager has loaded it, which its
+// renewal loop does at the latest when the old certificate is due.
+type autocertRenewer struct {
+	mgr     *autocert.Manager
+	cache   *issuanceCache
+	account *acmeAccount // not shared with autocert
+	http01  []string
+	lg      *logger.Logger
+
+	renewMu sync.Mutex
+	mu      sync.RWMutex
+	renewed map[string]*tls.Certificate // by host, until the manager serves it
+
+	http01Mu  sync.Mutex
+	challenge http.Handler // HTTP-01 handler of the manager
+}
+
+func newAutocertRenewer(mgr *autocert.Manager, cache *issuanceCache, account *acmeAccount, http01 []string, lg *logger.Logger) *autocertRenewer {
+	r := &autocertRenewer{mgr: mgr, cache: cache, account: account, http01: http01, lg: lg, renewed: make(map[string]*tls.Certificate)}
+	// Calling HTTPHandler also enables HTTP-01 on the manager; the port-80
+	// listener routes challenge requests for these hosts to it.
+	if len(http01) > 0 {
+		r.challenge = mgr.HTTPHandler(http.NotFoundHandler())
+		for _, host := range http01 {
+			registerACMEHTTPHandler(host, r.challenge)
+		}
+	}
+	return r
+}
+
+// addHTTP01Host routes HTTP-01 challenges for a host approved at runtime
+// (on-demand TLS) to the manager.
+func (r *autocertRenewer) addHTTP01Host(host string) {
+	r.http01Mu.Lock()
+	defer r.http01Mu.Unlock()
+	r.http01 = append(r.http01, host)
+	if r.challenge == nil {
+		r.challenge = r.mgr.HTTPHandler(http.NotFoundHandler())
+	}
+	registerACMEHTTPHandler(host, r.challenge)
+}
+
+// usesHTTP01 reports whether host is validated with HTTP-01.
+func (r *autocertRenewer) usesHTTP01(host string) bool {
+	r.http01Mu.Lock()
+	defer r.http01Mu.Unlock()
+	return slices.ContainsFunc(r.http01, func(h string) bool { return strings.EqualFold(h, host) })
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *autocertRenewer) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := r.mgr.GetCertificate(hello)
+	if slices.Equal(hello.SupportedProtos, []string{acme.ALPNProto}) {
+		return cert, err
+	}
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	r.mu.RLock()
+	fresh := r.renewed[host]
+	r.mu.RUnlock()
+	if fresh == nil || hello.SupportsCertificate(fresh) != nil {
+		return cert, err
+	}
+	if err == nil && cert.Leaf != nil && !cert.Leaf.NotAfter.Before(fresh.Leaf.NotAfter) {
+		// The manager has caught up.
+		r.mu.Lock()
+		if r.renewed[host] == fresh {
+			delete(r.renewed, host)
+		}
+		r.mu.Unlock()
+		return cert, nil
+	}
+	return fresh, nil
+}
+
+// renew obtains a new certificate for host and starts serving it. On failure
+// the current certificate stays in use. The order stops with ctx, and the
+// storage lock is held until it has.
+func (r *autocertRenewer) renew(ctx context.Context, host string) error {
+	r.renewMu.Lock()
+	defer r.renewMu.Unlock()
+
+	host = strings.ToLower(host)
+	ctx, cancel := context.WithTimeout(ctx, acmeIssueTimeout)
+	defer cancel()
+	// Nodes sharing the storage take turns; the lock is released when the
+	// new certificate is stored.
+	if err := r.cache.lock(ctx, host); err != nil {
+		return err
+	}
+	defer r.cache.unlock(host)
+	client, err := r.account.client(ctx)
+	if err != nil {
+		return fmt.Errorf("acme account: %w", err)
+	}
+	fresh, err := obtainCertificate(ctx, client, host, func(ctx context.Context, authz *acme.Authorization) error {
+		return r.solve(ctx, client, authz)
+	})
+	if err != nil {
+		return err
+	}
+	// Stored under autocert's key for the ECDSA certificate of host.
+	data, err := encodeCertBundle(fresh)
+	if err == nil {
+		err = r.cache.Put(ctx, host, data)
+	}
+	if err != nil {
+		r.lg.Errorf("ACME: caching certificate for %s: %v", host, err)
+	}
+	r.mu.Lock()
+	r.renewed[host] = fresh
+	r.mu.Unlock()
+	certs.Observe(host, fresh)
+	r.lg.Infof("ACME: renewed certificate for %s on request (expires %s)", host, fresh.Leaf.NotAfter.Format(time.RFC3339))
+	return nil
+}
+
+// solve answers one authorization with the challenge the host uses,
+// publishing the token in the cache entry autocert reads it from.
+func (r *autocertRenewer) solve(ctx context.Context, client *acme.Client, authz *acme.Authorization) error {
+	domain := authz.Identifier.Value
+	typ := "tls-alpn-01"
+	if r.usesHTTP01(domain) {
+		typ = "http-01"
+	}
+	var chal *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == typ {
+			chal = c
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered for %s", typ, domain)
+	}
+
+	var key string
+	var data []byte
+	if typ == "http-01" {
+		value, err := client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		key, data = chal.Token+"+http-01", []byte(value)
+	} else {
+		cert, err := client.TLSALPN01ChallengeCert(chal.Token, domain)
+		if err != nil {
+			return err
+		}
+		if data, err = encodeCertBundle(&cert); err != nil {
+			return err
+		}
+		key = domain + "+token"
+	}
+	if err := r.cache.Put(ctx, key, data); err != nil {
+		return fmt.Errorf("publish %s challenge: %w", typ, err)
+	}
+	defer r.cache.Delete(context.Background(), key)
+
+	if _, err := client.Accept(ctx, chal); err != nil {
+		return fmt.Errorf("accept challenge: %w", err)
+	}
+	if _, err := client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s validation for %s: %w", typ, domain, err)
+	}
+	return nil
+}
+
+// observeCachedCertificates records the certificates autocert has cached
+// for hosts, so the inventory is filled before the first handshake.
+func observeCachedCertificates(cache autocert.Cache, hosts []string) {
+	for _, host := range hosts {
+		data, err := cache.Get(context.Background(), strings.ToLower(host))
+		if errors.Is(err, autocert.ErrCacheMiss) {
+			continue
+		}
+		if err == nil {
+			var cert *tls.Certificate
+			if cert, err = parseCertBundle(data); err == nil {
+				certs.Observe(host, cert)
+				continue
+			}
+		}
+		certs.RecordError(host, err)
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	// defaultIssuanceLockTTL bounds how long a node that died mid-issuance
+	// keeps other nodes from obtaining the certificate.
+	defaultIssuanceLockTTL = 10 * time.Minute
+	// issuanceWait is how long a renewal waits for another node renewing
+	// the same certificate, to pick up its result.
+	issuanceWait = 30 * time.Second
+)
+
+// errIssuanceElsewhere fails a handshake for a certificate another node is
+// obtaining; the next handshake finds it in the storage.
+var errIssuanceElsewhere = errors.New("acme: certificate is being obtained by another node")
+
+// newCertStorage opens the certificate storage of the ACME settings.
+func newCertStorage(ac config.ACMEConfig) (certs.CertStorage, time.Duration, error) {
+	st := ac.Storage
+	if st == nil {
+		st = &config.CertStorageConfig{}
+	}
+	ttl := defaultIssuanceLockTTL
+	if st.LockTTL != "" {
+		d, err := time.ParseDuration(st.LockTTL)
+		if err != nil || d <= 0 {
+			return nil, 0, fmt.Errorf("acme storage lock_ttl %q is not a positive duration", st.LockTTL)
+		}
+		ttl = d
+	}
+
+	switch st.Type {
+	case "", config.CertStorageFS:
+		return certs.NewFileStorage(ac.CacheDir), ttl, nil
+	case config.CertStorageShared:
+		dir := st.Path
+		if dir == "" {
+			dir = ac.CacheDir
+		}
+		s, err := certs.NewSharedDirStorage(dir)
+		return s, ttl, err
+	case config.CertStorageS3:
+		if st.S3 == nil {
+			return nil, 0, errors.New("acme storage type \"s3\" requires an s3 section")
+		}
+		s, err := certs.NewS3Storage(certs.S3Options{
+			Endpoint:  st.S3.Endpoint,
+			Region:    st.S3.Region,
+			Bucket:    st.S3.Bucket,
+			Prefix:    st.S3.Prefix,
+			AccessKey: st.S3.AccessKey,
+			SecretKey: st.S3.SecretKey,
+			PathStyle: st.S3.PathStyle,
+		})
+		return s, ttl, err
+	}
+	return nil, 0, fmt.Errorf("unknown acme storage type %q", st.Type)
+}
+
+// describeCertStorage names the storage in log lines.
+func describeCertStorage(ac config.ACMEConfig) string {
+	if ac.Storage == nil || ac.Storage.Type == "" || ac.Storage.Type == config.CertStorageFS {
+		return "cache " + ac.CacheDir
+	}
+	switch ac.Storage.Type {
+	case config.CertStorageShared:
+		if ac.Storage.Path != "" {
+			return "shared storage " + ac.Storage.Path
+		}
+		return "shared storage " + ac.CacheDir
+	case config.CertStorageS3:
+		return fmt.Sprintf("s3 storage %s/%s", ac.Storage.S3.Bucket, ac.Storage.S3.Prefix)
+	}
+	return ac.Storage.Type
+}
+
+// issuanceCache is the autocert cache over a certificate storage. A
+// certificate (or account key) that is missing or due for renewal is locked
+// in the storage before autocert goes on to obtain it, so nodes sharing the
+// storage never issue the same certificate twice. The lock is released once
+// the new certificate is stored, or after the lock TTL when issuance fails.
+type issuanceCache struct {
+	storage certs.CertStorage
+	ttl     time.Duration
+	lg      *logger.Logger
+
+	mu   sync.Mutex
+	held map[string]*time.Timer // locks this node holds -> release timer
+}
+
+func newIssuanceCache(storage certs.CertStorage, ttl time.Duration, lg *logger.Logger) *issuanceCache {
+	return &issuanceCache{storage: storage, ttl: ttl, lg: lg, held: make(map[string]*time.Timer)}
+}
+
+// issuanceKey reports whether a cache entry is obtained from the CA, as
+// opposed to challenge tokens which every node writes for itself.
+func issuanceKey(key string) bool {
+	return !strings.HasSuffix(key, "+token") && !strings.HasSuffix(key, "+http-01")
+}
+
+// due reports whether a cached entry must be obtained again.
+func due(key string, data []byte, err error) bool {
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return true
+	}
+	if err != nil || key == acmeAccountKeyName {
+		return false
+	}
+	cert, perr := parseCertBundle(data)
+	return perr != nil || time.Until(cert.Leaf.NotAfter) <= acmeRenewBefore
+}
+
+// Get implements autocert.Cache.
+func (c *issuanceCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.storage.Get(ctx, key)
+	if !issuanceKey(key) || !due(key, data, err) || c.holds(key) {
+		return data, err
+	}
+
+	ok, lerr := c.storage.TryLock(ctx, key, c.ttl)
+	if lerr != nil {
+		// Failing open: a duplicate issuance beats not serving at all.
+		c.lg.Errorf("ACME storage: locking %s: %v", key, lerr)
+		return data, err
+	}
+	if ok {
+		// Another node may have finished just before we got the lock.
+		if fresh, ferr := c.storage.Get(ctx, key); !due(key, fresh, ferr) {
+			c.storage.Unlock(ctx, key)
+			return fresh, ferr
+		}
+		c.hold(key)
+		return data, err
+	}
+
+	if errors.Is(err, autocert.ErrCacheMiss) {
+		return nil, errIssuanceElsewhere
+	}
+	// A renewal in progress elsewhere: wait a little for its result, so
+	// autocert adopts it instead of renewing again.
+	wait, cancel := context.WithTimeout(ctx, issuanceWait)
+	defer cancel()
+	for {
+		select {
+		case <-wait.Done():
+			return data, err
+		case <-time.After(time.Second):
+		}
+		if fresh, ferr := c.storage.Get(ctx, key); ferr == nil && !due(key, fresh, nil) {
+			return fresh, nil
+		}
+	}
+}
+
+// Put implements autocert.Cache, releasing the lock taken for key.
+func (c *issuanceCache) Put(ctx context.Context, key string, data []byte) error {
+	err := c.storage.Put(ctx, key, data)
+	if err == nil {
+		c.unlock(key)
+	}
+	return err
+}
+
+// Delete implements autocert.Cache.
+func (c *issuanceCache) Delete(ctx context.Context, key string) error {
+	return c.storage.Delete(ctx, key)
+}
+
+// lock takes the storage lock for key, waiting for another node holding it.
+// It is released by the Put of key or by unlock.
+func (c *issuanceCache) lock(ctx context.Context, key string) error {
+	if c.holds(key) {
+		return nil
+	}
+	if err := certs.Lock(ctx, c.storage, key, c.ttl); err != nil {
+		return err
+	}
+	c.hold(key)
+	return nil
+}
+
+func (c *issuanceCache) holds(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.held[key]
+	return ok
+}
+
+func (c *issuanceCache) hold(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.held[key]; !ok {
+		c.held[key] = time.AfterFunc(c.ttl, func() { c.unlock(key) })
+	}
+}
+
+// unlock releases the lock on key if this node holds it.
+func (c *issuanceCache) unlock(key string) {
+	c.mu.Lock()
+	timer, ok := c.held[key]
+	delete(c.held, key)
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	timer.Stop()
+	if err := c.storage.Unlock(context.Background(), key); err != nil {
+		c.lg.Errorf("ACME storage: unlocking %s: %v", key, err)
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/armon/go-radix"
+	"github.com/mirkobrombin/goup/internal/acmedns"
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestWithACMEChallengeRoutesByHost(t *testing.T) {
+	challenge := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("token"))
+	})
+	registerACMEHTTPHandler("acme-http.example.com", challenge)
+	defer func() {
+		acmeHTTPHandlersMu.Lock()
+		delete(acmeHTTPHandlers, "acme-http.example.com")
+		acmeHTTPHandlersMu.Unlock()
+	}()
+
+	site := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("site"))
+	})
+	h := withACMEChallenge(site)
+
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"http://acme-http.example.com/.well-known/acme-challenge/abc", "token"},
+		{"http://acme-http.example.com:80/.well-known/acme-challenge/abc", "token"},
+		{"http://acme-http.example.com/index.html", "site"},
+		{"http://other.example.com/.well-known/acme-challenge/abc", "site"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, nil))
+		if rec.Body.String() != c.want {
+			t.Errorf("%s: got %q, want %q", c.url, rec.Body.String(), c.want)
+		}
+	}
+}
+
+func TestLookupVirtualHostWildcard(t *testing.T) {
+	exact := http.NotFoundHandler()
+	wildcard := http.RedirectHandler("/", http.StatusFound)
+	tree := radix.New()
+	tree.Insert("www.example.com", exact)
+	tree.Insert("*.example.com", wildcard)
+
+	if h := lookupVirtualHost(tree, "www.example.com"); h == nil {
+		t.Fatal("expected exact match")
+	}
+	if h := lookupVirtualHost(tree, "shop.example.com"); h == nil {
+		t.Fatal("expected wildcard match")
+	}
+	if h := lookupVirtualHost(tree, "a.b.example.com"); h != nil {
+		t.Fatal("wildcard must only cover one label")
+	}
+	if h := lookupVirtualHost(tree, "example.com"); h != nil {
+		t.Fatal("wildcard must not cover the apex")
+	}
+}
+
+func TestDNSCertManagerMatch(t *testing.T) {
+	m := newDNSCertManager(nil, nil, "", nil, nil)
+	m.add("*.example.com", acmedns.InternalProvider{})
+	m.add("api.example.org", acmedns.InternalProvider{})
+
+	cases := map[string]string{
+		"shop.example.com": "*.example.com",
+		"SHOP.Example.com": "*.example.com",
+		"api.example.org":  "api.example.org",
+		"example.com":      "",
+		"a.b.example.com":  "",
+	}
+	for name, want := range cases {
+		got, ok := m.match(name)
+		if got != want || ok != (want != "") {
+			t.Errorf("match(%q) = %q, %v; want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestCertBundleRoundTrip(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*.example.com"},
+		DNSNames:     []string{"*.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := encodeCertBundle(&tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	cert, err := parseCertBundle(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if cert.Leaf.Subject.CommonName != "*.example.com" {
+		t.Errorf("unexpected leaf %q", cert.Leaf.Subject.CommonName)
+	}
+	if got := dns01CacheKey("*.example.com"); got != "_wildcard.example.com+dns01" {
+		t.Errorf("unexpected cache key %q", got)
+	}
+}
+
+func TestAutocertRenewerServesRenewedCertificate(t *testing.T) {
+	ctx := context.Background()
+	cache := autocert.DirCache(t.TempDir())
+	cache.Put(ctx, "example.com", testCertBundle(t, "example.com", time.Now().Add(60*24*time.Hour)))
+	cache.Put(ctx, "example.com+token", testCertBundle(t, "example.com", time.Now().Add(time.Hour)))
+	newManager := func() *autocert.Manager {
+		return &autocert.Manager{Cache: cache, HostPolicy: autocert.HostWhitelist("example.com")}
+	}
+	r := newAutocertRenewer(newManager(), nil, nil, nil, nil)
+	freshData := testCertBundle(t, "example.com", time.Now().Add(90*24*time.Hour))
+	fresh, err := parseCertBundle(freshData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.renewed["example.com"] = fresh
+
+	hello := &tls.ClientHelloInfo{
+		ServerName:        "example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		CipherSuites:      []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+	if cert, err := r.mgr.GetCertificate(hello); err != nil || cert.Leaf.NotAfter.After(fresh.Leaf.NotAfter.Add(-24*time.Hour)) {
+		t.Fatalf("the manager serves %v (%v), want the old certificate", cert, err)
+	}
+	if cert, err := r.GetCertificate(hello); err != nil || !cert.Leaf.NotAfter.Equal(fresh.Leaf.NotAfter) {
+		t.Errorf("served %v (%v), want the renewed certificate", cert, err)
+	}
+	// TLS-ALPN-01 challenges are still answered by the manager.
+	challenge := *hello
+	challenge.SupportedProtos = []string{acme.ALPNProto}
+	if cert, err := r.GetCertificate(&challenge); err != nil || cert.Leaf.NotAfter.After(time.Now().Add(2*time.Hour)) {
+		t.Errorf("challenge: served %v (%v), want the token certificate", cert, err)
+	}
+
+	// Once the manager serves the new certificate, it takes over.
+	cache.Put(ctx, "example.com", freshData)
+	r.mgr = newManager()
+	if cert, err := r.GetCertificate(hello); err != nil || !cert.Leaf.NotAfter.Equal(fresh.Leaf.NotAfter) {
+		t.Errorf("served %v (%v) after the manager loaded it", cert, err)
+	}
+	if len(r.renewed) != 0 {
+		t.Error("the renewed certificate is kept after the manager caught up")
+	}
+}
+
+func TestSiteDomainFor(t *testing.T) {
+	confs := []config.SiteConfig{
+		{Domain: "*.example.com", SSL: config.SSLConfig{Enabled: true}},
+		{Domain: "api.example.com", SSL: config.SSLConfig{Enabled: true}},
+		{Domain: "plain.example.com"},
+	}
+	cases := map[string]string{
+		"api.example.com":   "api.example.com",
+		"www.example.com":   "*.example.com",
+		"plain.example.com": "*.example.com",
+		"example.org":       "",
+		"":                  "",
+	}
+	for host, want := range cases {
+		if got := siteDomainFor(confs, host); got != want {
+			t.Errorf("siteDomainFor(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+// testCertBundle returns a cache entry for name expiring at notAfter.
+func testCertBundle(t *testing.T, name string, notAfter time.Time) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := encodeCertBundle(&tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestIssuanceCacheLocksAcrossNodes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	node := func() *issuanceCache {
+		storage, err := certs.NewSharedDirStorage(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newIssuanceCache(storage, time.Minute, nil)
+	}
+	a, b := node(), node()
+
+	// The first node to miss obtains the certificate; the other one backs
+	// off until it is stored.
+	if _, err := a.Get(ctx, "example.com"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("first miss = %v, want cache miss", err)
+	}
+	if _, err := a.Get(ctx, "example.com"); !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("repeated miss on the lock holder = %v, want cache miss", err)
+	}
+	if _, err := b.Get(ctx, "example.com"); !errors.Is(err, errIssuanceElsewhere) {
+		t.Fatalf("miss on the other node = %v, want errIssuanceElsewhere", err)
+	}
+
+	bundle := testCertBundle(t, "example.com", time.Now().Add(90*24*time.Hour))
+	if err := a.Put(ctx, "example.com", bundle); err != nil {
+		t.Fatal(err)
+	}
+	if a.holds("example.com") {
+		t.Error("expected Put to release the lock")
+	}
+	if data, err := b.Get(ctx, "example.com"); err != nil || string(data) != string(bundle) {
+		t.Fatalf("Get on the other node after issuance: %v", err)
+	}
+
+	// A certificate due for renewal is locked too; challenge tokens never.
+	expiring := testCertBundle(t, "old.com", time.Now().Add(24*time.Hour))
+	if err := a.storage.Put(ctx, "old.com", expiring); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, "old.com"); err != nil || !b.holds("old.com") {
+		t.Fatalf("expected the renewing node to hold the lock: %v", err)
+	}
+	if _, err := a.Get(ctx, "old.com+token"); !errors.Is(err, autocert.ErrCacheMiss) || a.holds("old.com+token") {
+		t.Errorf("expected challenge tokens not to be locked: %v", err)
+	}
+	b.unlock("old.com")
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// archiveFS is a read-only fs.FS over the entries of a zip or uncompressed
+// tar file. Directories missing from the archive are implied by the paths
+// of their files; links, special files and names escaping the archive are
+// left out.
+//
+// Files are seekable, so ranges are served without reading the whole entry:
+// tar entries and stored zip entries are sections of the archive file,
+// deflated zip entries are decompressed again from the start when a read
+// seeks backwards.
+type archiveFS struct {
+	file  *os.File
+	nodes map[string]*archiveNode // by fs.FS name, "." is the root
+}
+
+// archiveNode is an entry of an archiveFS, and its fs.FileInfo and
+// fs.DirEntry.
+type archiveNode struct {
+	name     string
+	dir      bool
+	size     int64
+	modTime  time.Time
+	etag     string // strong, derived from the entry metadata
+	children []*archiveNode
+	open     func() (io.ReadSeeker, error)
+}
+
+func (n *archiveNode) Name() string       { return n.name }
+func (n *archiveNode) Size() int64        { return n.size }
+func (n *archiveNode) ModTime() time.Time { return n.modTime }
+func (n *archiveNode) IsDir() bool        { return n.dir }
+func (n *archiveNode) Sys() any           { return nil }
+
+func (n *archiveNode) Mode() fs.FileMode {
+	if n.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (n *archiveNode) Type() fs.FileMode          { return n.Mode().Type() }
+func (n *archiveNode) Info() (fs.FileInfo, error) { return n, nil }
+
+// openArchiveFS indexes the archive at name, a .zip or .tar file.
+func openArchiveFS(name string) (*archiveFS, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &archiveFS{
+		file:  f,
+		nodes: map[string]*archiveNode{".": {name: ".", dir: true, modTime: info.ModTime()}},
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".zip":
+		err = a.indexZip(info)
+	case ".tar":
+		err = a.indexTar(info)
+	default:
+		err = errors.New("not a .zip or .tar file")
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for _, n := range a.nodes {
+		slices.SortFunc(n.children, func(x, y *archiveNode) int { return strings.Compare(x.name, y.name) })
+	}
+	return a, nil
+}
+
+func (a *archiveFS) indexZip(info fs.FileInfo) error {
+	zr, err := zip.NewReader(a.file, info.Size())
+	if err != nil {
+		return err
+	}
+	for _, zf := range zr.File {
+		mode := zf.Mode()
+		if mode.IsDir() {
+			a.add(zf.Name, true, info.ModTime())
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		n := a.add(zf.Name, false, zf.Modified)
+		if n == nil {
+			continue
+		}
+		n.size = int64(zf.UncompressedSize64)
+		n.etag = fmt.Sprintf(`"%x-%x-%x"`, zf.CRC32, n.size, n.modTime.UnixNano())
+		if offset, err := zf.DataOffset(); err == nil && zf.Method == zip.Store {
+			n.open = func() (io.ReadSeeker, error) {
+				return io.NewSectionReader(a.file, offset, n.size), nil
+			}
+		} else {
+			n.open = func() (io.ReadSeeker, error) {
+				return &inflateReader{open: zf.Open, size: n.size}, nil
+			}
+		}
+	}
+	return nil
+}
+
+func (a *archiveFS) indexTar(info fs.FileInfo) error {
+	tr := tar.NewReader(a.file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			a.add(hdr.Name, true, hdr.ModTime)
+		case tar.TypeReg:
+			n := a.add(hdr.Name, false, hdr.ModTime)
+			if n == nil {
+				continue
+			}
+			// tar.Reader reads the archive block by block, so the data of
+			// the entry starts at the current offset.
+			offset, err := a.file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return err
+			}
+			n.size = hdr.Size
+			n.etag = fmt.Sprintf(`"%x-%x-%x"`, offset, n.size, n.modTime.UnixNano())
+			n.open = func() (io.ReadSeeker, error) {
+				return io.NewSectionReader(a.file, offset, n.size), nil
+			}
+		}
+	}
+}
+
+// add records the entry name and its parent directories. It returns nil
+// for names that can't be served.
+func (a *archiveFS) add(name string, dir bool, modTime time.Time) *archiveNode {
+	name = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(name, "\\", "/")), "/")
+	if name == "" || !fs.ValidPath(name) {
+		return nil
+	}
+	if n, ok := a.nodes[name]; ok {
+		if n.dir != dir {
+			return nil
+		}
+		n.modTime = modTime
+		return n
+	}
+	parentName := path.Dir(name)
+	parent := a.nodes[parentName]
+	if parent == nil {
+		parent = a.add(parentName, true, a.nodes["."].modTime)
+	}
+	if parent == nil || !parent.dir {
+		return nil
+	}
+	n := &archiveNode{name: path.Base(name), dir: dir, modTime: modTime}
+	a.nodes[name] = n
+	parent.children = append(parent.children, n)
+	return n
+}
+
+func (a *archiveFS) Close() error {
+	return a.file.Close()
+}
+
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	n, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if n.dir {
+		return &archiveDir{node: n}, nil
+	}
+	rs, err := n.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &archiveFile{node: n, ReadSeeker: rs}, nil
+}
+
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	return a.lookup("stat", name)
+}
+
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	n, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !n.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	entries := make([]fs.DirEntry, len(n.children))
+	for i, c := range n.children {
+		entries[i] = c
+	}
+	return entries, nil
+}
+
+func (a *archiveFS) lookup(op, name string) (*archiveNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	n, ok := a.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return n, nil
+}
+
+// archiveFile is an open file of an archiveFS.
+type archiveFile struct {
+	node *archiveNode
+	io.ReadSeeker
+}
+
+func (f *archiveFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+
+func (f *archiveFile) Close() error {
+	if c, ok := f.ReadSeeker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// archiveDir is an open directory of an archiveFS.
+type archiveDir struct {
+	node *archiveNode
+	read int
+}
+
+func (d *archiveDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+func (d *archiveDir) Close() error               { return nil }
+
+func (d *archiveDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: errors.New("is a directory")}
+}
+
+func (d *archiveDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	rest := d.node.children[d.read:]
+	if count > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && count < len(rest) {
+		rest = rest[:count]
+	}
+	d.read += len(rest)
+	entries := make([]fs.DirEntry, len(rest))
+	for i, c := range rest {
+		entries[i] = c
+	}
+	return entries, nil
+}
+
+// inflateReader makes a compressed zip entry seekable: Seek only records
+// the position, and Read skips forward to it, starting over when it lies
+// behind what was already decompressed.
+type inflateReader struct {
+	open func() (io.ReadCloser, error)
+	size int64
+	rc   io.ReadCloser
+	pos  int64 // position of rc
+	off  int64 // position requested
+}
+
+func (r *inflateReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before the start of the file")
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *inflateReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil || r.off < r.pos {
+		if r.rc != nil {
+			r.rc.Close()
+		}
+		rc, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.rc, r.pos = rc, 0
+	}
+	if r.off > r.pos {
+		n, err := io.CopyN(io.Discard, r.rc, r.off-r.pos)
+		r.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.rc.Read(p)
+	r.pos += int64(n)
+	r.off = r.pos
+	return n, err
+}
+
+func (r *inflateReader) Close() error {
+	if r.rc != nil {
+		return r.rc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/assets"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
+)
+
+// archiveRoot serves a static site from the zip or tar file of root_archive,
+// like serveStatic does from a directory: index.html, listings, sidecars and
+// ranges. The archive is reopened when its size or modification time
+// changes; requests still reading the previous one finish with it.
+type archiveRoot struct {
+	path string
+	site *staticSite
+
+	mu      sync.Mutex
+	current *loadedArchive
+	// failed identifies a version that could not be opened, so a broken
+	// (or half-copied) archive is retried only once it changes again.
+	failedSize    int64
+	failedModTime time.Time
+}
+
+// loadedArchive is an opened version of the archive and its readers.
+type loadedArchive struct {
+	fsys    *archiveFS
+	size    int64
+	modTime time.Time
+	refs    int
+	retired bool
+}
+
+func newArchiveRoot(conf config.SiteConfig) *archiveRoot {
+	return &archiveRoot{path: conf.RootArchive, site: &staticSite{listing: conf.Listing, markdown: newMarkdownPages(conf), access: newStaticAccess(conf)}}
+}
+
+// acquire returns the current version of the archive, reopening it if the
+// file changed. The caller must release it.
+func (a *archiveRoot) acquire() (*loadedArchive, error) {
+	info, statErr := cachedStat(a.path)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cur := a.current
+	changed := statErr == nil && (cur == nil || info.Size() != cur.size || !info.ModTime().Equal(cur.modTime))
+	retry := changed && (info.Size() != a.failedSize || !info.ModTime().Equal(a.failedModTime))
+	if retry {
+		fsys, err := openArchiveFS(a.path)
+		if err == nil {
+			if cur != nil {
+				cur.retired = true
+				if cur.refs == 0 {
+					cur.fsys.Close()
+				}
+			}
+			cur = &loadedArchive{fsys: fsys, size: info.Size(), modTime: info.ModTime()}
+			a.current = cur
+		} else {
+			a.failedSize, a.failedModTime = info.Size(), info.ModTime()
+			statErr = err
+		}
+	}
+	if cur == nil {
+		if statErr == nil {
+			statErr = fmt.Errorf("%s could not be opened", a.path)
+		}
+		return nil, statErr
+	}
+	cur.refs++
+	return cur, nil
+}
+
+func (a *archiveRoot) release(l *loadedArchive) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l.refs--
+	if l.retired && l.refs == 0 {
+		l.fsys.Close()
+	}
+}
+
+func (a *archiveRoot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l, err := a.acquire()
+	if err != nil {
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to open the site archive.")
+		return
+	}
+	defer a.release(l)
+	fsys := l.fsys
+
+	cleanPath := path.Clean("/" + strings.TrimPrefix(strings.ReplaceAll(r.URL.Path, "\\", "/"), "/"))
+	name := strings.TrimPrefix(cleanPath, "/")
+	for _, seg := range strings.Split(name, "/") {
+		if isHiddenName(seg) {
+			name = ""
+			break
+		}
+	}
+	if cleanPath == "/" {
+		name = "."
+	}
+	info, err := fs.Stat(fsys, name)
+	if name == "" || err != nil || a.site.access.denied(cleanPath) {
+		serveStaticError(w, r, http.StatusNotFound, "Page Not Found", "The page you are looking for does not exist.")
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			target := r.URL.Path + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		index, indexInfo := "", fs.FileInfo(nil)
+		for _, n := range a.site.access.indexFiles() {
+			if a.site.access.denied(path.Join(cleanPath, n)) {
+				continue
+			}
+			if i, err := fs.Stat(fsys, path.Join(name, n)); err == nil && !i.IsDir() {
+				index, indexInfo = path.Join(name, n), i
+				break
+			}
+		}
+		if indexInfo != nil {
+			name, info = index, indexInfo
+		} else {
+			dir, err := fs.Sub(fsys, name)
+			if err != nil {
+				serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to read directory.")
+				return
+			}
+			serveListing(w, r, cleanPath, dir, a.site)
+			return
+		}
+	}
+
+	if m := a.site.markdown; m != nil && m.match(name) {
+		if m.serve(w, r, cleanPath, info, func() ([]byte, error) { return fs.ReadFile(fsys, name) }) {
+			return
+		}
+	}
+
+	// Same preference as serveStatic: br, then zstd, then gzip.
+	acceptEncoding := r.Header.Get("Accept-Encoding")
+	servePath, serveInfo, contentEncoding := name, info, ""
+	for _, s := range []struct{ encoding, ext string }{{"br", ".br"}, {"zstd", ".zst"}, {"gzip", ".gz"}} {
+		if !middleware.AcceptsEncoding(acceptEncoding, s.encoding) {
+			continue
+		}
+		if sInfo, err := fs.Stat(fsys, name+s.ext); err == nil && !sInfo.IsDir() {
+			servePath, serveInfo, contentEncoding = name+s.ext, sInfo, s.encoding
+			break
+		}
+	}
+
+	file, err := fsys.Open(servePath)
+	if err != nil {
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to read file content.")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Add("Vary", "Accept-Encoding")
+	if contentEncoding != "" {
+		w.Header().Set("Content-Encoding", contentEncoding)
+		w.Header().Set("Content-Type", staticMimeType(name))
+	}
+	w.Header().Set("ETag", serveInfo.(*archiveNode).etag)
+	http.ServeContent(w, r, path.Base(name), serveInfo.ModTime(), file.(io.ReadSeeker))
+}
+
+// serveStaticError writes an error page for browsers and a one-line
+// message for everyone else.
+func serveStaticError(w http.ResponseWriter, r *http.Request, status int, title, message string) {
+	if isBrowser(r) {
+		assets.RenderErrorPage(w, status, title, message)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if status == http.StatusNotFound {
+		fmt.Fprintln(w, "404 Not Found")
+		return
+	}
+	fmt.Fprintf(w, "%d %s: %s\n", status, http.StatusText(status), message)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+var archiveTestFiles = []struct{ name, content string }{
+	{"index.html", "<h1>home</h1>"},
+	{"app.js", strings.Repeat("console.log('goup');\n", 100)},
+	{"app.js.br", "brotli app"},
+	{"docs/a.txt", "alpha"},
+	{"docs/sub/b.txt", "bravo"},
+	{".env", "SECRET=1"},
+}
+
+func writeTestZip(t *testing.T, name string, method uint16) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range archiveTestFiles {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: method, Modified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f.content))
+	}
+	zw.Close()
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTar(t *testing.T, name string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "docs/link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	for _, f := range archiveTestFiles {
+		tw.WriteHeader(&tar.Header{Name: "./" + f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.content)), ModTime: time.Unix(1700000000, 0)})
+		tw.Write([]byte(f.content))
+	}
+	tw.Close()
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serveArchiveRequest(root *archiveRoot, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, req)
+	return w
+}
+
+func TestArchiveRoot(t *testing.T) {
+	dir := t.TempDir()
+	archives := map[string]func(string){
+		"stored.zip":   func(p string) { writeTestZip(t, p, zip.Store) },
+		"deflated.zip": func(p string) { writeTestZip(t, p, zip.Deflate) },
+		"site.tar":     func(p string) { writeTestTar(t, p) },
+	}
+	for name, write := range archives {
+		t.Run(name, func(t *testing.T) {
+			p := filepath.Join(dir, name)
+			write(p)
+			root := newArchiveRoot(config.SiteConfig{RootArchive: p})
+
+			if w := serveArchiveRequest(root, "/", nil); w.Code != http.StatusOK || w.Body.String() != "<h1>home</h1>" {
+				t.Errorf("index: %d %q", w.Code, w.Body.String())
+			}
+
+			w := serveArchiveRequest(root, "/app.js", nil)
+			etag := w.Header().Get("ETag")
+			if w.Body.String() != archiveTestFiles[1].content || etag == "" || strings.HasPrefix(etag, "W/") {
+				t.Fatalf("app.js: %d bytes, ETag %q", w.Body.Len(), etag)
+			}
+			if w := serveArchiveRequest(root, "/app.js", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+				t.Errorf("revalidation: status %d, want 304", w.Code)
+			}
+			w = serveArchiveRequest(root, "/app.js", map[string]string{"Range": "bytes=1000-1010"})
+			if w.Code != http.StatusPartialContent || w.Body.String() != archiveTestFiles[1].content[1000:1011] {
+				t.Errorf("range: %d %q", w.Code, w.Body.String())
+			}
+			w = serveArchiveRequest(root, "/app.js", map[string]string{"Accept-Encoding": "br, gzip"})
+			if w.Body.String() != "brotli app" || w.Header().Get("Content-Encoding") != "br" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/javascript") {
+				t.Errorf("sidecar: %q %q %q", w.Body.String(), w.Header().Get("Content-Encoding"), w.Header().Get("Content-Type"))
+			}
+
+			if w := serveArchiveRequest(root, "/docs", nil); w.Code != http.StatusMovedPermanently {
+				t.Errorf("directory without slash: status %d", w.Code)
+			}
+			if w := serveArchiveRequest(root, "/docs/", map[string]string{"Accept": "*/*"}); w.Body.String() != "sub/\na.txt\n" {
+				t.Errorf("listing: %q", w.Body.String())
+			}
+			for _, target := range []string{"/.env", "/missing", "/docs/link", "/../index.html/x"} {
+				if w := serveArchiveRequest(root, target, nil); w.Code != http.StatusNotFound {
+					t.Errorf("%s: status %d, want 404", target, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveRootReload(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "site.zip")
+	writeTestZip(t, p, zip.Deflate)
+	root := newArchiveRoot(config.SiteConfig{RootArchive: p})
+	resetStatCache()
+	if w := serveArchiveRequest(root, "/index.html", nil); w.Body.String() != "<h1>home</h1>" {
+		t.Fatalf("got %q", w.Body.String())
+	}
+
+	// A broken replacement keeps the previous version in service.
+	next := p + ".new"
+	os.WriteFile(next, []byte("not a zip"), 0644)
+	os.Rename(next, p)
+	resetStatCache()
+	if w := serveArchiveRequest(root, "/index.html", nil); w.Body.String() != "<h1>home</h1>" {
+		t.Errorf("after a broken replacement: %d %q", w.Code, w.Body.String())
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, _ := zw.Create("index.html")
+	fw.Write([]byte("<h1>v2</h1>"))
+	zw.Close()
+	os.WriteFile(next, buf.Bytes(), 0644)
+	os.Rename(next, p)
+	resetStatCache()
+	if w := serveArchiveRequest(root, "/index.html", nil); w.Body.String() != "<h1>v2</h1>" {
+		t.Errorf("after the replacement: %q", w.Body.String())
+	}
+	if w := serveArchiveRequest(root, "/app.js", nil); w.Code != http.StatusNotFound {
+		t.Errorf("a file of the previous version: status %d", w.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/monitor"
+)
+
+const (
+	defaultFileCacheBytes    = 64 << 20
+	defaultFileCacheFileSize = 256 << 10
+)
+
+// cachedFile is the content of a static file kept in memory.
+type cachedFile struct {
+	path    string
+	info    os.FileInfo
+	modTime time.Time
+	size    int64
+	data    []byte
+	etag    string // same as the one served from disk
+}
+
+// fileCache keeps small static files (and their .br/.gz sidecars) in memory
+// within a byte budget, evicting the least recently used. Entries are
+// checked against the cachedStat result of every request, so a file
+// changed on disk is reloaded within the stat cache TTL.
+type fileCache struct {
+	maxBytes int64
+	maxFile  int64
+	stats    *monitor.FileCacheStats
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // path -> element holding *cachedFile
+	lru     *list.List               // most recently used first
+	size    int64
+}
+
+// newFileCache returns the file cache of a site, or nil when disabled.
+func newFileCache(conf config.SiteConfig) *fileCache {
+	if conf.FileCache == nil {
+		return nil
+	}
+	c := &fileCache{
+		maxBytes: conf.FileCache.MaxBytes,
+		maxFile:  conf.FileCache.MaxFileSize,
+		stats:    monitor.FileCache(conf.Domain),
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+	if c.maxBytes == 0 {
+		c.maxBytes = defaultFileCacheBytes
+	}
+	if c.maxFile == 0 {
+		c.maxFile = min(defaultFileCacheFileSize, c.maxBytes)
+	}
+	return c
+}
+
+// get returns the content of path, described by info, loading it on a miss.
+// It returns nil for files too large to cache or that cannot be read, which
+// the caller then serves from disk.
+func (c *fileCache) get(path string, info os.FileInfo) *cachedFile {
+	if info.Size() > c.maxFile {
+		return nil
+	}
+
+	c.mu.Lock()
+	if el, ok := c.entries[path]; ok {
+		f := el.Value.(*cachedFile)
+		// SameFile catches a file replaced by another one with the same size
+		// and mtime, as a new release unpacked from an archive may be.
+		if f.size == info.Size() && f.modTime.Equal(info.ModTime()) && os.SameFile(f.info, info) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			c.stats.Hits.Add(1)
+			return f
+		}
+		c.remove(el)
+	}
+	c.mu.Unlock()
+	c.stats.Misses.Add(1)
+
+	data, err := os.ReadFile(path)
+	if err != nil || int64(len(data)) != info.Size() {
+		// Unreadable, or changed since it was stat'ed: serve from disk.
+		return nil
+	}
+	f := &cachedFile{
+		path:    path,
+		info:    info,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		data:    data,
+		etag:    staticETag(info),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[path]; ok {
+		// Loaded concurrently; keep the newer one.
+		c.remove(el)
+	}
+	c.entries[path] = c.lru.PushFront(f)
+	c.size += f.size
+	c.stats.Entries.Add(1)
+	c.stats.Bytes.Add(f.size)
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions.Add(1)
+	}
+	return f
+}
+
+// remove drops an entry; c.mu must be held.
+func (c *fileCache) remove(el *list.Element) {
+	f := c.lru.Remove(el).(*cachedFile)
+	delete(c.entries, f.path)
+	c.size -= f.size
+	c.stats.Entries.Add(-1)
+	c.stats.Bytes.Add(-f.size)
+}
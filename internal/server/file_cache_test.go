@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestFileCacheServeStatic(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(1)"), 0644)
+	os.WriteFile(filepath.Join(root, "app.js.br"), []byte("brotli app"), 0644)
+	os.WriteFile(filepath.Join(root, "big.bin"), []byte(strings.Repeat("b", 2048)), 0644)
+	resetStatCache()
+
+	cache := newFileCache(config.SiteConfig{Domain: "filecache.test", FileCache: &config.FileCacheConfig{MaxFileSize: 1024}})
+	serve := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		serveStatic(w, req, root, &staticSite{cache: cache})
+		return w
+	}
+
+	first := serve("/app.js", nil)
+	if first.Code != http.StatusOK || first.Body.String() != "console.log(1)" {
+		t.Fatalf("got %d %q", first.Code, first.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected a strong ETag, got %q", etag)
+	}
+	info, _ := os.Stat(filepath.Join(root, "app.js"))
+	if etag != staticETag(info) {
+		t.Errorf("cached ETag %q differs from the one served from disk, %q", etag, staticETag(info))
+	}
+	if w := serve("/app.js", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", w.Code)
+	}
+	if got := cache.stats.Hits.Load(); got != 1 {
+		t.Errorf("hits = %d, want 1", got)
+	}
+
+	br := serve("/app.js", map[string]string{"Accept-Encoding": "br"})
+	if br.Body.String() != "brotli app" || br.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("sidecar: got %q encoding %q", br.Body.String(), br.Header().Get("Content-Encoding"))
+	}
+	if br.Header().Get("ETag") == etag {
+		t.Error("the sidecar must not share the ETag of the plain file")
+	}
+
+	if w := serve("/big.bin", nil); w.Body.Len() != 2048 {
+		t.Errorf("big file: got %d bytes", w.Body.Len())
+	}
+	if got := cache.stats.Entries.Load(); got != 2 {
+		t.Errorf("entries = %d, want 2 (files over max_file_size are not cached)", got)
+	}
+
+	// A rewrite is picked up once the stat cache sees the new size or mtime.
+	os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(2)"), 0644)
+	os.Chtimes(filepath.Join(root, "app.js"), time.Now(), time.Now().Add(time.Minute))
+	resetStatCache()
+	w := serve("/app.js", nil)
+	if w.Body.String() != "console.log(2)" || w.Header().Get("ETag") == etag {
+		t.Errorf("after rewrite: got %q etag %q", w.Body.String(), w.Header().Get("ETag"))
+	}
+
+	// So is another file with the same size and mtime renamed over it, as a
+	// new release unpacked from an archive may bring.
+	info, _ = os.Stat(filepath.Join(root, "app.js"))
+	os.WriteFile(filepath.Join(root, "app.js.new"), []byte("console.log(3)"), 0644)
+	os.Chtimes(filepath.Join(root, "app.js.new"), info.ModTime(), info.ModTime())
+	os.Rename(filepath.Join(root, "app.js.new"), filepath.Join(root, "app.js"))
+	resetStatCache()
+	if w := serve("/app.js", nil); w.Body.String() != "console.log(3)" {
+		t.Errorf("after a same-size replacement: got %q", w.Body.String())
+	}
+}
+
+func TestFileCacheEviction(t *testing.T) {
+	root := t.TempDir()
+	info := make(map[string]os.FileInfo)
+	for _, name := range []string{"a", "b", "c"} {
+		p := filepath.Join(root, name)
+		os.WriteFile(p, []byte(strings.Repeat(name, 40)), 0644)
+		info[name], _ = os.Stat(p)
+	}
+	cache := newFileCache(config.SiteConfig{Domain: "eviction.test", FileCache: &config.FileCacheConfig{MaxBytes: 100}})
+
+	get := func(name string) *cachedFile { return cache.get(filepath.Join(root, name), info[name]) }
+	get("a")
+	get("b")
+	get("a") // b is now the least recently used
+	get("c")
+
+	if _, ok := cache.entries[filepath.Join(root, "b")]; ok {
+		t.Error("expected b to be evicted")
+	}
+	if _, ok := cache.entries[filepath.Join(root, "a")]; !ok {
+		t.Error("expected a to stay cached")
+	}
+	if cache.size > 100 || cache.stats.Bytes.Load() != cache.size {
+		t.Errorf("size = %d, bytes = %d", cache.size, cache.stats.Bytes.Load())
+	}
+	if got := cache.stats.Evictions.Load(); got != 1 {
+		t.Errorf("evictions = %d, want 1", got)
+	}
+	if got := cache.stats.Misses.Load(); got != 3 {
+		t.Errorf("misses = %d, want 3", got)
+	}
+}
@@ -47,15 +47,48 @@ func createHandler(conf config.SiteConfig, log *logger.Logger, identifier string
 			proxy.ServeHTTP(w, r)
 		})
 
+	} else if conf.RootArchive != "" {
+		// Static site served from a zip or tar file
+		cacheControl := conf.CacheControl
+		root := newArchiveRoot(conf)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addCustomHeaders(w, conf.CustomHeaders, exposeHeaders)
+			if !root.site.access.allowMethod(w, r) {
+				return
+			}
+			if cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			root.ServeHTTP(w, r)
+		})
+
 	} else {
 		// Static File Handler with custom design and directory listing
 		cacheControl := conf.CacheControl
+		tf := newTryFiles(conf)
+		site := newStaticSite(conf, log)
+		dav := newDAVShare(conf)
 		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			addCustomHeaders(w, conf.CustomHeaders, exposeHeaders)
+			if dav != nil && dav.match(r) {
+				dav.ServeHTTP(w, r)
+				return
+			}
+			if site.uploads != nil && site.uploads.match(r) {
+				site.uploads.ServeHTTP(w, r)
+				return
+			}
+			if !site.access.allowMethod(w, r) {
+				return
+			}
 			if cacheControl != "" {
 				w.Header().Set("Cache-Control", cacheControl)
 			}
-			ServeStatic(w, r, conf.RootDirectory)
+			if tf != nil {
+				tf.serve(w, r, conf.RootDirectory, site)
+				return
+			}
+			serveStatic(w, r, conf.RootDirectory, site)
 		})
 	}
 
@@ -67,9 +100,20 @@ func createHandler(conf config.SiteConfig, log *logger.Logger, identifier string
 		siteMwManager.Use(middleware.ConcurrencyMiddleware(conf.MaxConcurrentConnections))
 	}
 
-	// Add Gzip Middleware (Smart Compression)
+	// Bandwidth limits wrap compression so they pace the bytes sent.
+	if conf.LimitRate > 0 || conf.LimitRatePerIP > 0 || len(conf.LimitRateRoutes) > 0 {
+		siteMwManager.Use(middleware.ThrottleMiddleware(conf))
+	}
+
+	// Add Compression Middleware (zstd, brotli, gzip)
 	// Keeps pre-compressed files if they exist, compresses others on the fly.
-	siteMwManager.Use(middleware.GzipMiddleware)
+	siteMwManager.Use(middleware.CompressionMiddleware(conf.Compression))
+
+	// Cache rules see the headers of the handler, static or proxied, and
+	// override them per path.
+	if len(conf.CacheRules) > 0 || conf.CacheAuto {
+		siteMwManager.Use(middleware.CacheRulesMiddleware(conf.CacheRules, conf.CacheAuto))
+	}
 
 	// Add logging middleware last to ensure it wraps the entire request.
 	// We default to true if the pointer is nil.
@@ -81,8 +125,9 @@ func createHandler(conf config.SiteConfig, log *logger.Logger, identifier string
 	handler = siteMwManager.Apply(handler)
 
 	// Edge middleware wraps the entire chain (outermost first). Applied in
-	// reverse so ForceHTTPS ends up outermost, then IP filtering, rate limiting,
-	// body limit, CORS, and finally security headers closest to the site chain.
+	// reverse so ForceHTTPS ends up outermost, then client certificates, IP
+	// filtering, rate limiting, body limit, CORS, and finally security headers
+	// closest to the site chain.
 	if conf.SecurityHeaders || conf.HSTS {
 		handler = middleware.SecurityHeadersMiddleware(conf.HSTS, conf.HSTSMaxAge, conf.SecurityHeaders)(handler)
 	}
@@ -100,6 +145,11 @@ func createHandler(conf config.SiteConfig, log *logger.Logger, identifier string
 	if len(conf.AllowIPs) > 0 || len(conf.DenyIPs) > 0 {
 		handler = middleware.IPFilterMiddleware(conf.AllowIPs, conf.DenyIPs)(handler)
 	}
+	// Always installed when configured, even in "none" mode, so spoofed
+	// client certificate headers never reach the upstream.
+	if conf.SSL.ClientAuth != nil {
+		handler = middleware.ClientCertMiddleware(conf.Domain, conf.SSL.ClientAuth)(handler)
+	}
 	if conf.ForceHTTPS {
 		handler = middleware.ForceHTTPSMiddleware()(handler)
 	}
@@ -0,0 +1,495 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/draw"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+)
+
+var (
+	defaultImageSizes     = []int{160, 320, 640, 960, 1280, 1920, 2560}
+	defaultImageQualities = []int{50, 75, 90}
+)
+
+const (
+	defaultImageQuality   = 75
+	defaultImageMaxPixels = 50_000_000
+	defaultImageCacheMax  = 1 << 30
+)
+
+// imageEncoders are the formats variants can be produced in, by media type.
+// The source format is kept unless the client's Accept prefers another.
+var imageEncoders = map[string]func(w io.Writer, img image.Image, quality int) error{
+	"image/jpeg": func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+	},
+	"image/png": func(w io.Writer, img image.Image, _ int) error {
+		return (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(w, img)
+	},
+	"image/gif": func(w io.Writer, img image.Image, _ int) error {
+		return gif.Encode(w, img, nil)
+	},
+}
+
+// imageFormats maps the extensions resized to their media type.
+var imageFormats = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+}
+
+// errNotResizable marks originals served as is: animated GIFs and images
+// over the pixel limit.
+var errNotResizable = errors.New("image can't be resized")
+
+// siteImages resizes the images of a static site on request and keeps the
+// variants in a disk cache, keyed by the source path, size and mtime, so an
+// updated original gets new variants.
+type siteImages struct {
+	widths, heights, qualities []int
+	maxPixels                  int64
+	cacheDir                   string
+	cacheMax                   int64
+	log                        *logger.Logger
+
+	slots chan struct{} // bounds the images being processed at once
+
+	mu       sync.Mutex
+	inflight map[string]*imageCall // variants being produced
+	asIs     map[string]bool       // originals found not resizable, by path, size and mtime
+	used     int64                 // bytes in the cache, -1 until counted
+}
+
+// imageCall is a variant being produced; err is set before done is closed.
+type imageCall struct {
+	done chan struct{}
+	err  error
+}
+
+// maxImageVerdicts bounds the remembered not-resizable sources; the set
+// starts over when full.
+const maxImageVerdicts = 10000
+
+// imageRequest is a validated set of resizing parameters.
+type imageRequest struct {
+	width, height int
+	fit           string
+	quality       int
+	format        string // media type of the variant
+}
+
+func newSiteImages(conf config.SiteConfig, log *logger.Logger) *siteImages {
+	c := conf.Images
+	if c == nil {
+		return nil
+	}
+	im := &siteImages{
+		widths:    c.Widths,
+		heights:   c.Heights,
+		qualities: c.Qualities,
+		maxPixels: c.MaxPixels,
+		cacheDir:  c.CacheDir,
+		cacheMax:  c.CacheMaxBytes,
+		log:       log,
+		slots:     make(chan struct{}, runtime.NumCPU()),
+		inflight:  make(map[string]*imageCall),
+		asIs:      make(map[string]bool),
+		used:      -1,
+	}
+	if len(im.widths) == 0 {
+		im.widths = defaultImageSizes
+	}
+	if len(im.heights) == 0 {
+		im.heights = defaultImageSizes
+	}
+	if len(im.qualities) == 0 {
+		im.qualities = defaultImageQualities
+	}
+	if im.maxPixels == 0 {
+		im.maxPixels = defaultImageMaxPixels
+	}
+	if im.cacheDir == "" {
+		im.cacheDir = config.GetImageCacheDir(conf.Domain)
+	}
+	if im.cacheMax == 0 {
+		im.cacheMax = defaultImageCacheMax
+	}
+	return im
+}
+
+// match reports whether r asks for a variant of the image at fullPath.
+func (im *siteImages) match(r *http.Request, fullPath string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if _, ok := imageFormats[strings.ToLower(filepath.Ext(fullPath))]; !ok {
+		return false
+	}
+	q := r.URL.Query()
+	return q.Has("w") || q.Has("h") || q.Has("fit") || q.Has("q")
+}
+
+// parse validates the query of r against the allowed values.
+func (im *siteImages) parse(r *http.Request, source string) (imageRequest, error) {
+	q := r.URL.Query()
+	req := imageRequest{fit: config.FitContain, quality: defaultImageQuality}
+	for _, p := range []struct {
+		name    string
+		allowed []int
+		dst     *int
+	}{{"w", im.widths, &req.width}, {"h", im.heights, &req.height}, {"q", im.qualities, &req.quality}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || !slices.Contains(p.allowed, n) {
+			return req, fmt.Errorf("%s must be one of %s", p.name, joinInts(p.allowed))
+		}
+		*p.dst = n
+	}
+	switch fit := q.Get("fit"); fit {
+	case "":
+	case config.FitContain, config.FitCover, config.FitFill:
+		req.fit = fit
+	default:
+		return req, fmt.Errorf("fit must be %s, %s or %s", config.FitContain, config.FitCover, config.FitFill)
+	}
+	req.format = negotiateImageFormat(r.Header.Get("Accept"), source)
+	if req.format != "image/jpeg" {
+		req.quality = 0
+	}
+	return req, nil
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// serve answers r with a variant of the image at fullPath, producing it
+// unless it is cached.
+func (im *siteImages) serve(w http.ResponseWriter, r *http.Request, fullPath string, info fs.FileInfo) {
+	source := imageFormats[strings.ToLower(filepath.Ext(fullPath))]
+	req, err := im.parse(r, source)
+	if err != nil {
+		http.Error(w, "400 Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+
+	key := imageCacheKey(fullPath, info, req)
+	variant := filepath.Join(im.cacheDir, key[:2], key+imageExt(req.format))
+	original := fmt.Sprintf("%s\x00%d\x00%d", fullPath, info.Size(), info.ModTime().UnixNano())
+	if err := im.produce(fullPath, variant, original, req); err != nil {
+		if errors.Is(err, errNotResizable) {
+			http.ServeFile(w, r, fullPath)
+			return
+		}
+		if im.log != nil {
+			im.log.Errorf("Image error for %s: %v", r.URL.Path, err)
+		}
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to process the image.")
+		return
+	}
+
+	f, err := os.Open(variant)
+	if err != nil {
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to read file content.")
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", req.format)
+	w.Header().Set("ETag", `"`+key[:32]+`"`)
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// imageCacheKey names a variant after everything it depends on.
+func imageCacheKey(fullPath string, info fs.FileInfo, req imageRequest) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d\x00%dx%d\x00%s\x00%d\x00%s",
+		fullPath, info.Size(), info.ModTime().UnixNano(), req.width, req.height, req.fit, req.quality, req.format))
+	return hex.EncodeToString(sum[:])
+}
+
+func imageExt(format string) string {
+	if format == "image/jpeg" {
+		return ".jpg"
+	}
+	return "." + strings.TrimPrefix(format, "image/")
+}
+
+// produce writes the variant unless it exists. Concurrent requests for the
+// same variant wait for the first one and get its result. A source found
+// not resizable, by path, size and mtime, is not decoded again.
+func (im *siteImages) produce(fullPath, variant, original string, req imageRequest) error {
+	im.mu.Lock()
+	if im.asIs[original] {
+		im.mu.Unlock()
+		return errNotResizable
+	}
+	if c, ok := im.inflight[variant]; ok {
+		im.mu.Unlock()
+		<-c.done
+		return c.err
+	}
+	// Checked under the lock: a variant is renamed into place before its
+	// call is removed.
+	if _, err := os.Stat(variant); err == nil {
+		im.mu.Unlock()
+		return nil
+	}
+	c := &imageCall{done: make(chan struct{})}
+	im.inflight[variant] = c
+	im.mu.Unlock()
+
+	c.err = im.resize(fullPath, variant, req)
+	im.mu.Lock()
+	delete(im.inflight, variant)
+	if errors.Is(c.err, errNotResizable) {
+		if len(im.asIs) >= maxImageVerdicts {
+			clear(im.asIs)
+		}
+		im.asIs[original] = true
+	}
+	im.mu.Unlock()
+	close(c.done)
+	return c.err
+}
+
+// resize decodes the original, scales it and writes the variant
+// atomically.
+func (im *siteImages) resize(fullPath, variant string, req imageRequest) error {
+	im.slots <- struct{}{}
+	defer func() { <-im.slots }()
+
+	src, err := os.Open(fullPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	cfg, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > im.maxPixels {
+		return errNotResizable
+	}
+	orientation := 1
+	if format == "jpeg" {
+		src.Seek(0, io.SeekStart)
+		orientation = jpegOrientation(src)
+	}
+	src.Seek(0, io.SeekStart)
+	var img image.Image
+	if format == "gif" {
+		g, err := gif.DecodeAll(src)
+		if err != nil {
+			return err
+		}
+		if len(g.Image) > 1 {
+			return errNotResizable
+		}
+		img = g.Image[0]
+	} else if img, _, err = image.Decode(src); err != nil {
+		return err
+	}
+
+	out := scaleImage(orient(img, orientation), req)
+	if err := os.MkdirAll(filepath.Dir(variant), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(variant), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := imageEncoders[req.format](tmp, out, req.quality); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), variant); err != nil {
+		return err
+	}
+	im.account(info.Size())
+	return nil
+}
+
+// account adds a new variant to the cache size and trims the cache to 90%
+// of its budget when it runs over, removing the oldest variants first.
+func (im *siteImages) account(size int64) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if im.used < 0 {
+		im.used = 0
+		filepath.WalkDir(im.cacheDir, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					im.used += info.Size()
+				}
+			}
+			return nil
+		})
+	} else {
+		im.used += size
+	}
+	if im.used <= im.cacheMax {
+		return
+	}
+
+	type cached struct {
+		path string
+		info fs.FileInfo
+	}
+	var files []cached
+	filepath.WalkDir(im.cacheDir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() && !strings.HasPrefix(d.Name(), ".tmp-") {
+			if info, err := d.Info(); err == nil {
+				files = append(files, cached{p, info})
+			}
+		}
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+	for _, f := range files {
+		if im.used <= im.cacheMax*9/10 {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			im.used -= f.info.Size()
+		}
+	}
+}
+
+// scaleImage fits img to the requested box, never enlarging it.
+func scaleImage(img image.Image, req imageRequest) image.Image {
+	b := img.Bounds()
+	sw, sh := float64(b.Dx()), float64(b.Dy())
+	tw, th := float64(req.width), float64(req.height)
+	crop := b
+
+	switch {
+	case tw == 0 && th == 0:
+		tw, th = sw, sh
+	case tw > 0 && th > 0 && req.fit == config.FitFill:
+		tw, th = math.Min(tw, sw), math.Min(th, sh)
+	case tw > 0 && th > 0 && req.fit == config.FitCover:
+		scale := math.Max(tw/sw, th/sh)
+		if scale > 1 {
+			scale = 1
+			tw, th = math.Min(tw, sw), math.Min(th, sh)
+		}
+		cw, ch := math.Min(sw, math.Round(tw/scale)), math.Min(sh, math.Round(th/scale))
+		x0 := b.Min.X + int((sw-cw)/2)
+		y0 := b.Min.Y + int((sh-ch)/2)
+		crop = image.Rect(x0, y0, x0+int(cw), y0+int(ch))
+	default:
+		scale := 1.0
+		if tw > 0 {
+			scale = math.Min(scale, tw/sw)
+		}
+		if th > 0 {
+			scale = math.Min(scale, th/sh)
+		}
+		tw, th = math.Max(1, math.Round(sw*scale)), math.Max(1, math.Round(sh*scale))
+	}
+
+	w, h := int(tw), int(th)
+	if crop == b && w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+	return dst
+}
+
+// flatten puts a transparent image on white, for JPEG.
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
+
+// negotiateImageFormat picks the format of a variant: the one with the
+// highest q-value in Accept among those with an encoder, the source format
+// winning ties. Without a match the source format is kept.
+func negotiateImageFormat(accept, source string) string {
+	if accept == "" {
+		return source
+	}
+	best, bestQ := source, acceptQuality(accept, source)
+	for _, format := range []string{"image/jpeg", "image/png", "image/gif"} {
+		if q := acceptQuality(accept, format); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the q-value Accept gives a media type, through the
+// most specific range matching it.
+func acceptQuality(accept, mediaType string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch {
+		case mt == mediaType:
+			s = 2
+		case mt == "image/*":
+			s = 1
+		case mt == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+	}
+	return q
+}
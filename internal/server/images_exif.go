@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+)
+
+// jpegOrientation returns the EXIF orientation (1 to 8) of a JPEG, or 1
+// when it has none. Only the first 64 KiB are searched, where cameras put
+// the APP1 segment.
+func jpegOrientation(r io.Reader) int {
+	buf := make([]byte, 64<<10)
+	n, _ := io.ReadFull(r, buf)
+	buf = buf[:n]
+	if len(buf) < 4 || buf[0] != 0xFF || buf[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(buf); {
+		if buf[i] != 0xFF {
+			return 1
+		}
+		marker := buf[i+1]
+		size := int(binary.BigEndian.Uint16(buf[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(buf) {
+			return 1
+		}
+		seg := buf[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// tiffOrientation reads tag 0x0112 from the first IFD of a TIFF header.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for e := ifd + 2; e+12 <= len(tiff) && count > 0; e, count = e+12, count-1 {
+		if order.Uint16(tiff[e:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[e+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient turns img upright according to an EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5 to 8 swap the axes.
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
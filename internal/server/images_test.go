@@ -0,0 +1,235 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func writeTestImage(t *testing.T, path string, w, h int, encode func(*os.File, image.Image) error) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newImageSite(t *testing.T) (string, *staticSite) {
+	t.Helper()
+	root := t.TempDir()
+	writeTestImage(t, filepath.Join(root, "photo.jpg"), 400, 200, func(f *os.File, img image.Image) error {
+		return jpeg.Encode(f, img, nil)
+	})
+	writeTestImage(t, filepath.Join(root, "logo.png"), 100, 100, func(f *os.File, img image.Image) error {
+		return png.Encode(f, img)
+	})
+	resetStatCache()
+	conf := config.SiteConfig{
+		Domain:        "example.com",
+		RootDirectory: root,
+		Images:        &config.ImagesConfig{Widths: []int{50, 100, 800}, Heights: []int{50, 100}, CacheDir: t.TempDir()},
+	}
+	return root, newStaticSite(conf, nil)
+}
+
+func serveImage(root string, site *staticSite, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	serveStatic(w, req, root, site)
+	return w
+}
+
+func decodeSize(t *testing.T, w *httptest.ResponseRecorder) (int, int, string) {
+	t.Helper()
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(w.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding the response: %v (status %d)", err, w.Code)
+	}
+	return cfg.Width, cfg.Height, format
+}
+
+func TestImageResize(t *testing.T) {
+	root, site := newImageSite(t)
+	tests := []struct {
+		target     string
+		wantW      int
+		wantH      int
+		wantFormat string
+	}{
+		{"/photo.jpg?w=100", 100, 50, "jpeg"},
+		{"/photo.jpg?h=50", 100, 50, "jpeg"},
+		{"/photo.jpg?w=100&h=100", 100, 50, "jpeg"},
+		{"/photo.jpg?w=100&h=100&fit=cover", 100, 100, "jpeg"},
+		{"/photo.jpg?w=50&h=100&fit=fill", 50, 100, "jpeg"},
+		{"/photo.jpg?w=800", 400, 200, "jpeg"}, // never enlarged
+		{"/logo.png?w=50", 50, 50, "png"},
+	}
+	for _, tt := range tests {
+		w := serveImage(root, site, tt.target, "")
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", tt.target, w.Code)
+			continue
+		}
+		gotW, gotH, format := decodeSize(t, w)
+		if gotW != tt.wantW || gotH != tt.wantH || format != tt.wantFormat {
+			t.Errorf("%s: %dx%d %s, want %dx%d %s", tt.target, gotW, gotH, format, tt.wantW, tt.wantH, tt.wantFormat)
+		}
+	}
+
+	for _, target := range []string{"/photo.jpg?w=123", "/photo.jpg?q=10", "/photo.jpg?fit=stretch", "/photo.jpg?w=abc"} {
+		if w := serveImage(root, site, target, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", target, w.Code)
+		}
+	}
+}
+
+func TestImageFormatNegotiation(t *testing.T) {
+	root, site := newImageSite(t)
+	w := serveImage(root, site, "/photo.jpg?w=50", "image/png,image/*;q=0.8")
+	if _, _, format := decodeSize(t, w); format != "png" || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("got %s (%s), want png", format, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Vary") != "Accept" {
+		t.Errorf("Vary = %q", w.Header().Get("Vary"))
+	}
+	w = serveImage(root, site, "/logo.png?w=50", "image/webp,*/*;q=0.8")
+	if _, _, format := decodeSize(t, w); format != "png" {
+		t.Errorf("unsupported preference: got %s, want the source format", format)
+	}
+
+	if got := negotiateImageFormat("image/jpeg;q=0.5,image/gif", "image/png"); got != "image/gif" {
+		t.Errorf("negotiateImageFormat = %q", got)
+	}
+	if got := negotiateImageFormat("image/png;q=0,*/*", "image/png"); got != "image/jpeg" {
+		t.Errorf("refused source: got %q", got)
+	}
+}
+
+func TestImageCache(t *testing.T) {
+	root, site := newImageSite(t)
+	first := serveImage(root, site, "/photo.jpg?w=50", "")
+	variants, _ := filepath.Glob(filepath.Join(site.images.cacheDir, "*", "*.jpg"))
+	if len(variants) != 1 {
+		t.Fatalf("cached variants: %v", variants)
+	}
+	info, _ := os.Stat(variants[0])
+
+	second := serveImage(root, site, "/photo.jpg?w=50", "")
+	if !bytes.Equal(first.Body.Bytes(), second.Body.Bytes()) || first.Header().Get("ETag") == "" {
+		t.Error("the cached variant differs")
+	}
+	if again, _ := os.Stat(variants[0]); !again.ModTime().Equal(info.ModTime()) {
+		t.Error("the variant was produced again")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/photo.jpg?w=50", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	w := httptest.NewRecorder()
+	serveStatic(w, req, root, site)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("conditional request: status %d", w.Code)
+	}
+}
+
+func TestImageCacheBudget(t *testing.T) {
+	root, site := newImageSite(t)
+	site.images.cacheMax = 1
+	serveImage(root, site, "/photo.jpg?w=50", "")
+	serveImage(root, site, "/photo.jpg?w=100", "")
+	variants, _ := filepath.Glob(filepath.Join(site.images.cacheDir, "*", "*.jpg"))
+	if len(variants) != 0 {
+		t.Errorf("the cache exceeds its budget: %v", variants)
+	}
+}
+
+func TestImageServedAsIs(t *testing.T) {
+	root, site := newImageSite(t)
+	frame := image.NewPaletted(image.Rect(0, 0, 100, 100), color.Palette{color.Black, color.White})
+	f, _ := os.Create(filepath.Join(root, "anim.gif"))
+	gif.EncodeAll(f, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}})
+	f.Close()
+	resetStatCache()
+	if w, h, _ := decodeSize(t, serveImage(root, site, "/anim.gif?w=50", "")); w != 100 || h != 100 {
+		t.Errorf("animated GIF: %dx%d", w, h)
+	}
+	if len(site.images.asIs) != 1 {
+		t.Errorf("%d verdicts remembered, want 1", len(site.images.asIs))
+	}
+
+	// A new version of the file is decoded again.
+	writeTestImage(t, filepath.Join(root, "anim.gif"), 100, 100, func(f *os.File, img image.Image) error {
+		return gif.Encode(f, img, nil)
+	})
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(root, "anim.gif"), later, later)
+	resetStatCache()
+	if w, h, _ := decodeSize(t, serveImage(root, site, "/anim.gif?w=50", "")); w != 50 || h != 50 {
+		t.Errorf("single-frame GIF after an animated one: %dx%d", w, h)
+	}
+
+	site.images.maxPixels = 1000
+	if w, h, _ := decodeSize(t, serveImage(root, site, "/photo.jpg?w=50", "")); w != 400 || h != 200 {
+		t.Errorf("over the pixel limit: %dx%d", w, h)
+	}
+}
+
+// exifJPEG prepends an APP1 segment with an orientation to a JPEG.
+func exifJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte("II*\x00\x08\x00\x00\x00\x01\x00")
+	entry := make([]byte, 12)
+	binary.LittleEndian.PutUint16(entry[0:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[2:], 3)
+	binary.LittleEndian.PutUint32(entry[4:], 1)
+	binary.LittleEndian.PutUint16(entry[8:], uint16(orientation))
+	payload := append(append([]byte("Exif\x00\x00"), tiff...), entry...)
+	payload = append(payload, 0, 0, 0, 0)
+
+	out := []byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(out[4:], uint16(len(payload)+2))
+	out = append(out, payload...)
+	return append(out, img.Bytes()[2:]...)
+}
+
+func TestImageOrientation(t *testing.T) {
+	root, site := newImageSite(t)
+	if err := os.WriteFile(filepath.Join(root, "portrait.jpg"), exifJPEG(t, 200, 100, 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+	resetStatCache()
+	if got := jpegOrientation(bytes.NewReader(exifJPEG(t, 8, 8, 8))); got != 8 {
+		t.Errorf("jpegOrientation = %d", got)
+	}
+	if w, h, _ := decodeSize(t, serveImage(root, site, "/portrait.jpg?h=100", "")); w != 50 || h != 100 {
+		t.Errorf("rotated: %dx%d, want 50x100", w, h)
+	}
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/assets"
+)
+
+// maxReadmeSize bounds the README shown above a listing.
+const maxReadmeSize = 64 << 10
+
+// readmeNames are the README files shown above a listing, by preference.
+var readmeNames = []string{"readme.md", "readme.markdown", "readme.txt", "readme"}
+
+// listingEntry is a directory entry as served in a JSON listing.
+type listingEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	URL     string    `json:"url"`
+}
+
+// serveListing lists the directory dir, served at cleanPath. Browsers get
+// the HTML page, clients accepting application/json a JSON array and
+// everything else one name per line. ?sort=name|size|mtime and
+// ?order=asc|desc pick the order, directories always coming first;
+// ?download=zip|tar.gz streams the whole directory when the site allows it.
+func serveListing(w http.ResponseWriter, r *http.Request, cleanPath string, dir fs.FS, site *staticSite) {
+	conf := site.listing
+	if format := r.URL.Query().Get("download"); format != "" {
+		if conf == nil || !slices.Contains(conf.Archives, format) {
+			http.Error(w, "404 Not Found: download not available", http.StatusNotFound)
+			return
+		}
+		serveArchive(w, r, cleanPath, dir, format, conf, site.access)
+		return
+	}
+
+	dirEntries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		if isBrowser(r) {
+			assets.RenderErrorPage(w, http.StatusInternalServerError, "Internal Server Error", "Unable to read directory.")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, "500 Internal Server Error: Unable to read directory.")
+		}
+		return
+	}
+
+	base := cleanPath
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	entries := make([]listingEntry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if isHiddenName(entry.Name()) || site.access.denied(base+entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// The entry vanished between ReadDir and Info (or is otherwise
+			// unreadable); skip it instead of panicking on a nil FileInfo.
+			continue
+		}
+		e := listingEntry{
+			Name:    entry.Name(),
+			IsDir:   entry.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+			URL:     base + url.PathEscape(entry.Name()),
+		}
+		if e.IsDir {
+			e.Size = 0
+			e.URL += "/"
+		}
+		entries = append(entries, e)
+	}
+
+	query := r.URL.Query()
+	sortBy := query.Get("sort")
+	if sortBy != "size" && sortBy != "mtime" {
+		sortBy = "name"
+	}
+	desc := query.Get("order") == "desc"
+	sortListing(entries, sortBy, desc)
+
+	switch {
+	case isBrowser(r):
+		data := assets.ListingPageData{
+			Path:     cleanPath,
+			ShowBack: cleanPath != "/",
+			Sort:     sortBy,
+			Desc:     desc,
+		}
+		for _, e := range entries {
+			data.Items = append(data.Items, assets.ListingItem{
+				Name:    e.Name,
+				IsDir:   e.IsDir,
+				Size:    formatSizeBytes(e.Size),
+				ModTime: e.ModTime.Local().Format("2006-01-02 15:04:05"),
+			})
+		}
+		if u := site.uploads; u != nil {
+			// The page depends on who asks for it.
+			w.Header().Set("Cache-Control", "private, no-store")
+			data.CanUpload = u.auth.Authorized(r)
+			data.CanDelete = data.CanUpload && u.delete
+			data.UploadLogin = !data.CanUpload
+		}
+		if conf != nil {
+			data.Archives = conf.Archives
+		}
+		if site.markdown != nil || (conf != nil && conf.Readme) {
+			name, readme := readListingReadme(dir, entries)
+			if site.markdown != nil && site.markdown.match(name) {
+				if doc := site.markdown.readme(cleanPath, readme); doc != nil {
+					data.ReadmeHTML, data.CodeCSS = doc.HTML, doc.CodeCSS
+				}
+			} else if conf != nil && conf.Readme {
+				data.Readme = readme
+			}
+		}
+		assets.RenderListing(w, data)
+
+	case strings.Contains(r.Header.Get("Accept"), "application/json"):
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		for _, e := range entries {
+			name := e.Name
+			if e.IsDir {
+				name += "/"
+			}
+			fmt.Fprintln(w, name)
+		}
+	}
+}
+
+// sortListing orders entries by name, size or mtime, directories first.
+// Ties fall back to the name.
+func sortListing(entries []listingEntry, sortBy string, desc bool) {
+	slices.SortStableFunc(entries, func(a, b listingEntry) int {
+		if a.IsDir != b.IsDir {
+			if a.IsDir {
+				return -1
+			}
+			return 1
+		}
+		var c int
+		switch sortBy {
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "mtime":
+			c = a.ModTime.Compare(b.ModTime)
+		}
+		if c == 0 {
+			c = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+		}
+		if desc {
+			c = -c
+		}
+		return c
+	})
+}
+
+// readListingReadme returns the name and text of the README among
+// entries, if any.
+func readListingReadme(dir fs.FS, entries []listingEntry) (string, string) {
+	for _, want := range readmeNames {
+		for _, e := range entries {
+			if e.IsDir || !strings.EqualFold(e.Name, want) {
+				continue
+			}
+			f, err := dir.Open(e.Name)
+			if err != nil {
+				return "", ""
+			}
+			defer f.Close()
+			data, _ := io.ReadAll(io.LimitReader(f, maxReadmeSize))
+			return e.Name, string(data)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Default limits of a directory download.
+const (
+	defaultArchiveMaxBytes = 1 << 30
+	defaultArchiveMaxFiles = 10000
+)
+
+// archiveEntry is a file or directory of a download, relative to its root.
+type archiveEntry struct {
+	name string // slash-separated, directories end with "/"
+	path string // in the listed fs.FS
+	info fs.FileInfo
+}
+
+// serveArchive streams the directory dir as a zip or tar.gz archive. Files
+// hidden from listings or denied, symlinks and special files are left out. The tree is
+// walked first so a download over the configured limits is refused before
+// anything is sent.
+func serveArchive(w http.ResponseWriter, r *http.Request, cleanPath string, dir fs.FS, format string, conf *config.ListingConfig, access *staticAccess) {
+	maxBytes, maxFiles := conf.ArchiveMaxBytes, conf.ArchiveMaxFiles
+	if maxBytes == 0 {
+		maxBytes = defaultArchiveMaxBytes
+	}
+	if maxFiles == 0 {
+		maxFiles = defaultArchiveMaxFiles
+	}
+
+	var entries []archiveEntry
+	var total int64
+	files := 0
+	tooLarge := false
+	err := fs.WalkDir(dir, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		if isHiddenName(d.Name()) || access.denied(path.Join(cleanPath, p)) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		name := p
+		if d.IsDir() {
+			name += "/"
+		} else {
+			files++
+			total += info.Size()
+			if files > maxFiles || total > maxBytes {
+				tooLarge = true
+				return fs.SkipAll
+			}
+		}
+		entries = append(entries, archiveEntry{name: name, path: p, info: info})
+		return nil
+	})
+	if err != nil {
+		http.Error(w, "500 Internal Server Error: Unable to read directory.", http.StatusInternalServerError)
+		return
+	}
+	if tooLarge {
+		http.Error(w, "413 Request Entity Too Large: downloads are limited to "+formatSizeBytes(maxBytes)+" and "+strconv.Itoa(maxFiles)+" files", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	base := path.Base(cleanPath)
+	if base == "/" || base == "." {
+		base, _, _ = strings.Cut(r.Host, ":")
+	}
+	filename := base + "." + format
+	contentType := "application/zip"
+	if format == config.ArchiveTarGz {
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	// Large directories take longer than any global write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	if format == config.ArchiveTarGz {
+		err = writeTarGz(w, dir, entries)
+	} else {
+		err = writeZip(w, dir, entries)
+	}
+	if err != nil {
+		// The headers are gone: abort the connection so the client sees a
+		// truncated download instead of a well-formed but broken archive.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func writeZip(w io.Writer, dir fs.FS, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		hdr, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		if e.info.IsDir() {
+			if _, err := zw.CreateHeader(hdr); err != nil {
+				return err
+			}
+			continue
+		}
+		hdr.Method = zip.Deflate
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if err := copyArchiveFile(fw, dir, e); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGz(w io.Writer, dir fs.FS, entries []archiveEntry) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr, err := tar.FileInfoHeader(e.info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = e.name
+		// Don't leak the server's users and groups.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !e.info.IsDir() {
+			if err := copyArchiveFile(tw, dir, e); err != nil {
+				return err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// copyArchiveFile copies exactly the size recorded for e, failing if the
+// file changed since the walk.
+func copyArchiveFile(w io.Writer, dir fs.FS, e archiveEntry) error {
+	f, err := dir.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(w, io.LimitReader(f, e.info.Size()))
+	if err == nil && n != e.info.Size() {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return &fs.PathError{Op: "archive", Path: e.name, Err: err}
+	}
+	return nil
+}
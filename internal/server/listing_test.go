@@ -0,0 +1,192 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func listingTestRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, f := range []struct{ name, content string }{
+		{"b.txt", "bb"},
+		{"a.txt", "aaaa"},
+		{"C.txt", "c"},
+		{"sub/inner.txt", "inner"},
+		{".secret", "hidden"},
+		{"README.md", "# Hello <b>"},
+	} {
+		p := filepath.Join(root, filepath.FromSlash(f.name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(f.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := base.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(p, mtime, mtime)
+	}
+	resetStatCache()
+	return root
+}
+
+func serveListingRequest(root, target, accept string, conf *config.ListingConfig) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	serveStatic(w, req, root, &staticSite{listing: conf})
+	return w
+}
+
+func TestListingSortAndFormats(t *testing.T) {
+	root := listingTestRoot(t)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"sub/", "a.txt", "b.txt", "C.txt", "README.md"}},
+		{"?sort=name&order=desc", []string{"sub/", "README.md", "C.txt", "b.txt", "a.txt"}},
+		{"?sort=size", []string{"sub/", "C.txt", "b.txt", "a.txt", "README.md"}},
+		{"?sort=mtime&order=desc", []string{"sub/", "README.md", "C.txt", "a.txt", "b.txt"}},
+	}
+	for _, tt := range tests {
+		w := serveListingRequest(root, "/"+tt.query, "*/*", nil)
+		got := strings.Fields(w.Body.String())
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	w := serveListingRequest(root, "/?sort=size&order=desc", "application/json", nil)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var entries []listingEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 || entries[1].Name != "README.md" || entries[1].Size != 11 || entries[1].URL != "/README.md" {
+		t.Errorf("unexpected JSON listing: %+v", entries)
+	}
+	if entries[0].URL != "/sub/" || !entries[0].IsDir {
+		t.Errorf("directory entry: %+v", entries[0])
+	}
+	if entries[2].ModTime.IsZero() {
+		t.Error("expected modification times in the JSON listing")
+	}
+}
+
+func TestListingReadmeAndArchiveLinks(t *testing.T) {
+	root := listingTestRoot(t)
+	conf := &config.ListingConfig{Readme: true, Archives: []string{config.ArchiveZip}}
+
+	body := serveListingRequest(root, "/", "text/html", conf).Body.String()
+	if !strings.Contains(body, `<pre class="listing-readme"># Hello &lt;b&gt;</pre>`) {
+		t.Error("expected the escaped README above the listing")
+	}
+	if !strings.Contains(body, `href="?download=zip"`) {
+		t.Error("expected a zip download link")
+	}
+
+	body = serveListingRequest(root, "/", "text/html", nil).Body.String()
+	if strings.Contains(body, `<pre class="listing-readme"`) || strings.Contains(body, "?download=") {
+		t.Error("README and downloads must be opt-in")
+	}
+}
+
+func TestListingArchiveDownload(t *testing.T) {
+	root := listingTestRoot(t)
+	conf := &config.ListingConfig{Archives: []string{config.ArchiveZip, config.ArchiveTarGz}}
+	want := map[string]string{"a.txt": "aaaa", "b.txt": "bb", "C.txt": "c", "README.md": "# Hello <b>", "sub/inner.txt": "inner"}
+
+	t.Run("zip", func(t *testing.T) {
+		w := serveListingRequest(root, "/?download=zip", "", conf)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+			t.Fatalf("got %d %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=example.com.zip` {
+			t.Errorf("Content-Disposition = %q", cd)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, f := range zr.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			got[f.Name] = string(data)
+		}
+		if len(got) != len(want) {
+			t.Errorf("zip holds %v, want %v", got, want)
+		}
+		for name, content := range want {
+			if got[name] != content {
+				t.Errorf("%s = %q, want %q", name, got[name], content)
+			}
+		}
+	})
+
+	t.Run("tar.gz", func(t *testing.T) {
+		w := serveListingRequest(root, "/sub/?download=tar.gz", "", conf)
+		if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=sub.tar.gz` {
+			t.Errorf("Content-Disposition = %q", cd)
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if hdr.Name != "inner.txt" || string(data) != "inner" || hdr.Uname != "" {
+			t.Errorf("got %q %q (user %q)", hdr.Name, data, hdr.Uname)
+		}
+		if _, err := tr.Next(); err != io.EOF {
+			t.Errorf("expected a single entry, got %v", err)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		limited := &config.ListingConfig{Archives: []string{config.ArchiveZip}, ArchiveMaxFiles: 2}
+		if w := serveListingRequest(root, "/?download=zip", "", limited); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("file limit: status = %d, want 413", w.Code)
+		}
+		limited = &config.ListingConfig{Archives: []string{config.ArchiveZip}, ArchiveMaxBytes: 10}
+		if w := serveListingRequest(root, "/?download=zip", "", limited); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("size limit: status = %d, want 413", w.Code)
+		}
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		if w := serveListingRequest(root, "/?download=tar.gz", "", &config.ListingConfig{Archives: []string{config.ArchiveZip}}); w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+		if w := serveListingRequest(root, "/?download=zip", "", nil); w.Code != http.StatusNotFound {
+			t.Errorf("status = %d, want 404", w.Code)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/mirkobrombin/goup/internal/assets"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/markdown"
+)
+
+// maxMarkdownSize bounds the files rendered; larger ones are served as is.
+const maxMarkdownSize = 1 << 20
+
+// markdownPages renders the Markdown files of a static site for browsers.
+type markdownPages struct {
+	exts  []string
+	style string
+}
+
+func newMarkdownPages(conf config.SiteConfig) *markdownPages {
+	if conf.Markdown == nil {
+		return nil
+	}
+	m := &markdownPages{exts: conf.Markdown.Extensions, style: conf.Markdown.CodeStyle}
+	if len(m.exts) == 0 {
+		m.exts = []string{".md", ".markdown"}
+	}
+	return m
+}
+
+// match reports whether the file name is rendered.
+func (m *markdownPages) match(name string) bool {
+	return slices.Contains(m.exts, strings.ToLower(path.Ext(name)))
+}
+
+// serve renders the file at urlPath for browsers, unless they ask for
+// ?raw=1, and reports whether it did; otherwise the caller serves the file
+// as is. Both responses vary with Accept.
+func (m *markdownPages) serve(w http.ResponseWriter, r *http.Request, urlPath string, info fs.FileInfo, read func() ([]byte, error)) bool {
+	w.Header().Add("Vary", "Accept")
+	if !isBrowser(r) || r.URL.Query().Get("raw") == "1" || info.Size() > maxMarkdownSize {
+		return false
+	}
+	src, err := read()
+	if err != nil {
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to read file content.")
+		return true
+	}
+	doc, err := markdown.Render(src, path.Dir(urlPath), m.style)
+	if err != nil {
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to render the page.")
+		return true
+	}
+	data := assets.MarkdownPageData{
+		Title:      doc.Title,
+		Path:       urlPath,
+		Content:    doc.HTML,
+		CodeCSS:    doc.CodeCSS,
+		FooterLink: "https://github.com/tryGoUp",
+		Styles:     assets.GlobalStyles,
+	}
+	for _, h := range doc.TOC {
+		data.TOC = append(data.TOC, assets.MarkdownHeading{Level: h.Level, ID: h.ID, Text: h.Text})
+	}
+	var buf bytes.Buffer
+	if err := assets.MarkdownTemplate.Execute(&buf, data); err != nil {
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to render the page.")
+		return true
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// Distinct from the ETag of the raw file, which varies on the same URL.
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-md"`, info.Size(), info.ModTime().UnixNano()))
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(buf.Bytes()))
+	return true
+}
+
+// readme renders a Markdown README for the bottom of a listing.
+func (m *markdownPages) readme(dirPath string, src string) *markdown.Document {
+	doc, err := markdown.Render([]byte(src), dirPath, m.style)
+	if err != nil {
+		return nil
+	}
+	return doc
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestMarkdownPages(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "docs"), 0755)
+	os.WriteFile(filepath.Join(root, "docs", "guide.md"), []byte("# Guide\n\nSee [the API](api.md)."), 0644)
+	os.WriteFile(filepath.Join(root, "docs", "README.md"), []byte("Read [the guide](guide.md)."), 0644)
+	resetStatCache()
+	site := newStaticSite(config.SiteConfig{RootDirectory: root, Markdown: &config.MarkdownConfig{}}, nil)
+
+	get := func(target, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		serveStatic(w, req, root, site)
+		return w
+	}
+
+	w := get("/docs/guide.md", "text/html")
+	body := w.Body.String()
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") || !strings.Contains(body, `<a href="/docs/api.md">the API</a>`) || !strings.Contains(body, "<title>Guide</title>") {
+		t.Errorf("rendered page: %q\n%s", w.Header().Get("Content-Type"), body)
+	}
+	if w.Header().Get("Vary") == "" || !strings.Contains(strings.Join(w.Header().Values("Vary"), ","), "Accept") {
+		t.Errorf("Vary = %q", w.Header().Values("Vary"))
+	}
+	etag := w.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/docs/guide.md", nil)
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	serveStatic(rec, req, root, site)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("revalidation: status %d", rec.Code)
+	}
+
+	for _, tt := range []struct{ target, accept string }{{"/docs/guide.md?raw=1", "text/html"}, {"/docs/guide.md", "*/*"}} {
+		w := get(tt.target, tt.accept)
+		if w.Body.String() != "# Guide\n\nSee [the API](api.md)." || w.Header().Get("ETag") == etag {
+			t.Errorf("%s (Accept %s): raw file expected, got %q", tt.target, tt.accept, w.Body.String())
+		}
+	}
+
+	w = get("/docs/", "text/html")
+	if !strings.Contains(w.Body.String(), `<article class="markdown-body listing-readme-html"><p>Read <a href="/docs/guide.md">the guide</a>.</p>`) {
+		t.Errorf("listing README:\n%s", w.Body.String())
+	}
+}
@@ -0,0 +1,268 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Cache-Control values of the automatic mode.
+const (
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	htmlCacheControl      = "no-cache"
+)
+
+// cacheRule is a compiled config.CacheRule.
+type cacheRule struct {
+	glob         string
+	re           *regexp.Regexp
+	cacheControl string
+	expires      time.Duration
+	hasExpires   bool
+	headers      map[string]string
+}
+
+// matches reports whether the rule applies to urlPath. A glob without a
+// slash matches the file name at any depth; one with a slash matches the
+// path or one of its parent directories.
+func (c *cacheRule) matches(urlPath string) bool {
+	if c.re != nil {
+		return c.re.MatchString(urlPath)
+	}
+	if !strings.Contains(c.glob, "/") {
+		ok, _ := path.Match(c.glob, path.Base(urlPath))
+		return ok
+	}
+	for p := urlPath; ; p = path.Dir(p) {
+		if ok, _ := path.Match(c.glob, p); ok {
+			return true
+		}
+		if p == "/" || p == "." || p == "" {
+			return false
+		}
+	}
+}
+
+// compileCacheRules skips rules that do not compile; config validation
+// reports them.
+func compileCacheRules(rules []config.CacheRule) []*cacheRule {
+	var compiled []*cacheRule
+	for _, r := range rules {
+		c := &cacheRule{glob: r.Path, cacheControl: r.CacheControl, headers: r.Headers}
+		if r.Regex != "" {
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				continue
+			}
+			c.re = re
+		} else if _, err := path.Match(r.Path, "/"); err != nil || r.Path == "" {
+			continue
+		}
+		if r.Expires != "" {
+			d, err := time.ParseDuration(r.Expires)
+			if err != nil {
+				continue
+			}
+			c.expires, c.hasExpires = d, true
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled
+}
+
+// CacheRulesMiddleware sets the caching headers of a response from the
+// first rule matching the request path, or, in automatic mode, from the
+// file name and type: fingerprinted assets ("app.3f9a2c.js") are immutable
+// and HTML is revalidated. The headers are decided once the handler has
+// written its own, so they override those of the site and of a proxied
+// backend, except that a response marked no-store keeps it.
+func CacheRulesMiddleware(rules []config.CacheRule, auto bool) MiddlewareFunc {
+	compiled := compileCacheRules(rules)
+	return func(next http.Handler) http.Handler {
+		if len(compiled) == 0 && !auto {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var rule *cacheRule
+			for _, c := range compiled {
+				if c.matches(r.URL.Path) {
+					rule = c
+					break
+				}
+			}
+			if rule == nil && !auto {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(&cacheWriter{ResponseWriter: w, path: r.URL.Path, rule: rule}, r)
+		})
+	}
+}
+
+// cacheableStatus reports whether caching headers apply to a status, the
+// same set nginx adds "expires" to: errors must not be cached for a year.
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusPartialContent,
+		http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusNotModified,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// cacheWriter applies a rule (nil in automatic mode) to the response
+// headers right before they are written.
+type cacheWriter struct {
+	http.ResponseWriter
+	path    string
+	rule    *cacheRule
+	applied bool
+}
+
+func (w *cacheWriter) apply(status int) {
+	if w.applied || status < http.StatusOK {
+		return
+	}
+	w.applied = true
+	if !cacheableStatus(status) {
+		return
+	}
+	h := w.Header()
+	noStore := strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-store")
+	if w.rule == nil {
+		if noStore {
+			return
+		}
+		switch {
+		case isHTML(h.Get("Content-Type"), w.path):
+			h.Set("Cache-Control", htmlCacheControl)
+			h.Del("Expires")
+		case fingerprinted(w.path):
+			h.Set("Cache-Control", immutableCacheControl)
+			h.Del("Expires")
+		}
+		return
+	}
+	for k, v := range w.rule.headers {
+		h.Set(k, v)
+	}
+	if noStore {
+		return
+	}
+	if w.rule.cacheControl != "" {
+		h.Set("Cache-Control", w.rule.cacheControl)
+	}
+	if w.rule.hasExpires {
+		h.Set("Expires", time.Now().Add(w.rule.expires).UTC().Format(http.TimeFormat))
+		if w.rule.cacheControl == "" {
+			h.Set("Cache-Control", "max-age="+strconv.Itoa(max(0, int(w.rule.expires.Seconds()))))
+		}
+	}
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	w.apply(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.apply(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// ReadFrom keeps the sendfile path of static files.
+func (w *cacheWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.apply(http.StatusOK)
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(writerOnly{w.ResponseWriter}, src)
+}
+
+// Flush forwards streaming flushes.
+func (w *cacheWriter) Flush() {
+	w.apply(http.StatusOK)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket and other upgraders take over the connection.
+func (w *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// isHTML reports whether a response is an HTML page, by its Content-Type
+// or, when none is set yet, by the extension of the path.
+func isHTML(contentType, urlPath string) bool {
+	if contentType == "" {
+		ext := strings.ToLower(path.Ext(urlPath))
+		return ext == ".html" || ext == ".htm" || strings.HasSuffix(urlPath, "/")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml")
+}
+
+// fingerprinted reports whether the file name of urlPath carries a content
+// hash, as bundlers emit them: "app.3f9a2c.js", "index-BqX7a1b2.css" or
+// "main.3f9a2c1d.js.map". A hash is a dot- or dash-separated part after the
+// base name, of at least 6 hex characters or at least 8 letters and digits,
+// mixing both; versions ("jquery-3.7.1") and words ("polyfills-es2015") are
+// not hashes. A name made of a hash alone needs 16 hex characters.
+func fingerprinted(urlPath string) bool {
+	name := path.Base(urlPath)
+	ext := path.Ext(name)
+	if ext == "" || ext == name {
+		return false
+	}
+	parts := strings.FieldsFunc(strings.TrimSuffix(name, ext), func(r rune) bool { return r == '.' || r == '-' })
+	if len(parts) == 1 {
+		return len(parts[0]) >= 16 && isHash(parts[0]) && strings.Trim(parts[0], "0123456789abcdefABCDEF") == ""
+	}
+	for _, part := range parts[1:] {
+		if isHash(part) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHash(s string) bool {
+	var digits, letters int
+	hex := true
+	for _, c := range s {
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F':
+			letters++
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_':
+			letters++
+			hex = false
+		default:
+			return false
+		}
+	}
+	if digits == 0 || letters == 0 {
+		return false
+	}
+	return hex && len(s) >= 6 || len(s) >= 8
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestFingerprinted(t *testing.T) {
+	tests := map[string]bool{
+		"/app.3f9a2c.js":                       true,
+		"/assets/index-BqX7a1b2.css":           true,
+		"/main.3f9a2c1d.js.map":                true,
+		"/chunks/123.8e0f4b2a.js":              true,
+		"/media/3f9a2c1d4e5f60718293.png":      true,
+		"/app.js":                              false,
+		"/jquery-3.7.1.min.js":                 false,
+		"/polyfills-es2015.js":                 false,
+		"/bootstrap-5.3.0-alpha1.css":          false,
+		"/report-20240101.pdf":                 false,
+		"/favicon.ico":                         false,
+		"/3f9a2c":                              false,
+		"/fonts/inter-v12-latin-regular.woff2": false,
+	}
+	for p, want := range tests {
+		if got := fingerprinted(p); got != want {
+			t.Errorf("fingerprinted(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
+
+func serveCacheRules(t *testing.T, mw MiddlewareFunc, target string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mw(handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestCacheRulesMiddleware(t *testing.T) {
+	mw := CacheRulesMiddleware([]config.CacheRule{
+		{Path: "/api/*", CacheControl: "no-store"},
+		{Path: "*.woff2", CacheControl: "public, max-age=2592000", Headers: map[string]string{"Access-Control-Allow-Origin": "*"}},
+		{Regex: `^/reports/\d+\.pdf$`, Expires: "1h"},
+	}, true)
+	static := func(contentType string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(status)
+		}
+	}
+
+	tests := []struct {
+		name, target, contentType string
+		status                    int
+		want                      string
+	}{
+		{"nested path", "/api/v1/users", "application/json", 200, "no-store"},
+		{"file name at any depth", "/fonts/a/inter.woff2", "font/woff2", 200, "public, max-age=2592000"},
+		{"expires sets max-age", "/reports/42.pdf", "application/pdf", 200, "max-age=3600"},
+		{"fingerprinted", "/assets/app.3f9a2c.js", "text/javascript", 200, immutableCacheControl},
+		{"fingerprinted revalidation", "/assets/app.3f9a2c.js", "", http.StatusNotModified, immutableCacheControl},
+		{"html", "/docs/", "text/html; charset=utf-8", 200, "no-cache"},
+		{"other files keep the site header", "/app.js", "text/javascript", 200, "public, max-age=60"},
+		{"errors are not cached", "/assets/app.3f9a2c.js", "text/plain", 404, "public, max-age=60"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveCacheRules(t, mw, tt.target, static(tt.contentType, tt.status))
+			if got := rec.Header().Get("Cache-Control"); got != tt.want {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	rec := serveCacheRules(t, mw, "/fonts/inter.woff2", static("font/woff2", 200))
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("the extra headers of the rule were not set")
+	}
+	rec = serveCacheRules(t, mw, "/reports/42.pdf", static("application/pdf", 200))
+	if exp, err := http.ParseTime(rec.Header().Get("Expires")); err != nil || time.Until(exp) < 59*time.Minute {
+		t.Errorf("Expires = %q", rec.Header().Get("Expires"))
+	}
+
+	// A page marked no-store, such as a listing with uploads, keeps it.
+	rec = serveCacheRules(t, mw, "/upload/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "private, no-store")
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>"))
+	})
+	if got := rec.Header().Get("Cache-Control"); got != "private, no-store" {
+		t.Errorf("no-store page: Cache-Control = %q", got)
+	}
+}
+
+func TestCacheRulesMiddlewareProxied(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=0")
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte("body{}"))
+	}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	proxy := httputil.NewSingleHostReverseProxy(u)
+
+	mw := CacheRulesMiddleware([]config.CacheRule{{Path: "/static", CacheControl: "public, max-age=86400"}}, false)
+	rec := serveCacheRules(t, mw, "/static/css/site.css", proxy.ServeHTTP)
+	if got := rec.Header().Values("Cache-Control"); len(got) != 1 || got[0] != "public, max-age=86400" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	if rec.Body.String() != "body{}" {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+	rec = serveCacheRules(t, mw, "/index.css", proxy.ServeHTTP)
+	if got := rec.Header().Get("Cache-Control"); got != "max-age=0" {
+		t.Errorf("unmatched path: Cache-Control = %q", got)
+	}
+}
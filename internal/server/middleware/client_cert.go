@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Default headers carrying client certificate details to upstreams.
+const (
+	defaultClientCertSubjectHeader     = "X-Client-Cert-Subject"
+	defaultClientCertSANHeader         = "X-Client-Cert-SAN"
+	defaultClientCertFingerprintHeader = "X-Client-Cert-Fingerprint"
+)
+
+// ClientCertMiddleware enforces a site's client certificate (mTLS) settings at
+// the HTTP layer and exposes a verified certificate to the rest of the chain:
+// its details are attached to the request context (see certs.ClientCert) and
+// forwarded to upstreams in headers. Unverified certificates, as in "request"
+// mode, are not exposed since anyone can make one with any subject. Inbound
+// copies of the headers are always stripped so clients cannot spoof them.
+//
+// The handshake itself is verified by the per-SNI tls.Config; this middleware
+// rejects requests whose SNI selected another site's TLS settings (421), and,
+// in "require" mode, requests without a verified certificate (403).
+func ClientCertMiddleware(domain string, ca *config.ClientAuthConfig) MiddlewareFunc {
+	mode := ca.NormalizedMode()
+	subjectHeader := headerOr(ca.Headers.Subject, defaultClientCertSubjectHeader)
+	sanHeader := headerOr(ca.Headers.SAN, defaultClientCertSANHeader)
+	fingerprintHeader := headerOr(ca.Headers.Fingerprint, defaultClientCertFingerprintHeader)
+	verifying := mode == config.ClientAuthRequire || mode == config.ClientAuthVerifyIfGiven
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Del(subjectHeader)
+			r.Header.Del(sanHeader)
+			r.Header.Del(fingerprintHeader)
+
+			if r.TLS == nil || mode == config.ClientAuthNone {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if verifying && r.TLS.ServerName != "" && !HostMatchesDomain(domain, r.TLS.ServerName) {
+				http.Error(w, "Misdirected Request", http.StatusMisdirectedRequest)
+				return
+			}
+			verified := len(r.TLS.VerifiedChains) > 0
+			if mode == config.ClientAuthRequire && !verified {
+				http.Error(w, "Client certificate required", http.StatusForbidden)
+				return
+			}
+			if !verified || len(r.TLS.PeerCertificates) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			info := certs.NewClientCertInfo(r.TLS.PeerCertificates[0], true)
+			r.Header.Set(subjectHeader, info.Subject)
+			r.Header.Set(sanHeader, strings.Join(info.SANs, ","))
+			r.Header.Set(fingerprintHeader, info.Fingerprint)
+			next.ServeHTTP(w, r.WithContext(certs.WithClientCert(r.Context(), info)))
+		})
+	}
+}
+
+// HostMatchesDomain reports whether host is served by a site domain, which
+// may be a wildcard ("*.example.com" covers exactly one extra label).
+func HostMatchesDomain(domain, host string) bool {
+	domain = strings.ToLower(domain)
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if domain == host {
+		return true
+	}
+	if rest, ok := strings.CutPrefix(domain, "*"); ok {
+		i := strings.IndexByte(host, '.')
+		return i > 0 && host[i:] == rest
+	}
+	return false
+}
+
+func headerOr(name, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
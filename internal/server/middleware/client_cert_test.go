@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestClientCertMiddleware(t *testing.T) {
+	peer := &x509.Certificate{
+		Raw:          []byte("der"),
+		SerialNumber: big.NewInt(7),
+		Subject:      pkix.Name{CommonName: "alice"},
+		DNSNames:     []string{"alice.example.com"},
+	}
+
+	var got *http.Request
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.WriteHeader(http.StatusOK)
+	})
+	serve := func(ca *config.ClientAuthConfig, state *tls.ConnectionState) (*httptest.ResponseRecorder, *http.Request) {
+		got = nil
+		req := httptest.NewRequest("GET", "https://api.example.com/", nil)
+		req.Header.Set("X-Client-Cert-Subject", "CN=spoofed")
+		req.TLS = state
+		rec := httptest.NewRecorder()
+		ClientCertMiddleware("api.example.com", ca)(next).ServeHTTP(rec, req)
+		return rec, got
+	}
+	verified := &tls.ConnectionState{
+		ServerName:       "api.example.com",
+		PeerCertificates: []*x509.Certificate{peer},
+		VerifiedChains:   [][]*x509.Certificate{{peer}},
+	}
+
+	// Spoofed headers are stripped even when client auth is off.
+	_, r := serve(&config.ClientAuthConfig{Mode: config.ClientAuthNone}, verified)
+	if v := r.Header.Get("X-Client-Cert-Subject"); v != "" {
+		t.Errorf("spoofed header forwarded: %q", v)
+	}
+
+	// Verified certificate details are forwarded in configured headers.
+	ca := &config.ClientAuthConfig{Mode: config.ClientAuthRequire, Headers: config.ClientCertHeaders{Subject: "X-SSL-Subject"}}
+	rec, r := serve(ca, verified)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("verified request: expected 200, got %d", rec.Code)
+	}
+	if v := r.Header.Get("X-SSL-Subject"); v != "CN=alice" {
+		t.Errorf("subject header = %q", v)
+	}
+	if v := r.Header.Get("X-Client-Cert-SAN"); v != "alice.example.com" {
+		t.Errorf("SAN header = %q", v)
+	}
+	if info := certs.ClientCert(r); info == nil || !info.Verified || info.CommonName != "alice" {
+		t.Errorf("context certificate = %+v", info)
+	}
+
+	// A self-signed certificate in request mode carries no identity.
+	selfSigned := &tls.ConnectionState{
+		ServerName:       "api.example.com",
+		PeerCertificates: []*x509.Certificate{{Raw: []byte("self"), SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "admin"}}},
+	}
+	for _, mode := range []string{config.ClientAuthRequest, config.ClientAuthVerifyIfGiven} {
+		rec, r = serve(&config.ClientAuthConfig{Mode: mode}, selfSigned)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unverified certificate: expected 200, got %d", mode, rec.Code)
+		}
+		for _, h := range []string{"X-Client-Cert-Subject", "X-Client-Cert-SAN", "X-Client-Cert-Fingerprint"} {
+			if v := r.Header.Get(h); v != "" {
+				t.Errorf("%s: %s = %q for an unverified certificate", mode, h, v)
+			}
+		}
+		if info := certs.ClientCert(r); info != nil {
+			t.Errorf("%s: context certificate = %+v for an unverified certificate", mode, info)
+		}
+	}
+
+	// Require mode without a verified certificate.
+	rec, _ = serve(ca, &tls.ConnectionState{ServerName: "api.example.com"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("missing certificate: expected 403, got %d", rec.Code)
+	}
+
+	// A handshake negotiated for another site's SNI.
+	rec, _ = serve(ca, &tls.ConnectionState{ServerName: "other.example.com"})
+	if rec.Code != http.StatusMisdirectedRequest {
+		t.Errorf("foreign SNI: expected 421, got %d", rec.Code)
+	}
+}
+
+func TestHostMatchesDomain(t *testing.T) {
+	cases := []struct {
+		domain, host string
+		want         bool
+	}{
+		{"example.com", "EXAMPLE.com.", true},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"example.com", "www.example.com", false},
+	}
+	for _, c := range cases {
+		if got := HostMatchesDomain(c.domain, c.host); got != c.want {
+			t.Errorf("HostMatchesDomain(%q, %q) = %v, want %v", c.domain, c.host, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,456 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Compressible Content Types
+var compressibleTypes = map[string]bool{
+	"text/html":                true,
+	"text/css":                 true,
+	"text/plain":               true,
+	"text/javascript":          true,
+	"application/javascript":   true,
+	"application/x-javascript": true,
+	"application/json":         true,
+	"application/xml":          true,
+	"text/xml":                 true,
+	"image/svg+xml":            true,
+}
+
+// Compression defaults. Brotli and zstd levels favour speed, since bodies
+// are compressed on every request; precompressed sidecars are the place for
+// maximum levels.
+var (
+	defaultEncodings = []string{config.EncodingZstd, config.EncodingBrotli, config.EncodingGzip}
+	defaultLevels    = map[string]int{
+		config.EncodingGzip:   gzip.DefaultCompression,
+		config.EncodingBrotli: 4,
+		config.EncodingZstd:   3,
+	}
+)
+
+// defaultMinLength skips bodies too small to shrink meaningfully once the
+// encoding overhead is paid.
+const defaultMinLength = 512
+
+// compressionPolicy is the resolved compression configuration of a site.
+type compressionPolicy struct {
+	encodings    []string
+	types        map[string]bool
+	typeFamilies []string // "text/" for "text/*"
+	minLength    int
+	levels       map[string]int
+	excludePaths []string
+}
+
+func newCompressionPolicy(conf *config.CompressionConfig) *compressionPolicy {
+	p := &compressionPolicy{
+		encodings: defaultEncodings,
+		types:     compressibleTypes,
+		minLength: defaultMinLength,
+		levels:    defaultLevels,
+	}
+	if conf == nil {
+		return p
+	}
+	if len(conf.Encodings) > 0 {
+		p.encodings = conf.Encodings
+	}
+	if len(conf.Types) > 0 {
+		p.types = make(map[string]bool, len(conf.Types))
+		for _, t := range conf.Types {
+			t = strings.ToLower(t)
+			if family, ok := strings.CutSuffix(t, "/*"); ok {
+				p.typeFamilies = append(p.typeFamilies, family+"/")
+			} else {
+				p.types[t] = true
+			}
+		}
+	}
+	if conf.MinLength > 0 {
+		p.minLength = conf.MinLength
+	}
+	if len(conf.Levels) > 0 {
+		p.levels = make(map[string]int, len(defaultLevels))
+		for enc, level := range defaultLevels {
+			p.levels[enc] = level
+		}
+		for enc, level := range conf.Levels {
+			p.levels[enc] = level
+		}
+	}
+	p.excludePaths = conf.ExcludePaths
+	return p
+}
+
+// compressible reports whether a Content-Type is worth compressing.
+func (p *compressionPolicy) compressible(contentType string) bool {
+	ct, _, _ := strings.Cut(contentType, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	if p.types[ct] {
+		return true
+	}
+	for _, family := range p.typeFamilies {
+		if strings.HasPrefix(ct, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressibleTypes returns the predicate deciding which Content-Types a site
+// compresses, for tools that produce precompressed sidecars ahead of time.
+func CompressibleTypes(conf *config.CompressionConfig) func(contentType string) bool {
+	return newCompressionPolicy(conf).compressible
+}
+
+// excluded reports whether urlPath is never compressed.
+func (p *compressionPolicy) excluded(urlPath string) bool {
+	for _, pattern := range p.excludePaths {
+		if strings.Contains(pattern, "*") {
+			if ok, _ := path.Match(pattern, urlPath); ok {
+				return true
+			}
+		} else if strings.HasPrefix(urlPath, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// GzipMiddleware compresses responses with the default policy. It predates
+// brotli and zstd support and now negotiates all three.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return CompressionMiddleware(nil)(next)
+}
+
+// CompressionMiddleware compresses responses with the best encoding the
+// client accepts (zstd, brotli or gzip, by Accept-Encoding q-values), when
+// the content type is compressible and the body reaches the minimum length.
+// Critical: It skips compression if "Content-Encoding" is already set (e.g. by
+// Smart Static Handler serving .gz).
+func CompressionMiddleware(conf *config.CompressionConfig) func(http.Handler) http.Handler {
+	policy := newCompressionPolicy(conf)
+	return func(next http.Handler) http.Handler {
+		if conf != nil && conf.Disabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := NegotiateEncoding(r.Header.Get("Accept-Encoding"), policy.encodings)
+			if encoding == "" || r.Header.Get("Sec-WebSocket-Key") != "" || policy.excluded(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// A previous compressed response advertised a weak "...-<encoding>"
+			// ETag. Strip that suffix from the inbound If-None-Match so
+			// http.ServeContent can match it against the handler's identity
+			// ETag and return 304 instead of a full re-compressed 200. Without
+			// this, revalidation of on-the-fly compressed content never hits.
+			if inm := r.Header.Get("If-None-Match"); inm != "" {
+				r.Header.Set("If-None-Match", stripEncodingETags(inm))
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				req:            r,
+				policy:         policy,
+				encoding:       encoding,
+			}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// NegotiateEncoding picks the encoding to use among offered (in server
+// preference order) for an Accept-Encoding header: the highest q-value
+// wins, ties go to the server's preference, "*" covers encodings not listed
+// and q=0 refuses one. It returns "" when none is acceptable.
+func NegotiateEncoding(acceptEncoding string, offered []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+		qs[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		q, ok := qs[enc]
+		if !ok {
+			if q, ok = qs["*"]; !ok {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// AcceptsEncoding reports whether an Accept-Encoding header allows enc.
+func AcceptsEncoding(acceptEncoding, enc string) bool {
+	return NegotiateEncoding(acceptEncoding, []string{enc}) != ""
+}
+
+// stripEncodingETags removes the encoding suffixes weakEncodingETag adds.
+func stripEncodingETags(inm string) string {
+	for _, enc := range defaultEncodings {
+		inm = strings.ReplaceAll(inm, "-"+enc+"\"", "\"")
+	}
+	return inm
+}
+
+// weakEncodingETag turns an ETag into a weak validator distinct from the
+// identity representation's tag.
+func weakEncodingETag(et, encoding string) string {
+	trimmed := strings.TrimPrefix(et, "W/")
+	trimmed = strings.Trim(trimmed, "\"")
+	return "W/\"" + trimmed + "-" + encoding + "\""
+}
+
+// compressWriter determines at the last moment whether to compress or not:
+// once the headers are known and, without a Content-Length, once enough of
+// the body is buffered to reach the minimum length.
+type compressWriter struct {
+	http.ResponseWriter
+	req      *http.Request
+	policy   *compressionPolicy
+	encoding string
+
+	encoder        io.WriteCloser
+	release        func()
+	shouldCompress bool
+	checked        bool // eligibility decided from the headers
+	decided        bool // headers written downstream
+	status         int
+	buf            []byte // body held back until minLength is reached
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if w.checked {
+		return // already pending
+	}
+	w.status = status
+	w.checked = true
+	if !w.eligible() {
+		w.commit(false)
+		return
+	}
+	// A known length decides right away; otherwise wait for the body.
+	if cl := w.Header().Get("Content-Length"); cl != "" {
+		n, err := strconv.Atoi(cl)
+		w.commit(err == nil && n >= w.policy.minLength)
+	}
+}
+
+// eligible reports whether the response may be compressed at all.
+func (w *compressWriter) eligible() bool {
+	if w.Header().Get("Content-Encoding") != "" {
+		return false
+	}
+	// Do not compress range responses: http.ServeContent emits 206 with
+	// identity byte offsets, and wrapping that in an encoding produces a
+	// spec-invalid, corrupt response.
+	if w.req != nil && w.req.Header.Get("Range") != "" {
+		return false
+	}
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified ||
+		w.status == http.StatusPartialContent {
+		return false
+	}
+	return w.policy.compressible(w.Header().Get("Content-Type"))
+}
+
+// commit writes the headers downstream, compressing or not, followed by
+// any buffered body.
+func (w *compressWriter) commit(compress bool) {
+	w.decided = true
+	if compress {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", w.encoding)
+		w.Header().Set("Vary", "Accept-Encoding")
+		// The compressed body is a different representation than the
+		// identity one, so it must not share the same strong ETag (that
+		// would let a cache serve compressed bytes as identity or vice
+		// versa). Mark it weak and distinct.
+		if et := w.Header().Get("ETag"); et != "" {
+			w.Header().Set("ETag", weakEncodingETag(et, w.encoding))
+		}
+		w.encoder, w.release = newEncoder(w.encoding, w.policy.levels[w.encoding], w.ResponseWriter)
+		w.shouldCompress = true
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) > 0 {
+		buf := w.buf
+		w.buf = nil
+		w.write(buf)
+	}
+}
+
+func (w *compressWriter) write(b []byte) (int, error) {
+	if w.shouldCompress {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.checked {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		return w.write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.policy.minLength {
+		w.commit(true)
+	}
+	return len(b), nil
+}
+
+func (w *compressWriter) Close() {
+	if w.checked && !w.decided {
+		// The whole body stayed below the minimum length.
+		w.commit(false)
+	}
+	if w.shouldCompress && w.encoder != nil {
+		w.encoder.Close()
+		w.release()
+		w.encoder = nil
+	}
+}
+
+// ReadFrom keeps the underlying ResponseWriter's fast path (sendfile) alive
+// when no compression happens; http.ServeContent uses io.Copy, which probes
+// for io.ReaderFrom on the writer it is handed.
+func (w *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !w.checked {
+		// Headers were not written yet (raw handlers): decide now with the
+		// headers set so far.
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided && !w.shouldCompress {
+		if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(src)
+		}
+	}
+	return io.Copy(writerOnly{w}, src)
+}
+
+// writerOnly hides ReadFrom so io.Copy does not recurse into it.
+type writerOnly struct{ io.Writer }
+
+// Flush forwards streaming flushes (SSE, chunked responses). A body still
+// held back is compressed: a streaming response is assumed to be long.
+func (w *compressWriter) Flush() {
+	if w.checked && !w.decided {
+		w.commit(true)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok && w.shouldCompress {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket and other upgraders take over the connection.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Push forwards HTTP/2 server pushes.
+func (w *compressWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Encoders are pooled per encoding and level.
+var encoderPools sync.Map // "encoding/level" -> *sync.Pool
+
+// newEncoder returns a pooled encoder writing to dst and the function that
+// returns it to the pool once closed.
+func newEncoder(encoding string, level int, dst io.Writer) (io.WriteCloser, func()) {
+	key := encoding + "/" + strconv.Itoa(level)
+	pool, ok := encoderPools.Load(key)
+	if !ok {
+		pool, _ = encoderPools.LoadOrStore(key, &sync.Pool{New: func() any {
+			return makeEncoder(encoding, level)
+		}})
+	}
+	p := pool.(*sync.Pool)
+	switch enc := p.Get().(type) {
+	case *gzip.Writer:
+		enc.Reset(dst)
+		return enc, func() { p.Put(enc) }
+	case *brotli.Writer:
+		enc.Reset(dst)
+		return enc, func() { p.Put(enc) }
+	case *zstd.Encoder:
+		enc.Reset(dst)
+		return enc, func() { p.Put(enc) }
+	}
+	panic("compression: unknown encoder for " + encoding)
+}
+
+func makeEncoder(encoding string, level int) any {
+	switch encoding {
+	case config.EncodingBrotli:
+		return brotli.NewWriterLevel(io.Discard, level)
+	case config.EncodingZstd:
+		enc, _ := zstd.NewWriter(io.Discard,
+			zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(1<<20))
+		return enc
+	default:
+		gz, err := gzip.NewWriterLevel(io.Discard, level)
+		if err != nil {
+			gz = gzip.NewWriter(io.Discard)
+		}
+		return gz
+	}
+}
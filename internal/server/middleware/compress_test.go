@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	offered := []string{"zstd", "br", "gzip"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"zstd;q=0, br;q=0.8, gzip;q=0.8", "br"},
+		{"*", "zstd"},
+		{"*;q=0.1, gzip;q=0.5", "gzip"},
+		{"gzip;q=0", ""},
+		{"identity", ""},
+		{"GZIP ; Q=1", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := NegotiateEncoding(tt.accept, offered); got != tt.want {
+			t.Errorf("NegotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+	if got := NegotiateEncoding("br, gzip", []string{"gzip", "br"}); got != "gzip" {
+		t.Errorf("expected ties to follow the server preference, got %q", got)
+	}
+}
+
+func decodeBody(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gr
+	case "br":
+		r = brotli.NewReader(body)
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		r = body
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", encoding, err)
+	}
+	return string(data)
+}
+
+func TestCompressionMiddlewareEncodings(t *testing.T) {
+	payload := strings.Repeat("compress me please ", 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(payload))
+	})
+
+	for _, enc := range []string{"gzip", "br", "zstd"} {
+		t.Run(enc, func(t *testing.T) {
+			mw := CompressionMiddleware(&config.CompressionConfig{Levels: map[string]int{enc: 5}})(handler)
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", enc)
+			w := httptest.NewRecorder()
+			mw.ServeHTTP(w, req)
+
+			resp := w.Result()
+			if got := resp.Header.Get("Content-Encoding"); got != enc {
+				t.Fatalf("Content-Encoding = %q, want %q", got, enc)
+			}
+			if got := resp.Header.Get("ETag"); got != `W/"abc-`+enc+`"` {
+				t.Errorf("ETag = %q", got)
+			}
+			if got := decodeBody(t, enc, resp.Body); got != payload {
+				t.Errorf("decoded body mismatch (%d bytes)", len(got))
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareRevalidation(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "v1.txt", time.Unix(1700000000, 0), strings.NewReader(strings.Repeat("x", 2000)))
+	})
+	mw := GzipMiddleware(handler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	req.Header.Set("If-None-Match", `W/"v1-br"`)
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want 304 for a brotli ETag", w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("a 304 must not carry a Content-Encoding")
+	}
+}
+
+func TestCompressionMiddlewarePolicy(t *testing.T) {
+	serve := func(conf *config.CompressionConfig, path, contentType string, body string, contentLength bool) *http.Response {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			if contentLength {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			}
+			// Written in pieces, like a streaming handler.
+			for i := 0; i < len(body); i += 100 {
+				w.Write([]byte(body[i:min(i+100, len(body))]))
+			}
+		})
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept-Encoding", "gzip, br, zstd")
+		w := httptest.NewRecorder()
+		CompressionMiddleware(conf)(handler).ServeHTTP(w, req)
+		return w.Result()
+	}
+	large := strings.Repeat("a", 4000)
+	small := strings.Repeat("a", 100)
+
+	tests := []struct {
+		name          string
+		conf          *config.CompressionConfig
+		path          string
+		contentType   string
+		body          string
+		contentLength bool
+		want          string
+	}{
+		{"default prefers zstd", nil, "/", "text/css", large, false, "zstd"},
+		{"small body", nil, "/", "text/css", small, false, ""},
+		{"small body with length", nil, "/", "text/css", small, true, ""},
+		{"large body with length", nil, "/", "text/css", large, true, "zstd"},
+		{"custom min length", &config.CompressionConfig{MinLength: 50}, "/", "text/css", small, false, "zstd"},
+		{"custom encodings", &config.CompressionConfig{Encodings: []string{"gzip"}}, "/", "text/css", large, false, "gzip"},
+		{"type not listed", &config.CompressionConfig{Types: []string{"application/json"}}, "/", "text/css", large, false, ""},
+		{"type family", &config.CompressionConfig{Types: []string{"text/*"}}, "/", "text/x-custom", large, false, "zstd"},
+		{"excluded prefix", &config.CompressionConfig{ExcludePaths: []string{"/events/"}}, "/events/stream", "text/plain", large, false, ""},
+		{"excluded pattern", &config.CompressionConfig{ExcludePaths: []string{"/*.json"}}, "/data.json", "application/json", large, false, ""},
+		{"disabled", &config.CompressionConfig{Disabled: true}, "/", "text/css", large, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := serve(tt.conf, tt.path, tt.contentType, tt.body, tt.contentLength)
+			if got := resp.Header.Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			if got := decodeBody(t, tt.want, resp.Body); got != tt.body {
+				t.Errorf("body mismatch: got %d bytes, want %d", len(got), len(tt.body))
+			}
+			if tt.want == "" && tt.contentLength && resp.Header.Get("Content-Length") == "" {
+				t.Error("expected Content-Length to be kept on an uncompressed response")
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareSkipsRanges(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		http.ServeContent(w, r, "a.txt", time.Unix(1700000000, 0), strings.NewReader(strings.Repeat("r", 4000)))
+	})
+	req := httptest.NewRequest("GET", "/a.txt", nil)
+	req.Header.Set("Accept-Encoding", "zstd, br, gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	w := httptest.NewRecorder()
+	GzipMiddleware(handler).ServeHTTP(w, req)
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" || w.Body.String() != "rrrrrrrrrr" {
+		t.Errorf("got %d %q encoding %q, want an identity 206", w.Code, w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+}
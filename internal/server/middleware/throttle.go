@@ -0,0 +1,265 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// Bounds of the chunks a throttled response is written in: about an
+// eighth of a second of the rate, so pacing stays smooth.
+const (
+	minThrottleChunk = 512
+	maxThrottleChunk = 64 << 10
+)
+
+// clientBucketIdle is how long a client bucket stays full and unused
+// before it is evicted.
+const clientBucketIdle = 10 * time.Minute
+
+// byteBucket is a token bucket of bytes. take reserves bytes and returns
+// how long to wait before sending them; the balance may go negative so
+// concurrent takers queue up fairly.
+type byteBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(rate int64) *byteBucket {
+	burst := float64(throttleChunk(rate))
+	return &byteBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+func (b *byteBucket) take(n int) time.Duration {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// idle reports whether the bucket, refilled up to now, is full and has
+// not been taken from since before cutoff.
+func (b *byteBucket) idle(now, cutoff time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tokens := min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	return b.last.Before(cutoff) && tokens >= b.burst
+}
+
+func throttleChunk(rate int64) int {
+	return int(min(max(rate/8, minThrottleChunk), maxThrottleChunk))
+}
+
+// throttleRule is the per-response limit under a path prefix.
+type throttleRule struct {
+	prefix string
+	rate   int64
+	after  int64
+}
+
+// clientBuckets shares one bucket between the responses to a client IP.
+// Idle buckets are evicted by a background sweeper.
+type clientBuckets struct {
+	mu      sync.Mutex
+	rate    int64
+	buckets map[string]*byteBucket
+}
+
+func newClientBuckets(rate int64) *clientBuckets {
+	c := &clientBuckets{rate: rate, buckets: make(map[string]*byteBucket)}
+	go c.sweep()
+	return c
+}
+
+func (c *clientBuckets) get(ip string) *byteBucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.buckets[ip]
+	if b == nil {
+		b = newByteBucket(c.rate)
+		c.buckets[ip] = b
+	}
+	return b
+}
+
+func (c *clientBuckets) sweep() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		c.evict(now)
+	}
+}
+
+// evict drops the buckets idle at now, which a new bucket would replace
+// with the same full balance.
+func (c *clientBuckets) evict(now time.Time) {
+	cutoff := now.Add(-clientBucketIdle)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ip, b := range c.buckets {
+		if b.idle(now, cutoff) {
+			delete(c.buckets, ip)
+		}
+	}
+}
+
+// ThrottleMiddleware limits the bandwidth of responses after their first
+// limit_rate_after bytes: limit_rate per response, replaced under the
+// prefixes of limit_rate_routes, and limit_rate_per_ip for all the
+// responses to one client, keyed on RemoteAddr like RateLimitMiddleware.
+// Placed outside compression, it paces the bytes actually sent. Responses
+// without a limit are not wrapped, so sendfile keeps working for them.
+func ThrottleMiddleware(conf config.SiteConfig) MiddlewareFunc {
+	site := throttleRule{prefix: "/", rate: conf.LimitRate, after: conf.LimitRateAfter}
+	var routes []throttleRule
+	for _, route := range conf.LimitRateRoutes {
+		routes = append(routes, throttleRule{prefix: route.Path, rate: route.LimitRate, after: route.LimitRateAfter})
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+	var clients *clientBuckets
+	if conf.LimitRatePerIP > 0 {
+		clients = newClientBuckets(conf.LimitRatePerIP)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rule := site
+			for _, route := range routes {
+				if strings.HasPrefix(r.URL.Path, route.prefix) {
+					rule = route
+					break
+				}
+			}
+			tw := &throttleWriter{ResponseWriter: w, ctx: r.Context(), after: rule.after}
+			if rule.rate > 0 {
+				tw.response = newByteBucket(rule.rate)
+				tw.chunk = throttleChunk(rule.rate)
+			}
+			if clients != nil {
+				ip, _, err := net.SplitHostPort(r.RemoteAddr)
+				if err != nil {
+					ip = r.RemoteAddr
+				}
+				tw.client = clients.get(ip)
+				if c := throttleChunk(clients.rate); tw.chunk == 0 || c < tw.chunk {
+					tw.chunk = c
+				}
+			}
+			if tw.response == nil && tw.client == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(tw, r)
+		})
+	}
+}
+
+// throttleWriter paces the body written through it. The first after bytes
+// count against neither bucket.
+type throttleWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	response *byteBucket // nil when only the client is limited
+	client   *byteBucket // nil without limit_rate_per_ip
+	after    int64       // bytes still allowed at full speed
+	chunk    int
+}
+
+func (w *throttleWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), w.chunk)
+		if err := w.wait(n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// wait sleeps until n bytes may be sent, or the request is canceled.
+func (w *throttleWriter) wait(n int) error {
+	free := int(min(int64(n), w.after))
+	w.after -= int64(free)
+	n -= free
+	if n == 0 {
+		return nil
+	}
+	var d time.Duration
+	if w.response != nil {
+		d = w.response.take(n)
+	}
+	if w.client != nil {
+		d = max(d, w.client.take(n))
+	}
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// ReadFrom sends the bytes below limit_rate_after through the underlying
+// ReadFrom (sendfile for static files), then paces the rest.
+func (w *throttleWriter) ReadFrom(src io.Reader) (int64, error) {
+	var sent int64
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok && w.after > 0 {
+		n, err := rf.ReadFrom(io.LimitReader(src, w.after))
+		sent, w.after = n, w.after-n
+		if err != nil || w.after > 0 {
+			// The body ended (or failed) within the free bytes.
+			return sent, err
+		}
+	}
+	n, err := io.Copy(writerOnly{w}, src)
+	return sent + n, err
+}
+
+// Flush forwards streaming flushes.
+func (w *throttleWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket and other upgraders take over the connection,
+// unthrottled.
+func (w *throttleWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (w *throttleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
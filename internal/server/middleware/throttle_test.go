@@ -0,0 +1,200 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// readFromRecorder counts the bytes that reach it through ReadFrom.
+type readFromRecorder struct {
+	*httptest.ResponseRecorder
+	readFrom int64
+}
+
+func (r *readFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	n, err := io.Copy(r.ResponseRecorder, src)
+	r.readFrom += n
+	return n, err
+}
+
+func timeThrottled(t *testing.T, conf config.SiteConfig, target string, size int) (time.Duration, *readFromRecorder) {
+	t.Helper()
+	body := bytes.Repeat([]byte("x"), size)
+	h := ThrottleMiddleware(conf)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hide WriterTo so io.Copy goes through ReadFrom, as with a file.
+		io.Copy(w, struct{ io.Reader }{bytes.NewReader(body)})
+	}))
+	rec := &readFromRecorder{ResponseRecorder: httptest.NewRecorder()}
+	start := time.Now()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	elapsed := time.Since(start)
+	if rec.Body.Len() != size {
+		t.Fatalf("%s: got %d bytes, want %d", target, rec.Body.Len(), size)
+	}
+	return elapsed, rec
+}
+
+func TestThrottleMiddleware(t *testing.T) {
+	conf := config.SiteConfig{
+		LimitRate:      100_000,
+		LimitRateAfter: 20_000,
+		LimitRateRoutes: []config.LimitRateRoute{
+			{Path: "/api/", LimitRate: 0},
+			{Path: "/slow/", LimitRate: 50_000},
+		},
+	}
+
+	// 40 kB over the free 20 kB, minus the 12.5 kB burst: about 0.3s.
+	elapsed, rec := timeThrottled(t, conf, "/files/big.iso", 60_000)
+	if elapsed < 250*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("limit_rate: took %v", elapsed)
+	}
+	if rec.readFrom != 20_000 {
+		t.Errorf("limit_rate_after: %d bytes through ReadFrom, want 20000", rec.readFrom)
+	}
+
+	if elapsed, rec := timeThrottled(t, conf, "/api/export", 60_000); elapsed > 100*time.Millisecond || rec.readFrom != 60_000 {
+		t.Errorf("unlimited route: took %v, %d bytes through ReadFrom", elapsed, rec.readFrom)
+	}
+	if elapsed, _ := timeThrottled(t, conf, "/slow/file", 25_000); elapsed < 250*time.Millisecond {
+		t.Errorf("route limit: took %v", elapsed)
+	}
+	if elapsed, _ := timeThrottled(t, conf, "/files/small.txt", 15_000); elapsed > 100*time.Millisecond {
+		t.Errorf("a body within limit_rate_after took %v", elapsed)
+	}
+}
+
+func TestThrottleMiddlewarePassThrough(t *testing.T) {
+	rec := httptest.NewRecorder()
+	var got http.ResponseWriter
+	ThrottleMiddleware(config.SiteConfig{LimitRateRoutes: []config.LimitRateRoute{{Path: "/slow/", LimitRate: 1000}}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = w }),
+	).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if got != rec {
+		t.Error("an unlimited response is wrapped")
+	}
+}
+
+func TestThrottleMiddlewarePerIP(t *testing.T) {
+	h := ThrottleMiddleware(config.SiteConfig{LimitRatePerIP: 100_000})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte("x"), 25_000))
+	}))
+
+	// Two responses to one client share its 100 kB/s: 50 kB minus the
+	// burst take about 0.375s.
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("per-IP limit: took %v", elapsed)
+	}
+
+	// Another client has its own bucket.
+	start = time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("another client: took %v", elapsed)
+	}
+}
+
+func TestThrottleMiddlewarePerIPAfter(t *testing.T) {
+	conf := config.SiteConfig{
+		LimitRatePerIP: 100_000,
+		LimitRateAfter: 50_000,
+		LimitRateRoutes: []config.LimitRateRoute{
+			{Path: "/api/", LimitRate: 0, LimitRateAfter: 20_000},
+		},
+	}
+
+	// The free bytes skip the client bucket too, through ReadFrom.
+	elapsed, rec := timeThrottled(t, conf, "/files/small.bin", 50_000)
+	if elapsed > 100*time.Millisecond || rec.readFrom != 50_000 {
+		t.Errorf("within limit_rate_after: took %v, %d bytes through ReadFrom", elapsed, rec.readFrom)
+	}
+
+	// 40 kB over the route's free 20 kB, minus the 12.5 kB burst: about
+	// 0.275s.
+	elapsed, rec = timeThrottled(t, conf, "/api/export", 60_000)
+	if elapsed < 200*time.Millisecond || rec.readFrom != 20_000 {
+		t.Errorf("unlimited route: took %v, %d bytes through ReadFrom", elapsed, rec.readFrom)
+	}
+}
+
+func TestThrottleMiddlewareCanceled(t *testing.T) {
+	var werr error
+	h := ThrottleMiddleware(config.SiteConfig{LimitRate: 1000})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, werr = w.Write([]byte(strings.Repeat("x", 100_000)))
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if werr == nil || time.Since(start) > time.Second {
+		t.Errorf("a canceled request kept writing: %v after %v", werr, time.Since(start))
+	}
+}
+
+func TestThrottleMiddlewareWithCompression(t *testing.T) {
+	mw := NewMiddlewareManager()
+	mw.Use(ThrottleMiddleware(config.SiteConfig{LimitRate: 100_000}))
+	mw.Use(CompressionMiddleware(nil))
+	h := mw.Apply(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("compressible ", 20_000)))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(rec, req)
+	// 260 kB of text compress to well under the burst: the compressed
+	// bytes are paced, not the original ones.
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("took %v", elapsed)
+	}
+	if got := decodeBody(t, rec.Header().Get("Content-Encoding"), rec.Body); got != strings.Repeat("compressible ", 20_000) {
+		t.Errorf("body of %d bytes", len(got))
+	}
+}
+
+func TestClientBucketsEvict(t *testing.T) {
+	c := newClientBuckets(1000)
+	c.get("192.0.2.1").take(5000)      // drained, refilled within seconds
+	c.get("192.0.2.2").take(1_000_000) // in debt for about 17 minutes
+	c.get("192.0.2.3")
+
+	c.evict(time.Now())
+	if len(c.buckets) != 3 {
+		t.Errorf("%d buckets after evicting recent ones, want 3", len(c.buckets))
+	}
+	c.evict(time.Now().Add(clientBucketIdle + time.Minute))
+	if _, ok := c.buckets["192.0.2.1"]; ok {
+		t.Error("a drained bucket that has refilled was kept")
+	}
+	if _, ok := c.buckets["192.0.2.3"]; ok {
+		t.Error("an unused bucket was kept")
+	}
+	if _, ok := c.buckets["192.0.2.2"]; !ok {
+		t.Error("a bucket still in debt was evicted")
+	}
+}
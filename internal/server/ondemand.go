@@ -0,0 +1,269 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	defaultOnDemandRateLimit   = 10
+	defaultOnDemandRateWindow  = time.Hour
+	defaultOnDemandNegativeTTL = 10 * time.Minute
+	// onDemandApprovalTTL is how long an approved host is trusted before the
+	// ask check runs again. autocert consults the host policy on every
+	// handshake, so approvals must be cached.
+	onDemandApprovalTTL = time.Hour
+	// onDemandAskTimeout bounds a single ask request.
+	onDemandAskTimeout = 5 * time.Second
+	// onDemandCacheMax bounds the approval and rejection caches, so random
+	// SNIs cannot grow them without limit.
+	onDemandCacheMax = 10000
+)
+
+var errOnDemandRateLimited = errors.New("on-demand TLS: issuance rate limit reached")
+
+// onDemandPolicy decides whether a certificate may be obtained for a host
+// that no site lists. A host must be approved by the ask endpoint or the
+// allow-list file; rejections are cached for a while, and new issuances are
+// rate limited so arbitrary SNIs cannot exhaust the CA's quotas.
+type onDemandPolicy struct {
+	ask       string
+	allowList *allowListFile
+	client    *http.Client
+	cache     autocert.Cache
+	lg        *logger.Logger
+	// onApprove is called the first time a host is approved.
+	onApprove func(host string)
+
+	limit       int
+	window      time.Duration
+	negativeTTL time.Duration
+
+	mu       sync.Mutex
+	approved map[string]time.Time // host -> approval expiry
+	rejected map[string]time.Time // host -> rejection expiry
+	issued   []time.Time          // issuance times inside the window
+}
+
+func newOnDemandPolicy(od *config.OnDemandConfig, cache autocert.Cache, lg *logger.Logger) *onDemandPolicy {
+	p := &onDemandPolicy{
+		ask:         od.Ask,
+		client:      &http.Client{Timeout: onDemandAskTimeout},
+		cache:       cache,
+		lg:          lg,
+		limit:       od.RateLimit,
+		window:      defaultOnDemandRateWindow,
+		negativeTTL: defaultOnDemandNegativeTTL,
+		approved:    make(map[string]time.Time),
+		rejected:    make(map[string]time.Time),
+	}
+	if p.limit == 0 {
+		p.limit = defaultOnDemandRateLimit
+	}
+	if d, err := time.ParseDuration(od.RateWindow); err == nil && d > 0 {
+		p.window = d
+	}
+	if d, err := time.ParseDuration(od.NegativeTTL); err == nil && d > 0 {
+		p.negativeTTL = d
+	}
+	if od.AllowList != "" {
+		p.allowList = &allowListFile{path: od.AllowList}
+	}
+	return p
+}
+
+// isApproved reports whether host is currently approved.
+func (p *onDemandPolicy) isApproved(host string) bool {
+	host = normalizeHost(host)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(p.approved[host])
+}
+
+// allow is an autocert.HostPolicy.
+func (p *onDemandPolicy) allow(ctx context.Context, host string) error {
+	host = normalizeHost(host)
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return fmt.Errorf("on-demand TLS: %q is not a domain name", host)
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	if now.Before(p.approved[host]) {
+		p.mu.Unlock()
+		return nil
+	}
+	if now.Before(p.rejected[host]) {
+		p.mu.Unlock()
+		return fmt.Errorf("on-demand TLS: %s was recently rejected", host)
+	}
+	p.mu.Unlock()
+
+	ok, err := p.check(ctx, host)
+	if err != nil {
+		// The ask endpoint being down is not a verdict on the host.
+		p.lg.Errorf("On-demand TLS check for %s failed: %v", host, err)
+		return err
+	}
+	if !ok {
+		p.mu.Lock()
+		p.rejected[host] = now.Add(p.negativeTTL)
+		prune(p.rejected, now)
+		p.mu.Unlock()
+		return fmt.Errorf("on-demand TLS: %s is not allowed", host)
+	}
+
+	// A certificate already in the cache costs no issuance.
+	_, cacheErr := p.cache.Get(ctx, host)
+	cached := cacheErr == nil
+
+	p.mu.Lock()
+	if !cached {
+		cutoff := now.Add(-p.window)
+		kept := p.issued[:0]
+		for _, t := range p.issued {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		p.issued = kept
+		if len(p.issued) >= p.limit {
+			p.mu.Unlock()
+			p.lg.Warnf("On-demand TLS: not issuing a certificate for %s, %d issuances in the last %s", host, p.limit, p.window)
+			return errOnDemandRateLimited
+		}
+		p.issued = append(p.issued, now)
+	}
+	_, known := p.approved[host]
+	p.approved[host] = now.Add(onDemandApprovalTTL)
+	prune(p.approved, now)
+	p.mu.Unlock()
+
+	if !known {
+		p.lg.Infof("On-demand TLS: approved %s", host)
+		if p.onApprove != nil {
+			p.onApprove(host)
+		}
+	}
+	return nil
+}
+
+// check asks the allow-list file, then the ask endpoint.
+func (p *onDemandPolicy) check(ctx context.Context, host string) (bool, error) {
+	if p.allowList != nil {
+		ok, err := p.allowList.allows(host)
+		if err != nil {
+			p.lg.Errorf("On-demand TLS allow list: %v", err)
+		}
+		if ok || p.ask == "" {
+			return ok, nil
+		}
+	}
+	u, err := url.Parse(p.ask)
+	if err != nil {
+		return false, err
+	}
+	q := u.Query()
+	q.Set("domain", host)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// prune drops expired entries once a cache reaches onDemandCacheMax, and
+// empties it if they are all still live.
+func prune(cache map[string]time.Time, now time.Time) {
+	if len(cache) < onDemandCacheMax {
+		return
+	}
+	for host, expiry := range cache {
+		if !now.Before(expiry) {
+			delete(cache, host)
+		}
+	}
+	if len(cache) >= onDemandCacheMax {
+		clear(cache)
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// allowListFile is a list of permitted domains, reloaded when the file
+// changes.
+type allowListFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	domains []string
+}
+
+func (a *allowListFile) allows(host string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.reload()
+	for _, d := range a.domains {
+		if middleware.HostMatchesDomain(d, host) {
+			return true, err
+		}
+	}
+	return false, err
+}
+
+// reload re-reads the file when its size or modification time changed. On
+// error the previous list stays in force.
+func (a *allowListFile) reload() error {
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(a.modTime) && info.Size() == a.size && a.domains != nil {
+		return nil
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	domains := []string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			domains = append(domains, normalizeHost(line))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	a.domains, a.modTime, a.size = domains, info.ModTime(), info.Size()
+	return nil
+}
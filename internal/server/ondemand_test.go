@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func newTestOnDemandPolicy(t *testing.T, od *config.OnDemandConfig) *onDemandPolicy {
+	t.Helper()
+	lg, err := logger.NewLogger("test_on_demand", nil)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	return newOnDemandPolicy(od, autocert.DirCache(t.TempDir()), lg)
+}
+
+func TestOnDemandAsk(t *testing.T) {
+	var asks atomic.Int32
+	ask := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asks.Add(1)
+		if r.URL.Query().Get("domain") != "shop.customer.com" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ask.Close()
+
+	p := newTestOnDemandPolicy(t, &config.OnDemandConfig{Ask: ask.URL})
+	var approved []string
+	p.onApprove = func(host string) { approved = append(approved, host) }
+	ctx := context.Background()
+
+	if err := p.allow(ctx, "Shop.Customer.com."); err != nil {
+		t.Fatalf("expected approval, got %v", err)
+	}
+	if err := p.allow(ctx, "shop.customer.com"); err != nil {
+		t.Fatalf("expected cached approval, got %v", err)
+	}
+	if !p.isApproved("shop.customer.com") {
+		t.Error("expected shop.customer.com to be approved")
+	}
+	if len(approved) != 1 || approved[0] != "shop.customer.com" {
+		t.Errorf("expected one onApprove call, got %v", approved)
+	}
+
+	// Rejections are cached, so the endpoint is not asked again.
+	for range 3 {
+		if err := p.allow(ctx, "random.example.net"); err == nil {
+			t.Fatal("expected random.example.net to be rejected")
+		}
+	}
+	if n := asks.Load(); n != 2 {
+		t.Errorf("expected 2 ask requests, got %d", n)
+	}
+
+	for _, host := range []string{"192.0.2.1", "localhost"} {
+		if err := p.allow(ctx, host); err == nil {
+			t.Errorf("expected %q to be rejected", host)
+		}
+	}
+	if n := asks.Load(); n != 2 {
+		t.Errorf("expected IPs and bare names not to be asked, got %d requests", n)
+	}
+}
+
+func TestOnDemandAskUnavailable(t *testing.T) {
+	ask := httptest.NewServer(http.NotFoundHandler())
+	url := ask.URL
+	ask.Close()
+
+	p := newTestOnDemandPolicy(t, &config.OnDemandConfig{Ask: url})
+	if err := p.allow(context.Background(), "shop.customer.com"); err == nil {
+		t.Fatal("expected an error with the ask endpoint down")
+	}
+	// A failed check is not cached as a rejection.
+	p.mu.Lock()
+	_, rejected := p.rejected["shop.customer.com"]
+	p.mu.Unlock()
+	if rejected {
+		t.Error("expected an unreachable ask endpoint not to be cached as a rejection")
+	}
+}
+
+func TestOnDemandRateLimit(t *testing.T) {
+	ask := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ask.Close()
+
+	p := newTestOnDemandPolicy(t, &config.OnDemandConfig{Ask: ask.URL, RateLimit: 2})
+	ctx := context.Background()
+	for _, host := range []string{"a.customer.com", "b.customer.com"} {
+		if err := p.allow(ctx, host); err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+	}
+	if err := p.allow(ctx, "c.customer.com"); !errors.Is(err, errOnDemandRateLimited) {
+		t.Fatalf("expected the rate limit, got %v", err)
+	}
+
+	// A host whose certificate is already cached needs no issuance.
+	if err := p.cache.Put(ctx, "d.customer.com", []byte("cached")); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.allow(ctx, "d.customer.com"); err != nil {
+		t.Fatalf("expected a cached host to pass the rate limit, got %v", err)
+	}
+
+	// Issuances outside the window no longer count.
+	p.mu.Lock()
+	for i := range p.issued {
+		p.issued[i] = p.issued[i].Add(-2 * defaultOnDemandRateWindow)
+	}
+	p.mu.Unlock()
+	if err := p.allow(ctx, "c.customer.com"); err != nil {
+		t.Fatalf("expected c.customer.com after the window, got %v", err)
+	}
+}
+
+func TestOnDemandAllowList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "domains.txt")
+	if err := os.WriteFile(file, []byte("# customers\nshop.customer.com\n*.tenant.io\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestOnDemandPolicy(t, &config.OnDemandConfig{AllowList: file, NegativeTTL: "1ns"})
+	ctx := context.Background()
+
+	for _, host := range []string{"shop.customer.com", "a.tenant.io"} {
+		if err := p.allow(ctx, host); err != nil {
+			t.Errorf("expected %s to be allowed, got %v", host, err)
+		}
+	}
+	if err := p.allow(ctx, "other.customer.com"); err == nil {
+		t.Error("expected other.customer.com to be rejected")
+	}
+
+	// The file is reloaded when it changes.
+	if err := os.WriteFile(file, []byte("shop.customer.com\nother.customer.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(file, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.allow(ctx, "other.customer.com"); err != nil {
+		t.Errorf("expected other.customer.com after the reload, got %v", err)
+	}
+}
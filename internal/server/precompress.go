@@ -0,0 +1,404 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"mime"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
+)
+
+// sidecarExts maps an encoding to the suffix of its precompressed sidecar,
+// as looked up by ServeStatic.
+var sidecarExts = map[string]string{
+	config.EncodingBrotli: ".br",
+	config.EncodingGzip:   ".gz",
+	config.EncodingZstd:   ".zst",
+}
+
+// precompressManifest lists, at the root, the sidecars Precompress wrote
+// and the size and mtime of the file each was made from. Only those
+// sidecars are ever replaced as stale or removed: a sitemap.xml.gz
+// published as is stays untouched. As a dotfile it is never served.
+const precompressManifest = ".goup-precompress.json"
+
+// sidecarSource is the size and mtime of the file a sidecar was made from.
+type sidecarSource struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"` // Unix nanoseconds
+}
+
+func newSidecarSource(info fs.FileInfo) sidecarSource {
+	return sidecarSource{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+}
+
+// DefaultPrecompressEncodings are the sidecars Precompress writes unless
+// told otherwise.
+var DefaultPrecompressEncodings = []string{config.EncodingBrotli, config.EncodingGzip}
+
+// PrecompressOptions configures Precompress. Zero values select the defaults.
+type PrecompressOptions struct {
+	// Encodings are the sidecars to write, among br, gzip and zstd.
+	Encodings []string
+	// MinSize skips files smaller than this many bytes.
+	MinSize int64
+	// Compressible decides which Content-Types get sidecars; it defaults to
+	// the types compressed on the fly.
+	Compressible func(contentType string) bool
+}
+
+func (o *PrecompressOptions) withDefaults() PrecompressOptions {
+	opts := *o
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = DefaultPrecompressEncodings
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 512
+	}
+	if opts.Compressible == nil {
+		opts.Compressible = middleware.CompressibleTypes(nil)
+	}
+	return opts
+}
+
+// PrecompressResult reports what a Precompress run did. Paths are relative
+// to the root.
+type PrecompressResult struct {
+	Written  []string // sidecars (re)generated
+	Removed  []string // orphan or stale sidecars, or ones no smaller than their file
+	UpToDate int      // sidecars still matching their file
+	// Unmanaged are sidecars Precompress did not write, older than their
+	// file. Like all those it did not write, they are left alone.
+	Unmanaged []string
+}
+
+// Precompress writes brotli, gzip and optionally zstd sidecars, at maximum
+// levels, next to the compressible files under root that ServeStatic
+// serves. Sidecars made from a file of the same size and mtime are kept,
+// sidecars whose file is gone are removed, and a sidecar that would not be
+// smaller than its file is not written at all. Only sidecars recorded in the
+// manifest of an earlier run are ever replaced or removed. Each sidecar carries the
+// mtime of its file, so Last-Modified does not depend on the representation
+// served.
+func Precompress(root string, opts PrecompressOptions) (PrecompressResult, error) {
+	opts = opts.withDefaults()
+	var res PrecompressResult
+
+	type job struct {
+		src, dst, encoding string
+		info               fs.FileInfo
+	}
+	var jobs []job
+	var stale []string
+	manifest := readPrecompressManifest(root)
+	rel := func(p string) string {
+		if r, err := filepath.Rel(root, p); err == nil {
+			return filepath.ToSlash(r)
+		}
+		return p
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && isHiddenName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if isSidecarName(d.Name()) {
+			return nil
+		}
+		if !precompressible(p, opts.Compressible) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		for enc, ext := range sidecarExts {
+			dst := p + ext
+			si, err := os.Stat(dst)
+			// Exact matches: a file replaced by an older one (rsync -t, tar,
+			// git checkout) must not keep the sidecar of the newer one.
+			src, managed := manifest[rel(dst)]
+			fresh := err == nil && managed && src == newSidecarSource(info) && si.ModTime().Equal(info.ModTime())
+			if info.Size() < opts.MinSize || !slices.Contains(opts.Encodings, enc) {
+				// Not regenerated by this run: drop it if it no longer
+				// matches its file rather than serve stale content.
+				if err == nil && managed && !fresh {
+					stale = append(stale, dst)
+				}
+				continue
+			}
+			if err == nil && !managed {
+				if si.ModTime().Before(info.ModTime()) {
+					res.Unmanaged = append(res.Unmanaged, rel(dst))
+				} else {
+					res.UpToDate++
+				}
+				continue
+			}
+			if fresh {
+				res.UpToDate++
+				continue
+			}
+			jobs = append(jobs, job{src: p, dst: dst, encoding: enc, info: info})
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	// Orphans: sidecars written by an earlier run whose file no longer
+	// exists. Entries whose sidecar is gone are forgotten.
+	for name := range manifest {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if _, err := os.Lstat(p); err != nil {
+			delete(manifest, name)
+			continue
+		}
+		if info, err := os.Stat(strings.TrimSuffix(p, filepath.Ext(p))); err != nil || !info.Mode().IsRegular() {
+			stale = append(stale, p)
+		}
+	}
+	for _, p := range stale {
+		if err := os.Remove(p); err != nil {
+			return res, err
+		}
+		delete(manifest, rel(p))
+		res.Removed = append(res.Removed, rel(p))
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+		ch   = make(chan job)
+	)
+	for range min(runtime.GOMAXPROCS(0), max(len(jobs), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range ch {
+				written, err := writeSidecar(j.src, j.dst, j.encoding, j.info)
+				mu.Lock()
+				switch _, managed := manifest[rel(j.dst)]; {
+				case err != nil:
+					errs = append(errs, fmt.Errorf("%s: %w", rel(j.dst), err))
+				case written:
+					res.Written = append(res.Written, rel(j.dst))
+					manifest[rel(j.dst)] = newSidecarSource(j.info)
+				case managed:
+					if err := os.Remove(j.dst); err == nil {
+						res.Removed = append(res.Removed, rel(j.dst))
+						delete(manifest, rel(j.dst))
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, j := range jobs {
+		ch <- j
+	}
+	close(ch)
+	wg.Wait()
+	if err := writePrecompressManifest(root, manifest); err != nil {
+		errs = append(errs, err)
+	}
+
+	sort.Strings(res.Written)
+	sort.Strings(res.Removed)
+	sort.Strings(res.Unmanaged)
+	return res, errors.Join(errs...)
+}
+
+// readPrecompressManifest returns the sidecars recorded at root, by path
+// relative to it; a missing or unreadable manifest records none.
+func readPrecompressManifest(root string) map[string]sidecarSource {
+	manifest := make(map[string]sidecarSource)
+	if data, err := os.ReadFile(filepath.Join(root, precompressManifest)); err == nil {
+		json.Unmarshal(data, &manifest)
+	}
+	return manifest
+}
+
+// writePrecompressManifest replaces the manifest at root, or removes it
+// when no sidecar is left.
+func writePrecompressManifest(root string, manifest map[string]sidecarSource) error {
+	p := filepath.Join(root, precompressManifest)
+	if len(manifest) == 0 {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(root, precompressManifest+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// WatchPrecompress runs Precompress on root, then again every time a file
+// under it changes, polling every interval until ctx is done. report
+// receives the result of every run.
+func WatchPrecompress(ctx context.Context, root string, opts PrecompressOptions, interval time.Duration, report func(PrecompressResult, error)) {
+	report(Precompress(root, opts))
+	last := snapshotTree(root)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if maps.Equal(last, snapshotTree(root)) {
+			continue
+		}
+		report(Precompress(root, opts))
+		// Taken after the run, so the sidecars just written don't trigger
+		// another one.
+		last = snapshotTree(root)
+	}
+}
+
+type fileStamp struct {
+	size    int64
+	modTime int64
+}
+
+// snapshotTree records the size and mtime of every file served under root.
+func snapshotTree(root string) map[string]fileStamp {
+	snap := make(map[string]fileStamp)
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if p != root && isHiddenName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			snap[p] = fileStamp{size: info.Size(), modTime: info.ModTime().UnixNano()}
+		}
+		return nil
+	})
+	return snap
+}
+
+func isSidecarName(name string) bool {
+	ext := filepath.Ext(name)
+	for _, e := range sidecarExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// precompressible reports whether the type of a file, from its extension,
+// is worth a sidecar.
+func precompressible(name string, compressible func(string) bool) bool {
+	ct := mime.TypeByExtension(filepath.Ext(name))
+	return ct != "" && compressible(ct)
+}
+
+// writeSidecar compresses src into dst through a temporary file, so the
+// server never sees a partial sidecar. It reports false, writing nothing,
+// when the result would not be smaller than src.
+func writeSidecar(src, dst, encoding string, info fs.FileInfo) (bool, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	// A dotfile, so a crash never leaves a servable leftover.
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	enc, err := sidecarEncoder(encoding, tmp)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(enc, in); err != nil {
+		return false, err
+	}
+	if err := enc.Close(); err != nil {
+		return false, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if size >= info.Size() {
+		return false, nil
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime()); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), dst)
+}
+
+func sidecarEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case config.EncodingBrotli:
+		return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+	case config.EncodingGzip:
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case config.EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestPrecompress(t *testing.T) {
+	root := t.TempDir()
+	css := strings.Repeat("body { color: red; }\n", 100)
+	writeTestFiles(t, root, map[string]string{
+		"style.css":         css,
+		"tiny.js":           "x()",
+		"photo.png":         strings.Repeat("p", 4096),
+		"gone.html":         strings.Repeat("<p>gone</p>\n", 100),
+		"sitemap.xml.gz":    "published as is",
+		"backup.tar.gz":     "not a sidecar",
+		".hidden/app.css":   css,
+		"nested/index.html": strings.Repeat("<p>hello</p>\n", 100),
+	})
+
+	opts := PrecompressOptions{Encodings: []string{config.EncodingBrotli, config.EncodingGzip, config.EncodingZstd}}
+	res, err := Precompress(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWritten := []string{
+		"gone.html.br", "gone.html.gz", "gone.html.zst",
+		"nested/index.html.br", "nested/index.html.gz", "nested/index.html.zst",
+		"style.css.br", "style.css.gz", "style.css.zst",
+	}
+	if !slices.Equal(res.Written, wantWritten) {
+		t.Errorf("written = %v, want %v", res.Written, wantWritten)
+	}
+
+	// Only the sidecars written by a run are orphans once their file goes.
+	os.Remove(filepath.Join(root, "gone.html"))
+	res, err = Precompress(root, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"gone.html.br", "gone.html.gz", "gone.html.zst"}; !slices.Equal(res.Removed, want) {
+		t.Errorf("removed = %v, want %v", res.Removed, want)
+	}
+	for _, name := range []string{"sitemap.xml.gz", "backup.tar.gz"} {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("%s was not written by precompress and must stay", name)
+		}
+	}
+
+	gz, _ := os.Open(filepath.Join(root, "style.css.gz"))
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != css {
+		t.Error("gzip sidecar does not decode to the file")
+	}
+	src, _ := os.Stat(filepath.Join(root, "style.css"))
+	side, _ := os.Stat(filepath.Join(root, "style.css.br"))
+	if !side.ModTime().Equal(src.ModTime()) {
+		t.Errorf("sidecar mtime %v, want the file's %v", side.ModTime(), src.ModTime())
+	}
+
+	// A second run has nothing to do.
+	res, err = Precompress(root, opts)
+	if err != nil || len(res.Written) != 0 || res.UpToDate != 6 {
+		t.Errorf("second run: %+v, %v", res, err)
+	}
+
+	// A file replaced by an older one still gets new sidecars.
+	earlier := src.ModTime().Add(-time.Hour)
+	os.WriteFile(filepath.Join(root, "style.css"), []byte(strings.ToUpper(css)), 0644)
+	os.Chtimes(filepath.Join(root, "style.css"), earlier, earlier)
+	res, err = Precompress(root, opts)
+	if err != nil || !slices.Equal(res.Written, []string{"style.css.br", "style.css.gz", "style.css.zst"}) {
+		t.Errorf("older replacement: %+v, %v", res, err)
+	}
+
+	// An updated file gets new sidecars; one that shrank below the threshold
+	// loses its stale ones.
+	later := time.Now().Add(time.Minute)
+	os.WriteFile(filepath.Join(root, "style.css"), []byte(css+css), 0644)
+	os.Chtimes(filepath.Join(root, "style.css"), later, later)
+	os.WriteFile(filepath.Join(root, "nested/index.html"), []byte("<p>hi</p>"), 0644)
+	os.Chtimes(filepath.Join(root, "nested/index.html"), later, later)
+	res, err = Precompress(root, PrecompressOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Written, []string{"style.css.br", "style.css.gz"}) {
+		t.Errorf("written = %v", res.Written)
+	}
+	wantRemoved := []string{"nested/index.html.br", "nested/index.html.gz", "nested/index.html.zst", "style.css.zst"}
+	if !slices.Equal(res.Removed, wantRemoved) {
+		t.Errorf("removed = %v, want %v", res.Removed, wantRemoved)
+	}
+
+	// The sidecars are what ServeStatic serves.
+	resetStatCache()
+	req := httptest.NewRequest(http.MethodGet, "/style.css", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	ServeStatic(w, req, root)
+	if w.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("Content-Encoding = %q", w.Header().Get("Content-Encoding"))
+	}
+	if data, _ := io.ReadAll(brotli.NewReader(w.Body)); string(data) != css+css {
+		t.Error("brotli sidecar does not decode to the updated file")
+	}
+}
+
+func TestPrecompressLeavesUnmanagedSidecars(t *testing.T) {
+	root := t.TempDir()
+	js := strings.Repeat("console.log('hi');\n", 100)
+	now := time.Now().Truncate(time.Second)
+	for name, mtime := range map[string]time.Time{
+		"app.js":    now.Add(-time.Hour),
+		"app.js.gz": now, // made by hand after the file
+		"old.js":    now,
+		"old.js.gz": now.Add(-time.Hour),
+	} {
+		content := js
+		if strings.HasSuffix(name, ".gz") {
+			content = "hand-made " + name
+		}
+		p := filepath.Join(root, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(p, mtime, mtime)
+	}
+
+	res, err := Precompress(root, PrecompressOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"app.js.br", "old.js.br"}; !slices.Equal(res.Written, want) {
+		t.Errorf("written = %v, want %v", res.Written, want)
+	}
+	if res.UpToDate != 1 || !slices.Equal(res.Unmanaged, []string{"old.js.gz"}) {
+		t.Errorf("up to date %d, unmanaged %v", res.UpToDate, res.Unmanaged)
+	}
+	for name, mtime := range map[string]time.Time{"app.js.gz": now, "old.js.gz": now.Add(-time.Hour)} {
+		p := filepath.Join(root, name)
+		data, _ := os.ReadFile(p)
+		info, _ := os.Stat(p)
+		if string(data) != "hand-made "+name || !info.ModTime().Equal(mtime) {
+			t.Errorf("%s was changed: %q at %v", name, data, info.ModTime())
+		}
+	}
+}
+
+func TestServeStaticZstdSidecar(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "app.js"), []byte("plain"), 0644)
+	os.WriteFile(filepath.Join(root, "app.js.zst"), []byte("zstd"), 0644)
+	os.WriteFile(filepath.Join(root, "app.js.gz"), []byte("gzip"), 0644)
+	resetStatCache()
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
+	w := httptest.NewRecorder()
+	ServeStatic(w, req, root)
+	if w.Body.String() != "zstd" || w.Header().Get("Content-Encoding") != "zstd" {
+		t.Errorf("got %q encoding %q, want the zstd sidecar", w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestWatchPrecompress(t *testing.T) {
+	root := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var written []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchPrecompress(ctx, root, PrecompressOptions{}, 10*time.Millisecond, func(res PrecompressResult, err error) {
+			mu.Lock()
+			written = append(written, res.Written...)
+			mu.Unlock()
+		})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(root, "late.css"), []byte(strings.Repeat("a { b: c }\n", 100)), 0644)
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(written)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if !slices.Equal(written, []string{"late.css.br", "late.css.gz"}) {
+		t.Errorf("written = %v", written)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/armon/go-radix"
@@ -90,6 +91,12 @@ func startSingleServer(conf config.SiteConfig, mwManager *middleware.MiddlewareM
 				return
 			}
 		}
+		if conf.RootArchive != "" {
+			if _, err := os.Stat(conf.RootArchive); os.IsNotExist(err) {
+				lg.Errorf("Root archive does not exist for %s: %v", conf.Domain, err)
+				return
+			}
+		}
 	}
 
 	// Plugins are initialized up front in launchWebComponents (serially, before
@@ -119,6 +126,7 @@ func startVirtualHostServer(port int, configs []config.SiteConfig, mwManager *mi
 	radixTree := radix.New()
 
 	var firstHandler http.Handler
+	var onDemandFallback bool
 
 	for _, conf := range configs {
 		if conf.ProxyPass == "" && conf.RootDirectory != "" {
@@ -126,6 +134,11 @@ func startVirtualHostServer(port int, configs []config.SiteConfig, mwManager *mi
 				lg.Errorf("Root directory does not exist for %s: %v", conf.Domain, err)
 			}
 		}
+		if conf.RootArchive != "" {
+			if _, err := os.Stat(conf.RootArchive); os.IsNotExist(err) {
+				lg.Errorf("Root archive does not exist for %s: %v", conf.Domain, err)
+			}
+		}
 
 		mwManagerCopy := mwManager.Copy()
 		mwManagerCopy.Use(plugin.PluginMiddleware(pm))
@@ -136,8 +149,11 @@ func startVirtualHostServer(port int, configs []config.SiteConfig, mwManager *mi
 			continue
 		}
 
-		if firstHandler == nil {
+		// Hosts approved by on-demand TLS belong to the on-demand site, so
+		// it takes over unknown hosts.
+		if firstHandler == nil || (conf.SSL.OnDemand != nil && !onDemandFallback) {
 			firstHandler = handler
+			onDemandFallback = conf.SSL.OnDemand != nil
 		}
 
 		radixTree.Insert(conf.Domain, handler)
@@ -152,8 +168,8 @@ func startVirtualHostServer(port int, configs []config.SiteConfig, mwManager *mi
 			host = r_.Host
 		}
 
-		if h, found := radixTree.Get(host); found {
-			h.(http.Handler).ServeHTTP(w_, r_)
+		if h := lookupVirtualHost(radixTree, host); h != nil {
+			h.ServeHTTP(w_, r_)
 		} else {
 			if firstHandler != nil {
 				firstHandler.ServeHTTP(w_, r_)
@@ -167,3 +183,17 @@ func startVirtualHostServer(port int, configs []config.SiteConfig, mwManager *mi
 	serverConf.SSL.Enabled = setupTLS(server, configs, lg)
 	startServerInstance(server, serverConf, lg)
 }
+
+// lookupVirtualHost returns the handler for host: an exact domain match first,
+// then a wildcard site ("*.example.com") covering one extra label.
+func lookupVirtualHost(tree *radix.Tree, host string) http.Handler {
+	if h, found := tree.Get(host); found {
+		return h.(http.Handler)
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		if h, found := tree.Get("*" + host[i:]); found {
+			return h.(http.Handler)
+		}
+	}
+	return nil
+}
@@ -41,7 +41,7 @@ func createHTTPServer(conf config.SiteConfig, handler http.Handler) *http.Server
 
 	s := &http.Server{
 		Addr:         fmt.Sprintf(":%d", conf.Port),
-		Handler:      withHealthCheck(handler),
+		Handler:      withHealthCheck(withACMEChallenge(handler)),
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
 		// "h3" is QUIC-only and must not be advertised over TCP; the HTTP/3
@@ -73,10 +73,12 @@ func startServerInstance(server *http.Server, conf config.SiteConfig, l *logger.
 
 	go func() {
 		if conf.SSL.Enabled {
-			// server.TLSConfig has already been populated by setupTLS with
-			// either static certificates (SNI-selected) or an ACME
-			// GetCertificate callback. Share the same certificate source with
-			// the QUIC (h3) server.
+			// server.TLSConfig has already been populated by setupTLS with a
+			// GetCertificate callback (reloadable static certificates and/or
+			// ACME). Share the same callback with the QUIC (h3) server so a
+			// reloaded or renewed certificate is served over HTTP/3 too. The
+			// per-site (SNI) configurations are shared the same way; quic-go
+			// forces the h3 ALPN on whatever GetConfigForClient returns.
 			l.Infof("Serving %s on HTTPS port %d with HTTP/2 and HTTP/3 support", conf.Domain, conf.Port)
 
 			h3 := &http3.Server{
@@ -84,9 +86,9 @@ func startServerInstance(server *http.Server, conf config.SiteConfig, l *logger.
 				Port:    conf.Port,
 				Handler: server.Handler,
 				TLSConfig: &tls.Config{
-					MinVersion:     tls.VersionTLS12,
-					Certificates:   server.TLSConfig.Certificates,
-					GetCertificate: server.TLSConfig.GetCertificate,
+					MinVersion:         tls.VersionTLS12,
+					GetCertificate:     server.TLSConfig.GetCertificate,
+					GetConfigForClient: server.TLSConfig.GetConfigForClient,
 				},
 			}
 			registerCloser(h3)
@@ -94,6 +94,16 @@ func launchWebComponents(configs []config.SiteConfig, enableTUI bool, enableBenc
 		}
 	}
 
+	// HTTP-01 challenges are validated on port 80; when no site listens there,
+	// run a small listener that only answers challenges and redirects.
+	if _, onPort80 := portConfigs[80]; !onPort80 && needsHTTP01(configs) {
+		if lg, err := logger.NewSystemLogger("ACME"); err == nil {
+			startACMEChallengeServer(lg)
+		} else {
+			fmt.Printf("Error setting up ACME logger: %v\n", err)
+		}
+	}
+
 	// Make restart drain every registered server, terminate plugin child
 	// processes on the force-restart path, and let SafeGuard watch memory once
 	// everything is wired up.
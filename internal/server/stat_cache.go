@@ -5,6 +5,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/mirkobrombin/goup/internal/releases"
 )
 
 // statCache caches os.Stat results (including "not found", the negative case)
@@ -31,10 +33,14 @@ var (
 
 func init() {
 	statCacheMap.Store(&sync.Map{})
+	// A release switch replaces the whole tree at once: don't serve stats
+	// of the previous one for another TTL.
+	releases.OnActivate(func(string) { resetStatCache() })
 }
 
-// resetStatCache drops every cached entry. Tests use it when they mutate the
-// filesystem and need the change visible before the TTL expires.
+// resetStatCache drops every cached entry. It runs when a site switches
+// release, and tests use it when they mutate the filesystem and need the
+// change visible before the TTL expires.
 func resetStatCache() {
 	statCacheMap.Store(&sync.Map{})
 	statCacheSize.Store(0)
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// defaultIndexFiles is served for a directory unless index_files is set.
+var defaultIndexFiles = []string{"index.html"}
+
+// staticAccess holds the access rules of a static site on top of the
+// hidden dotfiles: denied paths, index names, allowed methods and whether
+// symlinks may lead outside the root. A nil *staticAccess applies the
+// defaults.
+type staticAccess struct {
+	deny           [][]string // deny_paths, split in segments
+	index          []string
+	methods        []string
+	followSymlinks bool
+}
+
+func newStaticAccess(conf config.SiteConfig) *staticAccess {
+	a := &staticAccess{
+		index:          conf.IndexFiles,
+		methods:        conf.AllowedMethods,
+		followSymlinks: conf.FollowSymlinks == nil || *conf.FollowSymlinks,
+	}
+	if len(a.index) == 0 {
+		a.index = defaultIndexFiles
+		if conf.Templates != nil {
+			a.index = []string{"index.html", "index.gohtml"}
+		}
+	}
+	for _, pattern := range conf.DenyPaths {
+		// A pattern without a slash names a file or directory at any
+		// depth; others are matched from the root.
+		segs := strings.Split(strings.Trim(pattern, "/"), "/")
+		if !strings.Contains(pattern, "/") {
+			segs = []string{"**", pattern}
+		}
+		a.deny = append(a.deny, segs)
+	}
+	return a
+}
+
+// indexFiles returns the names served for a directory, in order.
+func (a *staticAccess) indexFiles() []string {
+	if a == nil {
+		return defaultIndexFiles
+	}
+	return a.index
+}
+
+// denied reports whether a deny_paths pattern matches cleanPath or one of
+// its parent directories.
+func (a *staticAccess) denied(cleanPath string) bool {
+	if a == nil || len(a.deny) == 0 {
+		return false
+	}
+	segs := strings.Split(strings.Trim(cleanPath, "/"), "/")
+	for _, pattern := range a.deny {
+		for n := 1; n <= len(segs); n++ {
+			if matchSegments(pattern, segs[:n]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchSegments matches path segments against glob segments, "**"
+// matching any number of them.
+func matchSegments(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segs[0])
+	return ok && matchSegments(pattern[1:], segs[1:])
+}
+
+// allowMethod reports whether the method of r is allowed, answering 405
+// with an Allow header when it isn't.
+func (a *staticAccess) allowMethod(w http.ResponseWriter, r *http.Request) bool {
+	if a == nil || len(a.methods) == 0 {
+		return true
+	}
+	allowed := a.methods
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(slices.Clip(allowed), http.MethodHead)
+	}
+	if slices.Contains(allowed, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	serveStaticError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed", "This method is not allowed here.")
+	return false
+}
+
+// outside reports whether fullPath resolves outside root through a
+// symlink, when the site doesn't follow those. The root itself may be a
+// symlink, as with releases.
+func (a *staticAccess) outside(root, fullPath string) bool {
+	if a == nil || a.followSymlinks {
+		return false
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return true
+	}
+	target, err := filepath.EvalSymlinks(fullPath)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(realRoot, target)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// outsideTarget is outside for a path that may not exist yet, such as the
+// target of an upload: a missing path is checked through its directory.
+func (a *staticAccess) outsideTarget(root, fullPath string) bool {
+	if a == nil || a.followSymlinks {
+		return false
+	}
+	if _, err := os.Lstat(fullPath); errors.Is(err, fs.ErrNotExist) {
+		fullPath = filepath.Dir(fullPath)
+		if _, err := os.Lstat(fullPath); errors.Is(err, fs.ErrNotExist) {
+			// Nothing can be created there.
+			return false
+		}
+	}
+	return a.outside(root, fullPath)
+}
+
+// dirFS returns the directory at fullPath as a file system whose files,
+// such as the README of a listing, may not resolve outside root.
+func (a *staticAccess) dirFS(root, fullPath string) fs.FS {
+	dir := os.DirFS(fullPath)
+	if a == nil || a.followSymlinks {
+		return dir
+	}
+	return rootedFS{FS: dir, root: root, dir: fullPath, access: a}
+}
+
+// rootedFS is an os.DirFS refusing to open what lies outside the root.
+type rootedFS struct {
+	fs.FS
+	root, dir string
+	access    *staticAccess
+}
+
+func (f rootedFS) Open(name string) (fs.File, error) {
+	if f.access.outside(f.root, filepath.Join(f.dir, filepath.FromSlash(name))) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.FS.Open(name)
+}
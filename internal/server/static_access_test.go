@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestStaticAccessDenied(t *testing.T) {
+	a := newStaticAccess(config.SiteConfig{DenyPaths: []string{"*.bak", "/private/**", "node_modules", "/docs/*.draft.md"}})
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/config.bak", true},
+		{"/a/b/config.bak", true},
+		{"/config.bak.txt", false},
+		{"/private", true},
+		{"/private/keys/id.pem", true},
+		{"/public/private/x", false},
+		{"/app/node_modules/pkg/index.js", true},
+		{"/docs/intro.draft.md", true},
+		{"/docs/sub/intro.draft.md", false},
+		{"/docs/intro.md", false},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		if got := a.denied(tt.path); got != tt.want {
+			t.Errorf("denied(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+	if (*staticAccess)(nil).denied("/x.bak") {
+		t.Error("a nil staticAccess denies nothing")
+	}
+}
+
+func TestStaticDenyPaths(t *testing.T) {
+	root, site := newStaticTestSite(t, config.SiteConfig{DenyPaths: []string{"*.bak", "/private/**"}}, map[string]string{
+		"site.conf.bak":    "secret",
+		"private/key.pem":  "secret",
+		"public/index.bak": "secret",
+		"public/ok.txt":    "ok",
+	})
+	for _, target := range []string{"/site.conf.bak", "/private/key.pem", "/private/"} {
+		if w := serveStaticTest(root, site, target); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, w.Code)
+		}
+	}
+	w := serveStaticTest(root, site, "/public/")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "index.bak") || !strings.Contains(w.Body.String(), "ok.txt") {
+		t.Errorf("listing: %d %q", w.Code, w.Body.String())
+	}
+	if w := serveStaticTest(root, site, "/"); strings.Contains(w.Body.String(), "private") {
+		t.Errorf("a denied directory is listed: %q", w.Body.String())
+	}
+}
+
+func TestStaticIndexFiles(t *testing.T) {
+	root, site := newStaticTestSite(t, config.SiteConfig{IndexFiles: []string{"index.htm", "default.html"}}, map[string]string{
+		"a/index.html":   "html",
+		"a/default.html": "default",
+		"b/index.htm":    "htm",
+		"b/default.html": "default",
+	})
+	if w := serveStaticTest(root, site, "/a/"); w.Body.String() != "default" {
+		t.Errorf("/a/: %q, want default.html", w.Body.String())
+	}
+	if w := serveStaticTest(root, site, "/b/"); w.Body.String() != "htm" {
+		t.Errorf("/b/: %q, want index.htm first", w.Body.String())
+	}
+
+	root, site = newStaticTestSite(t, config.SiteConfig{DenyPaths: []string{"index.html"}}, map[string]string{"index.html": "index"})
+	if w := serveStaticTest(root, site, "/"); w.Body.String() == "index" {
+		t.Error("a denied index file is served")
+	}
+}
+
+func TestStaticAllowedMethods(t *testing.T) {
+	a := newStaticAccess(config.SiteConfig{AllowedMethods: []string{"GET", "OPTIONS"}})
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		if !a.allowMethod(httptest.NewRecorder(), httptest.NewRequest(method, "/", nil)) {
+			t.Errorf("%s refused", method)
+		}
+	}
+	w := httptest.NewRecorder()
+	if a.allowMethod(w, httptest.NewRequest(http.MethodPost, "/", nil)) || w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: status %d, want 405", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, OPTIONS, HEAD" {
+		t.Errorf("Allow = %q", allow)
+	}
+	if !newStaticAccess(config.SiteConfig{}).allowMethod(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/", nil)) {
+		t.Error("every method is allowed by default")
+	}
+}
+
+func TestStaticSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	follow := false
+	root, site := newStaticTestSite(t, config.SiteConfig{FollowSymlinks: &follow}, map[string]string{"docs/page.txt": "page"})
+	for name, target := range map[string]string{
+		"leak.txt":  filepath.Join(outside, "secret.txt"),
+		"leakdir":   outside,
+		"inner.txt": filepath.Join(root, "docs", "page.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skip("symlinks unavailable:", err)
+		}
+	}
+
+	for _, target := range []string{"/leak.txt", "/leakdir/secret.txt"} {
+		if w := serveStaticTest(root, site, target); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, w.Code)
+		}
+	}
+	if w := serveStaticTest(root, site, "/inner.txt"); w.Body.String() != "page" {
+		t.Errorf("a symlink inside the root: %d %q", w.Code, w.Body.String())
+	}
+
+	// A root that is itself a symlink, as with releases, still works.
+	link := filepath.Join(t.TempDir(), "current")
+	os.Symlink(root, link)
+	if w := serveStaticTest(link, site, "/docs/page.txt"); w.Body.String() != "page" {
+		t.Errorf("through a symlinked root: %d %q", w.Code, w.Body.String())
+	}
+
+	_, following := newStaticTestSite(t, config.SiteConfig{}, nil)
+	if w := serveStaticTest(root, following, "/leak.txt"); w.Body.String() != "secret" {
+		t.Errorf("symlinks are followed by default: %d", w.Code)
+	}
+}
+
+func TestStaticSidecarAccess(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.gz"), []byte("secret"), 0644)
+	os.WriteFile(filepath.Join(outside, "README.md"), []byte("secret readme"), 0644)
+	follow := false
+	root, site := newStaticTestSite(t, config.SiteConfig{
+		FollowSymlinks: &follow,
+		DenyPaths:      []string{"*.br"},
+		Listing:        &config.ListingConfig{Readme: true},
+	}, map[string]string{
+		"app.js":        "plain",
+		"app.js.br":     "denied",
+		"docs/a.txt":    "a",
+		"docs/a.txt.br": "denied",
+	})
+	for name, target := range map[string]string{
+		"app.js.gz":      filepath.Join(outside, "secret.gz"),
+		"docs/a.txt.gz":  filepath.Join(root, "app.js"),
+		"docs/README.md": filepath.Join(outside, "README.md"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skip("symlinks unavailable:", err)
+		}
+	}
+
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+		w := httptest.NewRecorder()
+		serveStatic(w, req, root, site)
+		return w
+	}
+	// Neither a denied nor an escaping sidecar stands in for its file.
+	if w := get("/app.js"); w.Body.String() != "plain" || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("/app.js: got %q encoding %q, want the file", w.Body.String(), w.Header().Get("Content-Encoding"))
+	}
+	// A symlinked sidecar inside the root is fine.
+	if w := get("/docs/a.txt"); w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("/docs/a.txt: encoding %q, want the gzip sidecar", w.Header().Get("Content-Encoding"))
+	}
+
+	// Nor is a README outside the root shown in a listing.
+	req := httptest.NewRequest(http.MethodGet, "/docs/", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Accept", "text/html")
+	w := httptest.NewRecorder()
+	serveStatic(w, req, root, site)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret readme") {
+		t.Errorf("listing: %d, README outside the root shown: %v", w.Code, strings.Contains(w.Body.String(), "secret readme"))
+	}
+}
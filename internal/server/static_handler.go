@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"mime"
 	"net/http"
@@ -10,12 +11,74 @@ import (
 	"strings"
 
 	"github.com/mirkobrombin/goup/internal/assets"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
 )
 
-// ServeStatic serves static files with support for pre-compressed sidecar files (.br, .gz).
+// ServeStatic serves static files with support for pre-compressed sidecar files (.br, .zst, .gz).
 func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
+	serveStatic(w, r, root, nil)
+}
+
+// staticSite holds the per-site options of the static file server.
+type staticSite struct {
+	cache     *fileCache
+	listing   *config.ListingConfig
+	uploads   *uploads
+	templates *siteTemplates
+	markdown  *markdownPages
+	images    *siteImages
+	access    *staticAccess
+}
+
+func newStaticSite(conf config.SiteConfig, log *logger.Logger) *staticSite {
+	return &staticSite{
+		cache:     newFileCache(conf),
+		listing:   conf.Listing,
+		uploads:   newUploads(conf),
+		templates: newSiteTemplates(conf, log),
+		markdown:  newMarkdownPages(conf),
+		images:    newSiteImages(conf, log),
+		access:    newStaticAccess(conf),
+	}
+}
+
+// index returns the first index file of the directory at fullPath that
+// may be served, or a nil FileInfo.
+func (site *staticSite) index(root, cleanPath, fullPath string) (string, os.FileInfo) {
+	for _, name := range site.access.indexFiles() {
+		if site.access.denied(path.Join(cleanPath, name)) {
+			continue
+		}
+		indexPath := filepath.Join(fullPath, name)
+		if info, err := cachedStat(indexPath); err == nil && !info.IsDir() && !site.access.outside(root, indexPath) {
+			return indexPath, info
+		}
+	}
+	return "", nil
+}
+
+// sidecar returns the precompressed sidecar of the file served at
+// cleanPath, or a nil FileInfo. It must pass the access rules on its own:
+// a denied or escaping sidecar is never served in place of its file.
+func (site *staticSite) sidecar(root, cleanPath, fullPath, ext string) (string, os.FileInfo) {
+	p := fullPath + ext
+	info, err := cachedStat(p)
+	if err != nil || info.IsDir() || site.access.denied(cleanPath+ext) || site.access.outside(root, p) {
+		return "", nil
+	}
+	return p, info
+}
+
+// serveStatic is ServeStatic with the options of a site; nil selects the
+// defaults.
+func serveStatic(w http.ResponseWriter, r *http.Request, root string, site *staticSite) {
+	if site == nil {
+		site = &staticSite{}
+	}
 	cleanPath, fullPath, err := staticLocalPath(root, r.URL.Path)
-	if err != nil {
+	if err != nil || site.access.denied(cleanPath) {
 		if isBrowser(r) {
 			assets.RenderErrorPage(w, http.StatusNotFound, "Page Not Found", "The page you are looking for does not exist.")
 		} else {
@@ -27,6 +90,9 @@ func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
 	}
 
 	info, err := cachedStat(fullPath)
+	if err == nil && site.access.outside(root, fullPath) {
+		err = os.ErrNotExist
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			if isBrowser(r) {
@@ -48,6 +114,7 @@ func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
 		return
 	}
 
+	servedPath := cleanPath
 	if info.IsDir() {
 		// Redirect directory requests missing the trailing slash so relative
 		// links resolve against the directory itself (matters for symlinked
@@ -61,66 +128,30 @@ func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
 			return
 		}
 
-		indexPath := filepath.Join(fullPath, "index.html")
-		indexInfo, err := cachedStat(indexPath)
-		if err == nil && !indexInfo.IsDir() {
-			fullPath = indexPath
-			info = indexInfo
-		} else {
-			// Directory listing or Welcome Page
-			if cleanPath == "/" {
-				// If index.html is missing at root, we can still show listing if it's not empty
-			}
+		indexPath, indexInfo := site.index(root, cleanPath, fullPath)
+		if indexInfo == nil {
+			serveListing(w, r, cleanPath, site.access.dirFS(root, fullPath), site)
+			return
+		}
+		servedPath = path.Join(cleanPath, filepath.Base(indexPath))
+		fullPath, info = indexPath, indexInfo
+	}
 
-			entries, err := os.ReadDir(fullPath)
-			if err != nil {
-				if isBrowser(r) {
-					assets.RenderErrorPage(w, http.StatusInternalServerError, "Internal Server Error", "Unable to read directory.")
-				} else {
-					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-					w.WriteHeader(http.StatusInternalServerError)
-					fmt.Fprintln(w, "500 Internal Server Error: Unable to read directory.")
-				}
-				return
-			}
+	// Resized variants live in their own cache, away from the sidecars.
+	if site.images != nil && site.images.match(r, fullPath) {
+		site.images.serve(w, r, fullPath, info)
+		return
+	}
 
-			if isBrowser(r) {
-				var items []assets.ListingItem
-				for _, entry := range entries {
-					if isHiddenName(entry.Name()) {
-						continue
-					}
-					entryInfo, err := entry.Info()
-					if err != nil {
-						// The entry vanished between ReadDir and Info (or is
-						// otherwise unreadable); skip it instead of panicking on
-						// a nil FileInfo.
-						continue
-					}
-					items = append(items, assets.ListingItem{
-						Name:    entry.Name(),
-						IsDir:   entry.IsDir(),
-						Size:    formatSizeBytes(entryInfo.Size()),
-						ModTime: entryInfo.ModTime().Format("2006-01-02 15:04:05"),
-					})
-				}
-
-				showBack := cleanPath != "/"
-				assets.RenderDirectoryListing(w, cleanPath, items, showBack)
-			} else {
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				w.WriteHeader(http.StatusOK)
-				for _, entry := range entries {
-					if isHiddenName(entry.Name()) {
-						continue
-					}
-					name := entry.Name()
-					if entry.IsDir() {
-						name += "/"
-					}
-					fmt.Fprintln(w, name)
-				}
-			}
+	// Templates are rendered on every request; their sidecars, if any, hold
+	// the source.
+	if site.templates != nil && site.templates.match(fullPath) {
+		site.templates.serve(w, r, fullPath, info)
+		return
+	}
+
+	if site.markdown != nil && site.markdown.match(fullPath) {
+		if site.markdown.serve(w, r, cleanPath, info, func() ([]byte, error) { return os.ReadFile(fullPath) }) {
 			return
 		}
 	}
@@ -131,21 +162,28 @@ func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
 	var serveInfo os.FileInfo
 	var contentEncoding string
 
-	if strings.Contains(acceptEncoding, "br") {
-		brPath := fullPath + ".br"
-		if brInfo, err := cachedStat(brPath); err == nil && !brInfo.IsDir() {
-			servePath = brPath
-			serveInfo = brInfo
+	if middleware.AcceptsEncoding(acceptEncoding, "br") {
+		if p, si := site.sidecar(root, servedPath, fullPath, ".br"); si != nil {
+			servePath = p
+			serveInfo = si
 			contentEncoding = "br"
 			servedCompressed = true
 		}
 	}
 
-	if !servedCompressed && strings.Contains(acceptEncoding, "gzip") {
-		gzPath := fullPath + ".gz"
-		if gzInfo, err := cachedStat(gzPath); err == nil && !gzInfo.IsDir() {
-			servePath = gzPath
-			serveInfo = gzInfo
+	if !servedCompressed && middleware.AcceptsEncoding(acceptEncoding, "zstd") {
+		if p, si := site.sidecar(root, servedPath, fullPath, ".zst"); si != nil {
+			servePath = p
+			serveInfo = si
+			contentEncoding = "zstd"
+			servedCompressed = true
+		}
+	}
+
+	if !servedCompressed && middleware.AcceptsEncoding(acceptEncoding, "gzip") {
+		if p, si := site.sidecar(root, servedPath, fullPath, ".gz"); si != nil {
+			servePath = p
+			serveInfo = si
 			contentEncoding = "gzip"
 			servedCompressed = true
 		}
@@ -156,6 +194,21 @@ func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
 		serveInfo = info
 	}
 
+	var cached *cachedFile
+	if site.cache != nil {
+		cached = site.cache.get(servePath, serveInfo)
+	}
+	if cached != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if servedCompressed {
+			w.Header().Set("Content-Encoding", contentEncoding)
+			w.Header().Set("Content-Type", staticMimeType(fullPath))
+		}
+		w.Header().Set("ETag", cached.etag)
+		http.ServeContent(w, r, filepath.Base(fullPath), cached.modTime, bytes.NewReader(cached.data))
+		return
+	}
+
 	file, err := os.Open(servePath)
 	if err != nil {
 		if isBrowser(r) {
@@ -173,20 +226,29 @@ func ServeStatic(w http.ResponseWriter, r *http.Request, root string) {
 
 	if servedCompressed {
 		w.Header().Set("Content-Encoding", contentEncoding)
-		mimeType := mime.TypeByExtension(filepath.Ext(fullPath))
-		if mimeType == "" {
-			// Sniffing won't work on compressed data, so default if unknown
-			mimeType = "application/octet-stream"
-		}
-		w.Header().Set("Content-Type", mimeType)
+		w.Header().Set("Content-Type", staticMimeType(fullPath))
 	}
 
-	etag := fmt.Sprintf("\"%x-%x\"", serveInfo.Size(), serveInfo.ModTime().UnixNano())
-	w.Header().Set("ETag", etag)
+	w.Header().Set("ETag", staticETag(serveInfo))
 
 	http.ServeContent(w, r, filepath.Base(fullPath), serveInfo.ModTime(), file)
 }
 
+// staticETag is the strong ETag of a static file, by size and mtime. Files
+// in the memory cache use it too, so revalidations survive evictions.
+func staticETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// staticMimeType returns the Content-Type of a file served from a compressed
+// sidecar, where sniffing the content won't work.
+func staticMimeType(name string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(name)); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
 func formatSizeBytes(b int64) string {
 	const unit = 1024
 	if b < unit {
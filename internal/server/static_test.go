@@ -3,13 +3,47 @@ package server
 import (
 	"compress/gzip"
 	"io"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
 )
 
+// writeTestFiles writes files, by slash-separated path, under root.
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newStaticTestSite writes files to a new root and returns it with the
+// site conf describes there.
+func newStaticTestSite(t *testing.T, conf config.SiteConfig, files map[string]string) (string, *staticSite) {
+	t.Helper()
+	root := t.TempDir()
+	writeTestFiles(t, root, files)
+	resetStatCache()
+	conf.RootDirectory = root
+	return root, newStaticSite(conf, nil)
+}
+
+func serveStaticTest(root string, site *staticSite, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("User-Agent", "tester")
+	w := httptest.NewRecorder()
+	serveStatic(w, req, root, site)
+	return w
+}
+
 func TestServeStatic_PreCompressed(t *testing.T) {
 	rootDir := t.TempDir()
 
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+)
+
+// maxIncludeDepth stops a file that (indirectly) includes itself.
+const maxIncludeDepth = 16
+
+// siteTemplates renders the pages of a static site through html/template.
+// Parsed files are cached and parsed again when their modification time
+// or size changes.
+type siteTemplates struct {
+	root   string
+	exts   []string
+	env    map[string]bool
+	log    *logger.Logger
+	funcs  template.FuncMap
+	access *staticAccess
+
+	mu     sync.Mutex
+	parsed map[string]*parsedTemplate // by file path
+}
+
+type parsedTemplate struct {
+	tmpl    *template.Template
+	size    int64
+	modTime time.Time
+}
+
+// templateData is the dot of a page and of the files it includes.
+type templateData struct {
+	Method   string
+	Host     string
+	Path     string
+	Query    url.Values
+	Header   http.Header
+	Modified time.Time // of the page
+
+	dir   string // URL directory of the file being rendered
+	depth int
+}
+
+func newSiteTemplates(conf config.SiteConfig, log *logger.Logger) *siteTemplates {
+	if conf.Templates == nil {
+		return nil
+	}
+	t := &siteTemplates{
+		root:   conf.RootDirectory,
+		exts:   conf.Templates.Extensions,
+		env:    make(map[string]bool),
+		log:    log,
+		parsed: make(map[string]*parsedTemplate),
+		access: newStaticAccess(conf),
+	}
+	if len(t.exts) == 0 {
+		t.exts = []string{".html", ".gohtml"}
+	}
+	for _, name := range conf.Templates.Env {
+		t.env[name] = true
+	}
+	t.funcs = template.FuncMap{
+		"include": t.include,
+		"env":     t.getenv,
+		"now":     time.Now,
+		"date":    formatDate,
+	}
+	return t
+}
+
+// match reports whether the file at fullPath is rendered.
+func (t *siteTemplates) match(fullPath string) bool {
+	return slices.Contains(t.exts, strings.ToLower(filepath.Ext(fullPath)))
+}
+
+// serve renders the page at fullPath. Partials, files whose name starts
+// with "_", are only included.
+func (t *siteTemplates) serve(w http.ResponseWriter, r *http.Request, fullPath string, info os.FileInfo) {
+	if strings.HasPrefix(filepath.Base(fullPath), "_") {
+		serveStaticError(w, r, http.StatusNotFound, "Page Not Found", "The page you are looking for does not exist.")
+		return
+	}
+	rel, err := filepath.Rel(t.root, fullPath)
+	if err != nil {
+		rel = filepath.Base(fullPath)
+	}
+	data := &templateData{
+		Method:   r.Method,
+		Host:     r.Host,
+		Path:     r.URL.Path,
+		Query:    r.URL.Query(),
+		Header:   r.Header,
+		Modified: info.ModTime(),
+		dir:      path.Dir("/" + filepath.ToSlash(rel)),
+	}
+	var buf bytes.Buffer
+	if err := t.render(&buf, fullPath, info, data); err != nil {
+		if t.log != nil {
+			t.log.Errorf("Template error for %s: %v", r.URL.Path, err)
+		}
+		serveStaticError(w, r, http.StatusInternalServerError, "Internal Server Error", "Unable to render the page.")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+func (t *siteTemplates) render(buf *bytes.Buffer, fullPath string, info os.FileInfo, data *templateData) error {
+	tmpl, err := t.load(fullPath, info)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(buf, data)
+}
+
+// load returns the parsed template of a file, from the cache while the
+// file is unchanged.
+func (t *siteTemplates) load(fullPath string, info os.FileInfo) (*template.Template, error) {
+	t.mu.Lock()
+	p := t.parsed[fullPath]
+	t.mu.Unlock()
+	if p != nil && p.size == info.Size() && p.modTime.Equal(info.ModTime()) {
+		return p.tmpl, nil
+	}
+
+	src, err := os.ReadFile(fullPath)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(filepath.Base(fullPath)).Funcs(t.funcs).Parse(string(src))
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.parsed[fullPath] = &parsedTemplate{tmpl: tmpl, size: info.Size(), modTime: info.ModTime()}
+	t.mu.Unlock()
+	return tmpl, nil
+}
+
+// include renders another file of the site with the same data: {{include
+// "/partials/header.html" .}}. Names starting with a slash are resolved
+// from the root, others from the directory of the including file; hidden
+// files, denied paths and names outside the root can't be included.
+func (t *siteTemplates) include(name string, dot any) (template.HTML, error) {
+	data, ok := dot.(*templateData)
+	if !ok {
+		return "", errors.New("include: pass the page data as the second argument, e.g. {{include \"footer.html\" .}}")
+	}
+	if data.depth >= maxIncludeDepth {
+		return "", fmt.Errorf("include %s: nested too deeply", name)
+	}
+	urlPath := name
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = path.Join(data.dir, urlPath)
+	}
+	cleanPath, fullPath, err := staticLocalPath(t.root, urlPath)
+	if err != nil {
+		return "", fmt.Errorf("include %s: %w", name, err)
+	}
+	if t.access.denied(cleanPath) {
+		return "", fmt.Errorf("include %s: %w", name, fs.ErrNotExist)
+	}
+	if t.access.outside(t.root, fullPath) {
+		return "", fmt.Errorf("include %s: outside the root directory", name)
+	}
+	info, err := cachedStat(fullPath)
+	if err != nil {
+		return "", fmt.Errorf("include %s: %w", name, err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("include %s: not a file", name)
+	}
+
+	sub := *data
+	sub.dir = path.Dir(path.Clean(urlPath))
+	sub.depth++
+	var buf bytes.Buffer
+	if err := t.render(&buf, fullPath, info, &sub); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// getenv returns the environment variables listed in templates.env.
+func (t *siteTemplates) getenv(name string) string {
+	if !t.env[name] {
+		return ""
+	}
+	return os.Getenv(name)
+}
+
+// formatDate formats a time with a Go layout, the current time when none is
+// given: {{date "2006"}} or {{date "Jan 2, 2006" .Modified}}.
+func formatDate(layout string, times ...time.Time) (string, error) {
+	switch len(times) {
+	case 0:
+		return time.Now().Format(layout), nil
+	case 1:
+		return times[0].Format(layout), nil
+	}
+	return "", errors.New("date: expects a layout and at most one time")
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// templateConf enables templates, exposing one environment variable.
+var templateConf = config.SiteConfig{Templates: &config.TemplatesConfig{Env: []string{"GOUP_TEST_SITE"}}}
+
+func TestTemplates(t *testing.T) {
+	t.Setenv("GOUP_TEST_SITE", "Acme")
+	t.Setenv("GOUP_TEST_SECRET", "hunter2")
+	root, site := newStaticTestSite(t, templateConf, map[string]string{
+		"index.html":            `{{include "/partials/_header.html" .}}<p>{{.Query.Get "q"}}</p>`,
+		"partials/_header.html": `<h1>{{env "GOUP_TEST_SITE"}}{{env "GOUP_TEST_SECRET"}}</h1>{{include "_nav.html" .}}`,
+		"partials/_nav.html":    `<nav>{{.Path}} {{.Header.Get "User-Agent"}}</nav>`,
+		"blog/index.gohtml":     `{{date "2006"}}`,
+		"loop.html":             `{{include "loop.html" .}}`,
+		"escape.html":           `{{include "../../etc/passwd" .}}`,
+		"hidden.html":           `{{include ".env" .}}`,
+		".env":                  "SECRET=1",
+		"plain.txt":             `{{.Path}}`,
+	})
+
+	w := serveStaticTest(root, site, "/?q=<b>")
+	want := `<h1>Acme</h1><nav>/ tester</nav><p>&lt;b&gt;</p>`
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("index: %d %q, want %q", w.Code, w.Body.String(), want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if w := serveStaticTest(root, site, "/blog/"); w.Body.String() != strconv.Itoa(time.Now().Year()) {
+		t.Errorf("index.gohtml: %q", w.Body.String())
+	}
+	if w := serveStaticTest(root, site, "/plain.txt"); w.Body.String() != "{{.Path}}" {
+		t.Errorf("other files are served as is: %q", w.Body.String())
+	}
+	if w := serveStaticTest(root, site, "/partials/_header.html"); w.Code != http.StatusNotFound {
+		t.Errorf("partial: status %d, want 404", w.Code)
+	}
+	for _, target := range []string{"/loop.html", "/escape.html", "/hidden.html"} {
+		if w := serveStaticTest(root, site, target); w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "SECRET") {
+			t.Errorf("%s: %d %q", target, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestTemplatesReparseOnChange(t *testing.T) {
+	root, site := newStaticTestSite(t, templateConf, map[string]string{"page.html": "v1"})
+	if w := serveStaticTest(root, site, "/page.html"); w.Body.String() != "v1" {
+		t.Fatalf("got %q", w.Body.String())
+	}
+	p := filepath.Join(root, "page.html")
+	os.WriteFile(p, []byte("{{if true}}v2{{end}}"), 0644)
+	os.Chtimes(p, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	resetStatCache()
+	if w := serveStaticTest(root, site, "/page.html"); w.Body.String() != "v2" {
+		t.Errorf("after a change: %q", w.Body.String())
+	}
+}
+
+func TestTemplatesIncludeAccessRules(t *testing.T) {
+	outside := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.html"), []byte("SECRET"), 0644)
+	follow := false
+	root, site := newStaticTestSite(t, config.SiteConfig{
+		DenyPaths:      []string{"private"},
+		FollowSymlinks: &follow,
+		Templates:      &config.TemplatesConfig{},
+	}, map[string]string{
+		"private/_key.html": "SECRET",
+		"denied.html":       `{{include "/private/_key.html" .}}`,
+		"leak.html":         `{{include "/leak/secret.html" .}}`,
+	})
+	if err := os.Symlink(outside, filepath.Join(root, "leak")); err != nil {
+		t.Skip("symlinks unavailable:", err)
+	}
+	for _, target := range []string{"/denied.html", "/leak.html"} {
+		if w := serveStaticTest(root, site, target); w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "SECRET") {
+			t.Errorf("%s: %d %q", target, w.Code, w.Body.String())
+		}
+	}
+}
@@ -1,24 +1,46 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/mirkobrombin/goup/internal/acmedns"
+	"github.com/mirkobrombin/goup/internal/certs"
 	"github.com/mirkobrombin/goup/internal/config"
 	"github.com/mirkobrombin/goup/internal/logger"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
 // setupTLS configures server.TLSConfig for the given site configs (one for a
 // single-site server, many for a virtual host on the same port). It loads
-// static certificates and, when any site requests ACME, wires an autocert
-// manager that obtains and renews Let's Encrypt certificates (via TLS-ALPN-01
-// on the TLS port). It returns false when no site enables TLS.
+// static certificates and, when any site requests ACME, wires the managers
+// that obtain and renew certificates from the configured ACME CA: autocert
+// for TLS-ALPN-01 (on the TLS port) and HTTP-01 (on port 80), and a DNS-01
+// manager for wildcard and otherwise unreachable hosts. Per-site settings such
+// as client authentication are then layered on top, selected by SNI. It
+// returns false when no site enables TLS.
 func setupTLS(server *http.Server, confs []config.SiteConfig, lg *logger.Logger) bool {
-	var staticCerts []tls.Certificate
-	var acmeHosts []string
-	var acmeEmail, acmeCache string
+	if !setupCertificates(server, confs, lg) {
+		return false
+	}
+	setupSiteTLSConfigs(server.TLSConfig, confs, lg)
+	return true
+}
+
+// setupCertificates installs the certificate sources of setupTLS.
+func setupCertificates(server *http.Server, confs []config.SiteConfig, lg *logger.Logger) bool {
+	staticCerts := certs.NewStaticStore(lg)
+	var acmeHosts, http01Hosts, internalHosts []string
+	var dns01Sites []config.SiteConfig
+	var onDemandSite *config.SiteConfig
 	tlsWanted := false
 
 	for _, c := range confs {
@@ -26,26 +48,47 @@ func setupTLS(server *http.Server, confs []config.SiteConfig, lg *logger.Logger)
 			continue
 		}
 		tlsWanted = true
+		if c.SSL.Mode == config.SSLModeInternal {
+			certs.Track(c.Domain, certs.SourceInternal, "")
+			internalHosts = append(internalHosts, c.Domain)
+			continue
+		}
 		if c.SSL.ACME {
-			acmeHosts = append(acmeHosts, c.Domain)
-			if c.SSL.Email != "" {
-				acmeEmail = c.SSL.Email
+			certs.Track(c.Domain, certs.SourceACME, c.SSL.ACMEChallenge())
+			if c.SSL.OnDemand != nil && c.SSL.ACMEChallenge() != config.ChallengeDNS01 {
+				if onDemandSite == nil {
+					onDemandSite = &c
+				} else {
+					lg.Errorf("On-demand TLS is already enabled by %s, ignoring it for %s", onDemandSite.Domain, c.Domain)
+				}
 			}
-			if c.SSL.CacheDir != "" {
-				acmeCache = c.SSL.CacheDir
+			switch c.SSL.ACMEChallenge() {
+			case config.ChallengeDNS01:
+				dns01Sites = append(dns01Sites, c)
+			case config.ChallengeHTTP01:
+				acmeHosts = append(acmeHosts, c.Domain)
+				http01Hosts = append(http01Hosts, c.Domain)
+			default:
+				if strings.HasPrefix(c.Domain, "*.") {
+					err := fmt.Errorf("ACME for wildcard domain %s requires the dns-01 challenge", c.Domain)
+					lg.Errorf("%v", err)
+					certs.RecordError(c.Domain, err)
+					continue
+				}
+				acmeHosts = append(acmeHosts, c.Domain)
 			}
 			continue
 		}
 		if c.SSL.Certificate == "" || c.SSL.Key == "" {
-			lg.Errorf("SSL enabled for %s but certificate/key not set (and ACME off)", c.Domain)
+			certs.Track(c.Domain, certs.SourceStatic, "")
+			err := fmt.Errorf("SSL enabled for %s but certificate/key not set (and ACME off)", c.Domain)
+			lg.Errorf("%v", err)
+			certs.RecordError(c.Domain, err)
 			continue
 		}
-		cert, err := tls.LoadX509KeyPair(c.SSL.Certificate, c.SSL.Key)
-		if err != nil {
+		if err := staticCerts.Add(c.Domain, c.SSL.Certificate, c.SSL.Key); err != nil {
 			lg.Errorf("SSL certificate error for %s: %v", c.Domain, err)
-			continue
 		}
-		staticCerts = append(staticCerts, cert)
 	}
 
 	if !tlsWanted {
@@ -54,37 +97,263 @@ func setupTLS(server *http.Server, confs []config.SiteConfig, lg *logger.Logger)
 
 	server.TLSConfig.MinVersion = tls.VersionTLS12
 
-	if len(acmeHosts) == 0 {
-		// Static certificates only; the tls package selects by SNI.
-		server.TLSConfig.Certificates = staticCerts
-		return true
+	// Static certificates are served from a store that reloads them when the
+	// files change, so external renewals need no restart.
+	if staticCerts.Len() > 0 {
+		staticCerts.Watch(certs.DefaultWatchInterval)
 	}
 
-	cacheDir := acmeCache
-	if cacheDir == "" {
-		cacheDir = config.GetACMEDir()
+	internal := localIssuer(internalHosts, lg)
+
+	var ac config.ACMEConfig
+	var acmeHC *http.Client
+	var eab *acme.ExternalAccountBinding
+	var storage certs.CertStorage
+	var lockTTL time.Duration
+	if len(acmeHosts) > 0 || len(dns01Sites) > 0 {
+		ac = resolveACMEConfig(confs, lg)
+		var err error
+		if acmeHC, err = acmeHTTPClient(ac); err == nil {
+			if eab, err = externalAccountBinding(ac.EAB); err == nil {
+				storage, lockTTL, err = newCertStorage(ac)
+			}
+		}
+		if err != nil {
+			lg.Errorf("ACME disabled: %v", err)
+			for _, host := range acmeHosts {
+				certs.RecordError(host, err)
+			}
+			for _, c := range dns01Sites {
+				certs.RecordError(c.Domain, err)
+			}
+			acmeHosts, dns01Sites, onDemandSite = nil, nil, nil
+		}
 	}
-	mgr := &autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(acmeHosts...),
-		Cache:      autocert.DirCache(cacheDir),
-		Email:      acmeEmail,
+
+	// Every certificate served is recorded in the inventory, on-demand hosts
+	// under their own name.
+	var onDemand *onDemandPolicy
+	install := func(get func(*tls.ClientHelloInfo) (*tls.Certificate, error)) {
+		server.TLSConfig.GetCertificate = observeCertificates(get, func(host string) string {
+			if onDemand != nil && onDemand.isApproved(host) {
+				return normalizeHost(host)
+			}
+			return siteDomainFor(confs, host)
+		})
 	}
-	lg.Infof("ACME auto-TLS enabled for %v (cache %s)", acmeHosts, cacheDir)
 
-	// TLS-ALPN-01 needs the acme protocol advertised in ALPN.
-	server.TLSConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	if len(acmeHosts) == 0 && len(dns01Sites) == 0 {
+		if internal == nil {
+			// Static certificates only, selected by SNI.
+			install(withOCSPStapling(staticCerts.GetCertificate, config.GetACMEDir(), lg))
+			return true
+		}
+		// Local CA certificates, unless a static one matches. They name no
+		// OCSP responder, so no stapling is needed.
+		install(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert := staticCerts.Lookup(hello); cert != nil {
+				return cert, nil
+			}
+			return internal.GetCertificate(hello)
+		})
+		return true
+	}
+
+	cache := newIssuanceCache(storage, lockTTL, lg)
+
+	var mgr *autocertRenewer
+	if len(acmeHosts) > 0 {
+		hostPolicy := autocert.HostWhitelist(acmeHosts...)
+		if onDemandSite != nil {
+			onDemand = newOnDemandPolicy(onDemandSite.SSL.OnDemand, storage, lg)
+			listed := hostPolicy
+			hostPolicy = func(ctx context.Context, host string) error {
+				if listed(ctx, host) == nil {
+					return nil
+				}
+				return onDemand.allow(ctx, host)
+			}
+		}
+		mgr = newAutocertRenewer(&autocert.Manager{
+			Prompt:                 autocert.AcceptTOS,
+			HostPolicy:             hostPolicy,
+			Cache:                  cache,
+			Email:                  ac.Email,
+			Client:                 newACMEClient(ac, acmeHC),
+			ExternalAccountBinding: eab,
+		}, cache, newACMEAccount(cache, newACMEClient(ac, acmeHC), ac.Email, eab), http01Hosts, lg)
+		lg.Infof("ACME auto-TLS enabled for %v (directory %s, %s)", acmeHosts, ac.DirectoryURL, describeCertStorage(ac))
+		if len(http01Hosts) > 0 {
+			lg.Infof("ACME HTTP-01 challenge enabled for %v", http01Hosts)
+		}
+
+		// TLS-ALPN-01 needs the acme protocol advertised in ALPN.
+		server.TLSConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+
+		for _, host := range acmeHosts {
+			certs.RegisterRenewer(host, func(ctx context.Context) error {
+				return mgr.renew(ctx, host)
+			})
+		}
+		go observeCachedCertificates(storage, acmeHosts)
+
+		if onDemand != nil {
+			challenge := onDemandSite.SSL.ACMEChallenge()
+			onDemand.onApprove = func(host string) {
+				certs.Track(host, certs.SourceACME, challenge+" (on demand)")
+				certs.RegisterRenewer(host, func(ctx context.Context) error {
+					return mgr.renew(ctx, host)
+				})
+				if challenge == config.ChallengeHTTP01 {
+					mgr.addHTTP01Host(host)
+				}
+			}
+			lg.Infof("On-demand TLS enabled by %s", onDemandSite.Domain)
+		}
+	}
+
+	var dnsMgr *dnsCertManager
+	if len(dns01Sites) > 0 {
+		dnsMgr = newDNSCertManager(cache, newACMEClient(ac, acmeHC), ac.Email, eab, lg)
+		var domains []string
+		for _, c := range dns01Sites {
+			provider, err := acmedns.NewProvider(c.SSL)
+			if err != nil {
+				lg.Errorf("ACME DNS-01 for %s: %v", c.Domain, err)
+				continue
+			}
+			dnsMgr.add(c.Domain, provider)
+			domains = append(domains, c.Domain)
+			certs.RegisterRenewer(c.Domain, func(ctx context.Context) error {
+				return dnsMgr.renew(ctx, c.Domain)
+			})
+		}
+		lg.Infof("ACME DNS-01 enabled for %v (directory %s, %s)", domains, ac.DirectoryURL, describeCertStorage(ac))
+		dnsMgr.start()
+	}
 
 	// Prefer a matching static certificate (for non-ACME hosts sharing the
-	// port), otherwise let the ACME manager serve/obtain one.
-	certs := staticCerts
-	server.TLSConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		for i := range certs {
-			if err := hello.SupportsCertificate(&certs[i]); err == nil {
-				return &certs[i], nil
+	// port), then a local CA or DNS-01 managed name, otherwise let autocert
+	// serve/obtain one.
+	getCert := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if cert := staticCerts.Lookup(hello); cert != nil {
+			return cert, nil
+		}
+		if internal != nil && internal.Matches(hello.ServerName) {
+			return internal.GetCertificate(hello)
+		}
+		if dnsMgr != nil {
+			if _, ok := dnsMgr.match(hello.ServerName); ok {
+				return dnsMgr.GetCertificate(hello)
 			}
 		}
+		if mgr == nil {
+			return nil, fmt.Errorf("no certificate configured for %q", hello.ServerName)
+		}
 		return mgr.GetCertificate(hello)
 	}
+	install(withOCSPStapling(getCert, ac.CacheDir, lg))
 	return true
 }
+
+// observeCertificates records the certificates returned by get, and the
+// errors of failed lookups, under the inventory domain of the SNI.
+func observeCertificates(get func(*tls.ClientHelloInfo) (*tls.Certificate, error), domainFor func(host string) string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := get(hello)
+		if domain := domainFor(hello.ServerName); domain != "" {
+			// Handshakes without SNI (bare IPs, scanners) are not errors of
+			// the site's certificate.
+			if err != nil && hello.ServerName != "" {
+				certs.RecordError(domain, err)
+			} else if err == nil {
+				certs.Observe(domain, cert)
+			}
+		}
+		return cert, err
+	}
+}
+
+// siteDomainFor returns the domain of the TLS site serving host, preferring
+// an exact name over a wildcard. A lone site serves every host.
+func siteDomainFor(confs []config.SiteConfig, host string) string {
+	if len(confs) == 1 {
+		return confs[0].Domain
+	}
+	var wildcard string
+	for _, c := range confs {
+		if !c.SSL.Enabled || host == "" || !middleware.HostMatchesDomain(c.Domain, host) {
+			continue
+		}
+		if !strings.HasPrefix(c.Domain, "*") {
+			return c.Domain
+		}
+		wildcard = c.Domain
+	}
+	return wildcard
+}
+
+// localIssuer returns an issuer of local CA certificates for hosts (site
+// domains, wildcards allowed), creating the CA on first use. It returns nil
+// when hosts is empty or the CA cannot be loaded.
+func localIssuer(hosts []string, lg *logger.Logger) *certs.LocalIssuer {
+	if len(hosts) == 0 {
+		return nil
+	}
+	ca, created, err := certs.LoadOrCreateLocalCA(config.GetLocalCADir())
+	if err != nil {
+		lg.Errorf("Local CA unavailable for %v: %v", hosts, err)
+		return nil
+	}
+	if created {
+		lg.Infof("Created local development CA %s (add it to your trust store)", ca.CertFile)
+	}
+	lg.Infof("Local CA certificates enabled for %v (CA %s)", hosts, ca.CertFile)
+
+	// Handshakes without SNI (e.g. https://127.0.0.1) get the first
+	// non-wildcard host.
+	var fallback string
+	for _, h := range hosts {
+		if !strings.HasPrefix(h, "*") {
+			fallback = h
+			break
+		}
+	}
+	return ca.Issuer(func(host string) bool {
+		for _, h := range hosts {
+			if middleware.HostMatchesDomain(h, host) {
+				return true
+			}
+		}
+		return false
+	}, fallback)
+}
+
+// ocspStaplers holds one stapler per persistence directory, shared by every
+// TLS server of the process.
+var (
+	ocspStaplers   = make(map[string]*certs.OCSPStapler)
+	ocspStaplersMu sync.Mutex
+)
+
+// withOCSPStapling staples OCSP responses to the certificates returned by get,
+// persisting them under dir/ocsp (next to the ACME cache). It returns get
+// unchanged when stapling is disabled in the global config.
+func withOCSPStapling(get func(*tls.ClientHelloInfo) (*tls.Certificate, error), dir string, lg *logger.Logger) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	config.GlobalConfMu.RLock()
+	enabled := config.GlobalConf == nil || config.GlobalConf.OCSPStapling == nil || *config.GlobalConf.OCSPStapling
+	config.GlobalConfMu.RUnlock()
+	if !enabled {
+		return get
+	}
+
+	ocspDir := filepath.Join(dir, "ocsp")
+	ocspStaplersMu.Lock()
+	stapler := ocspStaplers[ocspDir]
+	if stapler == nil {
+		stapler = certs.NewOCSPStapler(ocspDir, lg)
+		ocspStaplers[ocspDir] = stapler
+	}
+	ocspStaplersMu.Unlock()
+	return stapler.Wrap(get)
+}
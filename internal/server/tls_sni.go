@@ -0,0 +1,247 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/certs"
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+	"github.com/mirkobrombin/goup/internal/server/middleware"
+	"golang.org/x/crypto/acme"
+)
+
+// siteTLS is the TLS configuration of one site on a shared port.
+type siteTLS struct {
+	domain  string
+	conf    *tls.Config
+	tickets *ticketKeyRing // nil when Go manages the ticket keys
+}
+
+// config returns the site's tls.Config, rotating its session ticket keys
+// when they are due.
+func (s *siteTLS) config() *tls.Config {
+	if s.tickets != nil {
+		s.tickets.rotate(s.conf, time.Now())
+	}
+	return s.conf
+}
+
+// setupSiteTLSConfigs derives a tls.Config from base for every site that
+// needs its own TLS settings (TLS policy, client certificates) and selects it
+// by SNI through GetConfigForClient, since tls.Config is otherwise per port.
+// The HTTP/3 server shares the callback, so the same settings apply over
+// QUIC. A single-site server uses its variant for every handshake, SNI or
+// not.
+func setupSiteTLSConfigs(base *tls.Config, confs []config.SiteConfig, lg *logger.Logger) {
+	var sites []*siteTLS
+	for _, c := range confs {
+		if !c.SSL.Enabled {
+			continue
+		}
+		site, err := newSiteTLS(base, c)
+		if err != nil {
+			lg.Errorf("TLS settings for %s: %v", c.Domain, err)
+			// Fail closed: a site asking for client certificates or a strict
+			// policy must not silently fall back to the port's defaults.
+			conf := base.Clone()
+			conf.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return nil, fmt.Errorf("TLS misconfigured for %s", c.Domain)
+			}
+			site = &siteTLS{domain: c.Domain, conf: conf}
+		}
+		if site != nil {
+			sites = append(sites, site)
+		}
+	}
+	if len(sites) == 0 {
+		return
+	}
+
+	single := len(confs) == 1
+	base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if single {
+			return sites[0].config(), nil
+		}
+		// Exact names win over wildcards.
+		var wildcard *siteTLS
+		for _, s := range sites {
+			if hello.ServerName == "" || !middleware.HostMatchesDomain(s.domain, hello.ServerName) {
+				continue
+			}
+			if s.domain[0] != '*' {
+				return s.config(), nil
+			}
+			wildcard = s
+		}
+		if wildcard != nil {
+			return wildcard.config(), nil
+		}
+		// nil keeps the port's default configuration.
+		return nil, nil
+	}
+}
+
+// newSiteTLS returns the TLS configuration of a site, or nil when the port's
+// default applies.
+func newSiteTLS(base *tls.Config, c config.SiteConfig) (*siteTLS, error) {
+	policy := c.SSL.TLSPolicy
+	clientAuth := c.SSL.ClientAuth.NormalizedMode() != config.ClientAuthNone
+	if policy == nil && !clientAuth {
+		return nil, nil
+	}
+
+	site := &siteTLS{domain: c.Domain, conf: base.Clone()}
+	site.conf.GetConfigForClient = nil
+	if policy != nil {
+		tickets, err := applyTLSPolicy(site.conf, policy)
+		if err != nil {
+			return nil, err
+		}
+		site.tickets = tickets
+	}
+	if clientAuth {
+		if err := applyClientAuth(site.conf, c.SSL.ClientAuth); err != nil {
+			return nil, err
+		}
+	}
+	return site, nil
+}
+
+// applyTLSPolicy sets the protocol settings of a site on conf.
+func applyTLSPolicy(conf *tls.Config, p *config.TLSPolicy) (*ticketKeyRing, error) {
+	minVersion, maxVersion, err := p.Versions()
+	if err != nil {
+		return nil, err
+	}
+	conf.MinVersion, conf.MaxVersion = minVersion, maxVersion
+	if conf.CipherSuites, err = p.CipherSuiteIDs(); err != nil {
+		return nil, err
+	}
+	curves, err := p.CurveIDs()
+	if err != nil {
+		return nil, err
+	}
+	if len(curves) > 0 {
+		conf.CurvePreferences = curves
+	}
+	if len(p.ALPN) > 0 {
+		protos := slices.Clone(p.ALPN)
+		// Keep answering TLS-ALPN-01 challenges for ACME sites.
+		if slices.Contains(conf.NextProtos, acme.ALPNProto) {
+			protos = append(protos, acme.ALPNProto)
+		}
+		conf.NextProtos = protos
+	}
+
+	if !p.SessionTicketsEnabled() {
+		conf.SessionTicketsDisabled = true
+		return nil, nil
+	}
+	rotation, err := p.TicketRotation()
+	if err != nil {
+		return nil, err
+	}
+	ring := &ticketKeyRing{interval: rotation}
+	ring.rotate(conf, time.Now())
+	return ring, nil
+}
+
+// applyClientAuth sets the client certificate (mTLS) settings of a site on
+// conf.
+func applyClientAuth(conf *tls.Config, ca *config.ClientAuthConfig) error {
+	mode := ca.NormalizedMode()
+	switch mode {
+	case config.ClientAuthRequest:
+		conf.ClientAuth = tls.RequestClientCert
+	case config.ClientAuthRequire:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	case config.ClientAuthVerifyIfGiven:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("unsupported client_auth mode %q", ca.Mode)
+	}
+	if ca.CABundle != "" {
+		pool, err := certs.LoadCertPool(ca.CABundle)
+		if err != nil {
+			return err
+		}
+		conf.ClientCAs = pool
+	} else if mode != config.ClientAuthRequest {
+		return errors.New("client_auth requires ca_bundle")
+	}
+
+	policy := &certs.ClientCertPolicy{AllowedSubjects: ca.AllowedSubjects, AllowedSANs: ca.AllowedSANs}
+	if ca.CRLFile != "" {
+		crl, err := certs.LoadCRL(ca.CRLFile)
+		if err != nil {
+			return err
+		}
+		policy.CRL = crl
+	}
+	verifying := mode != config.ClientAuthRequest
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		if !verifying || len(cs.PeerCertificates) == 0 {
+			return nil
+		}
+		for _, chain := range cs.VerifiedChains {
+			// Intermediates listed in the CRL are revoked too.
+			for _, cert := range chain[:len(chain)-1] {
+				if err := checkRevocation(policy, cert); err != nil {
+					return err
+				}
+			}
+		}
+		return policy.Check(cs.PeerCertificates[0])
+	}
+	return nil
+}
+
+// checkRevocation applies only the CRL part of a policy.
+func checkRevocation(p *certs.ClientCertPolicy, cert *x509.Certificate) error {
+	if p.CRL == nil {
+		return nil
+	}
+	return p.CRL.Check(cert)
+}
+
+// ticketKeysKept is how many session ticket keys are kept: the current one
+// encrypts new tickets, the older ones still decrypt tickets issued before
+// the last rotations.
+const ticketKeysKept = 3
+
+// ticketKeyRing rotates the session ticket keys of a tls.Config. Rotation
+// happens lazily on the handshake path, so idle sites need no goroutine.
+type ticketKeyRing struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	keys      [][32]byte // newest first
+	rotatedAt time.Time
+}
+
+// rotate installs a fresh key on conf when the current one is older than the
+// rotation interval (or on the first call).
+func (r *ticketKeyRing) rotate(conf *tls.Config, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.keys) > 0 && now.Sub(r.rotatedAt) < r.interval {
+		return
+	}
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return
+	}
+	r.keys = append([][32]byte{key}, r.keys...)
+	if len(r.keys) > ticketKeysKept {
+		r.keys = r.keys[:ticketKeysKept]
+	}
+	r.rotatedAt = now
+	conf.SetSessionTicketKeys(r.keys)
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/logger"
+)
+
+// testCA issues certificates for the mTLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) writePEM(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestClientAuthPerSNI(t *testing.T) {
+	ca := newTestCA(t)
+	caFile := ca.writePEM(t)
+
+	confs := []config.SiteConfig{
+		{Domain: "secure.example.com", SSL: config.SSLConfig{Enabled: true, ClientAuth: &config.ClientAuthConfig{
+			Mode:            config.ClientAuthRequire,
+			CABundle:        caFile,
+			AllowedSubjects: []string{"alice*"},
+		}}},
+		{Domain: "public.example.com", SSL: config.SSLConfig{Enabled: true}},
+	}
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{
+			ca.issue(t, "secure.example.com", x509.ExtKeyUsageServerAuth),
+		},
+	}
+	setupSiteTLSConfigs(base, confs, &logger.Logger{})
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.ServerName)
+	}))
+	ts.TLS = base
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(serverName string, clientCert *tls.Certificate) error {
+		conf := &tls.Config{RootCAs: roots, ServerName: serverName, InsecureSkipVerify: true}
+		if clientCert != nil {
+			conf.Certificates = []tls.Certificate{*clientCert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	alice := ca.issue(t, "alice", x509.ExtKeyUsageClientAuth)
+	bob := ca.issue(t, "bob", x509.ExtKeyUsageClientAuth)
+	foreign := newTestCA(t).issue(t, "alice", x509.ExtKeyUsageClientAuth)
+
+	if err := get("secure.example.com", &alice); err != nil {
+		t.Errorf("allowed client certificate rejected: %v", err)
+	}
+	if err := get("secure.example.com", nil); err == nil {
+		t.Error("handshake without client certificate succeeded")
+	}
+	if err := get("secure.example.com", &bob); err == nil {
+		t.Error("client certificate outside allowed_subjects accepted")
+	}
+	if err := get("secure.example.com", &foreign); err == nil {
+		t.Error("client certificate from another CA accepted")
+	}
+	if err := get("public.example.com", nil); err != nil {
+		t.Errorf("site without client_auth required a certificate: %v", err)
+	}
+}
+
+func TestTLSPolicyPerSNI(t *testing.T) {
+	ca := newTestCA(t)
+	confs := []config.SiteConfig{
+		{Domain: "modern.example.com", SSL: config.SSLConfig{Enabled: true, TLSPolicy: &config.TLSPolicy{
+			MinVersion: "1.3",
+			ALPN:       []string{"http/1.1"},
+		}}},
+		{Domain: "*.legacy.example.com", SSL: config.SSLConfig{Enabled: true, TLSPolicy: &config.TLSPolicy{
+			MaxVersion:   "1.2",
+			CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			Curves:       []string{"P384"},
+		}}},
+		{Domain: "default.example.com", SSL: config.SSLConfig{Enabled: true}},
+	}
+	base := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{ca.issue(t, "example.com", x509.ExtKeyUsageServerAuth)},
+	}
+	setupSiteTLSConfigs(base, confs, &logger.Logger{})
+
+	ts := httptest.NewUnstartedServer(http.NotFoundHandler())
+	ts.TLS = base
+	ts.StartTLS()
+	defer ts.Close()
+
+	dial := func(serverName string, maxVersion uint16) (tls.ConnectionState, error) {
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+			MaxVersion:         maxVersion,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+
+	if _, err := dial("modern.example.com", tls.VersionTLS12); err == nil {
+		t.Error("TLS 1.3-only site accepted a TLS 1.2 handshake")
+	}
+	cs, err := dial("modern.example.com", 0)
+	if err != nil {
+		t.Fatalf("TLS 1.3 handshake: %v", err)
+	}
+	if cs.NegotiatedProtocol != "http/1.1" {
+		t.Errorf("ALPN = %q, want http/1.1", cs.NegotiatedProtocol)
+	}
+
+	cs, err = dial("old.legacy.example.com", 0)
+	if err != nil {
+		t.Fatalf("legacy handshake: %v", err)
+	}
+	if cs.Version != tls.VersionTLS12 || cs.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("legacy site negotiated version %x suite %s", cs.Version, tls.CipherSuiteName(cs.CipherSuite))
+	}
+
+	cs, err = dial("default.example.com", 0)
+	if err != nil {
+		t.Fatalf("default handshake: %v", err)
+	}
+	if cs.Version != tls.VersionTLS13 || cs.NegotiatedProtocol != "h2" {
+		t.Errorf("default site negotiated version %x ALPN %q", cs.Version, cs.NegotiatedProtocol)
+	}
+}
+
+func TestTicketKeyRingRotation(t *testing.T) {
+	ring := &ticketKeyRing{interval: time.Hour}
+	conf := &tls.Config{}
+	now := time.Now()
+
+	ring.rotate(conf, now)
+	first := ring.keys[0]
+	ring.rotate(conf, now.Add(time.Minute))
+	if len(ring.keys) != 1 {
+		t.Fatalf("rotated before the interval elapsed: %d keys", len(ring.keys))
+	}
+	for i := 1; i <= 4; i++ {
+		ring.rotate(conf, now.Add(time.Duration(i)*time.Hour))
+	}
+	if len(ring.keys) != ticketKeysKept {
+		t.Errorf("kept %d keys, want %d", len(ring.keys), ticketKeysKept)
+	}
+	if ring.keys[0] == first {
+		t.Error("newest key was not replaced")
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mirkobrombin/goup/internal/assets"
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+// tryFilesRule is a try_files list and the path prefix it applies to.
+type tryFilesRule struct {
+	prefix     string
+	candidates []string
+	status     int // status of the fallback response
+}
+
+// tryFiles resolves static requests for paths missing on disk, like nginx's
+// try_files: candidates are tried in order and the last one is the
+// fallback, typically the index.html of a single-page app. Matched paths are
+// served by ServeStatic, so stat caching, precompressed sidecars and ETags
+// apply to them as to any other file.
+type tryFiles struct {
+	rules []tryFilesRule // longest prefix first
+}
+
+// newTryFiles returns the resolver of a site, or nil when it uses none.
+func newTryFiles(conf config.SiteConfig) *tryFiles {
+	var t tryFiles
+	for _, route := range conf.TryFilesRoutes {
+		t.rules = append(t.rules, newTryFilesRule(route.Path, route.TryFiles, route.Status))
+	}
+	if len(conf.TryFiles) > 0 {
+		t.rules = append(t.rules, newTryFilesRule("/", conf.TryFiles, conf.TryFilesStatus))
+	}
+	if len(t.rules) == 0 {
+		return nil
+	}
+	sort.SliceStable(t.rules, func(i, j int) bool {
+		return len(t.rules[i].prefix) > len(t.rules[j].prefix)
+	})
+	return &t
+}
+
+func newTryFilesRule(prefix string, candidates []string, status int) tryFilesRule {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return tryFilesRule{prefix: prefix, candidates: candidates, status: status}
+}
+
+// rule returns the rule covering urlPath, or nil.
+func (t *tryFiles) rule(urlPath string) *tryFilesRule {
+	for i := range t.rules {
+		if strings.HasPrefix(urlPath, t.rules[i].prefix) {
+			return &t.rules[i]
+		}
+	}
+	return nil
+}
+
+func (t *tryFiles) serve(w http.ResponseWriter, r *http.Request, root string, site *staticSite) {
+	rule := t.rule(r.URL.Path)
+	if rule == nil || len(rule.candidates) == 0 {
+		serveStatic(w, r, root, site)
+		return
+	}
+
+	var access *staticAccess
+	if site != nil {
+		access = site.access
+	}
+	last := len(rule.candidates) - 1
+	for _, candidate := range rule.candidates[:last] {
+		if p := expandTryFile(candidate, r.URL.Path); staticExists(root, p, access) {
+			serveStaticPath(w, r, root, p, site)
+			return
+		}
+	}
+
+	fallback := rule.candidates[last]
+	if code, ok := strings.CutPrefix(fallback, "="); ok {
+		status, _ := strconv.Atoi(code)
+		text := http.StatusText(status)
+		if isBrowser(r) {
+			assets.RenderErrorPage(w, status, text, "The page you are looking for does not exist.")
+		} else {
+			http.Error(w, strconv.Itoa(status)+" "+text, status)
+		}
+		return
+	}
+	p := expandTryFile(fallback, r.URL.Path)
+	if rule.status == http.StatusOK || p == r.URL.Path {
+		serveStaticPath(w, r, root, p, site)
+		return
+	}
+	// A fallback served as an error is always sent in full: no 304 or 206
+	// for a 404.
+	r = r.Clone(r.Context())
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range", "Range"} {
+		r.Header.Del(h)
+	}
+	serveStaticPath(&statusOverrideWriter{ResponseWriter: w, status: rule.status}, r, root, p, site)
+}
+
+// expandTryFile substitutes the request path for $uri in a candidate.
+func expandTryFile(candidate, uri string) string {
+	return strings.ReplaceAll(candidate, "$uri", uri)
+}
+
+// staticExists reports whether urlPath names a file under root, or a
+// directory when it ends with a slash, that the site may serve.
+func staticExists(root, urlPath string, access *staticAccess) bool {
+	cleanPath, fullPath, err := staticLocalPath(root, urlPath)
+	if err != nil || access.denied(cleanPath) {
+		return false
+	}
+	info, err := cachedStat(fullPath)
+	if err != nil || access.outside(root, fullPath) {
+		return false
+	}
+	return info.IsDir() == strings.HasSuffix(urlPath, "/")
+}
+
+// serveStaticPath serves urlPath instead of the requested path.
+func serveStaticPath(w http.ResponseWriter, r *http.Request, root, urlPath string, site *staticSite) {
+	if urlPath != r.URL.Path {
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path, u.RawPath = urlPath, ""
+		r2.URL = &u
+		r = r2
+	}
+	serveStatic(w, r, root, site)
+}
+
+// statusOverrideWriter replaces the 200 status of a response.
+type statusOverrideWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusOverrideWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusOK {
+		code = w.status
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusOverrideWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusOverrideWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
+)
+
+func TestTryFiles(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html":          "spa",
+		"about.html":          "about",
+		"docs/index.html":     "docs",
+		"docs/guide.html":     "guide",
+		"assets/app.js":       "js",
+		"index.html.gz":       "gzipped spa",
+		"admin/index.html":    "admin",
+		"admin/missing.html":  "admin missing",
+		"static/readme.txt":   "readme",
+		"docs/not-found.html": "docs 404",
+	} {
+		file := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tf := newTryFiles(config.SiteConfig{
+		TryFiles: []string{"$uri", "$uri.html", "$uri/index.html", "/index.html"},
+		TryFilesRoutes: []config.TryFilesRoute{
+			{Path: "/docs/", TryFiles: []string{"$uri", "$uri.html", "/docs/not-found.html"}, Status: http.StatusNotFound},
+			{Path: "/static/", TryFiles: []string{"$uri", "=404"}},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		header   map[string]string
+		status   int
+		body     string
+		encoding string
+	}{
+		{"existing file", "/assets/app.js", nil, http.StatusOK, "js", ""},
+		{"html extension", "/about", nil, http.StatusOK, "about", ""},
+		{"directory index", "/admin", nil, http.StatusOK, "admin", ""},
+		{"spa fallback", "/users/42/settings", nil, http.StatusOK, "spa", ""},
+		{"spa fallback uses sidecar", "/users/42", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzipped spa", "gzip"},
+		{"route fallback with 404", "/docs/missing", nil, http.StatusNotFound, "docs 404", ""},
+		{"route match", "/docs/guide", nil, http.StatusOK, "guide", ""},
+		{"route status code", "/static/missing.txt", nil, http.StatusNotFound, "", ""},
+		{"hidden paths stay hidden", "/.env", nil, http.StatusOK, "spa", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			tf.serve(w, req, root, nil)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.body != "" && w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+		})
+	}
+}
+
+func TestTryFilesConditionalRequests(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("spa"), 0644)
+	os.WriteFile(filepath.Join(root, "404.html"), []byte("not found"), 0644)
+
+	serve := func(tf *tryFiles, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		tf.serve(w, req, root, nil)
+		return w
+	}
+
+	// A 200 fallback revalidates like the file it serves.
+	spa := newTryFiles(config.SiteConfig{TryFiles: []string{"$uri", "/index.html"}})
+	first := serve(spa, "")
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag on the fallback")
+	}
+	if w := serve(spa, etag); w.Code != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want 304", w.Code)
+	}
+
+	// A 404 fallback is always sent in full.
+	notFound := newTryFiles(config.SiteConfig{TryFiles: []string{"$uri", "/404.html"}, TryFilesStatus: http.StatusNotFound})
+	etag = serve(notFound, "").Header().Get("ETag")
+	w := serve(notFound, etag)
+	if w.Code != http.StatusNotFound || w.Body.String() != "not found" {
+		t.Errorf("got %d %q, want the full 404 page", w.Code, w.Body.String())
+	}
+}
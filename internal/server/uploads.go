@@ -0,0 +1,537 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/middleware"
+)
+
+const (
+	defaultUploadMaxSize = 100 << 20
+	// uploadPartialsDir keeps resumable uploads in progress. Being a
+	// dotfile, it is never served, listed or archived.
+	uploadPartialsDir = ".goup-uploads"
+	// uploadPartialTTL is how long an abandoned resumable upload is kept.
+	uploadPartialTTL = 24 * time.Hour
+)
+
+var errUploadExists = errors.New("a file with that name already exists")
+
+// uploads accepts files into the root directory of a static site:
+//   - POST multipart/form-data to a directory, one or more "file" parts;
+//   - PUT of the raw body to a file path;
+//   - resumable PUTs with Content-Range: bytes start-end/total chunks,
+//     whose progress is reported in Upload-Offset (a PUT with
+//     Content-Range: bytes */total asks for it);
+//   - DELETE of a file, when allowed.
+//
+// Files are written to a temporary name next to their destination and
+// moved into place once complete, so a half-written upload is never served.
+type uploads struct {
+	root      string
+	maxSize   int64
+	exts      map[string]bool
+	overwrite string
+	delete    bool
+	auth      *middleware.SiteAuth
+	access    *staticAccess
+
+	mu       sync.Mutex // serializes placement and guards partials
+	partials map[string]*partialLock
+}
+
+// partialLock serializes the chunks of one resumable upload, from the
+// offset check to the append.
+type partialLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockPartial locks the partial file at p and returns its unlock function.
+func (u *uploads) lockPartial(p string) func() {
+	u.mu.Lock()
+	if u.partials == nil {
+		u.partials = make(map[string]*partialLock)
+	}
+	l := u.partials[p]
+	if l == nil {
+		l = &partialLock{}
+		u.partials[p] = l
+	}
+	l.refs++
+	u.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		u.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(u.partials, p)
+		}
+		u.mu.Unlock()
+	}
+}
+
+// newUploads returns the upload endpoint of a site, or nil when disabled.
+func newUploads(conf config.SiteConfig) *uploads {
+	if conf.Uploads == nil {
+		return nil
+	}
+	u := &uploads{
+		root:      conf.RootDirectory,
+		maxSize:   conf.Uploads.MaxSize,
+		overwrite: conf.Uploads.Overwrite,
+		delete:    conf.Uploads.AllowDelete,
+		auth:      middleware.NewSiteAuth("GoUp uploads", conf.Uploads.Users),
+		access:    newStaticAccess(conf),
+	}
+	if u.maxSize == 0 {
+		u.maxSize = defaultUploadMaxSize
+	}
+	if u.overwrite == "" {
+		u.overwrite = config.OverwriteDeny
+	}
+	if len(conf.Uploads.AllowedExtensions) > 0 {
+		u.exts = make(map[string]bool)
+		for _, ext := range conf.Uploads.AllowedExtensions {
+			u.exts[strings.ToLower(ext)] = true
+		}
+	}
+	return u
+}
+
+// match reports whether r is for the upload endpoint rather than a read.
+// GET ?login makes browsers ask for the credentials the listing needs to
+// show the upload form.
+func (u *uploads) match(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut:
+		return true
+	case http.MethodDelete:
+		return u.delete
+	case http.MethodGet:
+		return r.URL.Query().Has("login")
+	}
+	return false
+}
+
+// uploadedFile describes a stored upload in JSON responses.
+type uploadedFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+func (u *uploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !u.auth.Authorized(r) {
+		u.auth.Challenge(w)
+		return
+	}
+	// Browsers attach Basic credentials to cross-site form posts too.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if o, err := url.Parse(origin); err != nil || o.Host != r.Host {
+			http.Error(w, "403 Forbidden: cross-origin upload", http.StatusForbidden)
+			return
+		}
+	}
+
+	cleanPath, fullPath, err := staticLocalPath(u.root, r.URL.Path)
+	if err != nil {
+		http.Error(w, "403 Forbidden: invalid path", http.StatusForbidden)
+		return
+	}
+	// The access rules of the site hold for writes as for reads.
+	if u.access.denied(cleanPath) {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if u.access.outsideTarget(u.root, fullPath) {
+		http.Error(w, "403 Forbidden: outside the root directory", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		target := cleanPath
+		if strings.HasSuffix(r.URL.Path, "/") && target != "/" {
+			target += "/"
+		}
+		http.Redirect(w, r, target, http.StatusSeeOther)
+	case http.MethodPost:
+		u.servePost(w, r, cleanPath, fullPath)
+	case http.MethodPut:
+		if cr := r.Header.Get("Content-Range"); cr != "" {
+			u.serveChunk(w, r, cleanPath, fullPath, cr)
+		} else {
+			u.servePut(w, r, cleanPath, fullPath)
+		}
+	case http.MethodDelete:
+		u.serveDelete(w, cleanPath, fullPath)
+	}
+}
+
+// servePost stores the files of a multipart form into the directory at
+// fullPath.
+func (u *uploads) servePost(w http.ResponseWriter, r *http.Request, cleanPath, fullPath string) {
+	if info, err := os.Stat(fullPath); err != nil || !info.IsDir() {
+		http.Error(w, "404 Not Found: no such directory", http.StatusNotFound)
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "400 Bad Request: expected multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	var stored []uploadedFile
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, "400 Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+		name := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+		if u.access.denied(path.Join(cleanPath, name)) {
+			part.Close()
+			http.Error(w, "404 Not Found: "+name, http.StatusNotFound)
+			return
+		}
+		f, status, err := u.store(part, fullPath, path.Join(cleanPath, name))
+		part.Close()
+		if err != nil {
+			http.Error(w, strconv.Itoa(status)+" "+http.StatusText(status)+": "+name+": "+err.Error(), status)
+			return
+		}
+		stored = append(stored, f)
+	}
+	if len(stored) == 0 {
+		http.Error(w, `400 Bad Request: no "file" part in the form`, http.StatusBadRequest)
+		return
+	}
+
+	if isBrowser(r) {
+		dir := cleanPath
+		if dir != "/" {
+			dir += "/"
+		}
+		http.Redirect(w, r, dir, http.StatusSeeOther)
+		return
+	}
+	writeUploadJSON(w, http.StatusCreated, stored)
+}
+
+// servePut stores the request body at fullPath.
+func (u *uploads) servePut(w http.ResponseWriter, r *http.Request, cleanPath, fullPath string) {
+	if r.ContentLength > u.maxSize {
+		http.Error(w, "413 Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	_, existed := os.Lstat(fullPath)
+	f, status, err := u.store(r.Body, filepath.Dir(fullPath), cleanPath)
+	if err != nil {
+		http.Error(w, strconv.Itoa(status)+" "+http.StatusText(status)+": "+err.Error(), status)
+		return
+	}
+	status = http.StatusCreated
+	if existed == nil && f.URL == cleanPath {
+		status = http.StatusOK
+	}
+	w.Header().Set("Location", (&url.URL{Path: f.URL}).EscapedPath())
+	writeUploadJSON(w, status, []uploadedFile{f})
+}
+
+// store writes src as urlPath, in the directory dir, and moves it into
+// place. On failure it returns the HTTP status to answer.
+func (u *uploads) store(src io.Reader, dir, urlPath string) (uploadedFile, int, error) {
+	name := path.Base(urlPath)
+	if status, err := u.checkTarget(dir, name); err != nil {
+		return uploadedFile{}, status, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".goup-upload-*")
+	if err != nil {
+		return uploadedFile{}, http.StatusInternalServerError, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(src, u.maxSize+1))
+	if err == nil && n > u.maxSize {
+		err = fmt.Errorf("larger than %s", formatSizeBytes(u.maxSize))
+		tmp.Close()
+		return uploadedFile{}, http.StatusRequestEntityTooLarge, err
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return uploadedFile{}, http.StatusRequestEntityTooLarge, err
+		}
+		return uploadedFile{}, http.StatusBadRequest, err
+	}
+	os.Chmod(tmp.Name(), 0644)
+
+	final, err := u.place(tmp.Name(), dir, name)
+	if errors.Is(err, errUploadExists) {
+		return uploadedFile{}, http.StatusConflict, err
+	}
+	if err != nil {
+		return uploadedFile{}, http.StatusInternalServerError, err
+	}
+	return uploadedFile{Name: final, URL: path.Join(path.Dir(urlPath), final), Size: n}, 0, nil
+}
+
+// checkTarget validates the name of an upload into dir.
+func (u *uploads) checkTarget(dir, name string) (int, error) {
+	if name == "" || name == "/" || name == "." || isHiddenName(name) {
+		return http.StatusBadRequest, errors.New("invalid file name")
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return http.StatusConflict, errors.New("the parent directory does not exist")
+	}
+	if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.IsDir() {
+		return http.StatusConflict, errors.New("a directory with that name exists")
+	}
+	if u.exts != nil && !u.exts[strings.ToLower(filepath.Ext(name))] {
+		return http.StatusUnsupportedMediaType, errors.New("file type not allowed")
+	}
+	return 0, nil
+}
+
+// place moves the complete upload tmp to dir/name following the overwrite
+// policy and returns the name it got. Hard links make "deny" and "rename"
+// atomic: a name taken meanwhile is never overwritten.
+func (u *uploads) place(tmp, dir, name string) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	dst := filepath.Join(dir, name)
+	if u.overwrite == config.OverwriteReplace {
+		if err := os.Rename(tmp, dst); err != nil {
+			return "", err
+		}
+		removeSidecars(dst)
+		return name, nil
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		dst = filepath.Join(dir, candidate)
+		err := os.Link(tmp, dst)
+		if err == nil {
+			os.Remove(tmp)
+			// Sidecars left by a deleted file of the same name.
+			removeSidecars(dst)
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			// No hard links on this filesystem: check, then rename.
+			if _, statErr := os.Lstat(dst); statErr == nil {
+				err = os.ErrExist
+			} else if err = os.Rename(tmp, dst); err == nil {
+				removeSidecars(dst)
+				return candidate, nil
+			} else {
+				return "", err
+			}
+		}
+		if u.overwrite == config.OverwriteDeny {
+			return "", errUploadExists
+		}
+	}
+	return "", errUploadExists
+}
+
+// serveChunk appends one chunk of a resumable upload to fullPath. The
+// partial file is keyed by user, path and total size, so an interrupted
+// upload resumes from Upload-Offset with the same request.
+func (u *uploads) serveChunk(w http.ResponseWriter, r *http.Request, cleanPath, fullPath, contentRange string) {
+	start, end, total, ok := parseUploadRange(contentRange)
+	if !ok {
+		http.Error(w, "400 Bad Request: invalid Content-Range", http.StatusBadRequest)
+		return
+	}
+	if total > u.maxSize {
+		http.Error(w, "413 Request Entity Too Large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	dir, name := filepath.Dir(fullPath), filepath.Base(fullPath)
+	if status, err := u.checkTarget(dir, name); err != nil {
+		http.Error(w, strconv.Itoa(status)+" "+http.StatusText(status)+": "+err.Error(), status)
+		return
+	}
+
+	partialDir := filepath.Join(u.root, uploadPartialsDir)
+	if err := os.MkdirAll(partialDir, 0700); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	user, _, _ := r.BasicAuth()
+	key := sha256.Sum256([]byte(user + "\x00" + cleanPath + "\x00" + strconv.FormatInt(total, 10)))
+	partial := filepath.Join(partialDir, hex.EncodeToString(key[:16]))
+
+	// Held until the chunk is appended (and the file placed), so two
+	// requests for the same offset can't both append.
+	unlock := u.lockPartial(partial)
+	defer unlock()
+	var offset int64
+	if info, err := os.Stat(partial); err == nil {
+		offset = info.Size()
+	} else {
+		removeStalePartials(partialDir)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	if start < 0 {
+		// A status query.
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if start != offset {
+		http.Error(w, "409 Conflict: resume at Upload-Offset", http.StatusConflict)
+		return
+	}
+	if end >= total {
+		// A partial must never grow past the announced size.
+		http.Error(w, "400 Bad Request: invalid Content-Range", http.StatusBadRequest)
+		return
+	}
+
+	f, err := os.OpenFile(partial, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	n, err := io.Copy(f, io.LimitReader(r.Body, end-start+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	offset += n
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if err != nil || n != end-start+1 {
+		http.Error(w, "400 Bad Request: incomplete chunk, resume at Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	if offset < total {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	os.Chmod(partial, 0644)
+	final, err := u.place(partial, dir, name)
+	if err != nil {
+		os.Remove(partial)
+		status := http.StatusInternalServerError
+		if errors.Is(err, errUploadExists) {
+			status = http.StatusConflict
+		}
+		http.Error(w, strconv.Itoa(status)+" "+http.StatusText(status)+": "+err.Error(), status)
+		return
+	}
+	f2 := uploadedFile{Name: final, URL: path.Join(path.Dir(cleanPath), final), Size: total}
+	w.Header().Set("Location", (&url.URL{Path: f2.URL}).EscapedPath())
+	writeUploadJSON(w, http.StatusCreated, []uploadedFile{f2})
+}
+
+// parseUploadRange parses "bytes start-end/total", or "bytes */total" for
+// which start is -1.
+func parseUploadRange(v string) (start, end, total int64, ok bool) {
+	spec, found := strings.CutPrefix(v, "bytes ")
+	if !found {
+		return 0, 0, 0, false
+	}
+	rng, totalStr, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, 0, false
+	}
+	total, err := strconv.ParseInt(totalStr, 10, 64)
+	if err != nil || total <= 0 {
+		return 0, 0, 0, false
+	}
+	if rng == "*" {
+		return -1, -1, total, true
+	}
+	startStr, endStr, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, 0, false
+	}
+	start, err1 := strconv.ParseInt(startStr, 10, 64)
+	end, err2 := strconv.ParseInt(endStr, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < start || end >= total {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
+
+// removeStalePartials drops resumable uploads abandoned for too long.
+func removeStalePartials(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > uploadPartialTTL {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
+
+// serveDelete removes the file at fullPath and its precompressed sidecars.
+func (u *uploads) serveDelete(w http.ResponseWriter, cleanPath, fullPath string) {
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+		return
+	}
+	if !info.Mode().IsRegular() || fullPath == u.root {
+		http.Error(w, "409 Conflict: only files can be deleted", http.StatusConflict)
+		return
+	}
+	if err := os.Remove(fullPath); err != nil {
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	removeSidecars(fullPath)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeSidecars deletes the precompressed variants of a file that changed,
+// so they don't keep serving the old content.
+func removeSidecars(file string) {
+	for _, ext := range sidecarExts {
+		os.Remove(file + ext)
+	}
+}
+
+func writeUploadJSON(w http.ResponseWriter, status int, files []uploadedFile) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"files": files})
+}
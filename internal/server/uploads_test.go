@@ -0,0 +1,378 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestUploadServer(t *testing.T, conf config.UploadsConfig) (*httptest.Server, string) {
+	t.Helper()
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "docs"), 0755)
+	os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("alpha"), 0644)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	conf.Users = []config.Credential{{Username: "ci", PasswordHash: string(hash)}}
+	site := newStaticSite(config.SiteConfig{RootDirectory: root, Uploads: &conf}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if site.uploads.match(r) {
+			site.uploads.ServeHTTP(w, r)
+			return
+		}
+		serveStatic(w, r, root, site)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, root
+}
+
+func uploadDo(t *testing.T, srv *httptest.Server, method, path string, body io.Reader, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+path, body)
+	req.SetBasicAuth("ci", "pw")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	return resp, string(data)
+}
+
+func multipartBody(files map[string]string) (io.Reader, string) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, content := range files {
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write([]byte(content))
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func readFile(t *testing.T, root, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestUploadMultipartAndPut(t *testing.T) {
+	srv, root := newTestUploadServer(t, config.UploadsConfig{})
+
+	body, ct := multipartBody(map[string]string{"b.txt": "bravo", "c.txt": "charlie"})
+	resp, data := uploadDo(t, srv, http.MethodPost, "/docs/", body, map[string]string{"Content-Type": ct})
+	if resp.StatusCode != http.StatusCreated || !strings.Contains(data, `"url":"/docs/b.txt"`) {
+		t.Fatalf("multipart: %d %s", resp.StatusCode, data)
+	}
+
+	body, ct = multipartBody(map[string]string{"d.txt": "delta"})
+	resp, _ = uploadDo(t, srv, http.MethodPost, "/", body, map[string]string{"Content-Type": ct, "Accept": "text/html"})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/" {
+		t.Errorf("browser upload: %d to %q, want a 303 to the directory", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	resp, _ = uploadDo(t, srv, http.MethodPut, "/docs/e.txt", strings.NewReader("echo"), nil)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/docs/e.txt" {
+		t.Errorf("PUT: %d %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if resp, _ = uploadDo(t, srv, http.MethodPut, "/missing/f.txt", strings.NewReader("x"), nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("PUT into a missing directory: status %d, want 409", resp.StatusCode)
+	}
+
+	for name, want := range map[string]string{"docs/b.txt": "bravo", "docs/c.txt": "charlie", "d.txt": "delta", "docs/e.txt": "echo"} {
+		if got := readFile(t, root, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(root, "docs", ".goup-upload-*")); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestUploadOverwritePolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		status   int
+		location string
+		files    map[string]string
+	}{
+		{config.OverwriteDeny, http.StatusConflict, "", map[string]string{"docs/a.txt": "alpha"}},
+		{config.OverwriteReplace, http.StatusOK, "/docs/a.txt", map[string]string{"docs/a.txt": "new"}},
+		{config.OverwriteRename, http.StatusCreated, "/docs/a%20%281%29.txt", map[string]string{"docs/a.txt": "alpha", "docs/a (1).txt": "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			srv, root := newTestUploadServer(t, config.UploadsConfig{Overwrite: tt.policy})
+			os.WriteFile(filepath.Join(root, "docs", "a.txt.br"), []byte("stale"), 0644)
+			resp, data := uploadDo(t, srv, http.MethodPut, "/docs/a.txt", strings.NewReader("new"), nil)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d: %s", resp.StatusCode, tt.status, data)
+			}
+			if loc := resp.Header.Get("Location"); loc != tt.location {
+				t.Errorf("Location = %q, want %q", loc, tt.location)
+			}
+			for name, want := range tt.files {
+				if got := readFile(t, root, name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			_, err := os.Stat(filepath.Join(root, "docs", "a.txt.br"))
+			if replaced := tt.policy == config.OverwriteReplace; replaced == (err == nil) {
+				t.Errorf("sidecar present = %v after a %s upload", err == nil, tt.policy)
+			}
+		})
+	}
+}
+
+func TestUploadRestrictions(t *testing.T) {
+	srv, root := newTestUploadServer(t, config.UploadsConfig{MaxSize: 8, AllowedExtensions: []string{".txt", ".JPG"}})
+
+	tests := []struct {
+		path, body string
+		header     map[string]string
+		status     int
+	}{
+		{"/docs/photo.jpg", "img", nil, http.StatusCreated},
+		{"/docs/script.php", "<?php", nil, http.StatusUnsupportedMediaType},
+		{"/docs/big.txt", "0123456789", nil, http.StatusRequestEntityTooLarge},
+		{"/.env", "SECRET", nil, http.StatusForbidden},
+		{"/docs/x.txt", "x", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"/docs", "x", nil, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if resp, data := uploadDo(t, srv, http.MethodPut, tt.path, strings.NewReader(tt.body), tt.header); resp.StatusCode != tt.status {
+			t.Errorf("PUT %s: status %d, want %d: %s", tt.path, resp.StatusCode, tt.status, data)
+		}
+	}
+	for _, name := range []string{"docs/script.php", "docs/big.txt", ".env", "docs/x.txt"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+			t.Errorf("%s was written", name)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/docs/anon.txt", strings.NewReader("x"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("anonymous upload: status %d, want a 401 challenge", resp.StatusCode)
+	}
+}
+
+func TestUploadResumable(t *testing.T) {
+	srv, root := newTestUploadServer(t, config.UploadsConfig{})
+	put := func(contentRange, body string) *http.Response {
+		resp, _ := uploadDo(t, srv, http.MethodPut, "/docs/big.bin", strings.NewReader(body), map[string]string{"Content-Range": contentRange})
+		return resp
+	}
+
+	if resp := put("bytes 0-3/10", "0123"); resp.StatusCode != http.StatusAccepted || resp.Header.Get("Upload-Offset") != "4" {
+		t.Fatalf("first chunk: %d offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := put("bytes */10", ""); resp.StatusCode != http.StatusAccepted || resp.Header.Get("Upload-Offset") != "4" {
+		t.Errorf("status query: %d offset %q", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := put("bytes 6-9/10", "6789"); resp.StatusCode != http.StatusConflict || resp.Header.Get("Upload-Offset") != "4" {
+		t.Errorf("gap: %d offset %q, want 409 at 4", resp.StatusCode, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := put("bytes 9-4/10", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad range: status %d, want 400", resp.StatusCode)
+	}
+	if got := readFile(t, root, "docs/big.bin"); got != "<missing>" {
+		t.Errorf("a partial upload is visible: %q", got)
+	}
+	if resp := put("bytes 4-9/10", "456789"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("last chunk: status %d", resp.StatusCode)
+	}
+	if got := readFile(t, root, "docs/big.bin"); got != "0123456789" {
+		t.Errorf("assembled file = %q", got)
+	}
+	if partials, _ := os.ReadDir(filepath.Join(root, uploadPartialsDir)); len(partials) > 0 {
+		t.Errorf("partials left behind: %v", partials)
+	}
+}
+
+// slowReader yields its data after a pause, widening race windows.
+type slowReader struct {
+	data  string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestUploadResumableConcurrentChunks(t *testing.T) {
+	srv, root := newTestUploadServer(t, config.UploadsConfig{})
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses []int
+	)
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, _ := uploadDo(t, srv, http.MethodPut, "/docs/race.bin", &slowReader{data: "0123", delay: 20 * time.Millisecond},
+				map[string]string{"Content-Range": "bytes 0-3/10"})
+			mu.Lock()
+			statuses = append(statuses, resp.StatusCode)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	slices.Sort(statuses)
+	want := []int{http.StatusAccepted, http.StatusConflict, http.StatusConflict, http.StatusConflict, http.StatusConflict, http.StatusConflict}
+	if !slices.Equal(statuses, want) {
+		t.Errorf("statuses = %v, want one 202 and 409s", statuses)
+	}
+
+	put := func(contentRange, body string) *http.Response {
+		resp, _ := uploadDo(t, srv, http.MethodPut, "/docs/race.bin", strings.NewReader(body), map[string]string{"Content-Range": contentRange})
+		return resp
+	}
+	if resp := put("bytes 4-10/10", "4567890"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("a chunk past the total: status %d, want 400", resp.StatusCode)
+	}
+	if resp := put("bytes 4-9/10", "456789"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("last chunk: status %d", resp.StatusCode)
+	}
+	if got := readFile(t, root, "docs/race.bin"); got != "0123456789" {
+		t.Errorf("assembled file = %q", got)
+	}
+}
+
+func TestUploadDeleteAndListing(t *testing.T) {
+	srv, root := newTestUploadServer(t, config.UploadsConfig{AllowDelete: true})
+	os.WriteFile(filepath.Join(root, "docs", "a.txt.gz"), []byte("stale"), 0644)
+
+	resp, page := uploadDo(t, srv, http.MethodGet, "/docs/", nil, map[string]string{"Accept": "text/html"})
+	if !strings.Contains(page, `enctype="multipart/form-data"`) || !strings.Contains(page, `class="listing-delete"`) {
+		t.Error("expected the upload form and delete buttons for an authorized user")
+	}
+	if resp.Header.Get("Cache-Control") != "private, no-store" {
+		t.Errorf("Cache-Control = %q", resp.Header.Get("Cache-Control"))
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/docs/", nil)
+	req.Header.Set("Accept", "text/html")
+	anon, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(anon.Body)
+	anon.Body.Close()
+	if strings.Contains(string(data), "multipart/form-data") || !strings.Contains(string(data), `href="?login"`) {
+		t.Error("anonymous visitors should get a login link instead of the form")
+	}
+	if resp, _ := uploadDo(t, srv, http.MethodGet, "/docs/?login", nil, nil); resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/docs/" {
+		t.Errorf("login: %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+
+	for _, tt := range []struct {
+		path   string
+		status int
+	}{
+		{"/docs/a.txt", http.StatusNoContent},
+		{"/docs/a.txt", http.StatusNotFound},
+		{"/docs", http.StatusConflict},
+		{"/", http.StatusConflict},
+	} {
+		if resp, _ := uploadDo(t, srv, http.MethodDelete, tt.path, nil, nil); resp.StatusCode != tt.status {
+			t.Errorf("DELETE %s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "a.txt.gz")); err == nil {
+		t.Error("DELETE left the sidecar behind")
+	}
+}
+
+func TestUploadAccessRules(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "private"), 0755)
+	os.WriteFile(filepath.Join(root, "private", "key.txt"), []byte("key"), 0644)
+	os.WriteFile(filepath.Join(root, "app.log"), []byte("log"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	if err := os.Symlink(outside, filepath.Join(root, "leak")); err != nil {
+		t.Skip("symlinks unavailable:", err)
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	follow := false
+	site := newStaticSite(config.SiteConfig{
+		RootDirectory:  root,
+		DenyPaths:      []string{"private", "*.log"},
+		FollowSymlinks: &follow,
+		Uploads: &config.UploadsConfig{
+			AllowDelete: true,
+			Overwrite:   config.OverwriteReplace,
+			Users:       []config.Credential{{Username: "ci", PasswordHash: string(hash)}},
+		},
+	}, nil)
+	srv := httptest.NewServer(site.uploads)
+	t.Cleanup(srv.Close)
+
+	form, contentType := multipartBody(map[string]string{"other.log": "x"})
+	tests := []struct {
+		method, path string
+		body         io.Reader
+		header       map[string]string
+		status       int
+	}{
+		{http.MethodPut, "/private/new.txt", strings.NewReader("x"), nil, http.StatusNotFound},
+		{http.MethodPut, "/app.log", strings.NewReader("x"), nil, http.StatusNotFound},
+		{http.MethodPost, "/", form, map[string]string{"Content-Type": contentType}, http.StatusNotFound},
+		{http.MethodPut, "/leak/new.txt", strings.NewReader("x"), nil, http.StatusForbidden},
+		{http.MethodPut, "/leak/secret.txt", strings.NewReader("x"), nil, http.StatusForbidden},
+		{http.MethodPut, "/leak/part.txt", strings.NewReader("x"), map[string]string{"Content-Range": "bytes 0-0/2"}, http.StatusForbidden},
+		{http.MethodDelete, "/private/key.txt", nil, nil, http.StatusNotFound},
+		{http.MethodDelete, "/leak/secret.txt", nil, nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if resp, data := uploadDo(t, srv, tt.method, tt.path, tt.body, tt.header); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, resp.StatusCode, tt.status, data)
+		}
+	}
+
+	for name, want := range map[string]string{"private/key.txt": "key", "app.log": "log"} {
+		if got := readFile(t, root, name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"private/new.txt", "other.log"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+			t.Errorf("%s was written", name)
+		}
+	}
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 || readFile(t, outside, "secret.txt") != "secret" {
+		t.Errorf("an upload changed files outside the root: %v", entries)
+	}
+}
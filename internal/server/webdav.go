@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"github.com/mirkobrombin/goup/internal/middleware"
+	"golang.org/x/net/webdav"
+)
+
+// davReadMethods are the methods a read-only share accepts.
+var davReadMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// davShare serves the root directory of a static site over WebDAV below a
+// path prefix.
+type davShare struct {
+	prefix  string
+	handler http.Handler
+}
+
+// newDAVShare returns the WebDAV share of a site, or nil when it has none.
+func newDAVShare(conf config.SiteConfig) *davShare {
+	if conf.WebDAV == nil {
+		return nil
+	}
+	prefix := conf.WebDAV.Path
+	if prefix == "" {
+		prefix = config.DefaultWebDAVPath
+	}
+	dav := &webdav.Handler{
+		Prefix:     strings.TrimSuffix(prefix, "/"),
+		FileSystem: davFS{root: conf.RootDirectory, readOnly: conf.WebDAV.ReadOnly, access: newStaticAccess(conf)},
+		LockSystem: webdav.NewMemLS(),
+	}
+	var handler http.Handler = dav
+	if conf.WebDAV.ReadOnly {
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !davReadMethods[r.Method] {
+				http.Error(w, "403 Forbidden: read-only share", http.StatusForbidden)
+				return
+			}
+			dav.ServeHTTP(w, r)
+		})
+	}
+	return &davShare{
+		prefix:  prefix,
+		handler: middleware.SiteBasicAuth("GoUp WebDAV", conf.WebDAV.Users)(handler),
+	}
+}
+
+// match reports whether r is for the share.
+func (s *davShare) match(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, s.prefix) || r.URL.Path+"/" == s.prefix
+}
+
+func (s *davShare) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// davFS is the webdav.FileSystem of a site root. Names are resolved by
+// staticLocalPath and the access rules, like static requests, so traversal
+// is impossible, dotfiles and denied paths are neither visible nor
+// writable, and symlinks lead outside the root only if the site allows it.
+type davFS struct {
+	root     string
+	readOnly bool
+	access   *staticAccess
+}
+
+func (d davFS) resolve(name string) (string, error) {
+	cleanPath, fullPath, err := staticLocalPath(d.root, name)
+	if err != nil || d.access.denied(cleanPath) {
+		return "", os.ErrNotExist
+	}
+	if d.access.outsideTarget(d.root, fullPath) {
+		return "", os.ErrPermission
+	}
+	return fullPath, nil
+}
+
+// resolveWritable is resolve for names about to change; the root itself
+// can't be removed or renamed.
+func (d davFS) resolveWritable(name string) (string, error) {
+	if d.readOnly {
+		return "", os.ErrPermission
+	}
+	fullPath, err := d.resolve(name)
+	if err != nil || fullPath == d.root {
+		return "", os.ErrPermission
+	}
+	return fullPath, nil
+}
+
+func (d davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	fullPath, err := d.resolveWritable(name)
+	if err != nil {
+		return err
+	}
+	return os.Mkdir(fullPath, perm)
+}
+
+func (d davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	var fullPath string
+	var err error
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		fullPath, err = d.resolveWritable(name)
+	} else {
+		fullPath, err = d.resolve(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(fullPath, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_TRUNC|os.O_APPEND) != 0 {
+		// Like uploads: the precompressed variants no longer match.
+		removeSidecars(fullPath)
+	}
+	return davFile{File: f, access: d.access, name: name}, nil
+}
+
+func (d davFS) RemoveAll(ctx context.Context, name string) error {
+	fullPath, err := d.resolveWritable(name)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(fullPath); err != nil {
+		return err
+	}
+	removeSidecars(fullPath)
+	return nil
+}
+
+func (d davFS) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, err := d.resolveWritable(oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := d.resolveWritable(newName)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	removeSidecars(oldPath)
+	removeSidecars(newPath)
+	return nil
+}
+
+func (d davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	fullPath, err := d.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(fullPath)
+}
+
+// davFile hides dotfiles and denied paths from directory reads (PROPFIND
+// with depth).
+type davFile struct {
+	*os.File
+	access *staticAccess
+	name   string
+}
+
+func (f davFile) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := f.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !isHiddenName(info.Name()) && !f.access.denied(path.Join(f.name, info.Name())) {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mirkobrombin/goup/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestDAVServer(t *testing.T, readOnly bool) (*httptest.Server, string) {
+	t.Helper()
+	parent := t.TempDir()
+	root := filepath.Join(parent, "site")
+	os.MkdirAll(filepath.Join(root, "docs"), 0755)
+	os.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("alpha"), 0644)
+	os.WriteFile(filepath.Join(root, ".env"), []byte("SECRET=1"), 0644)
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	share := newDAVShare(config.SiteConfig{
+		RootDirectory: root,
+		WebDAV: &config.WebDAVConfig{
+			ReadOnly: readOnly,
+			Users:    []config.Credential{{Username: "ci", PasswordHash: string(hash)}},
+		},
+	})
+	srv := httptest.NewServer(share)
+	t.Cleanup(srv.Close)
+	return srv, root
+}
+
+func davDo(t *testing.T, srv *httptest.Server, method, path, body string, header map[string]string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	req.SetBasicAuth("ci", "pw")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestWebDAVShare(t *testing.T) {
+	srv, root := newTestDAVServer(t, false)
+
+	resp, _ := http.Get(srv.URL + "/dav/docs/a.txt")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("anonymous request: status %d, want a 401 challenge", resp.StatusCode)
+	}
+
+	resp = davDo(t, srv, "PROPFIND", "/dav/", "", map[string]string{"Depth": "1"})
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(string(body), "/dav/docs/") {
+		t.Fatalf("PROPFIND: %d %s", resp.StatusCode, body)
+	}
+	if strings.Contains(string(body), ".env") {
+		t.Error("PROPFIND must not list dotfiles")
+	}
+
+	steps := []struct {
+		method, path, body string
+		header             map[string]string
+		status             int
+	}{
+		{"MKCOL", "/dav/new/", "", nil, http.StatusCreated},
+		{"PUT", "/dav/new/b.txt", "bravo", nil, http.StatusCreated},
+		{"COPY", "/dav/new/b.txt", "", map[string]string{"Destination": srv.URL + "/dav/docs/b.txt"}, http.StatusCreated},
+		{"MOVE", "/dav/docs/a.txt", "", map[string]string{"Destination": srv.URL + "/dav/new/a.txt"}, http.StatusCreated},
+		{"DELETE", "/dav/docs/b.txt", "", nil, http.StatusNoContent},
+		{"LOCK", "/dav/new/b.txt", `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`, nil, http.StatusOK},
+		{"PUT", "/dav/.env", "SECRET=2", nil, http.StatusNotFound},
+		{"GET", "/dav/.env", "", nil, http.StatusNotFound},
+		{"PUT", "/dav/../escape.txt", "x", nil, http.StatusCreated},
+		{"DELETE", "/dav/", "", nil, http.StatusMethodNotAllowed},
+	}
+	for _, s := range steps {
+		if resp := davDo(t, srv, s.method, s.path, s.body, s.header); resp.StatusCode != s.status {
+			t.Errorf("%s %s: status %d, want %d", s.method, s.path, resp.StatusCode, s.status)
+		}
+	}
+
+	for name, want := range map[string]string{"new/a.txt": "alpha", "new/b.txt": "bravo", ".env": "SECRET=1", "escape.txt": "x"} {
+		if data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name))); string(data) != want {
+			t.Errorf("%s = %q (%v), want %q", name, data, err, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "..", "escape.txt")); err == nil {
+		t.Error("a PUT escaped the root directory")
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "b.txt")); err == nil {
+		t.Error("DELETE left the file behind")
+	}
+}
+
+func TestWebDAVReadOnly(t *testing.T) {
+	srv, root := newTestDAVServer(t, true)
+
+	if resp := davDo(t, srv, "PROPFIND", "/dav/docs/", "", map[string]string{"Depth": "1"}); resp.StatusCode != http.StatusMultiStatus {
+		t.Errorf("PROPFIND: status %d, want 207", resp.StatusCode)
+	}
+	resp := davDo(t, srv, "GET", "/dav/docs/a.txt", "", nil)
+	if data, _ := io.ReadAll(resp.Body); string(data) != "alpha" {
+		t.Errorf("GET = %q", data)
+	}
+	for _, method := range []string{"PUT", "DELETE", "MKCOL", "LOCK", "PROPPATCH"} {
+		if resp := davDo(t, srv, method, "/dav/docs/a.txt", "", nil); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", method, resp.StatusCode)
+		}
+	}
+	if resp := davDo(t, srv, "MOVE", "/dav/docs/a.txt", "", map[string]string{"Destination": srv.URL + "/dav/b.txt"}); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("MOVE: status %d, want 403", resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "a.txt")); err != nil {
+		t.Error("a read-only share was modified")
+	}
+}
+
+func TestWebDAVAccessRules(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "private"), 0755)
+	os.WriteFile(filepath.Join(root, "private", "key.txt"), []byte("key"), 0644)
+	os.WriteFile(filepath.Join(root, "app.log"), []byte("log"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	if err := os.Symlink(outside, filepath.Join(root, "leak")); err != nil {
+		t.Skip("symlinks unavailable:", err)
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("pw"), bcrypt.MinCost)
+	follow := false
+	srv := httptest.NewServer(newDAVShare(config.SiteConfig{
+		RootDirectory:  root,
+		DenyPaths:      []string{"private", "*.log"},
+		FollowSymlinks: &follow,
+		WebDAV:         &config.WebDAVConfig{Users: []config.Credential{{Username: "ci", PasswordHash: string(hash)}}},
+	}))
+	t.Cleanup(srv.Close)
+
+	resp := davDo(t, srv, "PROPFIND", "/dav/", "", map[string]string{"Depth": "1"})
+	body, _ := io.ReadAll(resp.Body)
+	if strings.Contains(string(body), "private") || strings.Contains(string(body), "app.log") {
+		t.Errorf("PROPFIND lists denied paths: %s", body)
+	}
+
+	for _, s := range []struct {
+		method, path string
+		header       map[string]string
+	}{
+		{"GET", "/dav/private/key.txt", nil},
+		{"GET", "/dav/app.log", nil},
+		{"PUT", "/dav/private/new.txt", nil},
+		{"PUT", "/dav/other.log", nil},
+		{"DELETE", "/dav/private/key.txt", nil},
+		{"MOVE", "/dav/app.log", map[string]string{"Destination": srv.URL + "/dav/app.txt"}},
+		{"GET", "/dav/leak/secret.txt", nil},
+		{"PUT", "/dav/leak/new.txt", nil},
+		{"MKCOL", "/dav/leak/dir/", nil},
+		{"DELETE", "/dav/leak/secret.txt", nil},
+	} {
+		if resp := davDo(t, srv, s.method, s.path, "x", s.header); resp.StatusCode < 400 {
+			t.Errorf("%s %s: status %d", s.method, s.path, resp.StatusCode)
+		}
+	}
+
+	for _, name := range []string{"private/key.txt", "app.log"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s was removed", name)
+		}
+	}
+	for _, name := range []string{"private/new.txt", "other.log", "app.txt"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+			t.Errorf("%s was written", name)
+		}
+	}
+	entries, _ := os.ReadDir(outside)
+	if len(entries) != 1 {
+		t.Errorf("the share wrote outside the root: %v", entries)
+	}
+}
+
+func TestWebDAVRemovesSidecars(t *testing.T) {
+	srv, root := newTestDAVServer(t, false)
+	for _, name := range []string{"index.html", "index.html.br", "docs/a.txt.gz", "docs/b.txt", "docs/b.txt.gz", "docs/c.txt", "docs/c.txt.br"} {
+		os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte("old"), 0644)
+	}
+
+	davDo(t, srv, "PUT", "/dav/index.html", "new", nil)
+	davDo(t, srv, "DELETE", "/dav/docs/a.txt", "", nil)
+	davDo(t, srv, "MOVE", "/dav/docs/b.txt", "", map[string]string{"Destination": srv.URL + "/dav/docs/c.txt", "Overwrite": "T"})
+	for _, name := range []string{"index.html.br", "docs/a.txt.gz", "docs/b.txt.gz", "docs/c.txt.br"} {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+			t.Errorf("%s was left behind", name)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "index.html")); string(data) != "new" {
+		t.Errorf("index.html = %q", data)
+	}
+}